@@ -27,24 +27,28 @@ SOFTWARE.
 package main
 
 import (
-	"bytes"
-
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 
 	"ecdhHandshake/eclypsesEcdh"
+	"mteToolkit/mteHttp"
 
 	"github.com/google/uuid"
 )
 
 var retcode int
 
+//------------------------------------------
+// Http client shared by all calls to the API
+var httpClient = mteHttp.NewClient()
+
 const (
 	//--------------------
 	// content type const
@@ -81,14 +85,6 @@ type HandshakeModel struct {
 	ClientDecoderPublicKey string
 }
 
-type ResponseModel[T any] struct {
-	Message      string
-	Success      bool
-	ResultCode   string
-	ExceptionUid string
-	Data         T
-}
-
 /**
  * Main function kicks off the ECDH Handshake
  */
@@ -101,13 +97,18 @@ func main() {
 	retcode = 0
 	defer func() { os.Exit(retcode) }()
 
+	//------------------------------------------
+	// Cancel outstanding calls when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	//-------------------------------
 	// Initialize client parameters
 	clientId := uuid.New()
 
 	//------------------------
 	// Call Handshake Method
-	handshake, retcode, err := PerformHandshakeWithServer(clientId.String())
+	handshake, retcode, err := PerformHandshakeWithServer(ctx, clientId.String())
 	if err != nil {
 		fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
 		return
@@ -134,7 +135,7 @@ func main() {
  * Returns HandshakeResponse: encoderSharedSecret, decoderSharedSecret
  *
  */
-func PerformHandshakeWithServer(clientId string) (out HandshakeResponse, retcode int, err error) {
+func PerformHandshakeWithServer(ctx context.Context, clientId string) (out HandshakeResponse, retcode int, err error) {
 
 	fmt.Println("Performing handshake for client: " + clientId)
 
@@ -176,7 +177,7 @@ func PerformHandshakeWithServer(clientId string) (out HandshakeResponse, retcode
 	}
 	//----------------------------------
 	// Make Http and get return string
-	hsModelString, errorcode, err := MakeHttpCall(restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
+	hsModelString, errorcode, err := MakeHttpCall(ctx, restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
 	if err != nil {
 		fmt.Println("Error making Http call: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
 		return handshakeResponse, errorcode, err
@@ -184,11 +185,10 @@ func PerformHandshakeWithServer(clientId string) (out HandshakeResponse, retcode
 	//-----------------------------
 	// marshal json back to class
 	hrBytes := []byte(hsModelString)
-	var serverResponse ResponseModel[HandshakeModel]
-	json.Unmarshal(hrBytes, &serverResponse)
-	if !serverResponse.Success {
-		fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
-		return handshakeResponse, errorFromServer, errors.New(serverResponse.Message)
+	serverResponse, err := mteHttp.DecodeResponse[HandshakeModel](hrBytes)
+	if err != nil {
+		fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
+		return handshakeResponse, errorFromServer, err
 	}
 	//--------------------------------------------
 	// Base64 Decode Encoder public key to []byte
@@ -235,7 +235,10 @@ func PerformHandshakeWithServer(clientId string) (out HandshakeResponse, retcode
 
 /**
  * Makes Http Call
+ * Uses the shared Http client, it retries failed calls when
+ * that is safe and checks the status code before we decode
  *
+ * ctx: cancels the call and any retries
  * route: Route to make the Http call
  * connectionMethod: POST OR GET
  * clientId: clientId string
@@ -245,55 +248,41 @@ func PerformHandshakeWithServer(clientId string) (out HandshakeResponse, retcode
  * Returns a json string of what server sends back
  *
  */
-func MakeHttpCall(route string,
+func MakeHttpCall(ctx context.Context,
+	route string,
 	connectionMethod string,
 	clientId string,
 	contentType string,
 	payload string) (out string, retcode int, err error) {
-	//--------------------
-	// set return string
-	var returnString string
-	//------------------------------------
-	// If this is a POST request do this
-	if connectionMethod == "POST" {
-		responseBody := bytes.NewBuffer([]byte(payload))
-		resp, err := http.Post(route, contentType, responseBody)
-		if err != nil {
-			fmt.Println("An Error Occured %v", err)
-			return "", errorHttpPost, err
-		}
-		defer resp.Body.Close()
-		//-----------------------
-		//Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		//Convert the body to type string
-		returnString = string(body)
-		//------------------------------------
-		// If this is a GET request do this
-	} else if connectionMethod == "GET" {
-		resp, err := http.Get(route)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorHttpGet, err
+	//--------------------------------
+	// Only POST and GET are supported
+	method := strings.ToUpper(connectionMethod)
+	if method != "POST" && method != "GET" {
+		fmt.Println("Invalid connection request")
+		return "", errorInvalidConnectionMethod, errors.New("invalid connection request")
+	}
+	request := mteHttp.Request{
+		Method:   method,
+		Url:      route,
+		ClientId: clientId,
+	}
+	if method == "POST" {
+		request.ContentType = contentType
+		request.Body = []byte(payload)
+	}
+	//-------------------------------
+	// Make the call and get the body
+	body, err := httpClient.Do(ctx, request)
+	if err != nil {
+		fmt.Println(err.Error())
+		var statusErr *mteHttp.StatusError
+		if errors.As(err, &statusErr) {
+			return string(statusErr.Body), errorFromServer, err
 		}
-		//------------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
+		if method == "POST" {
+			return "", errorHttpPost, err
 		}
-		//--------------------------------
-		//Convert the body to type string
-		returnString = string(body)
-	} else {
-		fmt.Println("invalid connection request")
-		return "", errorInvalidConnectionMethod, errors.New("Invalid connection request.")
+		return "", errorHttpGet, err
 	}
-	return returnString, 0, nil
+	return string(body), 0, nil
 }
@@ -1 +1,7 @@
-module goSocket
\ No newline at end of file
+module goSocket
+
+go 1.18
+
+require mteToolkit v0.0.0
+
+replace mteToolkit => ../mte-toolkit
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"mteToolkit/mteEntropy"
 )
 
 //-----------------------
@@ -109,23 +111,21 @@ func main() {
 		fmt.Println("There was an error attempting to initialize the MTE License.")
 		return
 	}
-	//----------------------------------------------------------------------------
-	// check how long entropy we need, set default
-	// Providing Entropy in this fashion is insecure. This is for demonstration
-	// purposes only and should never be done in practice.
-	//----------------------------------------------------------------------------
-	entropyBytes := mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg())
-	entropy := make([]byte, entropyBytes)
-	//---------------
-	// Fill with 0's
-	//---------------
-	for i := 0; i < entropyBytes; i++ {
-		entropy[i] = '0'
+	//-----------------------------------------------------------------
+	// Both run in this sample, so they share random entropy from one
+	// provider. In real applications the entropy comes from the
+	// handshake (mteEntropy.NewEcdhProvider).
+	//-----------------------------------------------------------------
+	err = mteEntropy.Set(mteEntropy.RandomProvider{},
+		mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder, decoder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Entropy error: %v\n", err)
+		retcode = 1
+		return
 	}
 	//--------------------
 	// Initialize encoder
 	//--------------------
-	encoder.SetEntropy(entropy)
 	encoder.SetNonceInt(nonce)
 	status := encoder.InstantiateStr(identifier)
 	if status != mte.Status_mte_status_success {
@@ -134,18 +134,9 @@ func main() {
 		retcode = int(status)
 		return
 	}
-	//---------------------------------------------------------------------------
-	// Since entropy is zero'd after using it for the encoder, fill in again
-	// Providing Entropy in this fashion is insecure. This is for demonstration
-	// purposes only and should never be done in practice.
-	//---------------------------------------------------------------------------
-	for i := 0; i < entropyBytes; i++ {
-		entropy[i] = '0'
-	}
 	//---------------------
 	// Initialize decoder
 	//---------------------
-	decoder.SetEntropy(entropy)
 	decoder.SetNonceInt(nonce)
 	status = decoder.InstantiateStr(identifier)
 	if status != mte.Status_mte_status_success {
@@ -1 +1,7 @@
-module goDemo
\ No newline at end of file
+module goDemo
+
+go 1.18
+
+require mteToolkit v0.0.0
+
+replace mteToolkit => ../mte-toolkit
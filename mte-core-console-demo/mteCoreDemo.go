@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"goDemo/mte"
 	"os"
-	"strings"
+
+	"mteToolkit/mteEntropy"
 )
 
 // Application constants
@@ -22,6 +24,41 @@ func main() {
 	//-----------------------------------------------------
 	retcode := 0
 	defer func() { os.Exit(retcode) }()
+	//------------------------------------------------------
+	// With a fixed length the REPL starts in FLEN mode, the
+	// FLEN Encoder pads every message to that length, so
+	// the encoded size does not give away the message
+	// length. The Core Decoder decodes FLEN messages.
+	//------------------------------------------------------
+	fixedLength := flag.Int("fixed-length", 0, "start in FLEN mode using this fixed length, 0 starts in Core mode")
+	//------------------------------------------------------
+	// With known entropy, nonce and personalization the REPL
+	// ends up in the same state as a peer instantiated with
+	// them. For debugging interop ONLY, the entropy is not
+	// secret and a warning is logged every time it is used.
+	//------------------------------------------------------
+	entropy := flag.String("entropy", "", "instantiate with this fixed entropy (text, hex: or b64:), INSECURE")
+	nonceFlag := flag.Uint64("nonce", nonce, "nonce to instantiate with")
+	personal := flag.String("personal", personalizationString, "personalization string to instantiate with")
+	flag.Parse()
+	if *fixedLength < 0 {
+		fmt.Fprintln(os.Stderr, "The fixed length can not be negative.")
+		retcode = 1
+		return
+	}
+	seed := seed{nonce: *nonceFlag, personal: *personal}
+	if *entropy != "" {
+		var err error
+		seed.entropy, err = parseMessage(*entropy)
+		if err == nil && len(seed.entropy) == 0 {
+			err = errors.New("the entropy is empty")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid entropy: %v\n", err)
+			retcode = 1
+			return
+		}
+	}
 	//-----------------------------------------
 	// Display the version of MTE we are using
 	// (optional) For demo purposes ONLY
@@ -36,123 +73,85 @@ func main() {
 		retcode = int(mte.Status_mte_status_license_error)
 		return
 	}
+	//--------------------------------------------
+	// Instantiate the Encoder and Decoder of every
+	// mode and keep their states, the REPL restores
+	// them for every command
+	//--------------------------------------------
+	r, err := newRepl(seed, *fixedLength, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			retcode = int(statusErr.status)
+		} else {
+			retcode = 1
+		}
+		return
+	}
+	if *fixedLength > 0 {
+		fmt.Printf("Using the FLEN Encoder with a fixed length of %d bytes\n", *fixedLength)
+	}
+	//-------------------------------
+	// run the REPL until quit typed
+	//-------------------------------
+	r.run(os.Stdin)
+	fmt.Println("Program stopped.")
+	retcode = int(100)
+}
+
+/**
+ * Creates the Encoder and Decoder of a mode and instantiates them
+ * Both run in this demo, so they get the same entropy from one
+ * provider. In real applications the entropy comes from the
+ * handshake (mteEntropy.NewEcdhProvider).
+ *
+ * Returns the Encoder and Decoder states
+ */
+func instantiate(mode string, fixedLength int, seed seed) (out modeState, err error) {
 	//--------------------------
 	// create the MTE Encoder
 	//--------------------------
-	mteEncoder := mte.NewEncDef()
+	mteEncoder := newEncoder(mode, fixedLength)
 	defer mteEncoder.Destroy()
-	//-------------------------------------------------------------------------
-	// Create all-zero entropy for this demo. The nonce will also be set to 0.
-	// This should never be done in real applications.
-	//-------------------------------------------------------------------------
-	entropyBytes := mte.GetDrbgsEntropyMinBytes(mteEncoder.GetDrbg())
-	entropy := make([]byte, entropyBytes)
-	// Fill with 0's
-	for i := 0; i < entropyBytes; i++ {
-		entropy[i] = '0'
+	//-------------------------
+	// create the MTE decoder
+	//-------------------------
+	mteDecoder := newDecoder(mode)
+	defer mteDecoder.Destroy()
+
+	err = mteEntropy.Set(seed.provider(),
+		mte.GetDrbgsEntropyMinBytes(mteEncoder.GetDrbg()), mteEncoder, mteDecoder)
+	if err != nil {
+		return out, fmt.Errorf("Entropy error: %w", err)
 	}
-	//--------------------------
-	// Set entropy and nonce
-	//--------------------------
-	mteEncoder.SetEntropy(entropy)
-	mteEncoder.SetNonceInt(nonce)
+	mteEncoder.SetNonceInt(seed.nonce)
 	//--------------------------
 	// Instantiate the encoder.
 	//--------------------------
-	encoderStatus := mteEncoder.InstantiateStr(personalizationString)
+	encoderStatus := mteEncoder.InstantiateStr(seed.personal)
 	if encoderStatus != mte.Status_mte_status_success {
-		// Handle an error here -- below is a sample
-		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
-			mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-		retcode = int(encoderStatus)
-		return
-	}
-	//-------------------------
-	// create the MTE decoder
-	//-------------------------
-	mteDecoder := mte.NewDecDef()
-	defer mteDecoder.Destroy()
-	//----------------------------------------------------------------------------
-	// Since the entropy is zero'ized so fill again
-	// Providing Entropy in this fashion is insecure. This is for demonstration
-	// purposes only and should never be done in practice.
-	//----------------------------------------------------------------------------
-	for i := 0; i < entropyBytes; i++ {
-		entropy[i] = '0'
+		return out, &statusError{action: mode + " Encoder instantiate", status: encoderStatus}
 	}
 	//---------------------
 	// Initialize decoder
 	//---------------------
-	mteDecoder.SetEntropy(entropy)
-	mteDecoder.SetNonceInt(nonce)
-	decoderStatus := mteDecoder.InstantiateStr(personalizationString)
+	mteDecoder.SetNonceInt(seed.nonce)
+	decoderStatus := mteDecoder.InstantiateStr(seed.personal)
 	if decoderStatus != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
-			mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-		retcode = int(decoderStatus)
-		return
+		return out, &statusError{action: mode + " Decoder instantiate", status: decoderStatus}
 	}
-	//-------------------------------
-	// run loop until quit typed in
-	//-------------------------------
-	for {
-		//-----------------------------
-		// Prompting message to encode
-		//-----------------------------
-		fmt.Print("\nPlease enter text to encode: (To end please type 'quit')\n")
-		reader := bufio.NewReader(os.Stdin)
-
-		textToEncode, _ := reader.ReadString('\n')
-		//---------------------------
-		// take off carriage return
-		//---------------------------
-		textToEncode = strings.Replace(textToEncode, "\n", "", -1)
-		textToEncode = strings.Replace(textToEncode, "\r", "", -1)
-
-		if strings.ToLower(textToEncode) == "quit" {
-			fmt.Println("Program stopped.")
-			retcode = int(100)
-			return
-		}
-		//--------------------------------
-		// Encode the string to a string
-		//--------------------------------
-		encoded, encoderStatus := mteEncoder.EncodeStrB64(textToEncode)
-		if encoderStatus != mte.Status_mte_status_success {
-			fmt.Fprintf(os.Stderr, "Encode error (%v): %v\n",
-				mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-			retcode = int(encoderStatus)
-			return
-		}
-		//--------------------------------------------------------------------------
-		// (optional) convert to base64 to view outgoing mte packet
-		// This is for demonstration purposes ONLY and should NOT be done normally
-		//--------------------------------------------------------------------------
-		fmt.Printf("Base64 encoded representation of the packet being sent: %q\n\n", encoded)
-
-		//--------------------------
-		// Decode string to string
-		//--------------------------
-		decoded, decoderStatus := mteDecoder.DecodeStrB64(encoded)
-		if mte.StatusIsError(decoderStatus) {
-			fmt.Fprintf(os.Stderr, "Decode error (%v): %v\n",
-				mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-			retcode = int(decoderStatus)
-			return
-		} else if decoderStatus != mte.Status_mte_status_success {
-			fmt.Fprintf(os.Stderr, "Decode warning (%v): %v\n",
-				mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-		}
-		//-----------------------------------------
-		// If the decoded is blank -- notify user
-		// otherwise display decoded message
-		//-----------------------------------------
-		if len(decoded) == 0 {
-			fmt.Print(os.Stderr, "Message was blank\n")
-		} else {
-			fmt.Printf("Decoded Message: '%s'", decoded)
-		}
+	return modeState{EncoderState: mteEncoder.SaveStateB64(), DecoderState: mteDecoder.SaveStateB64()}, nil
+}
 
-	}
+//--------------------------
+// MTE status as an error
+type statusError struct {
+	action string
+	status mte.Status
+}
 
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s error (%v): %v", e.action,
+		mte.GetStatusName(e.status), mte.GetStatusDescription(e.status))
 }
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goDemo/mte"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"mteToolkit/mteEntropy"
+)
+
+// REPL constants
+const (
+	modeCore = "core"
+	modeMke  = "mke"
+	modeFlen = "flen"
+
+	//-------------------------------------------
+	// Input prefixes for binary messages
+	hexPrefix = "hex:"
+	b64Prefix = "b64:"
+
+	stateFileMode = 0600
+)
+
+//--------------------------------------------------------
+// Methods every MTE Encoder type the REPL uses has
+type replEncoder interface {
+	SetEntropy(entropy []byte)
+	SetNonceInt(nonce uint64)
+	InstantiateStr(personal string) mte.Status
+	RestoreStateB64(state string) mte.Status
+	SaveStateB64() string
+	GetReseedCounter() uint64
+	GetDrbg() mte.Drbgs
+	Encode(message []byte) ([]byte, mte.Status)
+	Destroy()
+}
+
+//--------------------------------------------------------
+// Methods every MTE Decoder type the REPL uses has
+type replDecoder interface {
+	SetEntropy(entropy []byte)
+	SetNonceInt(nonce uint64)
+	InstantiateStr(personal string) mte.Status
+	RestoreStateB64(state string) mte.Status
+	SaveStateB64() string
+	GetReseedCounter() uint64
+	GetDrbg() mte.Drbgs
+	Decode(encoded []byte) ([]byte, mte.Status)
+	Destroy()
+}
+
+//-------------------------------------------
+// Encoder and Decoder states of one mode
+type modeState struct {
+	EncoderState string `json:",omitempty"`
+	DecoderState string `json:",omitempty"`
+}
+
+//-----------------------------------------------------------
+// States the save and restore commands keep in a slot or a
+// file, one pair for every mode. A state file of a peer or
+// a toolkit session only has EncoderState and DecoderState,
+// those are restored into its Mode or the current mode.
+type savedState struct {
+	Mode         string
+	FixedLength  int                  `json:",omitempty"`
+	States       map[string]modeState `json:",omitempty"`
+	EncoderState string               `json:",omitempty"`
+	DecoderState string               `json:",omitempty"`
+}
+
+//------------------------------------------------------------
+// How the Encoders and Decoders are instantiated. Without
+// entropy it is random, with it the REPL ends up in the same
+// state as a peer instantiated with the same three values.
+type seed struct {
+	entropy  []byte
+	nonce    uint64
+	personal string
+}
+
+/**
+ * Fixed entropy goes through the insecure test provider,
+ * which logs a warning every time it is used
+ */
+func (s seed) provider() mteEntropy.Provider {
+	if s.entropy == nil {
+		return mteEntropy.RandomProvider{}
+	}
+	return mteEntropy.NewInsecureFixedTestProvider(s.entropy)
+}
+
+//-------------------------------------------------------
+// Interactive Encoder and Decoder for debugging interop
+// with other MTE peers such as mobile and C# clients
+type repl struct {
+	mode        string
+	fixedLength int
+	seed        seed
+	states      map[string]modeState
+	lastEncoded string
+	slots       map[string]savedState
+	out         io.Writer
+}
+
+/**
+ * Creates the REPL and instantiates every mode with the seed
+ * With a fixed length it starts in FLEN mode, otherwise in Core
+ */
+func newRepl(seed seed, fixedLength int, output io.Writer) (out *repl, err error) {
+	r := &repl{
+		mode:        modeCore,
+		fixedLength: fixedLength,
+		slots:       make(map[string]savedState),
+		out:         output,
+	}
+	if fixedLength > 0 {
+		r.mode = modeFlen
+	}
+	err = r.instantiateAll(seed)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/**
+ * Reads commands until quit is typed or the input ends
+ * A failed command prints the error and the REPL goes on
+ */
+func (r *repl) run(in io.Reader) {
+	fmt.Fprint(r.out, "Type 'help' to see the commands.\n")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(r.out, "\nmte %s> ", r.mode)
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		command, args := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			command, args = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if strings.ToLower(command) == "quit" {
+			return
+		}
+		err := r.execute(strings.ToLower(command), args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+}
+
+func (r *repl) execute(command string, args string) error {
+	switch command {
+	case "help":
+		r.help()
+		return nil
+	case "encode":
+		return r.encode(args)
+	case "decode":
+		return r.decode(args)
+	case "save":
+		return r.save(args)
+	case "restore":
+		return r.restore(args)
+	case "status":
+		return r.status()
+	case "reseed":
+		return r.reseed(args)
+	case "mode":
+		return r.setMode(args)
+	}
+	return fmt.Errorf("Unknown command %q, type 'help' to see the commands.", command)
+}
+
+func (r *repl) help() {
+	fmt.Fprint(r.out, `Commands:
+  encode <message>          encode a message, prints the packet base64 encoded
+  decode [b64]              decode a packet, the last encoded one when empty
+  save <slot>               keep the Encoder and Decoder states in a slot
+  save file <path>          write the Encoder and Decoder states to a file
+  restore <slot>            go back to the states kept in a slot
+  restore file <path>       read the states from a file
+  restore file encoder|decoder <path>
+                            read a peer state into the Encoder or Decoder
+  status                    show the reseed counters and the reseed interval
+  reseed                    instantiate every mode again with random entropy
+  reseed <entropy> [nonce] [personalization]
+                            instantiate every mode again with known values
+  mode core|mke|flen [len]  switch to the Encoder and Decoder of a mode
+  quit                      end the program
+Messages and entropy are text, or binary when they start with hex: or b64:
+`)
+}
+
+/**
+ * Encodes a message with the Encoder of the current mode
+ * The Encoder state is only kept when the message encoded
+ */
+func (r *repl) encode(args string) error {
+	message, err := parseMessage(args)
+	if err != nil {
+		return err
+	}
+	//----------------------------------------------------
+	// The FLEN Encoder would cut longer messages off, so
+	// reject them instead
+	//----------------------------------------------------
+	if r.mode == modeFlen && len(message) > r.fixedLength {
+		return fmt.Errorf("Message is %d bytes, longer than the fixed length of %d bytes.",
+			len(message), r.fixedLength)
+	}
+	encoder, err := r.restoreEncoder()
+	if err != nil {
+		return err
+	}
+	defer encoder.Destroy()
+	encoded, encoderStatus := encoder.Encode(message)
+	if encoderStatus != mte.Status_mte_status_success {
+		return &statusError{action: "Encode", status: encoderStatus}
+	}
+	state := r.states[r.mode]
+	state.EncoderState = encoder.SaveStateB64()
+	r.states[r.mode] = state
+	r.lastEncoded = base64.StdEncoding.EncodeToString(encoded)
+	//--------------------------------------------------------------------------
+	// (optional) convert to base64 to view outgoing mte packet
+	// This is for demonstration purposes ONLY and should NOT be done normally
+	//--------------------------------------------------------------------------
+	fmt.Fprintf(r.out, "Base64 encoded representation of the packet being sent: %q\n", r.lastEncoded)
+	fmt.Fprintf(r.out, "Message %d bytes, packet %d bytes\n", len(message), len(encoded))
+	return nil
+}
+
+/**
+ * Decodes a base64 packet with the Decoder of the current mode
+ * The Decoder state is only kept when the packet decoded
+ */
+func (r *repl) decode(args string) error {
+	if args == "" {
+		args = r.lastEncoded
+	}
+	if args == "" {
+		return errors.New("Nothing to decode, encode a message or give a base64 packet.")
+	}
+	encoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(args, b64Prefix))
+	if err != nil {
+		return fmt.Errorf("Packet is not valid base64: %w", err)
+	}
+	decoder, err := r.restoreDecoder()
+	if err != nil {
+		return err
+	}
+	defer decoder.Destroy()
+	decoded, decoderStatus := decoder.Decode(encoded)
+	if mte.StatusIsError(decoderStatus) {
+		return &statusError{action: "Decode", status: decoderStatus}
+	} else if decoderStatus != mte.Status_mte_status_success {
+		fmt.Fprintf(os.Stderr, "Decode warning (%v): %v\n",
+			mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
+	}
+	state := r.states[r.mode]
+	state.DecoderState = decoder.SaveStateB64()
+	r.states[r.mode] = state
+	//-----------------------------------------
+	// If the decoded is blank -- notify user
+	// otherwise display decoded message
+	//-----------------------------------------
+	if len(decoded) == 0 {
+		fmt.Fprint(os.Stderr, "Message was blank\n")
+	} else if isText(decoded) {
+		fmt.Fprintf(r.out, "Decoded Message: '%s'\n", decoded)
+	} else {
+		fmt.Fprintf(r.out, "Decoded Message (hex): %s\n", hex.EncodeToString(decoded))
+	}
+	return nil
+}
+
+/**
+ * Keeps the states of every mode in a named slot or writes
+ * them to a file
+ */
+func (r *repl) save(args string) error {
+	saved := savedState{
+		Mode:        r.mode,
+		FixedLength: r.fixedLength,
+		States:      copyStates(r.states),
+	}
+	kind, _, name := splitTarget(args)
+	if name == "" {
+		return errors.New("Usage: save <slot> or save file <path>")
+	}
+	if kind == "file" {
+		stateBytes, err := json.MarshalIndent(saved, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(name, stateBytes, stateFileMode)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "States written to %s\n", name)
+		return nil
+	}
+	r.slots[name] = saved
+	fmt.Fprintf(r.out, "States kept in slot %s\n", name)
+	return nil
+}
+
+/**
+ * Goes back to the states of a named slot or a file
+ * The mode is restored with the states. A file can also be
+ * the state of a peer, base64 (SaveStateB64) or binary
+ * (SaveState), it goes into the Decoder of the current mode
+ * unless encoder is given.
+ */
+func (r *repl) restore(args string) error {
+	kind, side, name := splitTarget(args)
+	if name == "" {
+		return fmt.Errorf("Usage: restore <slot> or restore file [encoder|decoder] <path>, slots: %s", r.slotNames())
+	}
+	var saved savedState
+	if kind == "file" {
+		stateBytes, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		saved, err = readStateFile(stateBytes, side, r.mode)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", name, err)
+		}
+	} else {
+		var ok bool
+		saved, ok = r.slots[name]
+		if !ok {
+			return fmt.Errorf("No slot %s, slots: %s", name, r.slotNames())
+		}
+	}
+	//------------------------------------------------
+	// Make sure the states restore before using them
+	//------------------------------------------------
+	previousMode, previousFixedLength, previousStates := r.mode, r.fixedLength, copyStates(r.states)
+	err := r.apply(saved)
+	if err == nil {
+		err = r.status()
+	}
+	if err != nil {
+		r.mode, r.fixedLength, r.states = previousMode, previousFixedLength, previousStates
+		return err
+	}
+	return nil
+}
+
+/**
+ * Takes over the states of a slot or a file
+ * A single pair of states only replaces the mode it is for
+ */
+func (r *repl) apply(saved savedState) error {
+	if saved.Mode != "" {
+		r.mode = saved.Mode
+	}
+	if saved.FixedLength > 0 {
+		r.fixedLength = saved.FixedLength
+	}
+	if r.mode == modeFlen && r.fixedLength <= 0 {
+		return errors.New("FLEN states need a fixed length larger than 0.")
+	}
+	if len(saved.States) > 0 {
+		r.states = copyStates(saved.States)
+		if _, ok := r.states[r.mode]; !ok {
+			return fmt.Errorf("No %s states to restore.", r.mode)
+		}
+		return nil
+	}
+	if saved.EncoderState == "" && saved.DecoderState == "" {
+		return errors.New("No Encoder or Decoder state to restore.")
+	}
+	state := r.states[r.mode]
+	if saved.EncoderState != "" {
+		state.EncoderState = saved.EncoderState
+	}
+	if saved.DecoderState != "" {
+		state.DecoderState = saved.DecoderState
+	}
+	r.states[r.mode] = state
+	return nil
+}
+
+/**
+ * Shows the mode and the reseed counters of the Encoder
+ * and Decoder against the reseed interval of the DRBG
+ */
+func (r *repl) status() error {
+	encoder, err := r.restoreEncoder()
+	if err != nil {
+		return err
+	}
+	defer encoder.Destroy()
+	decoder, err := r.restoreDecoder()
+	if err != nil {
+		return err
+	}
+	defer decoder.Destroy()
+
+	fmt.Fprintf(r.out, "Mode: %s", r.mode)
+	if r.mode == modeFlen {
+		fmt.Fprintf(r.out, " (fixed length %d bytes)", r.fixedLength)
+	}
+	fmt.Fprintf(r.out, "\nDRBG: %s\n", mte.GetDrbgsName(encoder.GetDrbg()))
+	if r.seed.entropy != nil {
+		fmt.Fprintf(r.out, "Instantiated with fixed entropy, nonce %d and personalization %q\n",
+			r.seed.nonce, r.seed.personal)
+	}
+	interval := mte.GetDrbgsReseedInterval(encoder.GetDrbg())
+	printCounter(r.out, "Encoder", encoder.GetReseedCounter(), interval)
+	printCounter(r.out, "Decoder", decoder.GetReseedCounter(), interval)
+	return nil
+}
+
+/**
+ * Instantiates every mode again, with random entropy or with
+ * the entropy, nonce and personalization of a peer. The nonce
+ * and personalization stay the same when they are left out.
+ * Packets encoded before the reseed can no longer be decoded
+ */
+func (r *repl) reseed(args string) error {
+	seed := seed{nonce: r.seed.nonce, personal: r.seed.personal}
+	fields := strings.SplitN(args, " ", 3)
+	if args != "" {
+		var err error
+		seed.entropy, err = parseMessage(fields[0])
+		if err != nil {
+			return fmt.Errorf("Invalid entropy: %w", err)
+		}
+		if len(seed.entropy) == 0 {
+			return errors.New("Entropy can not be empty.")
+		}
+	}
+	if len(fields) > 1 {
+		var err error
+		seed.nonce, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid nonce %q.", fields[1])
+		}
+	}
+	if len(fields) > 2 {
+		seed.personal = strings.TrimSpace(fields[2])
+	}
+	err := r.instantiateAll(seed)
+	if err != nil {
+		return err
+	}
+	if seed.entropy == nil {
+		fmt.Fprint(r.out, "Encoders and Decoders instantiated with new entropy.\n")
+	} else {
+		fmt.Fprintf(r.out, "Encoders and Decoders instantiated with fixed entropy, nonce %d and personalization %q.\n",
+			seed.nonce, seed.personal)
+	}
+	return nil
+}
+
+/**
+ * Instantiates the Encoder and Decoder of every mode, each with
+ * its own constructor. FLEN is left out until a fixed length
+ * is known. The states only change when every mode instantiated.
+ */
+func (r *repl) instantiateAll(seed seed) error {
+	states := make(map[string]modeState)
+	for _, mode := range []string{modeCore, modeMke, modeFlen} {
+		if mode == modeFlen && r.fixedLength <= 0 {
+			continue
+		}
+		state, err := instantiate(mode, r.fixedLength, seed)
+		if err != nil {
+			return err
+		}
+		states[mode] = state
+	}
+	r.seed, r.states, r.lastEncoded = seed, states, ""
+	return nil
+}
+
+/**
+ * Switches to the Encoder and Decoder of a mode, FLEN needs a
+ * fixed length unless one was set before. FLEN is instantiated
+ * the first time it is used or when the fixed length changes.
+ */
+func (r *repl) setMode(args string) error {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		return errors.New("Usage: mode core|mke|flen [fixed length]")
+	}
+	switch fields[0] {
+	case modeCore, modeMke:
+		r.mode = fields[0]
+	case modeFlen:
+		fixedLength := r.fixedLength
+		if len(fields) > 1 {
+			var err error
+			fixedLength, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return fmt.Errorf("Invalid fixed length %q.", fields[1])
+			}
+		}
+		if fixedLength <= 0 {
+			return errors.New("FLEN needs a fixed length larger than 0: mode flen <length>")
+		}
+		if _, ok := r.states[modeFlen]; !ok || fixedLength != r.fixedLength {
+			state, err := instantiate(modeFlen, fixedLength, r.seed)
+			if err != nil {
+				return err
+			}
+			r.states[modeFlen] = state
+		}
+		r.mode, r.fixedLength = modeFlen, fixedLength
+	default:
+		return fmt.Errorf("Unknown mode %q, use core, mke or flen.", fields[0])
+	}
+	fmt.Fprintf(r.out, "Using %s mode\n", r.mode)
+	return nil
+}
+
+func (r *repl) restoreEncoder() (out replEncoder, err error) {
+	encoder := newEncoder(r.mode, r.fixedLength)
+	encoderStatus := encoder.RestoreStateB64(r.states[r.mode].EncoderState)
+	if encoderStatus != mte.Status_mte_status_success {
+		encoder.Destroy()
+		return nil, &statusError{action: "Encoder restore", status: encoderStatus}
+	}
+	return encoder, nil
+}
+
+func (r *repl) restoreDecoder() (out replDecoder, err error) {
+	decoder := newDecoder(r.mode)
+	decoderStatus := decoder.RestoreStateB64(r.states[r.mode].DecoderState)
+	if decoderStatus != mte.Status_mte_status_success {
+		decoder.Destroy()
+		return nil, &statusError{action: "Decoder restore", status: decoderStatus}
+	}
+	return decoder, nil
+}
+
+/**
+ * Creates the Encoder of a mode with its own constructor
+ */
+func newEncoder(mode string, fixedLength int) replEncoder {
+	switch mode {
+	case modeMke:
+		return mte.NewMkeEncDef()
+	case modeFlen:
+		return mte.NewFlenEncDef(fixedLength)
+	}
+	return mte.NewEncDef()
+}
+
+/**
+ * Creates the Decoder of a mode
+ * FLEN packets are decoded with the Core Decoder
+ */
+func newDecoder(mode string) replDecoder {
+	if mode == modeMke {
+		return mte.NewMkeDecDef()
+	}
+	return mte.NewDecDef()
+}
+
+func (r *repl) slotNames() string {
+	if len(r.slots) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(r.slots))
+	for name := range r.slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+/**
+ * Parses a message, hex: and b64: messages are binary
+ */
+func parseMessage(args string) (out []byte, err error) {
+	switch {
+	case strings.HasPrefix(args, hexPrefix):
+		out, err = hex.DecodeString(strings.TrimPrefix(args, hexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("Message is not valid hex: %w", err)
+		}
+		return out, nil
+	case strings.HasPrefix(args, b64Prefix):
+		out, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(args, b64Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("Message is not valid base64: %w", err)
+		}
+		return out, nil
+	}
+	return []byte(args), nil
+}
+
+/**
+ * Splits "file [encoder|decoder] <path>" from a slot name
+ */
+func splitTarget(args string) (kind string, side string, name string) {
+	fields := strings.SplitN(args, " ", 2)
+	if len(fields) < 2 || strings.ToLower(fields[0]) != "file" {
+		return "slot", "", args
+	}
+	name = strings.TrimSpace(fields[1])
+	fields = strings.SplitN(name, " ", 2)
+	if len(fields) == 2 {
+		switch strings.ToLower(fields[0]) {
+		case "encoder", "decoder":
+			return "file", strings.ToLower(fields[0]), strings.TrimSpace(fields[1])
+		}
+	}
+	return "file", "", name
+}
+
+/**
+ * Reads a state file, either one written by save file or the
+ * state of a peer. A peer state is base64 (SaveStateB64) or
+ * binary (SaveState), side picks the Encoder or the Decoder,
+ * the Decoder when empty, and it is restored into mode.
+ */
+func readStateFile(stateBytes []byte, side string, mode string) (out savedState, err error) {
+	if len(stateBytes) == 0 {
+		return out, errors.New("The file is empty.")
+	}
+	if side == "" {
+		var saved savedState
+		if json.Unmarshal(stateBytes, &saved) == nil &&
+			(len(saved.States) > 0 || saved.EncoderState != "" || saved.DecoderState != "") {
+			return saved, nil
+		}
+	}
+	state := strings.TrimSpace(string(stateBytes))
+	if _, err := base64.StdEncoding.DecodeString(state); err != nil || state == "" {
+		state = base64.StdEncoding.EncodeToString(stateBytes)
+	}
+	out.Mode = mode
+	if side == "encoder" {
+		out.EncoderState = state
+	} else {
+		out.DecoderState = state
+	}
+	return out, nil
+}
+
+func copyStates(states map[string]modeState) map[string]modeState {
+	out := make(map[string]modeState, len(states))
+	for mode, state := range states {
+		out[mode] = state
+	}
+	return out
+}
+
+/**
+ * Reports if a decoded message can be shown as text
+ */
+func isText(message []byte) bool {
+	if !utf8.Valid(message) {
+		return false
+	}
+	for _, r := range string(message) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func printCounter(out io.Writer, name string, counter uint64, interval uint64) {
+	percent := 0.0
+	if interval > 0 {
+		percent = float64(counter) * 100 / float64(interval)
+	}
+	fmt.Fprintf(out, "%s reseed counter: %d of %d (%.4f%%)\n", name, counter, interval, percent)
+}
@@ -0,0 +1,89 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"fileUpload/mte"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHandshake"
+)
+
+//------------------------------------------------------------
+// The MTE Go package of this sample, mteCoder wraps it in the
+// same interfaces the toolkit uses. errors.Is matches the
+// mteCoder error for the statuses callers act on.
+var library = &mteCoder.Library[mte.Status, mte.Drbgs]{
+	Success:           mte.Status_mte_status_success,
+	IsError:           mte.StatusIsError,
+	StatusName:        mte.GetStatusName,
+	StatusDescription: mte.GetStatusDescription,
+	ReseedInterval:    mte.GetDrbgsReseedInterval,
+	Errors: map[mte.Status]error{
+		mte.Status_mte_status_token_does_not_exist:  mteCoder.ErrTokenDoesNotExist,
+		mte.Status_mte_status_seq_mismatch:          mteCoder.ErrSeqMismatch,
+		mte.Status_mte_status_seq_outside_window:    mteCoder.ErrSeqOutsideWindow,
+		mte.Status_mte_status_seq_async_replay:      mteCoder.ErrSeqAsyncReplay,
+		mte.Status_mte_status_drbg_seedlife_reached: mteCoder.ErrSeedLifeReached,
+	},
+}
+
+//--------------------------
+// MTE status as an error
+type StatusError = mteCoder.StatusError[mte.Status]
+
+/**
+ * Creates the MTE MKE Encoder using the agreed options
+ */
+func NewMkeEncoder(options mteHandshake.MteOptions) mteCoder.ChunkEncoder {
+	if options.IsDefault() {
+		return library.ChunkEncoder(mte.NewMkeEncDef())
+	}
+	return library.ChunkEncoder(mte.NewMkeEncOpt(mte.Drbgs(options.Drbg), options.TokBytes,
+		mte.Verifiers(options.Verifiers), mte.GetDefaultCipher(), mte.GetDefaultHash()))
+}
+
+/**
+ * Creates the MTE MKE Decoder using the agreed options
+ */
+func NewMkeDecoder(options mteHandshake.MteOptions) mteCoder.ChunkDecoder {
+	if options.IsDefault() {
+		return library.ChunkDecoder(mte.NewMkeDecDef())
+	}
+	return library.ChunkDecoder(mte.NewMkeDecOpt(mte.Drbgs(options.Drbg), options.TokBytes,
+		mte.Verifiers(options.Verifiers), mte.GetDefaultCipher(), mte.GetDefaultHash(),
+		options.TimeWindow, options.SequenceWindow))
+}
+
+/**
+ * Entropy size the MKE Encoder and Decoder need
+ */
+func entropyBytes(options mteHandshake.MteOptions) int {
+	if options.IsDefault() {
+		return mte.GetDrbgsEntropyMinBytes(mte.GetDefaultDrbg())
+	}
+	return mte.GetDrbgsEntropyMinBytes(mte.Drbgs(options.Drbg))
+}
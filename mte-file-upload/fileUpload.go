@@ -28,27 +28,39 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"eclypsesEcdh"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
 	"fileUpload/mte"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteUpload"
 
 	"github.com/google/uuid"
 )
 
 var encoderState string
 var decoderState string
-var maxSeed uint64
+
+//------------------------------------------
+// Http client shared by all calls to the API
+var httpClient = mteHttp.NewClient()
+
+//-----------------------------------------
+// MTE options agreed on during handshake
+var mteOptions mteHandshake.MteOptions
 
 const (
 	//--------------------
@@ -89,24 +101,12 @@ const (
 	errorCreatingDecoder         = 112
 	errorBase64Decoding          = 113
 	errorDecodingData            = 114
+	errorNegotiatingOptions      = 115
+	errorRestoringState          = 116
+	errorEncodingData            = 117
 	endProgram                   = 120
 )
 
-type HandshakeModel struct {
-	TimeStamp              string
-	ConversationIdentifier string
-	ClientEncoderPublicKey string
-	ClientDecoderPublicKey string
-}
-
-type ResponseModel[T any] struct {
-	Message      string
-	Success      bool
-	ResultCode   string
-	ExceptionUid string
-	Data         T
-}
-
 /**
  * Main function kicks off the ECDH Handshake
  */
@@ -117,13 +117,18 @@ func main() {
 	retcode := 0
 	defer func() { os.Exit(retcode) }()
 
+	//------------------------------------------
+	// Cancel outstanding calls when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	//--------------------
 	// Initialize client
 	clientId := uuid.New()
 
 	//------------------------
 	// Call Handshake Method
-	retcode, err := PerformHandshakeWithServer(clientId.String())
+	retcode, err := PerformHandshakeWithServer(ctx, clientId.String())
 	if err != nil {
 		fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
 		return
@@ -152,24 +157,13 @@ func main() {
 		}
 		//-----------------------
 		// Create MTE from state
-		encoder := mte.NewMkeEncDef()
+		encoder := NewMkeEncoder(mteOptions)
 		defer encoder.Destroy()
 		if useMte {
-			encoderStatus := encoder.RestoreStateB64(encoderState)
-			if encoderStatus != mte.Status_mte_status_success {
-				fmt.Fprintf(os.Stderr, "Encoder restore error (%v): %v\n",
-					mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-				retcode = int(encoderStatus)
-				return
-			}
-
-			//---------------------
-			// Initialize Chunking
-			encoderStatus = encoder.StartEncrypt()
-			if encoderStatus != mte.Status_mte_status_success {
-				fmt.Fprintf(os.Stderr, "MTE Encoder StartEncrypt error (%v): %v\n",
-					mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-				retcode = int(encoderStatus)
+			err = mteCoder.RestoreStateB64(encoder, encoderState)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				retcode = errorRestoringState
 				return
 			}
 		}
@@ -193,205 +187,102 @@ func main() {
 		//------------------------------------------------------------
 		// If we are using the MTE add additional length to totalSize
 		if useMte {
-			totalSize += int64(encoder.EncryptFinishBytes())
+			totalSize = mteUpload.EncryptedSize(encoder, totalSize)
 		}
-		//-------------------------
-		// Use pipe to pass request
+		//---------------------------------------------
+		// Use pipe to pass request, the file is
+		// encrypted into the pipe while it is sent
 		rd, wr := io.Pipe()
-		defer rd.Close()
-
+		encrypted := make(chan error, 1)
 		go func() {
-			defer wr.Close()
-			//-------------
-			// Write file
-			buf := make([]byte, chunkSize)
-			for {
-				n, err := file.Read(buf)
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						if useMte {
-							//-----------------------------
-							// End of the file reached
-							// Finish the chunking session
-							//-----------------------------
-							finishEncode, status := encoder.FinishEncrypt()
-							if status != mte.Status_mte_status_success {
-								fmt.Fprintf(os.Stderr, "Encode finish error (%v): %v\n",
-									mte.GetStatusName(status), mte.GetStatusDescription(status))
-							}
-							//-------------------------------------------------
-							// If there are bytes to write, write them to file
-							//-------------------------------------------------
-							if finishEncode != nil {
-								if _, err := wr.Write(finishEncode); err != nil {
-									fmt.Printf("Error trying to write to file %s, err: %s", fi.Name(), err)
-								}
-							}
-							//-------------------------------------
-							// Check if we have reached reseed max
-							currentSeed := float64(encoder.GetReseedCounter())
-							if currentSeed > (float64(maxSeed) * float64(reseedPercent)) {
-								//---------------------------
-								// Uninstantiate the Decoder
-								encoderStatus := encoder.Uninstantiate()
-								if encoderStatus != mte.Status_mte_status_success {
-									//--------------------------------------
-									// Handle Encoder uninstantiate failure
-									fmt.Fprintf(os.Stderr, "Encoder uninstantiate error (%v): %v\n",
-										mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-									retcode = int(encoderStatus)
-								}
-
-								//------------------------
-								// Call Handshake Method
-								// This also re-creates Encoder and Decoder
-								retcode, err := PerformHandshakeWithServer(clientId.String())
-								if err != nil {
-									fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
-								}
-							} else {
-								//--------------------
-								// Save state Encoder
-								encoderState = encoder.SaveStateB64()
-							}
-						}
-					}
-					break
-				}
-				//---------------------------------------
-				// If we are using MTE encrypt the chunk
-				if useMte {
-					if n < chunkSize {
-						buf = buf[:n]
-					}
-					//-----------------------------------------------------------
-					// Encrypt the chunk
-					encoderStatus := encoder.EncryptChunk(buf)
-					if encoderStatus != mte.Status_mte_status_success {
-						fmt.Fprintf(os.Stderr, "Encode error (%v): %v\n",
-							mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-						break
-					}
-				}
-				_, _ = wr.Write(buf[:n])
+			var err error
+			if useMte {
+				_, err = mteUpload.Encrypt(encoder, wr, file, chunkSize)
+			} else {
+				_, err = io.Copy(wr, file)
 			}
+			wr.CloseWithError(err)
+			encrypted <- err
 		}()
 		//--------------------------
 		// Construct request with rd
 		req, _ := http.NewRequest("POST", uri, rd)
 		req.Header.Set(clientIdHeader, clientId.String())
 		req.ContentLength = totalSize
-		//-----------------
-		// Process request
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		//--------------------------------------------
+		// Process request, the body is streamed from
+		// the pipe so it is only sent once
+		hrBytes, err := httpClient.Send(ctx, req)
+		rd.Close()
+		//-----------------------------------------------
+		// Save the Encoder state unless the server turned
+		// the upload down before decoding it, any other
+		// failed upload may have moved its Decoder forward
+		encryptErr := <-encrypted
+		if useMte && !mteUpload.NotDecoded(err) {
+			encoderState = mteCoder.SaveStateB64(encoder)
+		}
+		if encryptErr != nil {
+			fmt.Fprintf(os.Stderr, "Encode error: %v\n", encryptErr)
+			retcode = errorEncodingData
+			return
+		}
 		if err != nil {
 			fmt.Println(err.Error())
 			retcode = errorReadingResponse
 			return
 		} else {
-			body := &bytes.Buffer{}
-			_, _ = body.ReadFrom(resp.Body)
-			defer resp.Body.Close()
-
 			//------------------------------------------
 			// Marshal json response to Response object
-			hrBytes, _ := ioutil.ReadAll(body)
-			var serverResponse ResponseModel[string]
-			json.Unmarshal(hrBytes, &serverResponse)
-			if !serverResponse.Success {
-				fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
+			serverResponse, err := mteHttp.DecodeResponse[string](hrBytes)
+			if err != nil {
+				fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
 				retcode = errorFromServer
 				return
 			}
 			var decodedText []byte
 			//-----------------------------------------------------
 			// Decode the response message if we are using the MTE
-			decoder := mte.NewMkeDecDef()
+			decoder := NewMkeDecoder(mteOptions)
 			defer decoder.Destroy()
 			if useMte {
 				//--------------------------------------------
 				// Base64 Decode server response  to []byte
-				encodedDatab64 := make([]byte, base64.StdEncoding.DecodedLen(len(serverResponse.Data)))
-				n, err := base64.StdEncoding.Decode(encodedDatab64, []byte(serverResponse.Data))
+				encodedData, err := base64.StdEncoding.DecodeString(serverResponse.Data)
 				if err != nil {
 					fmt.Println("Error base64 decode encoded data: " + err.Error() + " Code: " + strconv.Itoa(errorDecodingData))
 					retcode = errorDecodingData
 					return
 				}
-				encodedData := encodedDatab64[:n]
 				//----------------------------
 				// Restore the decoder state
-				decoderStatus := decoder.RestoreStateB64(decoderState)
-				if decoderStatus != mte.Status_mte_status_success {
-					fmt.Fprintf(os.Stderr, "Decoder restore error (%v): %v\n",
-						mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-					retcode = int(decoderStatus)
-					return
-				}
-
-				//---------------------
-				// Initialize Chunking
-				decoderStatus = decoder.StartDecrypt()
-				if decoderStatus != mte.Status_mte_status_success {
-					fmt.Fprintf(os.Stderr, "MTE Decoder startDecrypt error (%v): %v\n",
-						mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-					retcode = int(decoderStatus)
+				err = mteCoder.RestoreStateB64(decoder, decoderState)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					retcode = errorRestoringState
 					return
 				}
 				//-----------------------------------------------------------
 				// The response is going to be short don't need to loop
-				//-----------------------------------------------------------
-				// Decrypt the chunk
-				decodedData := decoder.DecryptChunk(encodedData)
-				if decodedData == nil {
-					fmt.Fprintf(os.Stderr, "Decode error.\n")
+				decodedText, err = mteUpload.Decrypt(decoder, encodedData)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Decode error: %v\n", err)
 					retcode = errorDecodingData
 					return
 				}
-				//-----------------------
-				// Finish Decode chunk
-				finishDecodeChunk, decoderStatus := decoder.FinishDecrypt()
-				if decoderStatus != mte.Status_mte_status_success {
-					//--------------------------------------------------------
-					// Decode finish decrypt unsuccessful and cannot continue
-					fmt.Fprintf(os.Stderr, "MTE Decoder finishDecrypt error (%v): %v\n",
-						mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-					retcode = int(decoderStatus)
-					return
-				}
-				//--------------------------------------------------
-				// Check if there are additional bytes; if so, append
-				if finishDecodeChunk != nil {
-					decodedText = append(decodedData[:], finishDecodeChunk[:]...)
-				} else {
-					decodedText = decodedData
-				}
+				decoderState = mteCoder.SaveStateB64(decoder)
 				//-------------------------------------
 				// Check if we have reached reseed max
-				currentSeed := float64(decoder.GetReseedCounter())
-				if currentSeed > (float64(maxSeed) * float64(reseedPercent)) {
-					//---------------------------
-					// Uninstantiate the Decoder
-					decoderStatus := decoder.Uninstantiate()
-					if decoderStatus != mte.Status_mte_status_success {
-						// Handle Decoder uninstantiate failure appropriately
-						fmt.Fprintf(os.Stderr, "Decoder uninstantiate error (%v): %v\n",
-							mte.GetStatusName(decoderStatus), mte.GetStatusDescription(decoderStatus))
-						retcode = int(decoderStatus)
-					}
-
+				if mteCoder.ReseedNeeded(encoder, reseedPercent) || mteCoder.ReseedNeeded(decoder, reseedPercent) {
 					//------------------------
 					// Call Handshake Method
 					// This also re-creates Encoder and Decoder
-					retcode, err := PerformHandshakeWithServer(clientId.String())
+					errorcode, err := PerformHandshakeWithServer(ctx, clientId.String())
 					if err != nil {
-						fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
+						fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
+						retcode = errorcode
+						return
 					}
-				} else {
-					//-------------------
-					// Save decode state
-					decoderState = decoder.SaveStateB64()
 				}
 			} else {
 				//-------------------------------
@@ -439,14 +330,16 @@ func main() {
  * Returns HandshakeResponse: encoderSharedSecret, decoderSharedSecret
  *
  */
-func PerformHandshakeWithServer(clientId string) (out int, err error) {
+func PerformHandshakeWithServer(ctx context.Context, clientId string) (out int, err error) {
 
 	fmt.Println("Performing handshake for client: " + clientId)
 
 	//--------------------------------------------
 	// Set default return and response parameters
-	var handshakeModel HandshakeModel
-	handshakeModel.ConversationIdentifier = clientId
+	// Offer the MTE options this client supports
+	offer := mteHandshake.DefaultCapabilities(int(mte.GetDefaultDrbg()), mte.GetDefaultTokBytes(),
+		int(mte.GetDefaultVerifiers()), mteHandshake.ModeMke)
+	handshakeModel := mteHandshake.NewRequest(clientId, offer)
 
 	//----------------------------------------------
 	// Create eclypses ECDH for Encoder and Decoder
@@ -480,7 +373,7 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	}
 	//----------------------------------
 	// Make Http and get return string
-	hsModelString, errorcode, err := MakeHttpCall(restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
+	hsModelString, errorcode, err := MakeHttpCall(ctx, restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
 	if err != nil {
 		fmt.Println("Error making Http call: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
 		return errorcode, err
@@ -488,11 +381,17 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	//-----------------------------
 	// Marshal json back to class
 	hrBytes := []byte(hsModelString)
-	var serverResponse ResponseModel[HandshakeModel]
-	json.Unmarshal(hrBytes, &serverResponse)
-	if !serverResponse.Success {
-		fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
-		return errorFromServer, errors.New(serverResponse.Message)
+	serverResponse, err := mteHttp.DecodeResponse[mteHandshake.HandshakeResponse](hrBytes)
+	if err != nil {
+		fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
+		return errorFromServer, err
+	}
+	//-------------------------------------------
+	// Check the MTE options the server agreed to
+	mteOptions, err = mteHandshake.Accept(offer, serverResponse.Data)
+	if err != nil {
+		fmt.Println("Error negotiating MTE options: " + err.Error() + " Code: " + strconv.Itoa(errorNegotiatingOptions))
+		return errorNegotiatingOptions, err
 	}
 
 	//--------------------------------------------
@@ -551,12 +450,12 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 
 	//---------------------------------
 	// Create MTE Encoder and Decoder
-	retcode, err := CreateMteEncoder(serverResponse.Data.TimeStamp, clientId, enSSBytes)
+	retcode, err := CreateMteEncoder(serverResponse.Data.TimeStamp, clientId, mteEntropy.NewEcdhProvider(enSSBytes))
 	if err != nil {
 		fmt.Println("Error creating Encoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingEncoder))
 		return retcode, err
 	}
-	retcode, err = CreateMteDecoder(serverResponse.Data.TimeStamp, clientId, deSSBytes)
+	retcode, err = CreateMteDecoder(serverResponse.Data.TimeStamp, clientId, mteEntropy.NewEcdhProvider(deSSBytes))
 	if err != nil {
 		fmt.Println("Error creating Decoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingDecoder))
 		return retcode, err
@@ -565,70 +464,66 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	return 0, nil
 }
 
-func CreateMteEncoder(timestamp string, clientId string, encoderEntropy []byte) (out int, err error) {
-	encoder := mte.NewMkeEncDef()
+func CreateMteEncoder(timestamp string, clientId string, encoderEntropy mteEntropy.Provider) (out int, err error) {
+	encoder := NewMkeEncoder(mteOptions)
 	defer encoder.Destroy()
-
-	//----------------------------
-	// Parse nonce from timestamp
-	nonce, err := strconv.ParseUint(timestamp, 10, 64)
+	code, err := instantiate(encoder, timestamp, clientId, encoderEntropy)
 	if err != nil {
-		panic(err)
-	}
-
-	//--------------------
-	// Initialize Encoder
-	//--------------------
-	encoder.SetEntropy(encoderEntropy)
-	encoder.SetNonceInt(nonce)
-	status := encoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("encoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
-	}
-	//-------------------------------
-	// Get the MTE max seed interval
-	if maxSeed <= 0 {
-		maxSeed = mte.GetDrbgsReseedInterval(encoder.GetDrbg())
+		fmt.Fprintf(os.Stderr, "Encoder %v\n", err)
+		return code, err
 	}
 	//--------------------
 	// Save Encoder state
-	encoderState = encoder.SaveStateB64()
-
+	encoderState = mteCoder.SaveStateB64(encoder)
 	return 0, nil
 }
 
-func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte) (out int, err error) {
-	decoder := mte.NewMkeEncDef()
+func CreateMteDecoder(timestamp string, clientId string, decoderEntropy mteEntropy.Provider) (out int, err error) {
+	decoder := NewMkeDecoder(mteOptions)
 	defer decoder.Destroy()
+	code, err := instantiate(decoder, timestamp, clientId, decoderEntropy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decoder %v\n", err)
+		return code, err
+	}
+	decoderState = mteCoder.SaveStateB64(decoder)
+	return 0, nil
+}
 
+/**
+ * Instantiates an Encoder or Decoder
+ * The nonce is the timestamp of the handshake response,
+ * the client ID is the personalization string
+ */
+func instantiate(seeder mteCoder.Seeder, timestamp string, clientId string, entropy mteEntropy.Provider) (out int, err error) {
 	//----------------------------
 	// Parse nonce from timestamp
 	nonce, err := strconv.ParseUint(timestamp, 10, 64)
 	if err != nil {
-		panic(err)
+		return errorFromServer, err
 	}
-
-	//--------------------
-	// Initialize Decoder
-	//--------------------
-	decoder.SetEntropy(decoderEntropy)
-	decoder.SetNonceInt(nonce)
-	status := decoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("decoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
+	err = mteEntropy.Set(entropy, entropyBytes(mteOptions), seeder)
+	if err != nil {
+		return errorCreatingSS, err
+	}
+	seeder.SetNonceInt(nonce)
+	err = seeder.InstantiateStr(clientId)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return int(statusErr.Status), err
+		}
+		return errorCreatingEncoder, err
 	}
-
-	decoderState = decoder.SaveStateB64()
 	return 0, nil
 }
 
 /**
  * Makes Http Call
+ * Uses the shared Http client, it retries failed calls when
+ * that is safe and checks the status code before we decode
  *
+ * ctx: cancels the call and any retries
  * route: Route to make the Http call
  * connectionMethod: POST OR GET
  * clientId: clientId string
@@ -638,55 +533,41 @@ func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte)
  * Returns a json string of what server sends back
  *
  */
-func MakeHttpCall(route string,
+func MakeHttpCall(ctx context.Context,
+	route string,
 	connectionMethod string,
 	clientId string,
 	contentType string,
 	payload string) (out string, retcode int, err error) {
-	//--------------------
-	// Set return string
-	var returnString string
-	//------------------------------------
-	// If this is a POST request do this
-	if strings.ToUpper(connectionMethod) == "POST" {
-		responseBody := bytes.NewBuffer([]byte(payload))
-		resp, err := http.Post(route, contentType, responseBody)
-		if err != nil {
-			fmt.Println("An Error Occured %v", err)
-			return "", errorHttpPost, err
-		}
-		defer resp.Body.Close()
-		//-----------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-		//------------------------------------
-		// If this is a GET request do this
-	} else if strings.ToUpper(connectionMethod) == "GET" {
-		resp, err := http.Get(route)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorHttpGet, err
-		}
-		//------------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-	} else {
+	//--------------------------------
+	// Only POST and GET are supported
+	method := strings.ToUpper(connectionMethod)
+	if method != "POST" && method != "GET" {
 		fmt.Println("Invalid connection request")
 		return "", errorInvalidConnectionMethod, errors.New("invalid connection request")
 	}
-	return returnString, 0, nil
+	request := mteHttp.Request{
+		Method:   method,
+		Url:      route,
+		ClientId: clientId,
+	}
+	if method == "POST" {
+		request.ContentType = contentType
+		request.Body = []byte(payload)
+	}
+	//-------------------------------
+	// Make the call and get the body
+	body, err := httpClient.Do(ctx, request)
+	if err != nil {
+		fmt.Println(err.Error())
+		var statusErr *mteHttp.StatusError
+		if errors.As(err, &statusErr) {
+			return string(statusErr.Body), errorFromServer, err
+		}
+		if method == "POST" {
+			return "", errorHttpPost, err
+		}
+		return "", errorHttpGet, err
+	}
+	return string(body), 0, nil
 }
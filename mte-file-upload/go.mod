@@ -2,4 +2,9 @@ module fileUpload
 
 go 1.18
 
-require github.com/google/uuid v1.3.0
+require (
+	github.com/google/uuid v1.3.0
+	mteToolkit v0.0.0
+)
+
+replace mteToolkit => ../mte-toolkit
@@ -19,6 +19,7 @@ import (
 	"strconv"
 
 	"goJail/mte"
+	"mteToolkit/mteEntropy"
 )
 
 type JailRetain struct {
@@ -128,9 +129,16 @@ func doMain() int {
 		// encoder should be done on device side
 		encoder.SetNonceInt(nonce)
 
-		// Set the entropy. Instantiate.
-		entropy8 := []byte(entropy)
-		decoder.SetEntropy(entropy8)
+		// Set the entropy. The device must use the same entropy,
+		// so this sample uses the fixed entropy that was entered.
+		err := mteEntropy.Set(mteEntropy.NewInsecureFixedTestProvider([]byte(entropy)),
+			mte.GetDrbgsEntropyMinBytes(drbg), decoder, encoder)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ENTROPY ERROR: %v", err)
+			return 1
+		}
+
+		// Instantiate.
 		status := decoder.InstantiateStr(personal)
 		if status != mte.Status_mte_status_success {
 			fmt.Fprintf(os.Stderr, "INSTANTIATE ERROR: %v",
@@ -138,9 +146,6 @@ func doMain() int {
 			return int(status)
 		}
 
-		// refill entropy for encoder
-		entropyEn := []byte(entropy)
-		encoder.SetEntropy(entropyEn)
 		encoderStatus := encoder.InstantiateStr(personal)
 		if encoderStatus != mte.Status_mte_status_success {
 			fmt.Fprintf(os.Stderr, "INSTANTIATE ERROR: %v",
@@ -167,6 +172,7 @@ func doMain() int {
 		var encoded string
 		if selection == "1" {
 			fmt.Println("Please enter encoded text:")
+			scanner.Scan()
 			encoded = scanner.Text()
 		} else {
 			fmt.Println("Please enter message to encode:")
@@ -2,9 +2,9 @@ module multipleClients
 
 go 1.18
 
-require github.com/google/uuid v1.3.0
-
 require (
-	github.com/cespare/xxhash/v2 v2.1.2 // indirect
-	github.com/coocood/freecache v1.2.1 // indirect
+	github.com/google/uuid v1.3.0
+	mteToolkit v0.0.0
 )
+
+replace mteToolkit => ../mte-toolkit
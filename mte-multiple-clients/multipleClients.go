@@ -28,27 +28,26 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	crRand "crypto/rand"
+	"context"
 	"eclypsesEcdh"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	mrand "math/rand"
-	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
 
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteStore"
 	"multipleClients/mte"
 
-	"github.com/coocood/freecache"
 	"github.com/google/uuid"
 )
 
@@ -60,7 +59,7 @@ const (
 	clientIdHeader = "x-client-id"
 	encPrefix      = "enc_"
 	decPrefix      = "dec_"
-	cacheExpire    = 0
+	optPrefix      = "opt_"
 	maxNumTrips    = 20
 
 	//---------------------------
@@ -101,42 +100,28 @@ const (
 	errorDecodingPK              = 123
 	errorMteLicense              = 124
 	errorParsingUint             = 125
-	errorNewCipher               = 126
-	errorNewGCM                  = 127
+	errorCreatingStore           = 126
+	errorNegotiatingOptions      = 128
 	endProgram                   = 130
 )
 
-type HandshakeModel struct {
-	TimeStamp              string
-	ConversationIdentifier string
-	ClientEncoderPublicKey string
-	ClientDecoderPublicKey string
-}
-
-type ResponseModel[T any] struct {
-	Message      string
-	Success      bool
-	ResultCode   string
-	ExceptionUid string
-	Data         T
-}
-
 //------------
 // Return code
 var retcode int
-var maxSeed uint64
 
 //---------------------------
 // Container for client Id's
 var clients map[int]string
 
-//--------------------------
-// Aes GCM cipher container
-var gcm cipher.AEAD
 
-//------------------------
-// FreeCache container
-var stateCache = freecache.NewCache(512 * 1024 * 1024)
+//------------------------------------------
+// Http client shared by all calls to the API
+var httpClient = mteHttp.NewClient()
+
+//------------------------------------------------
+// Encoder and Decoder states of every client, sealed
+// with a key that only lives as long as this process
+var stateStore mteStore.Store
 
 /**
  * Main function kicks off the handshake then multiple clients
@@ -147,11 +132,17 @@ func main() {
 	// Defer the exit so all other defer calls are called
 	retcode = 0
 	defer func() { os.Exit(retcode) }()
-	//---------------------
-	// Generate AES Cipher
-	err := GenerateAesCipher()
+
+	//------------------------------------------
+	// Cancel outstanding calls when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	//-------------------------------
+	// Create the sealed state store
+	err := CreateStateStore()
 	if err != nil {
 		fmt.Println(err)
+		retcode = errorCreatingStore
 		return
 	}
 
@@ -184,7 +175,7 @@ func main() {
 
 		//------------------------------------
 		// Perform handshake for this client
-		retcode, err := PerformHandshakeWithServer(i, clientId)
+		retcode, err := PerformHandshakeWithServer(ctx, i, clientId)
 		if err != nil {
 			fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
 			return
@@ -202,7 +193,7 @@ func main() {
 		//-----------------------------------------
 		// Send message to server for each client
 		for i := 1; i <= numClients; i++ {
-			go SendMultiToServer(clients[i], i, &wg)
+			go SendMultiToServer(ctx, clients[i], i, &wg)
 		}
 		//----------------------------------
 		// Wait till all tasks are complete
@@ -232,7 +223,7 @@ func main() {
 /**
  * Send MTE Encoded message to server
  */
-func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
+func SendMultiToServer(ctx context.Context, clientId string, clientNum int, wg *sync.WaitGroup) {
 
 	//-------------------------------------------------
 	// Make sure done is called when this if finsihed
@@ -240,63 +231,24 @@ func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
 	//--------------------------------------------
 	// Get random number of times to send messages
 	randNum := mrand.Intn(maxNumTrips-1) + 1
-	//-----------------
-	// Create Encoder
-	encoder := mte.NewEncDef()
-	defer encoder.Destroy()
-	//--------------------------------------------------
-	// Get the Encoder state from the cache and decrypt
-	encoderState, err := stateCache.Get([]byte(encPrefix + clientId))
-	nonceSize := gcm.NonceSize()
-	if len(encoderState) < nonceSize {
-		fmt.Println(err)
-		retcode = errorRetrievingState
-		return
-	}
-
-	aesNonce, encoderState := encoderState[:nonceSize], encoderState[nonceSize:]
-	decryptedState, err := gcm.Open(nil, aesNonce, encoderState, nil)
+	//-------------------------------------------------
+	// Get the MTE options agreed on for this client
+	options, err := GetClientOptions(clientId)
 	if err != nil {
-		fmt.Println(err)
-		retcode = errorDecryptingState
-		return
-	}
-	//---------------------------
-	// Restore the Encoder state
-	encoderStatus := encoder.RestoreState(decryptedState)
-	if encoderStatus != mte.Status_mte_status_success {
-		errorMessage := "Encoder restore error (" + mte.GetStatusName(encoderStatus) + ", " + mte.GetStatusDescription(encoderStatus) + ")"
-		fmt.Println(errorMessage)
-		retcode = errorRestoringState
-		return
-	}
-	//------------------------
-	// Create the MTE Decoder
-	decoder := mte.NewDecDef()
-	defer decoder.Destroy()
-	//-----------------------------------
-	// Get the Decoder state and decrypt
-	decoderState, err := stateCache.Get([]byte(decPrefix + clientId))
-	nonceSize = gcm.NonceSize()
-	if len(decoderState) < nonceSize {
 		fmt.Println(err)
 		retcode = errorRetrievingState
 		return
 	}
-
-	aesNonce, decoderState = decoderState[:nonceSize], decoderState[nonceSize:]
-	decryptedState, err = gcm.Open(nil, aesNonce, decoderState, nil)
+	//-------------------------------------------
+	// Create the Encoder and Decoder and restore
+	// their states from the store
+	encoder := NewCoreEncoder(options)
+	defer encoder.Destroy()
+	decoder := NewCoreDecoder(options)
+	defer decoder.Destroy()
+	err = RestoreStates(clientId, encoder, decoder)
 	if err != nil {
 		fmt.Println(err)
-		retcode = errorDecryptingState
-		return
-	}
-	//---------------------------
-	// Restore the Decoder state
-	decoderStatus := decoder.RestoreState(decryptedState)
-	if decoderStatus != mte.Status_mte_status_success {
-		errorMessage := "Decoder restore error (" + mte.GetStatusName(decoderStatus) + ", " + mte.GetStatusDescription(decoderStatus) + ")"
-		fmt.Println(errorMessage)
 		retcode = errorRestoringState
 		return
 	}
@@ -308,16 +260,15 @@ func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
 		message := "Hello from client " + strconv.Itoa(clientNum) + " : " + clientId + " for the " + strconv.Itoa(i) + " time"
 		//----------------
 		// Encode message
-		encoded, encoderStatus := encoder.EncodeStrB64(message)
-		if encoderStatus != mte.Status_mte_status_success {
-			errorMessage := "Encode error " + mte.GetStatusName(encoderStatus) + " , " + mte.GetStatusDescription(encoderStatus)
-			fmt.Println(errorMessage)
+		encoded, err := mteCoder.EncodeStrB64(encoder, message)
+		if err != nil {
+			fmt.Println(err)
 			retcode = errorEncodingData
 			return
 		}
 		//------------------------------------
 		// Make Http Call to send to server
-		hsModelString, errorcode, err := MakeHttpCall(restAPIName+multiClientRoute, "POST", clientId, textContent, encoded)
+		hsModelString, errorcode, err := MakeHttpCall(ctx, restAPIName+multiClientRoute, "POST", clientId, textContent, encoded)
 		if err != nil {
 			errorMessage := "Error making Http call: " + err.Error() + " Code: " + strconv.Itoa(errorcode)
 			fmt.Println(errorMessage)
@@ -328,20 +279,18 @@ func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
 		//-----------------------------
 		// Marshal json back to class
 		hrBytes := []byte(hsModelString)
-		var serverResponse ResponseModel[string]
-		json.Unmarshal(hrBytes, &serverResponse)
-		if !serverResponse.Success {
-			errorMessage := "Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer)
+		serverResponse, err := mteHttp.DecodeResponse[string](hrBytes)
+		if err != nil {
+			errorMessage := "Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer)
 			fmt.Println(errorMessage)
-			retcode = errorMarshalJson
+			retcode = errorFromServer
 			return
 		}
 		//-----------------------
 		// Decode return message
-		decodedMessage, decoderStatus := decoder.DecodeStrB64(serverResponse.Data)
-		if mte.StatusIsError(decoderStatus) {
-			errorMessage := "Decode error " + mte.GetStatusName(decoderStatus) + " , " + mte.GetStatusDescription(decoderStatus)
-			fmt.Println(errorMessage)
+		decodedMessage, err := mteCoder.DecodeStrB64(decoder, serverResponse.Data)
+		if err != nil {
+			fmt.Println(err)
 			retcode = errorDecodingData
 			return
 		}
@@ -349,77 +298,31 @@ func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
 		// Print out message received from server
 		fmt.Println("Received '" + decodedMessage + "' from multi-client server.")
 
-		//-------------------------------
-		// Check current reseed interval currentSeed float64
-		currentSeed := float64(encoder.GetReseedCounter())
-		if currentSeed > (float64(maxSeed) * float64(reseedPercent)) {
-			// Uninstantiate the Decoder
-			encoderStatus := encoder.Uninstantiate()
-			if encoderStatus != mte.Status_mte_status_success {
-				// Handle Encoder uninstantiate failure
-				fmt.Fprintf(os.Stderr, "Encoder uninstantiate error (%v): %v\n",
-					mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-				retcode = int(encoderStatus)
+		//----------------------------------------------
+		// Do a new handshake before the seed runs out
+		// and carry on with the new Encoder and Decoder
+		if mteCoder.ReseedNeeded(encoder, reseedPercent) || mteCoder.ReseedNeeded(decoder, reseedPercent) {
+			errorcode, err := PerformHandshakeWithServer(ctx, clientNum, clientId)
+			if err != nil {
+				fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
+				retcode = errorcode
+				return
 			}
-
-			//------------------------
-			// Call Handshake Method
-			// This also re-creates Encoder and Decoder
-			retcode, err := PerformHandshakeWithServer(i, clientId)
+			err = RestoreStates(clientId, encoder, decoder)
 			if err != nil {
-				fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
+				fmt.Println(err)
+				retcode = errorRestoringState
+				return
 			}
 		}
 	}
-	//--------------------
-	// Save Encoder state
-	encoderState = encoder.SaveState()
-	//-------------------------
-	// Delete from cache first
-	stateCache.Del([]byte(encPrefix + clientId))
-	//------------------------------------
-	// Encrypt state to put back in cache
-	// Creates a new byte array the size of the nonce
-	aesNonce = make([]byte, gcm.NonceSize())
-	//-----------------------------------------------------
-	// Populates our nonce with a cryptographically secure
-	// Random sequence
-	if _, err = io.ReadFull(crRand.Reader, aesNonce); err != nil {
-		fmt.Println(err)
-		retcode = errorEncryptingState
-		return
-	}
-	//---------------------------------------
-	// Seal/Encrpyt the actual Encoder State
-	encryptedState := gcm.Seal(aesNonce, aesNonce, encoderState, nil)
-	//-------------------------------------------
-	// Update cache with encrypted Encoder State
-	stateCache.Set([]byte(encPrefix+clientId), encryptedState, cacheExpire)
-
-	//---------------------
-	// Save Decoder state
-	decoderState = decoder.SaveState()
-	//-------------------------
-	// Delete from cache first
-	stateCache.Del([]byte(decPrefix + clientId))
-	//------------------------------------
-	// Encrypt state to put back in cache
-	// Creates a new byte array the size of the nonce
-	aesNonce = make([]byte, gcm.NonceSize())
-	//-----------------------------------------------------
-	// Populates our nonce with a cryptographically secure
-	// Random sequence
-	if _, err = io.ReadFull(crRand.Reader, aesNonce); err != nil {
+	//----------------------------------------
+	// Put the states back for the next round
+	err = SaveStates(clientId, encoder, decoder)
+	if err != nil {
 		fmt.Println(err)
 		retcode = errorEncryptingState
-		return
 	}
-	//---------------------------------------
-	// Seal/Encrpyt the actual Decoder State
-	encryptedState = gcm.Seal(aesNonce, aesNonce, decoderState, nil)
-	//-------------------------------------------
-	// Update cache with encrypted Encoder State
-	stateCache.Set([]byte(decPrefix+clientId), encryptedState, cacheExpire)
 }
 
 /**
@@ -433,14 +336,16 @@ func SendMultiToServer(clientId string, clientNum int, wg *sync.WaitGroup) {
  * Returns HandshakeResponse: encoderSharedSecret, decoderSharedSecret
  *
  */
-func PerformHandshakeWithServer(num int, clientId string) (out int, err error) {
+func PerformHandshakeWithServer(ctx context.Context, num int, clientId string) (out int, err error) {
 
 	fmt.Println("Performing handshake for client: " + strconv.FormatInt(int64(num), 10) + " ID: " + clientId)
 
 	//--------------------------------------------
 	// Set default return and response parameters
-	var handshakeModel HandshakeModel
-	handshakeModel.ConversationIdentifier = clientId
+	// Offer the MTE options this client supports
+	offer := mteHandshake.DefaultCapabilities(int(mte.GetDefaultDrbg()), mte.GetDefaultTokBytes(),
+		int(mte.GetDefaultVerifiers()), mteHandshake.ModeCore)
+	handshakeModel := mteHandshake.NewRequest(clientId, offer)
 
 	//----------------------------------------------
 	// Create eclypses ECDH for Encoder and Decoder
@@ -474,7 +379,7 @@ func PerformHandshakeWithServer(num int, clientId string) (out int, err error) {
 	}
 	//----------------------------------
 	// Make Http and get return string
-	hsModelString, errorcode, err := MakeHttpCall(restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
+	hsModelString, errorcode, err := MakeHttpCall(ctx, restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
 	if err != nil {
 		fmt.Println("Error making Http call: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
 		return errorcode, err
@@ -482,11 +387,24 @@ func PerformHandshakeWithServer(num int, clientId string) (out int, err error) {
 	//-----------------------------
 	// Marshal json back to class
 	hrBytes := []byte(hsModelString)
-	var serverResponse ResponseModel[HandshakeModel]
-	json.Unmarshal(hrBytes, &serverResponse)
-	if !serverResponse.Success {
-		fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
-		return errorFromServer, errors.New(serverResponse.Message)
+	serverResponse, err := mteHttp.DecodeResponse[mteHandshake.HandshakeResponse](hrBytes)
+	if err != nil {
+		fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
+		return errorFromServer, err
+	}
+	//-------------------------------------------
+	// Check the MTE options the server agreed to
+	options, err := mteHandshake.Accept(offer, serverResponse.Data)
+	if err != nil {
+		fmt.Println("Error negotiating MTE options: " + err.Error() + " Code: " + strconv.Itoa(errorNegotiatingOptions))
+		return errorNegotiatingOptions, err
+	}
+	//---------------------------------------------
+	// Keep the options so the states can be restored
+	err = SetClientOptions(clientId, options)
+	if err != nil {
+		fmt.Println("Error saving MTE options: " + err.Error() + " Code: " + strconv.Itoa(errorMarshalJson))
+		return errorMarshalJson, err
 	}
 
 	//--------------------------------------------
@@ -546,12 +464,12 @@ func PerformHandshakeWithServer(num int, clientId string) (out int, err error) {
 
 	//---------------------------------
 	// Create MTE Encoder and Decoder
-	retcode, err := CreateMteEncoder(serverResponse.Data.TimeStamp, clientId, enSSBytes)
+	retcode, err := CreateMteEncoder(serverResponse.Data.TimeStamp, clientId, mteEntropy.NewEcdhProvider(enSSBytes), options)
 	if err != nil {
 		fmt.Println("Error creating Encoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingEncoder))
 		return retcode, err
 	}
-	retcode, err = CreateMteDecoder(serverResponse.Data.TimeStamp, clientId, deSSBytes)
+	retcode, err = CreateMteDecoder(serverResponse.Data.TimeStamp, clientId, mteEntropy.NewEcdhProvider(deSSBytes), options)
 	if err != nil {
 		fmt.Println("Error creating Decoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingDecoder))
 		return retcode, err
@@ -561,116 +479,143 @@ func PerformHandshakeWithServer(num int, clientId string) (out int, err error) {
 }
 
 /**
- * Creates the MTE Encoder and saves encrypted version to Cache
+ * Creates the MTE Encoder and saves its state in the store
  */
-func CreateMteEncoder(timestamp string, clientId string, encoderEntropy []byte) (out int, err error) {
-	encoder := mte.NewEncDef()
+func CreateMteEncoder(timestamp string, clientId string, encoderEntropy mteEntropy.Provider, options mteHandshake.MteOptions) (out int, err error) {
+	encoder := NewCoreEncoder(options)
 	defer encoder.Destroy()
+	code, err := instantiate(encoder, timestamp, clientId, encoderEntropy, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encoder %v\n", err)
+		return code, err
+	}
+	err = stateStore.Set(encPrefix+clientId, encoder.SaveState())
+	if err != nil {
+		return errorEncryptingState, err
+	}
+	return 0, nil
+}
 
+/**
+ * Creates the MTE Decoder and saves its state in the store
+ */
+func CreateMteDecoder(timestamp string, clientId string, decoderEntropy mteEntropy.Provider, options mteHandshake.MteOptions) (out int, err error) {
+	decoder := NewCoreDecoder(options)
+	defer decoder.Destroy()
+	code, err := instantiate(decoder, timestamp, clientId, decoderEntropy, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decoder %v\n", err)
+		return code, err
+	}
+	err = stateStore.Set(decPrefix+clientId, decoder.SaveState())
+	if err != nil {
+		return errorEncryptingState, err
+	}
+	return 0, nil
+}
+
+/**
+ * Instantiates an Encoder or Decoder
+ * The nonce is the timestamp of the handshake response,
+ * the client ID is the personalization string
+ */
+func instantiate(seeder mteCoder.Seeder, timestamp string, clientId string, entropy mteEntropy.Provider, options mteHandshake.MteOptions) (out int, err error) {
 	//----------------------------
 	// Parse nonce from timestamp
 	nonce, err := strconv.ParseUint(timestamp, 10, 64)
 	if err != nil {
-		fmt.Println(err.Error())
-		retcode = errorParsingUint
-		return 0, err
+		return errorParsingUint, err
 	}
-
-	//--------------------
-	// Initialize Encoder
-	encoder.SetEntropy(encoderEntropy)
-	encoder.SetNonceInt(nonce)
-	status := encoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("encoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
+	err = mteEntropy.Set(entropy, entropyBytes(options), seeder)
+	if err != nil {
+		return errorCreatingSS, err
 	}
-
-	//-------------------------------
-	// Get the MTE max seed interval
-	if maxSeed <= 0 {
-		maxSeed = mte.GetDrbgsReseedInterval(encoder.GetDrbg())
-	}
-	//----------------------
-	// Get the Encoder state
-	encoderState := encoder.SaveState()
-	//-----------------------------------------------
-	// Encrypt the Encoder state
-	// Creates a new byte array the size of the nonce
-	aesNonce := make([]byte, gcm.NonceSize())
-	//-----------------------------------------------------
-	// Populates our nonce with a cryptographically secure
-	// Random sequence
-	if _, err = io.ReadFull(crRand.Reader, aesNonce); err != nil {
-		fmt.Println(err)
-		retcode = errorEncryptingState
-		return 0, err
+	seeder.SetNonceInt(nonce)
+	err = seeder.InstantiateStr(clientId)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return int(statusErr.Status), err
+		}
+		return errorCreatingEncoder, err
 	}
-	//-----------------------------
-	// Encrypt/Seal Encoder state
-	encryptedState := gcm.Seal(aesNonce, aesNonce, encoderState, nil)
-	//------------------------------
-	// Set encrypted state to Cache
-	stateCache.Set([]byte(encPrefix+clientId), encryptedState, cacheExpire)
-
 	return 0, nil
 }
 
 /**
- * Creates the MTE Decoder and saves encrypted version to Cache
+ * Creates the store of the client states
+ * The states are sealed with a new random key, they are only
+ * needed while this process runs
  */
-func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte) (out int, err error) {
-	decoder := mte.NewDecDef()
-	defer decoder.Destroy()
+func CreateStateStore() error {
+	key, err := mteStore.NewKey()
+	if err != nil {
+		return err
+	}
+	stateStore, err = mteStore.NewSealedStore(mteStore.NewMemoryStore(), key)
+	return err
+}
 
-	//----------------------------
-	// Parse nonce from timestamp
-	nonce, err := strconv.ParseUint(timestamp, 10, 64)
+/**
+ * Restores the Encoder and Decoder states of a client
+ */
+func RestoreStates(clientId string, encoder mteCoder.State, decoder mteCoder.State) error {
+	encoderState, err := stateStore.Get(encPrefix + clientId)
 	if err != nil {
-		fmt.Println(err.Error())
-		retcode = errorParsingUint
-		return 0, err
+		return err
 	}
+	err = encoder.RestoreState(encoderState)
+	if err != nil {
+		return err
+	}
+	decoderState, err := stateStore.Get(decPrefix + clientId)
+	if err != nil {
+		return err
+	}
+	return decoder.RestoreState(decoderState)
+}
 
-	//--------------------
-	// Initialize Decoder
-	//--------------------
-	decoder.SetEntropy(decoderEntropy)
-	decoder.SetNonceInt(nonce)
-	status := decoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("decoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
+/**
+ * Saves the Encoder and Decoder states of a client
+ */
+func SaveStates(clientId string, encoder mteCoder.State, decoder mteCoder.State) error {
+	err := stateStore.Set(encPrefix+clientId, encoder.SaveState())
+	if err != nil {
+		return err
 	}
-	//-------------------------
-	// Get the Decoder state
-	decoderState := decoder.SaveState()
-	//-------------------------------------------------
-	// Encrypt state before saving in cache
-	// Creates a new byte array the size of the nonce
-	aesNonce := make([]byte, gcm.NonceSize())
-	//------------------------------------------------------
-	// Populates our nonce with a cryptographically secure
-	// Random sequence
-	if _, err = io.ReadFull(crRand.Reader, aesNonce); err != nil {
-		fmt.Println(err)
-		retcode = errorEncryptingState
-		return 0, err
+	return stateStore.Set(decPrefix+clientId, decoder.SaveState())
+}
+
+/**
+ * Saves the agreed MTE options for a client in the store
+ */
+func SetClientOptions(clientId string, options mteHandshake.MteOptions) error {
+	optionBytes, err := json.Marshal(options)
+	if err != nil {
+		return err
 	}
-	//---------------------------------
-	// Encrypt/Seal the Decoder state
-	encryptedState := gcm.Seal(aesNonce, aesNonce, decoderState, nil)
-	//----------------------------------------------
-	// Set the encrypted Decoder state in the cache
-	stateCache.Set([]byte(decPrefix+clientId), encryptedState, cacheExpire)
-	return 0, nil
+	return stateStore.Set(optPrefix+clientId, optionBytes)
+}
+
+/**
+ * Gets the agreed MTE options for a client from the store
+ */
+func GetClientOptions(clientId string) (out mteHandshake.MteOptions, err error) {
+	var options mteHandshake.MteOptions
+	optionBytes, err := stateStore.Get(optPrefix + clientId)
+	if err != nil {
+		return options, err
+	}
+	err = json.Unmarshal(optionBytes, &options)
+	return options, err
 }
 
 /**
  * Makes Http Call
+ * Uses the shared Http client, it retries failed calls when
+ * that is safe and checks the status code before we decode
  *
+ * ctx: cancels the call and any retries
  * route: Route to make the Http call
  * connectionMethod: POST OR GET
  * clientId: clientId string
@@ -680,105 +625,41 @@ func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte)
  * Returns a json string of what server sends back
  *
  */
-func MakeHttpCall(route string,
+func MakeHttpCall(ctx context.Context,
+	route string,
 	connectionMethod string,
 	clientId string,
 	contentType string,
 	payload string) (out string, retcode int, err error) {
-	//--------------------
-	// Set return string
-	var returnString string
-	//------------------------------------
-	// If this is a POST request do this
-	if strings.ToUpper(connectionMethod) == "POST" {
-		client := &http.Client{}
-		bodyReader := bytes.NewReader([]byte(payload))
-		req, _ := http.NewRequest("POST", route, bodyReader)
-		//----------------------------------------
-		// Set client ID header and content-type
-		req.Header.Set(clientIdHeader, clientId)
-		req.Header.Set("Content-Type", contentType)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println(err.Error())
-			retcode = errorReadingResponse
-			return "", errorReadingResponse, err
-		}
-		defer resp.Body.Close()
-		//-----------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-		//------------------------------------
-		// If this is a GET request do this
-	} else if strings.ToUpper(connectionMethod) == "GET" {
-		resp, err := http.Get(route)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorHttpGet, err
-		}
-		//------------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-	} else {
+	//--------------------------------
+	// Only POST and GET are supported
+	method := strings.ToUpper(connectionMethod)
+	if method != "POST" && method != "GET" {
 		fmt.Println("Invalid connection request")
 		return "", errorInvalidConnectionMethod, errors.New("invalid connection request")
 	}
-	return returnString, 0, nil
-}
-
-/**
- * Generate random string for AES key
- */
-var chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890-"
-
-func shortID(length int) string {
-	ll := len(chars)
-	b := make([]byte, length)
-	mrand.Read(b) // generates len(b) random bytes
-	for i := 0; i < length; i++ {
-		b[i] = chars[int(b[i])%ll]
+	request := mteHttp.Request{
+		Method:   method,
+		Url:      route,
+		ClientId: clientId,
 	}
-	return string(b)
-}
-
-/**
- * Generate the AES Cipher container
- */
-func GenerateAesCipher() error {
-
-	//--------------------------------
-	// Generate random string for key
-	aesKey := []byte(shortID(32))
-	//-------------------------------------------------------
-	// Generate a new aes cipher using our 32 byte long key
-	c, err := aes.NewCipher(aesKey)
-	if err != nil {
-		fmt.Println(err)
-		retcode = errorNewCipher
-		return err
+	if method == "POST" {
+		request.ContentType = contentType
+		request.Body = []byte(payload)
 	}
-	//----------------
-	// Generate GCM
-	gcm, err = cipher.NewGCM(c)
+	//-------------------------------
+	// Make the call and get the body
+	body, err := httpClient.Do(ctx, request)
 	if err != nil {
-		fmt.Println(err)
-		retcode = errorNewGCM
-		return err
+		fmt.Println(err.Error())
+		var statusErr *mteHttp.StatusError
+		if errors.As(err, &statusErr) {
+			return string(statusErr.Body), errorFromServer, err
+		}
+		if method == "POST" {
+			return "", errorHttpPost, err
+		}
+		return "", errorHttpGet, err
 	}
-	return nil
+	return string(body), 0, nil
 }
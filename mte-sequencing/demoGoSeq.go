@@ -30,6 +30,7 @@ import (
 	"strings"
 
 	"goSeq/mte"
+	"mteToolkit/mteEntropy"
 )
 
 func doMain() int {
@@ -65,13 +66,26 @@ func doMain() int {
   encoder := mte.NewEncDef()
   defer encoder.Destroy()
 
-  // Create all-zero entropy for this demo. The nonce will also be set to 0.
-  // This should never be done in real applications.
-  entropyBytes := mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg())
-  entropy := make([]byte, entropyBytes)
+  // Create decoders with different sequence windows.
+  decoderV := mte.NewDecWin(0, 0);
+  decoderF := mte.NewDecWin(0, 2);
+  decoderA := mte.NewDecWin(0, -2);
+  defer decoderV.Destroy()
+  defer decoderF.Destroy()
+  defer decoderA.Destroy()
+
+  // They all run in this demo, so they share random entropy from one
+  // provider. Real applications get it from the handshake. The nonce
+  // is set to 0.
+  err := mteEntropy.Set(mteEntropy.RandomProvider{},
+    mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()),
+    encoder, decoderV, decoderF, decoderA)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Entropy error: %v\n", err)
+    return 1
+  }
 
   // Instantiate the encoder.
-  encoder.SetEntropy(entropy)
   encoder.SetNonceInt(0)
   status = encoder.InstantiateStr(personal)
   if status != mte.Status_mte_status_success {
@@ -92,24 +106,13 @@ func doMain() int {
     fmt.Printf("Encode #%v: %v -> %v\n", i, inputs[i], encodings[i]);
   }
 
-  // Create decoders with different sequence windows.
-  decoderV := mte.NewDecWin(0, 0);
-  decoderF := mte.NewDecWin(0, 2);
-  decoderA := mte.NewDecWin(0, -2);
-  defer decoderV.Destroy()
-  defer decoderF.Destroy()
-  defer decoderA.Destroy()
-
   // Instantiate the decoders.
-  decoderV.SetEntropy(entropy)
   decoderV.SetNonceInt(0)
   status = decoderV.InstantiateStr(personal)
   if status == mte.Status_mte_status_success {
-    decoderF.SetEntropy(entropy)
     decoderF.SetNonceInt(0)
     status = decoderF.InstantiateStr(personal)
     if status == mte.Status_mte_status_success {
-      decoderA.SetEntropy(entropy)
       decoderA.SetNonceInt(0)
       status = decoderA.InstantiateStr(personal)
     }
@@ -1,2 +1,7 @@
 module goSeq
 
+go 1.18
+
+require mteToolkit v0.0.0
+
+replace mteToolkit => ../mte-toolkit
@@ -28,39 +28,59 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"eclypsesEcdh"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
 	"mteSwitching/mte"
+	"mteToolkit/mteAuth"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteMode"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteSwitch"
+	"mteToolkit/mteUpload"
 
 	"github.com/google/uuid"
 )
 
-//--------------------------------------
-// Store the Encoder and Decoder states
-var encoderState string
-var decoderState string
-var maxSeed uint64
+//-----------------------------------------------------
+// Store the Encoder and Decoder states of each mode
+// With mode switching Core and MKE are instantiated with
+// their own personalization string so they never share a
+// state. A legacy server keeps one state for both, the
+// session then only keeps the MKE states
+var session *mteSwitch.Session
 
-//-------------------------------
-// Store the authorization token
-var access_token string
+//---------------------------------------------
+// Picks Core or MKE for every message we send
+var modePolicy = mteMode.DefaultPolicy
+
+//------------------------------------------
+// Http client shared by all calls to the API
+var httpClient = mteHttp.NewClient()
+
+//------------------------------------------
+// Keeps the access token, logs in again when
+// it is missing, expired or rejected
+var authenticator *mteAuth.Authenticator
 
 const (
 	//--------------------
 	// Content type const
 	jsonContent    = "application/json"
 	textContent    = "text/plain"
+	binaryContent  = "application/octet-stream"
 	chunkSize      = 1024
 	clientIdHeader = "x-client-id"
 	reseedPercent  = .9
@@ -97,25 +117,12 @@ const (
 	errorBase64Decoding          = 113
 	errorDecodingData            = 114
 	errorPathDoesNotExist        = 115
+	errorNegotiatingOptions      = 116
+	errorLogin                   = 117
+	errorSelectingMode           = 118
 	endProgram                   = 120
 )
 
-type HandshakeModel struct {
-	TimeStamp              string
-	ConversationIdentifier string
-	ClientEncoderPublicKey string
-	ClientDecoderPublicKey string
-}
-
-type ResponseModel[T any] struct {
-	Message      string
-	Success      bool
-	ResultCode   string
-	access_token string
-	ExceptionUid string
-	Data         T
-}
-
 type LoginModel struct {
 	Password string
 	UserName string
@@ -131,31 +138,51 @@ func main() {
 	retcode := 0
 	defer func() { os.Exit(retcode) }()
 
+	//------------------------------------------
+	// Cancel outstanding calls when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	//--------------------
 	// Initialize client
 	clientId := uuid.New().String()
 
 	//------------------------
 	// Call Handshake Method
-	retcode, err := PerformHandshakeWithServer(clientId)
+	retcode, err := PerformHandshakeWithServer(ctx, clientId)
 	if err != nil {
 		fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
 		return
 	}
 
+	//------------------------------------------
+	// Read the login from the environment, the
+	// file named by MTE_LOGIN_FILE or the user
+	credentials, err := mteAuth.ReadCredentials(os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Println("Error reading login: " + err.Error())
+		retcode = errorLogin
+		return
+	}
+	authenticator = mteAuth.NewAuthenticator(func(ctx context.Context) (string, error) {
+		accessToken, _, err := LoginToServer(ctx, clientId, credentials)
+		return accessToken, err
+	})
+
 	//---------------------
 	// Call Login Method
 	// This uses MTE Core
-	retcode, err = LoginToServer(clientId)
+	_, err = authenticator.Token(ctx)
 	if err != nil {
 		fmt.Println("Error during login: " + err.Error())
+		retcode = errorLogin
 		return
 	}
 
 	//-------------------------
 	// Call Upload File Method
 	// This uses MTE MKE add-on
-	retcode, err = UploadFile(clientId)
+	retcode, err = UploadFile(ctx, clientId)
 	if err != nil {
 		fmt.Println("Error file upload: " + err.Error())
 		return
@@ -170,7 +197,7 @@ func main() {
  *
  * Uses MTE MKE Add-on
  */
-func UploadFile(clientId string) (out int, err error) {
+func UploadFile(ctx context.Context, clientId string) (out int, err error) {
 	//------------------------------
 	// Loop till user chooses to end
 	for {
@@ -192,270 +219,17 @@ func UploadFile(clientId string) (out int, err error) {
 			fmt.Printf("Path does not exist! %s", err)
 			return errorPathDoesNotExist, err
 		}
-		//---------------------------
-		// Create MTE MKE from state
-		encoder := mte.NewMkeEncDef()
-		defer encoder.Destroy()
-		if useMte {
-			encoderStatus := encoder.RestoreStateB64(encoderState)
-			if encoderStatus != mte.Status_mte_status_success {
-				errorMessage := "Encoder restore error (" + mte.GetStatusName(encoderStatus) + "): " + mte.GetStatusDescription(encoderStatus)
-				fmt.Println(errorMessage)
-				return int(encoderStatus), errors.New(errorMessage)
-			}
-
-			//---------------------
-			// Initialize Chunking
-			encoderStatus = encoder.StartEncrypt()
-			if encoderStatus != mte.Status_mte_status_success {
-				errorMessage := "MTE Encoder StartEncrypt error (" + mte.GetStatusName(encoderStatus) + "): " + mte.GetStatusDescription(encoderStatus)
-				fmt.Println(errorMessage)
-				return int(encoderStatus), errors.New(errorMessage)
-			}
-		}
-		//----------------------------
-		// Open file and retrieve info
-		file, _ := os.Open(fPath)
-		fi, _ := file.Stat()
-		defer file.Close()
-		//----------
-		// Set URI
-		var route string
-		if useMte {
-			route = fileUploadMteRoute
-		} else {
-			route = fileUploadNoMteRoute
-		}
-		uri := restAPIName + route + fi.Name()
-		//-------------------------
-		// Calculate content length
-		totalSize := fi.Size()
-		//------------------------------------------------------------
-		// If we are using the MTE add additional length to totalSize
-		if useMte {
-			totalSize += int64(encoder.EncryptFinishBytes())
-		}
-		//-------------------------
-		// Use pipe to pass request
-		rd, wr := io.Pipe()
-		defer rd.Close()
-
-		go func() {
-			defer wr.Close()
-			//-------------
-			// Write file
-			buf := make([]byte, chunkSize)
-			for {
-				n, err := file.Read(buf)
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						if useMte {
-							//-----------------------------
-							// End of the file reached
-							// Finish the chunking session
-							//-----------------------------
-							finishEncode, status := encoder.FinishEncrypt()
-							if status != mte.Status_mte_status_success {
-								fmt.Fprintf(os.Stderr, "Encode finish error (%v): %v\n",
-									mte.GetStatusName(status), mte.GetStatusDescription(status))
-							}
-							//-------------------------------------------------
-							// If there are bytes to write, write them to file
-							//-------------------------------------------------
-							if finishEncode != nil {
-								if _, err := wr.Write(finishEncode); err != nil {
-									fmt.Printf("Error trying to write to file %s, err: %s", fi.Name(), err)
-								}
-							}
-							//-------------------------------------
-							// Check if we have reached reseed max
-							currentSeed := float64(encoder.GetReseedCounter())
-							if currentSeed > (float64(maxSeed) * float64(reseedPercent)) {
-								//---------------------------
-								// Uninstantiate the Encoder
-								encoderStatus := encoder.Uninstantiate()
-								if encoderStatus != mte.Status_mte_status_success {
-									//--------------------------------------
-									// Handle Encoder uninstantiate failure
-									errorMessage := "Encoder uninstantiate error (" + mte.GetStatusName(encoderStatus) + "): " + mte.GetStatusDescription(encoderStatus) + "\n"
-									fmt.Println(errorMessage)
-								}
-
-								//------------------------
-								// Call Handshake Method
-								// This also re-creates Encoder and Decoder
-								retcode, err := PerformHandshakeWithServer(clientId)
-								if err != nil {
-									fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
-								}
-							} else {
-								//--------------------
-								// Save state Encoder
-								encoderState = encoder.SaveStateB64()
-							}
-						}
-					}
-					break
-				}
-				//---------------------------------------
-				// If we are using MTE encrypt the chunk
-				if useMte {
-					if n < chunkSize {
-						buf = buf[:n]
-					}
-					//-----------------------------------------------------------
-					// Encrypt the chunk
-					encoderStatus := encoder.EncryptChunk(buf)
-					if encoderStatus != mte.Status_mte_status_success {
-						fmt.Fprintf(os.Stderr, "Encode error (%v): %v\n",
-							mte.GetStatusName(encoderStatus), mte.GetStatusDescription(encoderStatus))
-						break
-					}
-				}
-				_, _ = wr.Write(buf[:n])
-			}
-		}()
-		//--------------------------
-		// Construct request with rd
-		req, _ := http.NewRequest("POST", uri, rd)
-		//------------------------------------------------------
-		// If we have an access token add authentication header
-		if access_token != "" {
-			// Create a Bearer string by appending string access token
-			var bearer = "Bearer " + access_token
-			// add authorization header to the req
-			req.Header.Add("Authorization", bearer)
+		//--------------------------------------------
+		// Send the file, if the server rejected the
+		// access token log in again and send it again
+		retcode, err := SendFile(ctx, clientId, fPath)
+		if mteAuth.IsUnauthorized(err) {
+			fmt.Println("Access token was rejected, logging in again")
+			authenticator.Invalidate()
+			retcode, err = SendFile(ctx, clientId, fPath)
 		}
-
-		req.Header.Set(clientIdHeader, clientId)
-		req.ContentLength = totalSize
-		//-----------------
-		// Process request
-		client := &http.Client{}
-		resp, err := client.Do(req)
 		if err != nil {
-			fmt.Println(err.Error())
-			return errorReadingResponse, err
-		} else {
-			body := &bytes.Buffer{}
-			_, _ = body.ReadFrom(resp.Body)
-			defer resp.Body.Close()
-
-			//------------------------------------------
-			// Marshal json response to Response object
-			hrBytes, _ := ioutil.ReadAll(body)
-			var serverResponse ResponseModel[string]
-			json.Unmarshal(hrBytes, &serverResponse)
-			if !serverResponse.Success {
-				errorMessage := "Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer)
-				fmt.Println(errorMessage)
-				return errorFromServer, errors.New(errorMessage)
-			}
-			//------------------
-			// set access token
-			access_token = serverResponse.access_token
-
-			var decodedText []byte
-			//-----------------------------------------------------
-			// Decode the response message if we are using the MTE
-			decoder := mte.NewMkeDecDef()
-			defer decoder.Destroy()
-			if useMte {
-				//--------------------------------------------
-				// Base64 Decode server response  to []byte
-				encodedDatab64 := make([]byte, base64.StdEncoding.DecodedLen(len(serverResponse.Data)))
-				n, err := base64.StdEncoding.Decode(encodedDatab64, []byte(serverResponse.Data))
-				if err != nil {
-					errorMessage := "Error base64 decode encoded data: " + err.Error() + " Code: " + strconv.Itoa(errorDecodingData)
-					fmt.Println(errorMessage)
-					return errorDecodingData, errors.New(errorMessage)
-				}
-				encodedData := encodedDatab64[:n]
-				//----------------------------
-				// Restore the Decoder state
-				decoderStatus := decoder.RestoreStateB64(decoderState)
-				if decoderStatus != mte.Status_mte_status_success {
-					errorMessage := "Decoder restore error (" + mte.GetStatusName(decoderStatus) + "): " + mte.GetStatusDescription(decoderStatus)
-					fmt.Println(errorMessage)
-					return int(decoderStatus), errors.New(errorMessage)
-				}
-
-				//---------------------
-				// Initialize Chunking
-				decoderStatus = decoder.StartDecrypt()
-				if decoderStatus != mte.Status_mte_status_success {
-					errorMessage := "MTE Decoder StartDecrypt error (" + mte.GetStatusName(decoderStatus) + "): " + mte.GetStatusDescription(decoderStatus)
-					fmt.Println(errorMessage)
-					return int(decoderStatus), errors.New(errorMessage)
-				}
-				//-----------------------------------------------------------
-				// The response is going to be short don't need to loop
-				//-----------------------------------------------------------
-				// Decrypt the chunk
-				decodedData := decoder.DecryptChunk(encodedData)
-				if decodedData == nil {
-					errorMessage := "Decode error."
-					fmt.Println(errorMessage)
-					return errorDecodingData, errors.New(errorMessage)
-				}
-				//-----------------------
-				// Finish Decode chunk
-				finishDecodeChunk, decoderStatus := decoder.FinishDecrypt()
-				if decoderStatus != mte.Status_mte_status_success {
-					//--------------------------------------------------------
-					// Decode finish decrypt unsuccessful and cannot continue
-					errorMessage := "MTE Decoder FinishDecrypt error (" + mte.GetStatusName(decoderStatus) + "): " + mte.GetStatusDescription(decoderStatus)
-					fmt.Println(errorMessage)
-					return int(decoderStatus), errors.New(errorMessage)
-				}
-				//--------------------------------------------------
-				// Check if there are additional bytes; if so, append
-				if finishDecodeChunk != nil {
-					decodedText = append(decodedData[:], finishDecodeChunk[:]...)
-				} else {
-					decodedText = decodedData
-				}
-				//-------------------------------------
-				// Check if we have reached reseed max
-				currentSeed := float64(decoder.GetReseedCounter())
-				if currentSeed > (float64(maxSeed) * float64(reseedPercent)) {
-					//---------------------------
-					// Uninstantiate the Decoder
-					decoderStatus := decoder.Uninstantiate()
-					if decoderStatus != mte.Status_mte_status_success {
-						//---------------------------------------
-						// Handle Decoder uninstantiate failure
-						errorMessage := "Decoder uninstantiate error (" + mte.GetStatusName(decoderStatus) + "): " + mte.GetStatusDescription(decoderStatus) + "\n"
-						fmt.Println(errorMessage)
-						return int(decoderStatus), errors.New(errorMessage)
-					}
-
-					//------------------------
-					// Call Handshake Method
-					// This also re-creates Encoder and Decoder
-					retcode, err := PerformHandshakeWithServer(clientId)
-					if err != nil {
-						fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
-						return retcode, err
-					}
-				} else {
-					//-------------------
-					// Save decode state
-					decoderState = decoder.SaveStateB64()
-				}
-			} else {
-				//-------------------------------
-				// Base64 Decode response string
-				decodedText, err = base64.StdEncoding.DecodeString(string(serverResponse.Data))
-				if err != nil {
-					errorMessage := "error base64 decoding string"
-					fmt.Println(errorMessage)
-					return errorBase64Decoding, errors.New(errorMessage)
-				}
-			}
-			//-------------------------------
-			// Print out response from server
-			fmt.Println("Response from server: " + string(decodedText))
+			return retcode, err
 		}
 		//------------------------------------------------
 		// Prompt user if they want to upload another file
@@ -477,94 +251,293 @@ func UploadFile(clientId string) (out int, err error) {
 	}
 }
 
+/**
+ * Sends one file to the API
+ * The server checks the access token before it decodes the
+ * file. When it rejects the token the Encoder state is not
+ * saved, so the file can be sent again from the same state
+ * after logging in again. After any other answer the server
+ * may have decoded the file and the Encoder state is saved.
+ *
+ * Files are streamed in chunks, so the policy must pick the
+ * MTE MKE Add-on. The body starts with the MKE mode marker.
+ */
+func SendFile(ctx context.Context, clientId string, fPath string) (out int, err error) {
+	//-----------------------------------------
+	// Get the access token, this logs in when
+	// there is none yet or it is about to expire
+	bearer := http.Header{}
+	err = authenticator.Authorize(ctx, bearer)
+	if err != nil {
+		fmt.Println("Error during login: " + err.Error())
+		return errorLogin, err
+	}
+	//-------------------------------------------
+	// Check the file gets sent with MTE MKE, the
+	// Core Encoder can not stream the file
+	if useMte {
+		fi, err := os.Stat(fPath)
+		if err != nil {
+			fmt.Printf("Path does not exist! %s", err)
+			return errorPathDoesNotExist, err
+		}
+		mode, err := session.Policy.Pick(fi.Size(), binaryContent, session.Options)
+		if err == nil && mode != mteHandshake.ModeMke {
+			err = fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mteHandshake.ModeMke)
+		}
+		if err != nil {
+			fmt.Println("Error selecting MTE mode: " + err.Error())
+			return errorSelectingMode, err
+		}
+	}
+	//---------------------------
+	// Create MTE MKE from state
+	var encoder mteCoder.ChunkEncoder
+	if useMte {
+		encoder, err = session.MkeEncoder()
+		if err != nil {
+			fmt.Println("Encoder restore error: " + err.Error())
+			return statusCode(err, errorCreatingEncoder), err
+		}
+		defer encoder.Destroy()
+
+		//---------------------
+		// Initialize Chunking
+		err = encoder.StartEncrypt()
+		if err != nil {
+			fmt.Println("MTE Encoder StartEncrypt error: " + err.Error())
+			return statusCode(err, errorCreatingEncoder), err
+		}
+	}
+	//----------------------------
+	// Open file and retrieve info
+	file, err := os.Open(fPath)
+	if err != nil {
+		fmt.Printf("Path does not exist! %s", err)
+		return errorPathDoesNotExist, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		fmt.Printf("Path does not exist! %s", err)
+		return errorPathDoesNotExist, err
+	}
+	//----------
+	// Set URI
+	var route string
+	if useMte {
+		route = fileUploadMteRoute
+	} else {
+		route = fileUploadNoMteRoute
+	}
+	uri := restAPIName + route + fi.Name()
+	//-------------------------
+	// Calculate content length
+	totalSize := fi.Size()
+	//------------------------------------------------------------
+	// If we are using the MTE add additional length to totalSize
+	// for the mode marker and the bytes FinishEncrypt adds
+	if useMte {
+		totalSize += int64(encoder.EncryptFinishBytes())
+		if session.ModeSwitching() {
+			totalSize++
+		}
+	}
+	//-------------------------
+	// Use pipe to pass request
+	rd, wr := io.Pipe()
+	defer rd.Close()
+
+	//-------------------------------------------
+	// Closed once the Encoder is no longer used
+	encodeDone := make(chan struct{})
+	go func() {
+		defer close(encodeDone)
+		defer wr.Close()
+		//------------------------------------------
+		// Tell the server to use its MKE Decoder, a
+		// legacy server expects the MKE without marker
+		if useMte && session.ModeSwitching() {
+			if _, err := wr.Write([]byte{mteMode.MarkerMke}); err != nil {
+				return
+			}
+		}
+		//-------------
+		// Write file
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				if errors.Is(err, io.EOF) && useMte {
+					//-----------------------------
+					// End of the file reached
+					// Finish the chunking session
+					//-----------------------------
+					finishEncode, err := encoder.FinishEncrypt()
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Encode finish error: %v\n", err)
+					}
+					//-------------------------------------------------
+					// If there are bytes to write, write them to file
+					//-------------------------------------------------
+					if finishEncode != nil {
+						if _, err := wr.Write(finishEncode); err != nil {
+							fmt.Printf("Error trying to write to file %s, err: %s", fi.Name(), err)
+						}
+					}
+				}
+				break
+			}
+			//---------------------------------------
+			// If we are using MTE encrypt the chunk
+			if useMte {
+				if n < chunkSize {
+					buf = buf[:n]
+				}
+				//-----------------------------------------------------------
+				// Encrypt the chunk
+				err = encoder.EncryptChunk(buf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Encode error: %v\n", err)
+					break
+				}
+			}
+			_, _ = wr.Write(buf[:n])
+		}
+	}()
+	//--------------------------
+	// Construct request with rd
+	req, _ := http.NewRequest("POST", uri, rd)
+	//---------------------------------
+	// Add the authentication header
+	req.Header.Set(mteAuth.AuthorizationHeader, bearer.Get(mteAuth.AuthorizationHeader))
+	req.Header.Set(clientIdHeader, clientId)
+	req.ContentLength = totalSize
+	//--------------------------------------------
+	// Process request, the body is streamed from
+	// the pipe so it is only sent once
+	hrBytes, err := httpClient.Send(ctx, req)
+	//----------------------------------------------
+	// Stop the writer and wait until it is done
+	// with the Encoder before we look at the state
+	rd.Close()
+	<-encodeDone
+	if useMte && !mteUpload.NotDecoded(err) {
+		session.SaveMkeEncoderState(encoder)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return errorReadingResponse, err
+	}
+	//------------------------------------------
+	// Marshal json response to Response object
+	serverResponse, err := mteHttp.DecodeResponse[string](hrBytes)
+	if err != nil {
+		errorMessage := "Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer)
+		fmt.Println(errorMessage)
+		return errorFromServer, errors.New(errorMessage)
+	}
+	//------------------------------------------
+	// Keep the access token unless a new one
+	// was handed out with this response
+	authenticator.SetToken(serverResponse.AccessToken)
+
+	var decodedText []byte
+	if useMte {
+		//---------------------------------------------
+		// Decode the response message with the Decoder
+		// named by its mode marker
+		var retcode int
+		decodedText, _, retcode, err = DecodeMessage(serverResponse.Data, mteHandshake.ModeMke)
+		if err != nil {
+			return retcode, err
+		}
+		//-------------------------------------
+		// Check if we have reached reseed max
+		handshakeNeeded, retcode, err := ReseedNeeded()
+		if err != nil {
+			return retcode, err
+		}
+		if handshakeNeeded {
+			//------------------------
+			// Call Handshake Method
+			// This also re-creates every Encoder and Decoder
+			retcode, err := PerformHandshakeWithServer(ctx, clientId)
+			if err != nil {
+				fmt.Println("Error: " + err.Error() + " Code: " + strconv.Itoa(retcode))
+				return retcode, err
+			}
+		}
+	} else {
+		//-------------------------------
+		// Base64 Decode response string
+		decodedText, err = base64.StdEncoding.DecodeString(string(serverResponse.Data))
+		if err != nil {
+			errorMessage := "error base64 decoding string"
+			fmt.Println(errorMessage)
+			return errorBase64Decoding, errors.New(errorMessage)
+		}
+	}
+	//-------------------------------
+	// Print out response from server
+	fmt.Println("Response from server: " + string(decodedText))
+	return 0, nil
+}
+
 /**
  * Login to API server
- * Uses default username and password
- * Uses MTE Core
+ * The policy picks MTE Core for the login
+ *
+ * clientId: clientId string
+ * credentials: user name and password to log in with
+ *
+ * Returns the access token handed out by the server
  */
-func LoginToServer(clientId string) (out int, err error) {
+func LoginToServer(ctx context.Context, clientId string, credentials mteAuth.Credentials) (out string, retcode int, err error) {
 	//-----------------
 	// Set login model
-	// This is a demonstration, username and password should not
-	// Be put in code as plain text
 	login := LoginModel{
-		Password: "P@ssw0rd!",
-		UserName: "email@eclypses.com",
+		Password: credentials.Password,
+		UserName: credentials.UserName,
 	}
 	//--------------------------
 	// Serialize the login model
 	serializedLogin, err := json.Marshal(login)
 	if err != nil {
 		fmt.Println(err.Error())
-		return
-	}
-	//---------------------
-	// Create the Encoder
-	encoder := mte.NewEncDef()
-	defer encoder.Destroy()
-	//------------------------
-	// Restore Encoder state
-	encoderStatus := encoder.RestoreStateB64(encoderState)
-	if encoderStatus != mte.Status_mte_status_success {
-		errorMessage := "Encoder restore error (" + mte.GetStatusName(encoderStatus) + ", " + mte.GetStatusDescription(encoderStatus) + ")"
-		fmt.Println(errorMessage)
-		return int(encoderStatus), errors.New(errorMessage)
+		return "", errorMarshalJson, err
 	}
-	//----------------------------------
-	// Encode the serialized Login Model
-	encodedLogin, encoderStatus := encoder.EncodeB64(serializedLogin)
-	if encoderStatus != mte.Status_mte_status_success {
-		errorMessage := "Encode error " + mte.GetStatusName(encoderStatus) + " , " + mte.GetStatusDescription(encoderStatus)
-		fmt.Println(errorMessage)
-		return
+	//----------------------------------------------
+	// Encode the serialized Login Model, the policy
+	// picks MTE Core for a short json message
+	encodedLogin, mode, retcode, err := EncodeMessage(serializedLogin, jsonContent)
+	if err != nil {
+		return "", retcode, err
 	}
-	//----------------------------
-	// Save updated Encoder state
-	encoderState = encoder.SaveStateB64()
 	//-----------------
 	// Make Http call
-	loginResponse, retcode, err := MakeHttpCall(restAPIName+loginRoute, "POST", clientId, textContent, encodedLogin)
+	loginResponse, retcode, err := MakeHttpCall(ctx, restAPIName+loginRoute, "POST", clientId, textContent, encodedLogin)
 	if err != nil {
 		fmt.Println(err.Error())
-		return retcode, err
+		return "", retcode, err
 	}
 	//-----------------------------
 	// Marshal json back to class
 	hrBytes := []byte(loginResponse)
-	var serverResponse ResponseModel[string]
-	json.Unmarshal(hrBytes, &serverResponse)
-	if !serverResponse.Success {
-		fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
-		return errorFromServer, errors.New(serverResponse.Message)
-	}
-	//-----------------------------------------
-	// Set access_token for next communication
-	access_token = serverResponse.access_token
-	//---------------------
-	// Decode the response
-	decoder := mte.NewDecDef()
-	defer decoder.Destroy()
-
-	//---------------------------
-	// Restore the Decoder state
-	decoderStatus := decoder.RestoreStateB64(decoderState)
-	if mte.StatusIsError(decoderStatus) {
-		errorMessage := "Decoder restore error (" + mte.GetStatusName(decoderStatus) + ", " + mte.GetStatusDescription(decoderStatus) + ")"
-		fmt.Println(errorMessage)
-		return int(decoderStatus), errors.New(errorMessage)
+	serverResponse, err := mteHttp.DecodeResponse[string](hrBytes)
+	if err != nil {
+		fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
+		return "", errorFromServer, err
 	}
-
-	//-----------------------
-	// Decode return message
-	decodedMessage, decoderStatus := decoder.DecodeStrB64(serverResponse.Data)
-	if mte.StatusIsError(decoderStatus) {
-		errorMessage := "Decode error " + mte.GetStatusName(decoderStatus) + " , " + mte.GetStatusDescription(decoderStatus)
-		fmt.Println(errorMessage)
-		return int(decoderStatus), errors.New(errorMessage)
+	//----------------------------------------------
+	// Decode the response with the Decoder named by
+	// its mode marker
+	decodedMessage, _, retcode, err := DecodeMessage(serverResponse.Data, mode)
+	if err != nil {
+		return "", retcode, err
 	}
-	fmt.Println("Login Response: " + decodedMessage)
-	decoderState = decoder.SaveStateB64()
-	return 0, nil
+	fmt.Println("Login Response: " + string(decodedMessage))
+	return serverResponse.AccessToken, 0, nil
 }
 
 /**
@@ -578,14 +551,16 @@ func LoginToServer(clientId string) (out int, err error) {
  * Returns HandshakeResponse: encoderSharedSecret, decoderSharedSecret
  *
  */
-func PerformHandshakeWithServer(clientId string) (out int, err error) {
+func PerformHandshakeWithServer(ctx context.Context, clientId string) (out int, err error) {
 
 	fmt.Println("Performing handshake for client: " + clientId)
 
 	//--------------------------------------------
 	// Set default return and response parameters
-	var handshakeModel HandshakeModel
-	handshakeModel.ConversationIdentifier = clientId
+	// Offer the MTE options this client supports
+	offer := mteHandshake.DefaultCapabilities(int(mte.GetDefaultDrbg()), mte.GetDefaultTokBytes(),
+		int(mte.GetDefaultVerifiers()), mteHandshake.ModeCore, mteHandshake.ModeMke)
+	handshakeModel := mteHandshake.NewRequest(clientId, offer)
 
 	//----------------------------------------------
 	// Create Eclypses ECDH for Encoder and Decoder
@@ -619,7 +594,7 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	}
 	//----------------------------------
 	// Make Http and get return string
-	hsModelString, errorcode, err := MakeHttpCall(restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
+	hsModelString, errorcode, err := MakeHttpCall(ctx, restAPIName+handshakeRoute, "POST", clientId, jsonContent, string(handshakeString))
 	if err != nil {
 		fmt.Println("Error making Http call: " + err.Error() + " Code: " + strconv.Itoa(errorcode))
 		return errorcode, err
@@ -627,11 +602,17 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	//-----------------------------
 	// Marshal json back to class
 	hrBytes := []byte(hsModelString)
-	var serverResponse ResponseModel[HandshakeModel]
-	json.Unmarshal(hrBytes, &serverResponse)
-	if !serverResponse.Success {
-		fmt.Println("Error back from server: " + serverResponse.Message + " Code: " + strconv.Itoa(errorFromServer))
-		return errorFromServer, errors.New(serverResponse.Message)
+	serverResponse, err := mteHttp.DecodeResponse[mteHandshake.HandshakeResponse](hrBytes)
+	if err != nil {
+		fmt.Println("Error back from server: " + err.Error() + " Code: " + strconv.Itoa(errorFromServer))
+		return errorFromServer, err
+	}
+	//-------------------------------------------
+	// Check the MTE options the server agreed to
+	options, err := mteHandshake.Accept(offer, serverResponse.Data)
+	if err != nil {
+		fmt.Println("Error negotiating MTE options: " + err.Error() + " Code: " + strconv.Itoa(errorNegotiatingOptions))
+		return errorNegotiatingOptions, err
 	}
 
 	//--------------------------------------------
@@ -690,86 +671,121 @@ func PerformHandshakeWithServer(clientId string) (out int, err error) {
 	}
 
 	//---------------------------------
-	// Create MTE Encoder and Decoder
-	retcode, err := CreateMteEncoder(serverResponse.Data.TimeStamp, clientId, enSSBytes)
+	// Create MTE Encoders and Decoders
+	retcode, err := CreateMteSession(serverResponse.Data.TimeStamp, clientId, options, enSSBytes, deSSBytes)
 	if err != nil {
-		fmt.Println("Error creating Encoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingEncoder))
-		return retcode, err
-	}
-	retcode, err = CreateMteDecoder(serverResponse.Data.TimeStamp, clientId, deSSBytes)
-	if err != nil {
-		fmt.Println("Error creating Decoder: " + err.Error() + " Code: " + strconv.Itoa(errorCreatingDecoder))
+		fmt.Println("Error creating Encoders and Decoders: " + err.Error() + " Code: " + strconv.Itoa(retcode))
 		return retcode, err
 	}
 
 	return 0, nil
 }
 
-func CreateMteEncoder(timestamp string, clientId string, encoderEntropy []byte) (out int, err error) {
-	encoder := mte.NewMkeEncDef()
-	defer encoder.Destroy()
-
+/**
+ * Creates the Core and MKE Encoders and Decoders from the
+ * shared secrets, the secrets are cleared once they are used
+ * With mode switching each mode the handshake agreed on gets its
+ * own Encoder and Decoder with the personalization string
+ * clientId/mode. For a legacy server only the MKE pair is
+ * instantiated, with the client ID, and the Core uses its state
+ */
+func CreateMteSession(timestamp string,
+	clientId string,
+	options mteHandshake.MteOptions,
+	encoderEntropy []byte,
+	decoderEntropy []byte) (out int, err error) {
 	//----------------------------
 	// Parse nonce from timestamp
 	nonce, err := strconv.ParseUint(timestamp, 10, 64)
 	if err != nil {
-		panic(err)
+		return errorCreatingEncoder, fmt.Errorf("parsing nonce from timestamp: %w", err)
 	}
-
-	//--------------------
-	// Initialize Encoder
-	//--------------------
-	encoder.SetEntropy(encoderEntropy)
-	encoder.SetNonceInt(nonce)
-	status := encoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("encoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
+	secrets := &mteSession.Secrets{
+		ClientId:       clientId,
+		Nonce:          nonce,
+		Options:        options,
+		EncoderEntropy: encoderEntropy,
+		DecoderEntropy: decoderEntropy,
 	}
-	//-------------------------------
-	// Get the MTE max seed interval
-	if maxSeed <= 0 {
-		maxSeed = mte.GetDrbgsReseedInterval(encoder.GetDrbg())
+	newSession, err := mteSwitch.New(switchCoders, secrets, modePolicy)
+	if err != nil {
+		return statusCode(err, errorCreatingEncoder), err
 	}
-	//--------------------
-	// Save encoder state
-	encoderState = encoder.SaveStateB64()
-
+	session = newSession
 	return 0, nil
 }
 
-func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte) (out int, err error) {
-	decoder := mte.NewMkeEncDef()
-	defer decoder.Destroy()
+/**
+ * Encodes a message with the mode the policy picks
+ * MKE messages are encrypted in one chunk, the same
+ * way the server answers file uploads
+ *
+ * message: message to encode
+ * contentType: content type of the message
+ *
+ * Returns the base64 encoded envelope, the mode marker
+ * followed by the encoded message, and the mode. A legacy
+ * server gets the encoded message without the marker
+ */
+func EncodeMessage(message []byte, contentType string) (out string, mode string, retcode int, err error) {
+	envelope, mode, err := session.Encode(message, contentType)
+	if err != nil {
+		fmt.Println("Encode error: " + err.Error())
+		return "", mode, statusCode(err, errorSelectingMode), err
+	}
+	return base64.StdEncoding.EncodeToString(envelope), mode, 0, nil
+}
 
-	//----------------------------
-	// Parse nonce from timestamp
-	nonce, err := strconv.ParseUint(timestamp, 10, 64)
+/**
+ * Decodes a base64 encoded envelope with the Decoder its
+ * mode marker names, the Decoder state is only kept when
+ * the message decoded
+ *
+ * data: base64 encoded envelope
+ * requestMode: mode of the request this answers, a legacy
+ * server answers in that mode without a marker
+ *
+ * Returns the message and the mode it was sent with
+ */
+func DecodeMessage(data string, requestMode string) (out []byte, mode string, retcode int, err error) {
+	envelope, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		panic(err)
+		errorMessage := "Error base64 decode encoded data: " + err.Error() + " Code: " + strconv.Itoa(errorDecodingData)
+		fmt.Println(errorMessage)
+		return nil, "", errorDecodingData, errors.New(errorMessage)
+	}
+	if session.ModeSwitching() {
+		out, mode, err = session.Decode(envelope)
+	} else {
+		mode = requestMode
+		out, err = session.DecodeMode(mode, envelope)
 	}
+	if err != nil {
+		fmt.Println("Decode error: " + err.Error())
+		return nil, mode, statusCode(err, errorDecodingData), err
+	}
+	return out, mode, 0, nil
+}
 
-	//--------------------
-	// Initialize Decoder
-	//--------------------
-	decoder.SetEntropy(decoderEntropy)
-	decoder.SetNonceInt(nonce)
-	status := decoder.InstantiateStr(clientId)
-	if status != mte.Status_mte_status_success {
-		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
-			mte.GetStatusName(status), mte.GetStatusDescription(status))
-		return int(status), errors.New("decoder instantiate error (" + mte.GetStatusName(status) + "):" + mte.GetStatusDescription(status))
+/**
+ * Checks if any Encoder or Decoder reached reseed max
+ * A new handshake re-creates all of them
+ */
+func ReseedNeeded() (out bool, retcode int, err error) {
+	needed, err := session.ReseedNeeded(reseedPercent)
+	if err != nil {
+		fmt.Println("Reseed check error: " + err.Error())
+		return false, statusCode(err, errorCreatingEncoder), err
 	}
-	//--------------------
-	// Save Decoder state
-	decoderState = decoder.SaveStateB64()
-	return 0, nil
+	return needed, 0, nil
 }
 
 /**
  * Makes Http Call
+ * Uses the shared Http client, it retries failed calls when
+ * that is safe and checks the status code before we decode
  *
+ * ctx: cancels the call and any retries
  * route: Route to make the Http call
  * connectionMethod: POST OR GET
  * clientId: clientId string
@@ -779,63 +795,41 @@ func CreateMteDecoder(timestamp string, clientId string, decoderEntropy []byte)
  * Returns a json string of what server sends back
  *
  */
-func MakeHttpCall(route string,
+func MakeHttpCall(ctx context.Context,
+	route string,
 	connectionMethod string,
 	clientId string,
 	contentType string,
 	payload string) (out string, retcode int, err error) {
-	//--------------------
-	// Set return string
-	var returnString string
-	//------------------------------------
-	// If this is a POST request do this
-	if strings.ToUpper(connectionMethod) == "POST" {
-		client := &http.Client{}
-		bodyReader := bytes.NewReader([]byte(payload))
-		req, _ := http.NewRequest("POST", route, bodyReader)
-		//----------------------------------------
-		// Set client ID header and content-type
-		req.Header.Set(clientIdHeader, clientId)
-		req.Header.Set("Content-Type", contentType)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println(err.Error())
-			retcode = errorReadingResponse
-			return "", errorReadingResponse, err
-		}
-		defer resp.Body.Close()
-		//-----------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-		//------------------------------------
-		// If this is a GET request do this
-	} else if strings.ToUpper(connectionMethod) == "GET" {
-		resp, err := http.Get(route)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorHttpGet, err
-		}
-		//------------------------
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", errorReadingResponse, err
-		}
-		//--------------------------------
-		// Convert the body to type string
-		returnString = string(body)
-	} else {
+	//--------------------------------
+	// Only POST and GET are supported
+	method := strings.ToUpper(connectionMethod)
+	if method != "POST" && method != "GET" {
 		fmt.Println("Invalid connection request")
 		return "", errorInvalidConnectionMethod, errors.New("invalid connection request")
 	}
-	return returnString, 0, nil
+	request := mteHttp.Request{
+		Method:   method,
+		Url:      route,
+		ClientId: clientId,
+	}
+	if method == "POST" {
+		request.ContentType = contentType
+		request.Body = []byte(payload)
+	}
+	//-------------------------------
+	// Make the call and get the body
+	body, err := httpClient.Do(ctx, request)
+	if err != nil {
+		fmt.Println(err.Error())
+		var statusErr *mteHttp.StatusError
+		if errors.As(err, &statusErr) {
+			return string(statusErr.Body), errorFromServer, err
+		}
+		if method == "POST" {
+			return "", errorHttpPost, err
+		}
+		return "", errorHttpGet, err
+	}
+	return string(body), 0, nil
 }
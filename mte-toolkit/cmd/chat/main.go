@@ -0,0 +1,201 @@
+/*
+****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*****************************************************************************
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteChat"
+	"mteToolkit/mteHandshake"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultAddress = "127.0.0.1:7000"
+
+	//--------------------------
+	// Error return exit codes
+	errorUsage      = 101
+	errorMteLicense = 102
+	errorConnecting = 103
+	errorHandshake  = 104
+	errorSending    = 105
+	errorReceiving  = 106
+)
+
+/**
+ * Chat command
+ * Two peers chat over TCP, one listens and one connects
+ * The ECDH handshake runs directly on the socket, then every
+ * line typed is sent MTE encoded to the other peer
+ *
+ * Usage: chat listen [-addr host:port]
+ *        chat connect [-addr host:port] [-client id]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	if len(os.Args) < 2 || (os.Args[1] != "listen" && os.Args[1] != "connect") {
+		fmt.Fprintln(os.Stderr, "Usage: chat listen|connect [-addr host:port] [-client id]")
+		return errorUsage
+	}
+	mode := os.Args[1]
+	flags := flag.NewFlagSet("chat "+mode, flag.ExitOnError)
+	address := flags.String("addr", defaultAddress, "address to listen on or connect to")
+	clientId := flags.String("client", "", "client ID when connecting (a new one is created when empty)")
+	flags.Parse(os.Args[2:])
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	//---------------------------------
+	// Stop waiting when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	//-------------------------------------------
+	// Open the connection and do the handshake
+	var peer *mteChat.Peer
+	if mode == "listen" {
+		listener, err := net.Listen("tcp", *address)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening: %v\n", err)
+			return errorConnecting
+		}
+		fmt.Printf("Waiting for a peer on %s\n", listener.Addr())
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error accepting: %v\n", err)
+			return errorConnecting
+		}
+		peer, err = mteChat.Accept(conn, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+		if err != nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "Handshake error: %v\n", err)
+			return errorHandshake
+		}
+	} else {
+		if *clientId == "" {
+			*clientId = uuid.New().String()
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", *address)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error connecting: %v\n", err)
+			return errorConnecting
+		}
+		peer, err = mteChat.Connect(conn, *clientId, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+		if err != nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "Handshake error: %v\n", err)
+			return errorHandshake
+		}
+	}
+	defer peer.Close()
+	fmt.Printf("Chatting as client: %s\n", peer.ClientId())
+	fmt.Println("Type a message and press enter, Ctrl+C or end of input to quit")
+
+	//----------------------------------------------
+	// Print what the other peer sends until it
+	// closes the connection
+	received := make(chan error, 1)
+	go func() {
+		for {
+			message, err := peer.Receive()
+			if err != nil {
+				received <- err
+				return
+			}
+			fmt.Printf("peer> %s\n", message)
+		}
+	}()
+
+	//------------------------------------
+	// Send every line typed to the peer
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case err := <-received:
+			if errors.Is(err, io.EOF) {
+				fmt.Println("Peer closed the chat")
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "Error receiving: %v\n", err)
+			return errorReceiving
+		case line, ok := <-lines:
+			if !ok {
+				return 0
+			}
+			if line == "" {
+				continue
+			}
+			err := peer.Send([]byte(line))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error sending: %v\n", err)
+				return errorSending
+			}
+		}
+	}
+}
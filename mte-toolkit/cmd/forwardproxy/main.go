@@ -0,0 +1,134 @@
+/*
+****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*****************************************************************************
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteProxy"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultListen = "127.0.0.1:8081"
+
+	//--------------------------
+	// Error return exit codes
+	errorUsage      = 101
+	errorMteLicense = 102
+	errorHandshake  = 103
+	errorServing    = 104
+)
+
+/**
+ * Forward proxy command
+ * Lets local tools such as curl reach an MTE server. The
+ * proxy does the handshake on startup, encodes the body of
+ * every request sent to it and decodes the responses
+ *
+ * Usage: forwardproxy -server https://dev-echo.eclypses.com [-listen host:port]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	listen := flag.String("listen", defaultListen, "address the proxy listens on, keep it on localhost")
+	server := flag.String("server", "", "url of the MTE server")
+	clientId := flag.String("client", "", "client ID (a new one is created when empty)")
+	maxBodySize := flag.Int64("max-body", mteProxy.DefaultMaxBodySize, "largest request or response body in bytes")
+	flag.Parse()
+	serverUrl, err := url.Parse(*server)
+	if *server == "" || err != nil || serverUrl.Scheme == "" || serverUrl.Host == "" {
+		fmt.Fprintln(os.Stderr, "Usage: forwardproxy -server https://host [-listen host:port] [-client id]")
+		return errorUsage
+	}
+	if *clientId == "" {
+		*clientId = uuid.New().String()
+	}
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	//-------------------------------------
+	// Handshake with the MTE server before
+	// accepting local requests
+	proxy, err := mteProxy.NewForwardProxy(ctx, serverUrl, *clientId, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Handshake error: %v\n", err)
+		return errorHandshake
+	}
+	proxy.MaxBodySize = *maxBodySize
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	//------------------------------------
+	// Stop the server when Ctrl+C is hit
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Proxying %s to %s as client: %s\n", *listen, serverUrl, *clientId)
+	err = httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		return errorServing
+	}
+	return 0
+}
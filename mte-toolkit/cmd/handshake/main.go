@@ -0,0 +1,170 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultServer  = "https://dev-echo.eclypses.com" // Public Echo API
+	defaultSession = "mteSession.json"
+
+	//--------------------------
+	// Error return exit codes
+	errorPerformingHandshake = 101
+	errorSavingSession       = 102
+	errorMteLicense          = 103
+	errorReadingAttestation  = 104
+)
+
+/**
+ * Handshake command
+ * Performs the handshake with the server and writes the
+ * session file other commands and processes can load
+ *
+ * Usage: handshake [-server url] [-client id] [-out file]
+ *                  [-platform p] [-app-version v] [-jail-algo n] [-attestation file]
+ *                  [-fixed-length n]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	server := flag.String("server", defaultServer, "API server url")
+	clientId := flag.String("client", "", "client ID (a new one is created when empty)")
+	out := flag.String("out", defaultSession, "session file to write")
+	timeout := flag.Duration("timeout", mteHttp.DefaultRequestTimeout, "timeout of each Http attempt")
+	retries := flag.Int("retries", mteHttp.DefaultRetries, "number of times a failed Http call is retried")
+	platform := flag.String("platform", "", "device platform sent with the handshake, for example ios or android")
+	appVersion := flag.String("app-version", "", "app version sent with the handshake")
+	jailAlgo := flag.Int("jail-algo", mte.JailAlgoNone, "device jailbreak algorithm sent with the handshake")
+	attestation := flag.String("attestation", "", "file holding the device attestation blob")
+	fixedLength := flag.Int("fixed-length", 0, "FLEN fixed length to offer, 0 does not offer FLEN")
+	flag.Parse()
+
+	//---------------------------------
+	// Stop waiting when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client := mteHttp.NewClient()
+	client.RequestTimeout = *timeout
+	client.Retries = *retries
+
+	if *clientId == "" {
+		*clientId = uuid.New().String()
+	}
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	company := os.Getenv("MTE_COMPANY")
+	license := os.Getenv("MTE_LICENSE")
+	if !mte.InitLicense(company, license) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	//---------------------------------------------
+	// Describe the device when any of it was given
+	request := mteHandshake.NewRequest(*clientId, ClientCapabilities(*fixedLength))
+	if *platform != "" || *appVersion != "" || *jailAlgo != mte.JailAlgoNone || *attestation != "" {
+		request.Device = &mteHandshake.Device{
+			Platform:   *platform,
+			AppVersion: *appVersion,
+			JailAlgo:   *jailAlgo,
+		}
+		if *attestation != "" {
+			attestationBytes, err := ioutil.ReadFile(*attestation)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading attestation: %v\n", err)
+				return errorReadingAttestation
+			}
+			request.Device.Attestation = attestationBytes
+		}
+	}
+
+	fmt.Println("Performing handshake for client: " + *clientId)
+	secrets, err := mteAdapter.ExchangeRequest(ctx, client, *server, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Handshake error: %v\n", err)
+		return errorPerformingHandshake
+	}
+	session, err := mteAdapter.NewSession(*server, *clientId, secrets.Nonce, secrets.Options,
+		mteEntropy.NewEcdhProvider(secrets.EncoderEntropy), mteEntropy.NewEcdhProvider(secrets.DecoderEntropy))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Handshake error: %v\n", err)
+		return errorPerformingHandshake
+	}
+
+	err = session.Save(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+		return errorSavingSession
+	}
+
+	fmt.Printf("Completed handshake for client: %s\n", session.ClientId)
+	fmt.Printf("Server: %s\n", session.ServerUrl)
+	fmt.Printf("DRBG: %s\n", mte.GetDrbgsName(mte.Drbgs(session.Drbg)))
+	if session.Options.HasMode(mteHandshake.ModeFlen) {
+		fmt.Printf("FLEN fixed length: %d\n", session.Options.FixedLength)
+	}
+	fmt.Printf("Session written to: %s\n", *out)
+	return 0
+}
+
+/**
+ * MTE options this client offers during the handshake
+ *
+ * fixedLength: FLEN fixed length to offer, 0 does not offer FLEN
+ */
+func ClientCapabilities(fixedLength int) mteHandshake.Capabilities {
+	capabilities := mteAdapter.DefaultCapabilities(mteHandshake.ModeCore, mteHandshake.ModeMke)
+	if fixedLength > 0 {
+		capabilities.Modes = append(capabilities.Modes, mteHandshake.ModeFlen)
+		capabilities.FixedLength = fixedLength
+	}
+	return capabilities
+}
@@ -0,0 +1,280 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteJail"
+)
+
+const (
+	//--------------------------------------------
+	// Decoder options, the same as the goJail
+	// sample so a device can use its settings
+	drbg      = mte.Drbgs_mte_drbgs_hash_sha1
+	tokBytes  = 2
+	verifiers = mte.Verifiers_mte_verifiers_none
+
+	//-----------------
+	// Default values
+	defaultPersonal = "user1"
+	defaultEntropy  = "0123456789abcdef"
+	defaultNonce    = uint64(1234)
+
+	//------------------
+	// Check outcomes
+	outcomeDecoded     = "decoded"
+	outcomeCompromised = "compromised"
+	outcomeError       = "error"
+
+	//--------------------------
+	// Error return exit codes
+	errorUsage          = 101
+	errorReadingVectors = 102
+	errorMteLicense     = 103
+	errorWritingReport  = 104
+	errorChecksFailed   = 105
+)
+
+//--------------------------------------------------------
+// Message produced by a device and what should happen
+// when it is decoded. Empty fields use the flag values.
+// Nonce is a pointer so a vector can ask for nonce 0,
+// Expect is "decoded" (the default) or "compromised",
+// Message is the plaintext the device encoded, if known
+type Vector struct {
+	Name     string  `json:"name"`
+	Personal string  `json:"personalization,omitempty"`
+	Entropy  string  `json:"entropy,omitempty"`
+	Nonce    *uint64 `json:"nonce,omitempty"`
+	JailAlgo int     `json:"jailAlgo"`
+	Encoded  string  `json:"encoded"`
+	Message  string  `json:"message,omitempty"`
+	Expect   string  `json:"expect,omitempty"`
+}
+
+//----------------------------
+// Test vector file contents
+type VectorFile struct {
+	Vectors []Vector `json:"vectors"`
+}
+
+//------------------------------
+// Outcome of checking a Vector
+type Result struct {
+	Name         string `json:"name"`
+	JailAlgo     int    `json:"jailAlgo"`
+	Device       string `json:"device"`
+	Expect       string `json:"expect"`
+	Outcome      string `json:"outcome"`
+	Status       string `json:"status"`
+	Decoded      string `json:"decoded,omitempty"`
+	MutatedNonce string `json:"mutatedNonce,omitempty"`
+	Pass         bool   `json:"pass"`
+	Error        string `json:"error,omitempty"`
+}
+
+//-------------------------------
+// Machine-readable check report
+type Report struct {
+	MteVersion string   `json:"mteVersion"`
+	Passed     int      `json:"passed"`
+	Failed     int      `json:"failed"`
+	Results    []Result `json:"results"`
+}
+
+/**
+ * Jailbreak check command
+ * Decodes messages produced by a device with the jail nonce
+ * callback of its device type and writes a JSON pass/fail
+ * report, so mobile builds can be validated without prompts.
+ * The vectors come from a file or from the flags.
+ *
+ * Usage: jailcheck -vectors file [-out file]
+ *        jailcheck -device n -encoded b64 [-message text] [-out file]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	vectorPath := flag.String("vectors", "", "JSON test vector file")
+	personal := flag.String("personal", defaultPersonal, "personalization string")
+	entropy := flag.String("entropy", defaultEntropy, "entropy")
+	nonce := flag.Uint64("nonce", defaultNonce, "nonce seed")
+	device := flag.Int("device", mte.JailAlgoNone, "device type (jailbreak algorithm) when -vectors is not set")
+	encoded := flag.String("encoded", "", "base64 message encoded by the device when -vectors is not set")
+	message := flag.String("message", "", "message the device encoded, checked when set")
+	expect := flag.String("expect", outcomeDecoded, "expected outcome, decoded or compromised")
+	out := flag.String("out", "-", "report file, - writes to stdout")
+	flag.Parse()
+
+	//----------------------------------------------
+	// Read the vectors from the file or the flags
+	var vectors []Vector
+	if *vectorPath != "" {
+		vectorBytes, err := ioutil.ReadFile(*vectorPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading vectors: %v\n", err)
+			return errorReadingVectors
+		}
+		var file VectorFile
+		err = json.Unmarshal(vectorBytes, &file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing vectors: %v\n", err)
+			return errorReadingVectors
+		}
+		vectors = file.Vectors
+	} else {
+		if *encoded == "" {
+			fmt.Fprintln(os.Stderr, "Either -vectors or -encoded is required")
+			flag.Usage()
+			return errorUsage
+		}
+		vectors = []Vector{{
+			Name:     "flags",
+			JailAlgo: *device,
+			Encoded:  *encoded,
+			Message:  *message,
+			Expect:   *expect,
+		}}
+	}
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	report := Report{MteVersion: mte.GetVersion(), Results: []Result{}}
+	for _, vector := range vectors {
+		if vector.Personal == "" {
+			vector.Personal = *personal
+		}
+		if vector.Entropy == "" {
+			vector.Entropy = *entropy
+		}
+		if vector.Nonce == nil {
+			vector.Nonce = nonce
+		}
+		if vector.Expect == "" {
+			vector.Expect = outcomeDecoded
+		}
+		result := check(vector)
+		if result.Pass {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	//---------------------
+	// Write the report
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating report: %v\n", err)
+		return errorWritingReport
+	}
+	reportBytes = append(reportBytes, '\n')
+	if *out == "-" {
+		_, err = os.Stdout.Write(reportBytes)
+	} else {
+		err = ioutil.WriteFile(*out, reportBytes, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		return errorWritingReport
+	}
+	if report.Failed > 0 {
+		return errorChecksFailed
+	}
+	return 0
+}
+
+/**
+ * Decodes one vector with a Decoder instantiated with
+ * the jail nonce callback of the vector device type
+ */
+func check(vector Vector) Result {
+	result := Result{
+		Name:     vector.Name,
+		JailAlgo: vector.JailAlgo,
+		Expect:   vector.Expect,
+		Outcome:  outcomeError,
+	}
+	if vector.JailAlgo < mte.JailAlgoNone || vector.JailAlgo >= mte.NumJailAlgo {
+		result.Error = fmt.Sprintf("invalid device type %d", vector.JailAlgo)
+		return result
+	}
+	result.Device = mte.JailAlgos[vector.JailAlgo]
+	if vector.Expect != outcomeDecoded && vector.Expect != outcomeCompromised {
+		result.Error = "invalid expected outcome " + vector.Expect
+		return result
+	}
+
+	decoder := mte.NewDecOpt(drbg, tokBytes, verifiers, 0, 0)
+	defer decoder.Destroy()
+	retain, err := mteJail.Instantiate(decoder, mte.JailAlgo(vector.JailAlgo), *vector.Nonce,
+		mteEntropy.NewInsecureFixedTestProvider([]byte(vector.Entropy)), vector.Personal)
+	if err != nil {
+		result.Error = "Decoder " + err.Error()
+		return result
+	}
+	result.MutatedNonce = base64.StdEncoding.EncodeToString(retain.Mutated())
+
+	decoded, status := decoder.DecodeStrB64(vector.Encoded)
+	result.Status = mte.GetStatusName(status)
+	switch status {
+	case mte.Status_mte_status_success:
+		result.Outcome = outcomeDecoded
+		result.Decoded = decoded
+	case mte.Status_mte_status_token_does_not_exist:
+		result.Outcome = outcomeCompromised
+	default:
+		result.Error = mte.GetStatusDescription(status)
+		return result
+	}
+	result.Pass = result.Outcome == vector.Expect
+	if result.Pass && vector.Expect == outcomeDecoded && vector.Message != "" && decoded != vector.Message {
+		result.Pass = false
+		result.Error = "decoded message does not match"
+	}
+	return result
+}
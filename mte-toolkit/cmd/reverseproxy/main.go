@@ -0,0 +1,139 @@
+/*
+****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*****************************************************************************
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteProxy"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultListen = "127.0.0.1:8080"
+
+	//--------------------------
+	// Error return exit codes
+	errorUsage      = 101
+	errorMteLicense = 102
+	errorStore      = 103
+	errorServing    = 104
+)
+
+/**
+ * Reverse proxy command
+ * Terminates MTE in front of a service that does not use it.
+ * Clients do the handshake with the proxy, their MTE encoded
+ * requests are decoded and forwarded in plaintext to the
+ * upstream service and the responses are encoded back
+ *
+ * Usage: reverseproxy -upstream http://localhost:5000 [-listen host:port]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	listen := flag.String("listen", defaultListen, "address the proxy listens on")
+	upstream := flag.String("upstream", "", "url of the upstream service")
+	handshakeRoute := flag.String("handshake-route", "/api/handshake", "route of the handshake")
+	maxBodySize := flag.Int64("max-body", mteProxy.DefaultMaxBodySize, "largest request or response body in bytes")
+	flag.Parse()
+	upstreamUrl, err := url.Parse(*upstream)
+	if *upstream == "" || err != nil || upstreamUrl.Scheme == "" || upstreamUrl.Host == "" {
+		fmt.Fprintln(os.Stderr, "Usage: reverseproxy -upstream http://host:port [-listen host:port]")
+		return errorUsage
+	}
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	//-----------------------------------------------
+	// The client states are sealed with a key that
+	// only lives in this process, so they are gone
+	// on restart and the clients do a new handshake
+	key, err := mteStore.NewKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the store key: %v\n", err)
+		return errorStore
+	}
+	store, err := mteStore.NewSealedStore(mteStore.NewMemoryStore(), key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the store: %v\n", err)
+		return errorStore
+	}
+
+	proxy := mteProxy.NewReverseProxy(upstreamUrl, store, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+	proxy.HandshakeRoute = *handshakeRoute
+	proxy.MaxBodySize = *maxBodySize
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	//------------------------------------
+	// Stop the server when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Proxying %s to %s\n", *listen, upstreamUrl)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		return errorServing
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"os/signal"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteSequencing"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultCount = 20
+	personal     = "seqlink"
+
+	//--------------------------
+	// Error return exit codes
+	errorMteLicense      = 101
+	errorReadingConfig   = 102
+	errorOpeningLink     = 103
+	errorCreatingEncoder = 104
+	errorCreatingDecoder = 105
+	errorSending         = 106
+)
+
+/**
+ * Sequencing link command
+ * Sends numbered messages over a local UDP link that drops,
+ * duplicates and reorders packets, and prints the event the
+ * receiver reports for every packet it gets
+ *
+ * Usage: seqlink [-config file] [-window n] [-count n] [-drop p] [-duplicate p] [-reorder p] [-ordered]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	configPath := flag.String("config", "", "receiver config file, overrides -window")
+	window := flag.Int("window", -2, "sequence window of the receiver")
+	count := flag.Int("count", defaultCount, "number of messages to send")
+	drop := flag.Float64("drop", 0.1, "chance a packet is dropped")
+	duplicate := flag.Float64("duplicate", 0.1, "chance a packet is sent twice")
+	reorder := flag.Float64("reorder", 0.2, "chance a packet is held back and sent after the next one")
+	ordered := flag.Bool("ordered", false, "release the messages in sequence order through a reorder buffer")
+	flag.Parse()
+
+	//---------------------------------
+	// Stop waiting when Ctrl+C is hit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	config := mteSequencing.Config{SequenceWindow: *window}
+	if *configPath != "" {
+		var err error
+		config, err = mteSequencing.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+			return errorReadingConfig
+		}
+	}
+
+	//------------------------------------
+	// Open both ends of the UDP link
+	receiverLink, err := mteSequencing.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening link: %v\n", err)
+		return errorOpeningLink
+	}
+	defer receiverLink.Close()
+	senderLink, err := mteSequencing.DialUDP(receiverLink.LocalAddr().String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening link: %v\n", err)
+		return errorOpeningLink
+	}
+	defer senderLink.Close()
+
+	//--------------------------------------------------
+	// Both ends share random entropy and nonce, in a
+	// real system these come from the handshake
+	encoder := mte.NewEncDef()
+	defer encoder.Destroy()
+	decoder := mteSequencing.NewDecoder(config)
+	defer decoder.Destroy()
+	err = mteEntropy.Set(mteEntropy.RandomProvider{}, mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder, decoder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating entropy: %v\n", err)
+		return errorCreatingEncoder
+	}
+	nonceBytes := make([]byte, 8)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating nonce: %v\n", err)
+		return errorCreatingEncoder
+	}
+	nonce := binary.BigEndian.Uint64(nonceBytes)
+
+	encoder.SetNonceInt(nonce)
+	status := encoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
+			mte.GetStatusName(status), mte.GetStatusDescription(status))
+		return errorCreatingEncoder
+	}
+	decoder.SetNonceInt(nonce)
+	status = decoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
+			mte.GetStatusName(status), mte.GetStatusDescription(status))
+		return errorCreatingDecoder
+	}
+
+	//------------------------------------------
+	// Print every event the receiver reports
+	printEvent := func(event mteSequencing.Event) {
+		switch {
+		case event.Kind == mteSequencing.EventDecoded:
+			fmt.Printf("#%d %v: %s\n", event.Sequence, event.Kind, event.Message)
+		case event.Err != nil && event.Status == mte.Status_mte_status_success:
+			fmt.Printf("#%d %v: %v\n", event.Sequence, event.Kind, event.Err)
+		default:
+			fmt.Printf("#%d %v: %v\n", event.Sequence, event.Kind, mte.GetStatusName(event.Status))
+		}
+	}
+	receiveCtx, stopReceiving := context.WithCancel(ctx)
+	receiveDone := make(chan struct{})
+	go func() {
+		defer close(receiveDone)
+		if *ordered {
+			buffer := mteSequencing.NewReorderBuffer(decoder, mteSequencing.DefaultReorderPolicy(), printEvent)
+			buffer.Run(receiveCtx, receiverLink)
+		} else {
+			mteSequencing.NewReceiver(decoder, receiverLink, printEvent).Run(receiveCtx)
+		}
+	}()
+
+	//-----------------------------------------
+	// Send the messages over the lossy link
+	fmt.Printf("Sequence window: %d\n", config.SequenceWindow)
+	sender := mteSequencing.NewSender(encoder, &lossyLink{
+		link:      senderLink,
+		drop:      *drop,
+		duplicate: *duplicate,
+		reorder:   *reorder,
+		random:    mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	})
+	for i := 0; i < *count; i++ {
+		_, err = sender.Send(ctx, []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending: %v\n", err)
+			stopReceiving()
+			return errorSending
+		}
+	}
+
+	//----------------------------------------
+	// Give the last packets time to arrive and
+	// the reorder buffer time to give up on gaps
+	time.Sleep(2 * mteSequencing.DefaultReorderTimeout)
+	stopReceiving()
+	<-receiveDone
+	return 0
+}
+
+//-------------------------------------------------
+// Link that drops, duplicates and reorders packets
+type lossyLink struct {
+	link      mteSequencing.Link
+	drop      float64
+	duplicate float64
+	reorder   float64
+	random    *mrand.Rand
+	held      []byte
+}
+
+func (l *lossyLink) Receive(ctx context.Context) ([]byte, error) {
+	return l.link.Receive(ctx)
+}
+
+func (l *lossyLink) Close() error {
+	return l.link.Close()
+}
+
+func (l *lossyLink) Send(ctx context.Context, packet []byte) error {
+	if l.random.Float64() < l.drop {
+		fmt.Printf("#%d dropped\n", binary.BigEndian.Uint64(packet))
+		return nil
+	}
+	if l.held == nil && l.random.Float64() < l.reorder {
+		l.held = packet
+		return nil
+	}
+	err := l.link.Send(ctx, packet)
+	if err == nil && l.random.Float64() < l.duplicate {
+		err = l.link.Send(ctx, packet)
+	}
+	if err == nil && l.held != nil {
+		err = l.link.Send(ctx, l.held)
+		l.held = nil
+	}
+	return err
+}
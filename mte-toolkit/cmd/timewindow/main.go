@@ -0,0 +1,139 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteTimestamp"
+)
+
+const (
+	personal = "timewindow"
+
+	//--------------------------
+	// Error return exit codes
+	errorMteLicense      = 101
+	errorCreatingEncoder = 102
+	errorCreatingDecoder = 103
+	errorEncoding        = 104
+)
+
+/**
+ * Time window command
+ * Encodes messages with the timestamp verifier and decodes
+ * them after a delay, showing that a message older than the
+ * time window is rejected. The delay is simulated with a fake
+ * clock unless -wait is set.
+ *
+ * Usage: timewindow [-window d] [-delays d,d,...] [-wait]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	window := flag.Duration("window", 2*time.Second, "time window of the Decoder")
+	delays := flag.String("delays", "0s,1s,3s", "comma separated delays between encode and decode")
+	wait := flag.Bool("wait", false, "really wait for each delay instead of using a fake clock")
+	flag.Parse()
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	var clock mteTimestamp.Clock = mteTimestamp.SystemClock{}
+	fakeClock := mteTimestamp.NewFakeClock(time.Now())
+	if !*wait {
+		clock = fakeClock
+	}
+	config := mteTimestamp.Config{Window: *window, SequenceWindow: -1, Clock: clock}
+
+	//-------------------------------------------------------
+	// Create the Encoder and Decoder with shared random
+	// entropy, in a real system it comes from the handshake
+	encoder := mteTimestamp.NewEncoder(config)
+	defer encoder.Destroy()
+	decoder := mteTimestamp.NewDecoder(config)
+	defer decoder.Destroy()
+	err := mteEntropy.Set(mteEntropy.RandomProvider{}, mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder, decoder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating entropy: %v\n", err)
+		return errorCreatingEncoder
+	}
+	encoder.SetNonceInt(0)
+	status := encoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		fmt.Fprintf(os.Stderr, "Encoder instantiate error (%v): %v\n",
+			mte.GetStatusName(status), mte.GetStatusDescription(status))
+		return errorCreatingEncoder
+	}
+	decoder.SetNonceInt(0)
+	status = decoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		fmt.Fprintf(os.Stderr, "Decoder instantiate error (%v): %v\n",
+			mte.GetStatusName(status), mte.GetStatusDescription(status))
+		return errorCreatingDecoder
+	}
+
+	fmt.Printf("Time window: %v\n", *window)
+	for i, field := range strings.Split(*delays, ",") {
+		field = strings.TrimSpace(field)
+		delay, err := time.ParseDuration(field)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid delay %q: %v\n", field, err)
+			continue
+		}
+		message := fmt.Sprintf("message %d", i)
+		encoded, status := encoder.EncodeStr(message)
+		if status != mte.Status_mte_status_success {
+			fmt.Fprintf(os.Stderr, "Encode error (%v): %v\n",
+				mte.GetStatusName(status), mte.GetStatusDescription(status))
+			return errorEncoding
+		}
+		if *wait {
+			time.Sleep(delay)
+		} else {
+			fakeClock.Advance(delay)
+		}
+		decoded, status := decoder.DecodeStr(encoded)
+		fmt.Printf("Decode after %v: %v, %v\n", delay, mte.GetStatusName(status), decoded)
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteStore"
+	"mteToolkit/mteWebSocket"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	//-----------------
+	// Default values
+	defaultAddress  = "127.0.0.1:7001"
+	defaultUrl      = "ws://127.0.0.1:7001/ws"
+	defaultStateDir = ".mteWebSocket"
+	clientIdKey     = "client_id"
+
+	//--------------------------
+	// Error return exit codes
+	errorUsage      = 101
+	errorMteLicense = 102
+	errorStore      = 103
+	errorConnecting = 104
+	errorSending    = 105
+	errorReceiving  = 106
+)
+
+/**
+ * WebSocket echo command
+ * The server sends every message back, the client sends every
+ * line typed and prints the answer. The client keeps its session
+ * in the state directory, so running it again continues the same
+ * session while the server is still up
+ *
+ * Usage: wsecho serve [-addr host:port]
+ *        wsecho connect [-url ws://host:port/ws] [-state dir]
+ */
+func main() {
+	os.Exit(doMain())
+}
+
+func doMain() int {
+	if len(os.Args) < 2 || (os.Args[1] != "serve" && os.Args[1] != "connect") {
+		fmt.Fprintln(os.Stderr, "Usage: wsecho serve|connect [-addr host:port] [-url ws://host:port/ws] [-state dir]")
+		return errorUsage
+	}
+	mode := os.Args[1]
+	flags := flag.NewFlagSet("wsecho "+mode, flag.ExitOnError)
+	address := flags.String("addr", defaultAddress, "address the server listens on")
+	url := flags.String("url", defaultUrl, "url the client connects to")
+	stateDir := flags.String("state", defaultStateDir, "directory the client keeps its session in")
+	flags.Parse(os.Args[2:])
+
+	//--------------------------------------
+	// Initialize MTE license. This attempts
+	// to load the license from environment
+	if !mte.InitLicense(os.Getenv("MTE_COMPANY"), os.Getenv("MTE_LICENSE")) {
+		fmt.Fprintf(os.Stderr, "License init error (%v): %v\n",
+			mte.GetStatusName(mte.Status_mte_status_license_error),
+			mte.GetStatusDescription(mte.Status_mte_status_license_error))
+		return errorMteLicense
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if mode == "serve" {
+		return serve(ctx, *address)
+	}
+	return connect(ctx, *url, &mteStore.FileStore{Dir: *stateDir})
+}
+
+/**
+ * Runs the echo server until Ctrl+C is hit
+ * The sessions of closed connections are sealed with a
+ * key that only lives in this process
+ */
+func serve(ctx context.Context, address string) int {
+	key, err := mteStore.NewKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the store key: %v\n", err)
+		return errorStore
+	}
+	store, err := mteStore.NewSealedStore(mteStore.NewMemoryStore(), key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the store: %v\n", err)
+		return errorStore
+	}
+	upgrader := &mteWebSocket.Upgrader{Capabilities: mteAdapter.DefaultCapabilities(mteHandshake.ModeCore), Store: store}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			log.Printf("Upgrade error: %v", err)
+			return
+		}
+		defer conn.Close()
+		log.Printf("Client %s connected, resumed: %v", conn.ClientId(), conn.Resumed())
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					log.Printf("Client %s: %v", conn.ClientId(), err)
+				}
+				return
+			}
+			err = conn.WriteMessage(messageType, message)
+			if err != nil {
+				log.Printf("Client %s: %v", conn.ClientId(), err)
+				return
+			}
+		}
+	})
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("Echo server listening on %s\n", address)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		return errorConnecting
+	}
+	return 0
+}
+
+/**
+ * Connects to the echo server and sends every line typed
+ */
+func connect(ctx context.Context, url string, store *mteStore.FileStore) int {
+	//-------------------------------------------
+	// Keep the client ID with the session so the
+	// next run resumes as the same client
+	clientId, err := store.Get(clientIdKey)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		clientId = []byte(uuid.New().String())
+		err = store.Set(clientIdKey, clientId)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the client ID: %v\n", err)
+		return errorStore
+	}
+
+	conn, err := mteWebSocket.Dial(ctx, url, nil, string(clientId), mteAdapter.DefaultCapabilities(mteHandshake.ModeCore), store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting: %v\n", err)
+		return errorConnecting
+	}
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing: %v\n", err)
+		}
+	}()
+	fmt.Printf("Connected as client: %s, resumed: %v\n", conn.ClientId(), conn.Resumed())
+	fmt.Println("Type a message and press enter, Ctrl+C or end of input to quit")
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case line, ok := <-lines:
+			if !ok {
+				return 0
+			}
+			err = conn.WriteMessage(mteWebSocket.TextMessage, []byte(line))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error sending: %v\n", err)
+				return errorSending
+			}
+			_, echo, err := conn.ReadMessage()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error receiving: %v\n", err)
+				return errorReceiving
+			}
+			fmt.Printf("echo> %s\n", echo)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+module mteToolkit
+
+go 1.18
+
+require (
+	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.3
+)
@@ -0,0 +1,157 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteTesting
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"mteToolkit/mte"
+)
+
+const (
+	//---------------------------------------------
+	// Environment variables holding the license
+	CompanyEnv = "MTE_COMPANY"
+	LicenseEnv = "MTE_LICENSE"
+)
+
+//----------------------------------------
+// The license is only initialized once
+var licenseOnce sync.Once
+var licenseOk bool
+
+/**
+ * Initializes the MTE license from the environment
+ * Skips the test when no license is set, so go test ./...
+ * passes on machines without one, and fails it when the
+ * license that is set is not accepted
+ */
+func RequireLicense(t testing.TB) {
+	t.Helper()
+	company, license := os.Getenv(CompanyEnv), os.Getenv(LicenseEnv)
+	if company == "" || license == "" {
+		t.Skipf("set %s and %s to run the MTE tests", CompanyEnv, LicenseEnv)
+	}
+	licenseOnce.Do(func() {
+		licenseOk = mte.InitLicense(company, license)
+	})
+	if !licenseOk {
+		t.Fatal("MTE license init error")
+	}
+}
+
+//-----------------------------------------------------------
+// Everything sent over the connections of a test, in both
+// directions, so the test can check no plaintext leaked
+type Wire struct {
+	lock sync.Mutex
+	data bytes.Buffer
+}
+
+/**
+ * Returns a copy of everything recorded so far
+ */
+func (w *Wire) Bytes() []byte {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]byte(nil), w.data.Bytes()...)
+}
+
+/**
+ * Wraps a connection so what it reads and writes is recorded
+ */
+func (w *Wire) Conn(conn net.Conn) net.Conn {
+	return &recordingConn{Conn: conn, wire: w}
+}
+
+/**
+ * Wraps a listener so every accepted connection is recorded
+ */
+func (w *Wire) Listener(listener net.Listener) net.Listener {
+	return &recordingListener{Listener: listener, wire: w}
+}
+
+/**
+ * Fails the test when any of the plaintexts, or their
+ * base64, was sent over the wire
+ */
+func (w *Wire) CheckEncoded(t testing.TB, plaintexts ...[]byte) {
+	t.Helper()
+	wire := w.Bytes()
+	if len(wire) == 0 {
+		t.Fatal("nothing was recorded on the wire")
+	}
+	for _, plaintext := range plaintexts {
+		if bytes.Contains(wire, plaintext) {
+			t.Errorf("plaintext %q found on the wire", plaintext)
+		}
+		if bytes.Contains(wire, []byte(base64.StdEncoding.EncodeToString(plaintext))) {
+			t.Errorf("base64 of %q found on the wire", plaintext)
+		}
+	}
+}
+
+func (w *Wire) record(b []byte) {
+	w.lock.Lock()
+	w.data.Write(b)
+	w.lock.Unlock()
+}
+
+type recordingConn struct {
+	net.Conn
+	wire *Wire
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.wire.record(b[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.wire.record(b[:n])
+	return n, err
+}
+
+type recordingListener struct {
+	net.Listener
+	wire *Wire
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.wire.Conn(conn), nil
+}
@@ -0,0 +1,258 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteAdapter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"mteToolkit/eclypsesEcdh"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteSession"
+)
+
+const (
+	//--------------------
+	// Content type const
+	jsonContent = "application/json"
+)
+
+/**
+ * Performs Handshake with Server
+ * Creates the ECDH public keys and sends them to server
+ * with the MTE options this client offers
+ * When the client receives it back generates the shared secrets
+ * Then creates the Encoder and Decoder states
+ *
+ * ctx: cancels the handshake
+ * client: Http client used to reach the server
+ * serverUrl: API server url
+ * clientId: clientId string
+ * offer: MTE options this client supports
+ *
+ * Returns the new Session
+ */
+func PerformHandshake(ctx context.Context,
+	client *mteHttp.Client,
+	serverUrl string,
+	clientId string,
+	offer mteHandshake.Capabilities) (out *mteSession.Session, err error) {
+	secrets, err := Exchange(ctx, client, serverUrl, clientId, offer)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(serverUrl, clientId, secrets.Nonce, secrets.Options,
+		mteEntropy.NewEcdhProvider(secrets.EncoderEntropy), mteEntropy.NewEcdhProvider(secrets.DecoderEntropy))
+}
+
+/**
+ * Performs the key exchange of the handshake
+ * Use this instead of PerformHandshake to instantiate
+ * something other than one Encoder and Decoder pair
+ *
+ * Returns the shared secrets, nonce and agreed options
+ */
+func Exchange(ctx context.Context,
+	client *mteHttp.Client,
+	serverUrl string,
+	clientId string,
+	offer mteHandshake.Capabilities) (out *mteSession.Secrets, err error) {
+	return ExchangeRequest(ctx, client, serverUrl, mteHandshake.NewRequest(clientId, offer))
+}
+
+/**
+ * Performs the key exchange for a prepared request
+ * Use this to send more than the capabilities, for example
+ * the Device the client runs on
+ *
+ * request: request from mteHandshake.NewRequest, the public
+ * keys are filled in here
+ *
+ * Returns the shared secrets, nonce and agreed options
+ */
+func ExchangeRequest(ctx context.Context,
+	client *mteHttp.Client,
+	serverUrl string,
+	request mteHandshake.HandshakeRequest) (out *mteSession.Secrets, err error) {
+	exchange, err := NewClientExchange(request)
+	if err != nil {
+		return nil, err
+	}
+	defer exchange.Close()
+
+	//---------------------------------------
+	// Send the request and read the answer
+	handshakeBytes, err := json.Marshal(exchange.Request())
+	if err != nil {
+		return nil, err
+	}
+	body, err := client.Do(ctx, mteHttp.Request{
+		Method:      "POST",
+		Url:         serverUrl + mteSession.HandshakeRoute,
+		ClientId:    request.ConversationIdentifier,
+		ContentType: jsonContent,
+		Body:        handshakeBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	serverResponse, err := mteHttp.DecodeResponse[mteHandshake.HandshakeResponse](body)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := exchange.Finish(serverResponse.Data)
+	if err != nil {
+		return nil, err
+	}
+	secrets.ServerUrl = serverUrl
+	return secrets, nil
+}
+
+//------------------------------------------------------------
+// Client side of the key exchange over any transport
+// Send the Request to the server, pass its answer to Finish
+// and Close the exchange to clear the ECDH keys
+type ClientExchange struct {
+	request     mteHandshake.HandshakeRequest
+	offer       mteHandshake.Capabilities
+	encoderEcdh *eclypsesEcdh.EclypsesEcdh
+	decoderEcdh *eclypsesEcdh.EclypsesEcdh
+}
+
+/**
+ * Creates the client ECDH keys for a handshake request
+ *
+ * request: request from mteHandshake.NewRequest, the public
+ * keys are filled in here
+ *
+ * Returns the ClientExchange
+ */
+func NewClientExchange(request mteHandshake.HandshakeRequest) (out *ClientExchange, err error) {
+	e := &ClientExchange{
+		request:     request,
+		encoderEcdh: eclypsesEcdh.New(),
+		decoderEcdh: eclypsesEcdh.New(),
+	}
+	if request.Capabilities != nil {
+		e.offer = *request.Capabilities
+	}
+
+	//-----------------------------------
+	// Get the Encoder and Decoder keys
+	clientEncoderPKBytes, err := e.encoderEcdh.GetPublicKey()
+	if err != nil {
+		e.Close()
+		return nil, fmt.Errorf("creating Encoder public key: %w", err)
+	}
+	clientDecoderPKBytes, err := e.decoderEcdh.GetPublicKey()
+	if err != nil {
+		e.Close()
+		return nil, fmt.Errorf("creating Decoder public key: %w", err)
+	}
+	e.request.ClientEncoderPublicKey = base64.StdEncoding.EncodeToString(clientEncoderPKBytes)
+	e.request.ClientDecoderPublicKey = base64.StdEncoding.EncodeToString(clientDecoderPKBytes)
+	return e, nil
+}
+
+/**
+ * Returns the request with the client public keys
+ */
+func (e *ClientExchange) Request() mteHandshake.HandshakeRequest {
+	return e.request
+}
+
+/**
+ * Checks the server response and creates the shared secrets
+ *
+ * response: handshake response from the server
+ *
+ * Returns the shared secrets, nonce and agreed options
+ */
+func (e *ClientExchange) Finish(response mteHandshake.HandshakeResponse) (out *mteSession.Secrets, err error) {
+	//-------------------------------------------
+	// Check the MTE options the server agreed to
+	options, err := mteHandshake.Accept(e.offer, response)
+	if err != nil {
+		return nil, err
+	}
+
+	//-------------------------------
+	// Create the shared secrets
+	enSSBytes, err := createSharedSecret(e.encoderEcdh, response.ClientEncoderPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating Encoder shared secret: %w", err)
+	}
+	deSSBytes, err := createSharedSecret(e.decoderEcdh, response.ClientDecoderPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating Decoder shared secret: %w", err)
+	}
+
+	//----------------------------
+	// Parse nonce from timestamp
+	nonce, err := strconv.ParseUint(response.TimeStamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing nonce: %w", err)
+	}
+	return &mteSession.Secrets{
+		ClientId:       e.request.ConversationIdentifier,
+		Nonce:          nonce,
+		Options:        options,
+		EncoderEntropy: enSSBytes,
+		DecoderEntropy: deSSBytes,
+		Device:         e.request.Device,
+	}, nil
+}
+
+/**
+ * Clears the ECDH keys
+ */
+func (e *ClientExchange) Close() {
+	e.encoderEcdh.ClearContainer()
+	e.decoderEcdh.ClearContainer()
+}
+
+//----------------------------------------
+// The part of Eclypses ECDH we need here
+type sharedSecretCreator interface {
+	CreateSharedSecret(partnerPublicKey []byte, entropy []byte) ([]byte, error)
+}
+
+/**
+ * Base64 decodes the partner public key and creates the shared secret
+ */
+func createSharedSecret(ecdh sharedSecretCreator, partnerPublicKey string) (out []byte, err error) {
+	partnerPublicKeyBytes, err := base64.StdEncoding.DecodeString(partnerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.CreateSharedSecret(partnerPublicKeyBytes, nil)
+}
@@ -0,0 +1,270 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteAdapter
+
+import (
+	"fmt"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteMode"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteSwitch"
+)
+
+//-----------------------------------------------------------
+// The MTE Go package of the toolkit, errors.Is matches the
+// mteCoder error for the statuses callers act on
+var Library = &mteCoder.Library[mte.Status, mte.Drbgs]{
+	Success:           mte.Status_mte_status_success,
+	IsError:           mte.StatusIsError,
+	StatusName:        mte.GetStatusName,
+	StatusDescription: mte.GetStatusDescription,
+	ReseedInterval:    mte.GetDrbgsReseedInterval,
+	Errors: map[mte.Status]error{
+		mte.Status_mte_status_token_does_not_exist:  mteCoder.ErrTokenDoesNotExist,
+		mte.Status_mte_status_seq_mismatch:          mteCoder.ErrSeqMismatch,
+		mte.Status_mte_status_seq_outside_window:    mteCoder.ErrSeqOutsideWindow,
+		mte.Status_mte_status_seq_async_replay:      mteCoder.ErrSeqAsyncReplay,
+		mte.Status_mte_status_drbg_seedlife_reached: mteCoder.ErrSeedLifeReached,
+	},
+}
+
+//--------------------------
+// MTE status as an error
+type StatusError = mteCoder.StatusError[mte.Status]
+
+/**
+ * Creates the error of a status that is not success
+ */
+func NewStatusError(action string, status mte.Status) error {
+	return Library.NewStatusError(action, status)
+}
+
+//------------------------------------------------------
+// The MTE Core Encoder and Decoder, sessions created by
+// NewSession and the toolkit servers use these
+var MteCoders = mteSession.Coders{
+	NewEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+		return WrapEncoder(NewCoreEncoder(options))
+	},
+	NewDecoder: func(options mteHandshake.MteOptions) mteCoder.Decoder {
+		return WrapDecoder(NewCoreDecoder(options))
+	},
+	EntropyBytes: func(options mteHandshake.MteOptions) int {
+		return mte.GetDrbgsEntropyMinBytes(drbgOf(options))
+	},
+}
+
+//------------------------------------------------------
+// The MTE Encoders and Decoders of every mode for
+// mteSwitch sessions
+var SwitchCoders = mteSwitch.Coders{
+	NewCoreEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+		return WrapEncoder(NewCoreEncoder(options))
+	},
+	NewCoreDecoder: func(options mteHandshake.MteOptions) mteCoder.Decoder {
+		return WrapDecoder(NewCoreDecoder(options))
+	},
+	NewFlenEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+		return WrapFlenEncoder(NewFlenEncoder(options))
+	},
+	NewMkeEncoder: func(options mteHandshake.MteOptions) mteCoder.ChunkEncoder {
+		return WrapChunkEncoder(NewMkeEncoder(options))
+	},
+	NewMkeDecoder: func(options mteHandshake.MteOptions) mteCoder.ChunkDecoder {
+		return WrapChunkDecoder(NewMkeDecoder(options))
+	},
+	EntropyBytes: MteCoders.EntropyBytes,
+}
+
+/**
+ * Creates a new session on the MTE Core Encoder and Decoder
+ * and keeps the DRBG of the options, see mteSession.New
+ *
+ * Returns the Session
+ */
+func NewSession(serverUrl string,
+	clientId string,
+	nonce uint64,
+	options mteHandshake.MteOptions,
+	encoderEntropy mteEntropy.Provider,
+	decoderEntropy mteEntropy.Provider) (out *mteSession.Session, err error) {
+
+	session, err := mteSession.New(MteCoders, serverUrl, clientId, nonce, options, encoderEntropy, decoderEntropy)
+	if err != nil {
+		return nil, err
+	}
+	session.Drbg = int(drbgOf(options))
+	return session, nil
+}
+
+/**
+ * Encodes a message with the FLEN Encoder, padded to the
+ * agreed fixed length so the encoded size does not give away
+ * the message length, and moves the session Encoder state forward
+ * FLEN shares the state of the Core Encoder, the other side
+ * decodes with its Core Decoder
+ * Messages longer than the fixed length are rejected with
+ * mteHandshake.ErrMessageTooLong
+ *
+ * Returns the encoded message
+ */
+func EncodeFixed(session *mteSession.Session, message []byte) (out []byte, err error) {
+	if !session.Options.HasMode(mteHandshake.ModeFlen) {
+		return nil, fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mteHandshake.ModeFlen)
+	}
+	err = session.Options.CheckFixedLength(len(message))
+	if err != nil {
+		return nil, err
+	}
+	encoder := WrapFlenEncoder(NewFlenEncoder(session.Options))
+	defer encoder.Destroy()
+	err = mteCoder.RestoreStateB64(encoder, session.EncoderState)
+	if err != nil {
+		return nil, fmt.Errorf("FLEN Encoder restore: %w", err)
+	}
+	encoded, err := encoder.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	session.SaveEncoderState(encoder)
+	return encoded, nil
+}
+
+/**
+ * Wraps an instantiated or new MTE Core Encoder
+ */
+func WrapEncoder(encoder *mte.MteEnc) mteCoder.Encoder {
+	return Library.Encoder(encoder)
+}
+
+/**
+ * Wraps an instantiated or new MTE FLEN Encoder, the other
+ * side decodes its messages with the Core Decoder
+ */
+func WrapFlenEncoder(encoder *mte.MteFlenEnc) mteCoder.Encoder {
+	return Library.Encoder(encoder)
+}
+
+/**
+ * Wraps an instantiated or new MTE Core Decoder
+ */
+func WrapDecoder(decoder *mte.MteDec) mteCoder.Decoder {
+	return Library.Decoder(decoder)
+}
+
+/**
+ * Wraps an instantiated or new MTE MKE Encoder
+ */
+func WrapChunkEncoder(encoder *mte.MteMkeEnc) mteCoder.ChunkEncoder {
+	return Library.ChunkEncoder(encoder)
+}
+
+/**
+ * Wraps an instantiated or new MTE MKE Decoder
+ */
+func WrapChunkDecoder(decoder *mte.MteMkeDec) mteCoder.ChunkDecoder {
+	return Library.ChunkDecoder(decoder)
+}
+
+/**
+ * DRBG the MTE Encoders and Decoders use with these options
+ */
+func drbgOf(options mteHandshake.MteOptions) mte.Drbgs {
+	if options.IsDefault() {
+		return mte.GetDefaultDrbg()
+	}
+	return mte.Drbgs(options.Drbg)
+}
+
+/**
+ * Creates the MTE Core Encoder using the agreed options
+ */
+func NewCoreEncoder(options mteHandshake.MteOptions) *mte.MteEnc {
+	if options.IsDefault() {
+		return mte.NewEncDef()
+	}
+	return mte.NewEncOpt(mte.Drbgs(options.Drbg), options.TokBytes, mte.Verifiers(options.Verifiers))
+}
+
+/**
+ * Creates the MTE Core Decoder using the agreed options
+ */
+func NewCoreDecoder(options mteHandshake.MteOptions) *mte.MteDec {
+	if options.IsDefault() {
+		return mte.NewDecDef()
+	}
+	return mte.NewDecOpt(mte.Drbgs(options.Drbg), options.TokBytes, mte.Verifiers(options.Verifiers),
+		options.TimeWindow, options.SequenceWindow)
+}
+
+/**
+ * Creates the MTE FLEN Encoder using the agreed options
+ * and fixed length, the Core Decoder decodes its messages
+ */
+func NewFlenEncoder(options mteHandshake.MteOptions) *mte.MteFlenEnc {
+	if options.IsDefault() {
+		return mte.NewFlenEncDef(options.FixedLength)
+	}
+	return mte.NewFlenEncOpt(mte.Drbgs(options.Drbg), options.TokBytes, mte.Verifiers(options.Verifiers),
+		options.FixedLength)
+}
+
+/**
+ * Creates the MTE MKE Encoder using the agreed options
+ */
+func NewMkeEncoder(options mteHandshake.MteOptions) *mte.MteMkeEnc {
+	if options.IsDefault() {
+		return mte.NewMkeEncDef()
+	}
+	return mte.NewMkeEncOpt(mte.Drbgs(options.Drbg), options.TokBytes, mte.Verifiers(options.Verifiers),
+		mte.GetDefaultCipher(), mte.GetDefaultHash())
+}
+
+/**
+ * Creates the MTE MKE Decoder using the agreed options
+ */
+func NewMkeDecoder(options mteHandshake.MteOptions) *mte.MteMkeDec {
+	if options.IsDefault() {
+		return mte.NewMkeDecDef()
+	}
+	return mte.NewMkeDecOpt(mte.Drbgs(options.Drbg), options.TokBytes, mte.Verifiers(options.Verifiers),
+		mte.GetDefaultCipher(), mte.GetDefaultHash(), options.TimeWindow, options.SequenceWindow)
+}
+
+/**
+ * Capabilities offering the defaults of this MTE library
+ *
+ * modes: MTE modes to offer
+ */
+func DefaultCapabilities(modes ...string) mteHandshake.Capabilities {
+	return mteHandshake.DefaultCapabilities(int(mte.GetDefaultDrbg()), mte.GetDefaultTokBytes(),
+		int(mte.GetDefaultVerifiers()), modes...)
+}
@@ -0,0 +1,116 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteAdapter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"mteToolkit/eclypsesEcdh"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+)
+
+/**
+ * Server side of the handshake
+ * Creates the server ECDH keys, the shared secrets and the nonce
+ * for a client request and negotiates the MTE options
+ * The server Decoder pairs with the client Encoder, so its
+ * entropy comes from the client Encoder public key and the
+ * other way around
+ *
+ * request: handshake request sent by the client
+ * server: MTE options this server supports
+ *
+ * Returns the response to send back and the server secrets,
+ * which keep the Device the client reported
+ */
+func Respond(request mteHandshake.HandshakeRequest,
+	server mteHandshake.Capabilities) (out mteHandshake.HandshakeResponse, secrets *mteSession.Secrets, err error) {
+	response := mteHandshake.HandshakeResponse{
+		ConversationIdentifier: request.ConversationIdentifier,
+		Version:                mteHandshake.LegacyVersion,
+	}
+
+	//--------------------------------------------
+	// Legacy clients get the MTE defaults
+	options := mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion}
+	if request.Version >= mteHandshake.ProtocolVersion && request.Capabilities != nil {
+		options, err = mteHandshake.Negotiate(*request.Capabilities, server)
+		if err != nil {
+			return response, nil, err
+		}
+		response.Version = mteHandshake.ProtocolVersion
+		response.Options = &options
+	}
+
+	//----------------------------------------------
+	// Create Eclypses ECDH for Encoder and Decoder
+	encoderEcdh := eclypsesEcdh.New()
+	decoderEcdh := eclypsesEcdh.New()
+	defer encoderEcdh.ClearContainer()
+	defer decoderEcdh.ClearContainer()
+
+	encoderPKBytes, err := encoderEcdh.GetPublicKey()
+	if err != nil {
+		return response, nil, fmt.Errorf("creating Encoder public key: %w", err)
+	}
+	decoderPKBytes, err := decoderEcdh.GetPublicKey()
+	if err != nil {
+		return response, nil, fmt.Errorf("creating Decoder public key: %w", err)
+	}
+
+	//-------------------------------
+	// Create the shared secrets
+	enSSBytes, err := createSharedSecret(encoderEcdh, request.ClientDecoderPublicKey)
+	if err != nil {
+		return response, nil, fmt.Errorf("creating Encoder shared secret: %w", err)
+	}
+	deSSBytes, err := createSharedSecret(decoderEcdh, request.ClientEncoderPublicKey)
+	if err != nil {
+		return response, nil, fmt.Errorf("creating Decoder shared secret: %w", err)
+	}
+
+	//----------------------------------------------
+	// The client reads the nonce from the timestamp
+	// and the server keys from the same fields it sent
+	nonce := uint64(time.Now().UnixMilli())
+	response.TimeStamp = strconv.FormatUint(nonce, 10)
+	response.ClientEncoderPublicKey = base64.StdEncoding.EncodeToString(decoderPKBytes)
+	response.ClientDecoderPublicKey = base64.StdEncoding.EncodeToString(encoderPKBytes)
+
+	return response, &mteSession.Secrets{
+		ClientId:       request.ConversationIdentifier,
+		Nonce:          nonce,
+		Options:        options,
+		EncoderEntropy: enSSBytes,
+		DecoderEntropy: deSSBytes,
+		Device:         request.Device,
+	}, nil
+}
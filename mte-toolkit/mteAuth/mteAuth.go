@@ -0,0 +1,291 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteAuth
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mteToolkit/mteHttp"
+)
+
+const (
+	//---------------------------------------------
+	// Environment variables holding the login
+	UserNameEnv        = "MTE_LOGIN_USERNAME"
+	PasswordEnv        = "MTE_LOGIN_PASSWORD"
+	CredentialsFileEnv = "MTE_LOGIN_FILE"
+
+	//-------------------------------------------------
+	// Lifetime used when the token has no expiry, and
+	// how long before the expiry the token is renewed
+	DefaultTokenLifetime = 15 * time.Minute
+	DefaultExpiryMargin  = 30 * time.Second
+
+	//---------------------
+	// Authorization header
+	AuthorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+//------------------
+// Error messages
+var ErrNoCredentials = errors.New("no login credentials")
+var ErrNoToken = errors.New("server did not return an access token")
+
+//------------------------------------------
+// Login sent to the server, the file holds
+// the same json the login route expects
+type Credentials struct {
+	UserName string
+	Password string
+}
+
+//------------------------------------
+// Access token and when it expires
+type Token struct {
+	AccessToken string
+	Expires     time.Time
+}
+
+/**
+ * Reads the login credentials
+ * The file named by MTE_LOGIN_FILE is used first, then the
+ * MTE_LOGIN_USERNAME and MTE_LOGIN_PASSWORD environment variables.
+ * When neither is set the user is prompted for them.
+ *
+ * in: where the prompt answers are read from
+ * out: where the prompts are written to
+ */
+func ReadCredentials(in io.Reader, out io.Writer) (credentials Credentials, err error) {
+	if path := os.Getenv(CredentialsFileEnv); path != "" {
+		return CredentialsFromFile(path)
+	}
+	credentials, err = CredentialsFromEnv()
+	if err == nil {
+		return credentials, nil
+	}
+	return PromptCredentials(in, out)
+}
+
+/**
+ * Reads the credentials from the environment variables
+ */
+func CredentialsFromEnv() (credentials Credentials, err error) {
+	credentials.UserName = os.Getenv(UserNameEnv)
+	credentials.Password = os.Getenv(PasswordEnv)
+	if credentials.UserName == "" || credentials.Password == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return credentials, nil
+}
+
+/**
+ * Reads the credentials from a json file
+ * The file should only be readable by the current user
+ */
+func CredentialsFromFile(path string) (credentials Credentials, err error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("reading credentials file: %w", err)
+	}
+	err = json.Unmarshal(fileBytes, &credentials)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("parsing credentials file: %w", err)
+	}
+	if credentials.UserName == "" || credentials.Password == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return credentials, nil
+}
+
+/**
+ * Prompts the user for the credentials
+ * The password is echoed, use the environment or a
+ * file when running where others can see the screen
+ */
+func PromptCredentials(in io.Reader, out io.Writer) (credentials Credentials, err error) {
+	reader := bufio.NewReader(in)
+	fmt.Fprint(out, "Please enter login user name\n")
+	credentials.UserName, err = readLine(reader)
+	if err != nil {
+		return Credentials{}, err
+	}
+	fmt.Fprint(out, "Please enter login password\n")
+	credentials.Password, err = readLine(reader)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if credentials.UserName == "" || credentials.Password == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return credentials, nil
+}
+
+/**
+ * Reads one line without the carriage return
+ */
+func readLine(reader *bufio.Reader) (out string, err error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+/**
+ * Creates a Token from the access token the server returned
+ * The expiry is read from the exp claim when the token is a JWT,
+ * otherwise the token is good for DefaultTokenLifetime
+ *
+ * accessToken: token returned by the login route
+ * now: time the token was received
+ */
+func NewToken(accessToken string, now time.Time) Token {
+	token := Token{AccessToken: accessToken, Expires: now.Add(DefaultTokenLifetime)}
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return token
+	}
+	claimBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return token
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(claimBytes, &claims) == nil && claims.Exp > 0 {
+		token.Expires = time.Unix(claims.Exp, 0)
+	}
+	return token
+}
+
+/**
+ * Returns true when the token is missing or expires within margin
+ */
+func (t Token) Expired(now time.Time, margin time.Duration) bool {
+	return t.AccessToken == "" || !now.Add(margin).Before(t.Expires)
+}
+
+//------------------------------------------------------
+// Logs in and returns the access token from the server
+type LoginFunc func(ctx context.Context) (accessToken string, err error)
+
+//---------------------------------------------------------
+// Keeps the access token and logs in again when it is
+// missing, about to expire or rejected by the server
+type Authenticator struct {
+	login        LoginFunc
+	token        Token
+	ExpiryMargin time.Duration
+	Now          func() time.Time
+	lock         sync.Mutex
+}
+
+/**
+ * Creates an Authenticator that uses login to get a token
+ */
+func NewAuthenticator(login LoginFunc) *Authenticator {
+	return &Authenticator{
+		login:        login,
+		ExpiryMargin: DefaultExpiryMargin,
+		Now:          time.Now,
+	}
+}
+
+/**
+ * Returns a valid access token, logging in first when
+ * there is no token or it is about to expire
+ */
+func (a *Authenticator) Token(ctx context.Context) (out string, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.token.Expired(a.Now(), a.ExpiryMargin) {
+		return a.token.AccessToken, nil
+	}
+	accessToken, err := a.login(ctx)
+	if err != nil {
+		return "", err
+	}
+	if accessToken == "" {
+		return "", ErrNoToken
+	}
+	a.token = NewToken(accessToken, a.Now())
+	return a.token.AccessToken, nil
+}
+
+/**
+ * Stores a new access token handed out by the server
+ */
+func (a *Authenticator) SetToken(accessToken string) {
+	if accessToken == "" {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.token = NewToken(accessToken, a.Now())
+}
+
+/**
+ * Drops the token so the next call to Token logs in again
+ */
+func (a *Authenticator) Invalidate() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.token = Token{}
+}
+
+/**
+ * Sets the bearer header on the request header
+ */
+func (a *Authenticator) Authorize(ctx context.Context, header http.Header) error {
+	accessToken, err := a.Token(ctx)
+	if err != nil {
+		return err
+	}
+	header.Set(AuthorizationHeader, bearerPrefix+accessToken)
+	return nil
+}
+
+/**
+ * Returns true when the server rejected the access token
+ */
+func IsUnauthorized(err error) bool {
+	var statusErr *mteHttp.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,267 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteAuth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mteToolkit/mteHttp"
+)
+
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+/**
+ * Builds an unsigned JWT with the given claims
+ */
+func testJwt(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".signature"
+}
+
+func TestNewToken(t *testing.T) {
+	exp := testNow.Add(time.Hour)
+	fallback := testNow.Add(DefaultTokenLifetime)
+	cases := []struct {
+		name    string
+		token   string
+		expires time.Time
+	}{
+		{"jwt exp", testJwt(fmt.Sprintf(`{"sub":"u","exp":%d}`, exp.Unix())), exp},
+		{"padded claims", strings.Replace(testJwt(fmt.Sprintf(`{"exp":%d}`, exp.Unix())), ".signature", "==.signature", 1), exp},
+		{"no exp", testJwt(`{"sub":"u"}`), fallback},
+		{"zero exp", testJwt(`{"exp":0}`), fallback},
+		{"exp not a number", testJwt(`{"exp":"soon"}`), fallback},
+		{"claims not json", testJwt(`not json`), fallback},
+		{"claims not base64", "a.!!!.c", fallback},
+		{"opaque token", "3f2a9c", fallback},
+		{"two parts", "a.b", fallback},
+	}
+	for _, c := range cases {
+		token := NewToken(c.token, testNow)
+		if token.AccessToken != c.token {
+			t.Errorf("%s: access token changed to %q", c.name, token.AccessToken)
+		}
+		if !token.Expires.Equal(c.expires) {
+			t.Errorf("%s: expires %v want %v", c.name, token.Expires, c.expires)
+		}
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	token := Token{AccessToken: "token", Expires: testNow.Add(time.Minute)}
+	cases := []struct {
+		now     time.Time
+		margin  time.Duration
+		expired bool
+	}{
+		{testNow, 0, false},
+		{testNow, 30 * time.Second, false},
+		{testNow, time.Minute, true},
+		{testNow.Add(time.Minute), 0, true},
+		{testNow.Add(2 * time.Minute), 0, true},
+	}
+	for _, c := range cases {
+		if got := token.Expired(c.now, c.margin); got != c.expired {
+			t.Errorf("at %v margin %v: expired %v want %v", c.now, c.margin, got, c.expired)
+		}
+	}
+	if !(Token{Expires: testNow.Add(time.Hour)}).Expired(testNow, 0) {
+		t.Error("empty access token not expired")
+	}
+}
+
+/**
+ * Creates an Authenticator on a fake clock whose login
+ * hands out the given tokens in order
+ */
+func testAuthenticator(tokens ...string) (auth *Authenticator, now *time.Time, logins *int) {
+	clock := testNow
+	count := 0
+	auth = NewAuthenticator(func(ctx context.Context) (string, error) {
+		if count >= len(tokens) {
+			return "", errors.New("login refused")
+		}
+		count++
+		return tokens[count-1], nil
+	})
+	auth.Now = func() time.Time { return clock }
+	return auth, &clock, &count
+}
+
+func TestAuthenticatorLogsInOnce(t *testing.T) {
+	auth, _, logins := testAuthenticator("first", "second")
+	for i := 0; i < 3; i++ {
+		token, err := auth.Token(context.Background())
+		if err != nil || token != "first" {
+			t.Fatalf("call %d: got %q, %v", i, token, err)
+		}
+	}
+	if *logins != 1 {
+		t.Errorf("logged in %d times", *logins)
+	}
+}
+
+func TestAuthenticatorRenewsBeforeFallbackExpiry(t *testing.T) {
+	auth, now, logins := testAuthenticator("first", "second")
+	auth.Token(context.Background())
+
+	//------------------------------------------------
+	// The opaque token is good for 15 minutes, it is
+	// renewed once the expiry margin is reached
+	*now = testNow.Add(DefaultTokenLifetime - DefaultExpiryMargin - time.Second)
+	if token, _ := auth.Token(context.Background()); token != "first" {
+		t.Fatalf("renewed too early, got %q", token)
+	}
+	*now = testNow.Add(DefaultTokenLifetime - DefaultExpiryMargin)
+	if token, _ := auth.Token(context.Background()); token != "second" {
+		t.Fatalf("not renewed at the margin, got %q", token)
+	}
+	if *logins != 2 {
+		t.Errorf("logged in %d times", *logins)
+	}
+}
+
+func TestAuthenticatorUsesJwtExpiry(t *testing.T) {
+	first := testJwt(fmt.Sprintf(`{"exp":%d}`, testNow.Add(time.Hour).Unix()))
+	auth, now, _ := testAuthenticator(first, "second")
+	auth.Token(context.Background())
+	*now = testNow.Add(30 * time.Minute)
+	if token, _ := auth.Token(context.Background()); token != first {
+		t.Errorf("JWT renewed after the fallback lifetime, got %q", token)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	auth, _, logins := testAuthenticator("first", "second")
+	auth.Token(context.Background())
+	auth.Invalidate()
+	token, err := auth.Token(context.Background())
+	if err != nil || token != "second" {
+		t.Fatalf("got %q, %v", token, err)
+	}
+	if *logins != 2 {
+		t.Errorf("logged in %d times", *logins)
+	}
+}
+
+func TestSetToken(t *testing.T) {
+	auth, _, logins := testAuthenticator("first")
+	auth.SetToken("from server")
+	auth.SetToken("")
+	token, err := auth.Token(context.Background())
+	if err != nil || token != "from server" {
+		t.Fatalf("got %q, %v", token, err)
+	}
+	if *logins != 0 {
+		t.Errorf("logged in %d times", *logins)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	auth, _, _ := testAuthenticator()
+	if _, err := auth.Token(context.Background()); err == nil {
+		t.Error("login error not returned")
+	}
+	auth, _, _ = testAuthenticator("")
+	if _, err := auth.Token(context.Background()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("got %v want ErrNoToken", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	auth, _, _ := testAuthenticator("first")
+	header := http.Header{}
+	if err := auth.Authorize(context.Background(), header); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(AuthorizationHeader); got != "Bearer first" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&mteHttp.StatusError{StatusCode: http.StatusUnauthorized}, true},
+		{fmt.Errorf("login: %w", &mteHttp.StatusError{StatusCode: http.StatusUnauthorized}), true},
+		{&mteHttp.StatusError{StatusCode: http.StatusForbidden}, false},
+		{&mteHttp.ServerError{ResultCode: "401"}, false},
+		{errors.New("401 Unauthorized"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsUnauthorized(c.err); got != c.want {
+			t.Errorf("%v: got %v want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestCredentialsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	credentials, err := CredentialsFromFile(write("good.json", `{"UserName":"user","Password":"secret"}`))
+	if err != nil || credentials != (Credentials{UserName: "user", Password: "secret"}) {
+		t.Errorf("got %+v, %v", credentials, err)
+	}
+	if _, err = CredentialsFromFile(write("empty.json", `{"UserName":"user"}`)); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no password: got %v", err)
+	}
+	if _, err = CredentialsFromFile(write("bad.json", `{`)); err == nil {
+		t.Error("malformed file accepted")
+	}
+	if _, err = CredentialsFromFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestPromptCredentials(t *testing.T) {
+	var out strings.Builder
+	credentials, err := PromptCredentials(strings.NewReader("user\r\nsecret"), &out)
+	if err != nil || credentials != (Credentials{UserName: "user", Password: "secret"}) {
+		t.Errorf("got %+v, %v", credentials, err)
+	}
+	if _, err = PromptCredentials(strings.NewReader("user\n\n"), &out); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("empty password: got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteChat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteFrame"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+)
+
+//-------------------------------------------------------------
+// One side of a chat over a stream connection such as TCP
+// Everything travels in mteFrame frames. The ECDH handshake
+// is the first exchange on the connection in handshake frames,
+// then every message is an MTE packet in a data frame and a
+// close frame ends the chat. Each peer holds its own Encoder
+// and Decoder for the life of the connection, the Encoder of
+// one side pairs with the Decoder of the other. Send and
+// Receive can be called from different goroutines.
+type Peer struct {
+	clientId  string
+	conn      net.Conn
+	reader    *mteFrame.Reader
+	writer    *mteFrame.Writer
+	encoder   *mte.MteEnc
+	decoder   *mte.MteDec
+	writeLock sync.Mutex
+	readLock  sync.Mutex
+}
+
+/**
+ * Connects as the client of the chat
+ * Sends the handshake request and waits for the response
+ *
+ * conn: connection to the listening peer
+ * clientId: client ID, also used as personalization string
+ * offer: MTE options this peer supports
+ *
+ * Returns the Peer, it owns the connection
+ */
+func Connect(conn net.Conn, clientId string, offer mteHandshake.Capabilities) (out *Peer, err error) {
+	exchange, err := mteAdapter.NewClientExchange(mteHandshake.NewRequest(clientId, offer))
+	if err != nil {
+		return nil, err
+	}
+	defer exchange.Close()
+
+	p := newPeer(conn)
+	p.clientId = clientId
+	err = p.writeJson(exchange.Request())
+	if err != nil {
+		return nil, fmt.Errorf("sending handshake: %w", err)
+	}
+	var response mteHandshake.HandshakeResponse
+	err = p.readJson(&response)
+	if err != nil {
+		return nil, fmt.Errorf("reading handshake: %w", err)
+	}
+	secrets, err := exchange.Finish(response)
+	if err != nil {
+		return nil, err
+	}
+	err = p.instantiate(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+/**
+ * Accepts the chat as the listening side
+ * Waits for the handshake request and answers it
+ *
+ * conn: connection accepted from the client
+ * server: MTE options this peer supports
+ *
+ * Returns the Peer, it owns the connection
+ */
+func Accept(conn net.Conn, server mteHandshake.Capabilities) (out *Peer, err error) {
+	p := newPeer(conn)
+	var request mteHandshake.HandshakeRequest
+	err = p.readJson(&request)
+	if err != nil {
+		return nil, fmt.Errorf("reading handshake: %w", err)
+	}
+	response, secrets, err := mteAdapter.Respond(request, server)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writeJson(response)
+	if err != nil {
+		return nil, fmt.Errorf("sending handshake: %w", err)
+	}
+	p.clientId = secrets.ClientId
+	err = p.instantiate(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+/**
+ * Returns the client ID of the chat
+ */
+func (p *Peer) ClientId() string {
+	return p.clientId
+}
+
+/**
+ * Encodes a message and sends it to the other peer
+ */
+func (p *Peer) Send(message []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	if p.encoder == nil {
+		return net.ErrClosed
+	}
+	encoded, status := p.encoder.Encode(message)
+	if status != mte.Status_mte_status_success {
+		return mteAdapter.NewStatusError("Encode", status)
+	}
+	return p.writer.WriteFrame(mteFrame.Frame{Type: mteFrame.TypeData, Payload: encoded})
+}
+
+/**
+ * Waits for the next message from the other peer and decodes it
+ * Returns io.EOF once the other peer closed the chat or the
+ * connection
+ */
+func (p *Peer) Receive() (out []byte, err error) {
+	p.readLock.Lock()
+	defer p.readLock.Unlock()
+	if p.decoder == nil {
+		return nil, net.ErrClosed
+	}
+	frame, err := p.reader.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	switch frame.Type {
+	case mteFrame.TypeData:
+	case mteFrame.TypeClose:
+		return nil, io.EOF
+	default:
+		return nil, fmt.Errorf("%w: 0x%02x in a chat", mteFrame.ErrUnknownType, frame.Type)
+	}
+	decoded, status := p.decoder.Decode(frame.Payload)
+	if mte.StatusIsError(status) {
+		return nil, mteAdapter.NewStatusError("Decode", status)
+	}
+	return decoded, nil
+}
+
+/**
+ * Tells the other peer the chat is over, closes the connection
+ * and destroys the Encoder and Decoder
+ * Wait for Send and Receive to return before calling it
+ */
+func (p *Peer) Close() error {
+	p.writeLock.Lock()
+	if p.encoder != nil {
+		_ = p.writer.WriteFrame(mteFrame.Frame{Type: mteFrame.TypeClose})
+	}
+	p.writeLock.Unlock()
+	err := p.conn.Close()
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	p.readLock.Lock()
+	defer p.readLock.Unlock()
+	if p.encoder != nil {
+		p.encoder.Destroy()
+		p.encoder = nil
+	}
+	if p.decoder != nil {
+		p.decoder.Destroy()
+		p.decoder = nil
+	}
+	return err
+}
+
+func newPeer(conn net.Conn) *Peer {
+	return &Peer{
+		conn:   conn,
+		reader: mteFrame.NewReader(conn, 0),
+		writer: mteFrame.NewWriter(conn, 0),
+	}
+}
+
+func (p *Peer) writeJson(value interface{}) error {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteFrame(mteFrame.Frame{Type: mteFrame.TypeHandshake, Payload: valueBytes})
+}
+
+func (p *Peer) readJson(value interface{}) error {
+	frame, err := p.reader.ReadFrame()
+	if err != nil {
+		return err
+	}
+	if frame.Type != mteFrame.TypeHandshake {
+		return fmt.Errorf("%w: 0x%02x during the handshake", mteFrame.ErrUnknownType, frame.Type)
+	}
+	return json.Unmarshal(frame.Payload, value)
+}
+
+/**
+ * Instantiates the Encoder and Decoder from the handshake
+ * secrets, the client ID is the personalization string
+ */
+func (p *Peer) instantiate(secrets *mteSession.Secrets) error {
+	encoder := mteAdapter.NewCoreEncoder(secrets.Options)
+	err := mteEntropy.Set(mteEntropy.NewEcdhProvider(secrets.EncoderEntropy),
+		mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder)
+	if err != nil {
+		encoder.Destroy()
+		return fmt.Errorf("Encoder entropy: %w", err)
+	}
+	encoder.SetNonceInt(secrets.Nonce)
+	status := encoder.InstantiateStr(p.clientId)
+	if status != mte.Status_mte_status_success {
+		encoder.Destroy()
+		return mteAdapter.NewStatusError("Encoder instantiate", status)
+	}
+
+	decoder := mteAdapter.NewCoreDecoder(secrets.Options)
+	err = mteEntropy.Set(mteEntropy.NewEcdhProvider(secrets.DecoderEntropy),
+		mte.GetDrbgsEntropyMinBytes(decoder.GetDrbg()), decoder)
+	if err != nil {
+		encoder.Destroy()
+		decoder.Destroy()
+		return fmt.Errorf("Decoder entropy: %w", err)
+	}
+	decoder.SetNonceInt(secrets.Nonce)
+	status = decoder.InstantiateStr(p.clientId)
+	if status != mte.Status_mte_status_success {
+		encoder.Destroy()
+		decoder.Destroy()
+		return mteAdapter.NewStatusError("Decoder instantiate", status)
+	}
+	p.encoder = encoder
+	p.decoder = decoder
+	return nil
+}
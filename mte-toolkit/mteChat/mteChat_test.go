@@ -0,0 +1,227 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteChat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteFrame"
+	"mteToolkit/mteHandshake"
+)
+
+const testClientId = "chat-test-client"
+
+/**
+ * Starts a listening peer on localhost and connects to it
+ * Returns both peers, the listening one second
+ */
+func connectPeers(t *testing.T) (client *Peer, server *Peer, wire *mteTesting.Wire) {
+	mteTesting.RequireLicense(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type accepted struct {
+		peer *Peer
+		err  error
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- accepted{err: err}
+			return
+		}
+		peer, err := Accept(conn, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+		if err != nil {
+			conn.Close()
+		}
+		done <- accepted{peer: peer, err: err}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire = &mteTesting.Wire{}
+	client, err = Connect(wire.Conn(conn), testClientId, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+	if err != nil {
+		conn.Close()
+		t.Fatalf("connect: %v", err)
+	}
+	result := <-done
+	if result.err != nil {
+		client.Close()
+		t.Fatalf("accept: %v", result.err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		result.peer.Close()
+	})
+	return client, result.peer, wire
+}
+
+func TestChatBothDirections(t *testing.T) {
+	client, server, _ := connectPeers(t)
+	if server.ClientId() != testClientId {
+		t.Errorf("server client ID: got %q want %q", server.ClientId(), testClientId)
+	}
+
+	//-------------------------------------------
+	// The listening peer answers every message
+	served := make(chan error, 1)
+	go func() {
+		for {
+			message, err := server.Receive()
+			if err != nil {
+				served <- err
+				return
+			}
+			err = server.Send(append([]byte("re: "), message...))
+			if err != nil {
+				served <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		message := fmt.Sprintf("message %d", i)
+		err := client.Send([]byte(message))
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		reply, err := client.Receive()
+		if err != nil {
+			t.Fatalf("receive %d: %v", i, err)
+		}
+		if string(reply) != "re: "+message {
+			t.Fatalf("reply %d: got %q", i, reply)
+		}
+	}
+
+	//-------------------------------------------------
+	// The listening peer sees the end of the chat
+	client.Close()
+	err := <-served
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("after close: got %v want io.EOF", err)
+	}
+}
+
+func TestChatSendsAtTheSameTime(t *testing.T) {
+	client, server, _ := connectPeers(t)
+	const count = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for _, pair := range []struct {
+		name     string
+		from, to *Peer
+	}{{"client", client, server}, {"server", server, client}} {
+		pair := pair
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if err := pair.from.Send([]byte(fmt.Sprintf("%s %d", pair.name, i))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				message, err := pair.to.Receive()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want := fmt.Sprintf("%s %d", pair.name, i); string(message) != want {
+					errs <- fmt.Errorf("got %q want %q", message, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWireIsEncoded(t *testing.T) {
+	client, server, wire := connectPeers(t)
+	secret := []byte("this text must never be on the wire")
+	err := client.Send(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := server.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, secret) {
+		t.Fatalf("got %q", message)
+	}
+	wire.CheckEncoded(t, secret)
+}
+
+func TestReceiveRejectsOtherFrames(t *testing.T) {
+	client, server, _ := connectPeers(t)
+	for _, frameType := range []byte{mteFrame.TypeChunk, mteFrame.TypeHandshake} {
+		err := client.writer.WriteFrame(mteFrame.Frame{Type: frameType, Payload: []byte("not a message")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.Receive(); !errors.Is(err, mteFrame.ErrUnknownType) {
+			t.Errorf("frame type 0x%02x: got %v want ErrUnknownType", frameType, err)
+		}
+	}
+
+	//-------------------------------------------
+	// The chat goes on after the frames it skipped
+	if err := client.Send([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	message, err := server.Receive()
+	if err != nil || string(message) != "still here" {
+		t.Errorf("got %q %v", message, err)
+	}
+}
@@ -0,0 +1,234 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCheckpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//-------------------------------------
+	// Checkpoints kept when no size is set
+	DefaultSize = 8
+)
+
+//------------------
+// Error messages
+var ErrUnknownCheckpoint = errors.New("unknown checkpoint")
+
+//-----------------------------------
+// One labelled snapshot of a state
+type Checkpoint struct {
+	Label   string
+	State   []byte
+	Created time.Time
+}
+
+//-------------------------------------------------------------
+// Keeps a bounded ring of labelled snapshots of a Decoder so
+// a batch that fails validation can be processed again from
+// the state the Decoder had before the batch, without a new
+// handshake. When the ring is full the oldest one is dropped.
+// Every change is written to the Store under Key, use a
+// mteStore.SealedStore, the snapshots are as secret as the
+// Decoder state itself. The Decoder is any mteCoder.State,
+// wrap an MTE Decoder with the mteAdapter wrappers.
+type Manager struct {
+	decoder     mteCoder.State
+	size        int
+	store       mteStore.Store
+	key         string
+	checkpoints []Checkpoint
+	lock        sync.Mutex
+}
+
+/**
+ * Creates a checkpoint Manager
+ *
+ * decoder: the Decoder to take snapshots of
+ * size: number of checkpoints to keep, DefaultSize when 0
+ * store: where the checkpoints are kept, may be nil
+ * key: key of the checkpoints in the store
+ *
+ * Returns the Manager holding the checkpoints already in store
+ */
+func NewManager(decoder mteCoder.State, size int, store mteStore.Store, key string) (out *Manager, err error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	m := &Manager{decoder: decoder, size: size, store: store, key: key}
+	if store == nil {
+		return m, nil
+	}
+	stored, err := store.Get(key)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading checkpoints: %w", err)
+	}
+	err = json.Unmarshal(stored, &m.checkpoints)
+	if err != nil {
+		return nil, fmt.Errorf("parsing checkpoints: %w", err)
+	}
+	if len(m.checkpoints) > size {
+		m.checkpoints = m.checkpoints[len(m.checkpoints)-size:]
+	}
+	return m, nil
+}
+
+/**
+ * Takes a snapshot of the Decoder under label
+ * A checkpoint with the same label is replaced
+ */
+func (m *Manager) Save(label string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.remove(label)
+	m.checkpoints = append(m.checkpoints, Checkpoint{
+		Label:   label,
+		State:   m.decoder.SaveState(),
+		Created: time.Now(),
+	})
+	if len(m.checkpoints) > m.size {
+		m.checkpoints = m.checkpoints[len(m.checkpoints)-m.size:]
+	}
+	return m.persist()
+}
+
+/**
+ * Restores the Decoder to the checkpoint with label
+ * The checkpoint is kept, every checkpoint taken after it
+ * is dropped as it describes a state that no longer exists
+ */
+func (m *Manager) Rollback(label string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	i := m.find(label)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownCheckpoint, label)
+	}
+	err := m.decoder.RestoreState(m.checkpoints[i].State)
+	if err != nil {
+		return fmt.Errorf("restoring checkpoint %s: %w", label, err)
+	}
+	m.checkpoints = m.checkpoints[:i+1]
+	return m.persist()
+}
+
+/**
+ * Processes a batch from a checkpoint
+ * Takes a checkpoint under label, then runs process. When
+ * process returns an error, for example because the batch
+ * failed validation, the Decoder is rolled back to the
+ * checkpoint so the batch can be processed again.
+ *
+ * Returns the error of process, or of the rollback
+ */
+func (m *Manager) Batch(label string, process func() error) error {
+	err := m.Save(label)
+	if err != nil {
+		return err
+	}
+	err = process()
+	if err == nil {
+		return nil
+	}
+	rollbackErr := m.Rollback(label)
+	if rollbackErr != nil {
+		return fmt.Errorf("%v, rollback failed: %w", err, rollbackErr)
+	}
+	return err
+}
+
+/**
+ * Drops the checkpoint with label
+ */
+func (m *Manager) Delete(label string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.remove(label) {
+		return nil
+	}
+	return m.persist()
+}
+
+/**
+ * Returns the labels of the checkpoints, oldest first
+ */
+func (m *Manager) Labels() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	labels := make([]string, len(m.checkpoints))
+	for i, checkpoint := range m.checkpoints {
+		labels[i] = checkpoint.Label
+	}
+	return labels
+}
+
+func (m *Manager) find(label string) int {
+	for i, checkpoint := range m.checkpoints {
+		if checkpoint.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Manager) remove(label string) bool {
+	i := m.find(label)
+	if i < 0 {
+		return false
+	}
+	m.checkpoints = append(m.checkpoints[:i], m.checkpoints[i+1:]...)
+	return true
+}
+
+/**
+ * Writes the checkpoints to the store
+ */
+func (m *Manager) persist() error {
+	if m.store == nil {
+		return nil
+	}
+	checkpointBytes, err := json.Marshal(m.checkpoints)
+	if err != nil {
+		return err
+	}
+	err = m.store.Set(m.key, checkpointBytes)
+	if err != nil {
+		return fmt.Errorf("saving checkpoints: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCheckpoint
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteStore"
+)
+
+//----------------------------------------------
+// Decoder stand in, the state is a counter the
+// test moves forward as messages are decoded
+type fakeDecoder struct {
+	state int
+}
+
+func (d *fakeDecoder) SaveState() []byte {
+	return []byte(strconv.Itoa(d.state))
+}
+
+func (d *fakeDecoder) RestoreState(state []byte) (err error) {
+	d.state, err = strconv.Atoi(string(state))
+	return err
+}
+
+func checkLabels(t *testing.T, m *Manager, want ...string) {
+	t.Helper()
+	if got := m.Labels(); !reflect.DeepEqual(got, want) && !(len(got) == 0 && len(want) == 0) {
+		t.Errorf("labels %v want %v", got, want)
+	}
+}
+
+func TestRollbackDropsLaterCheckpoints(t *testing.T) {
+	decoder := &fakeDecoder{}
+	m, err := NewManager(decoder, 0, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, label := range []string{"a", "b", "c"} {
+		decoder.state = i * 10
+		if err = m.Save(label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoder.state = 99
+	if err = m.Rollback("b"); err != nil {
+		t.Fatal(err)
+	}
+	if decoder.state != 10 {
+		t.Errorf("state %d after rollback, want 10", decoder.state)
+	}
+	checkLabels(t, m, "a", "b")
+	if err = m.Rollback("c"); !errors.Is(err, ErrUnknownCheckpoint) {
+		t.Errorf("rollback to a dropped checkpoint: got %v", err)
+	}
+
+	//------------------------------------------
+	// The checkpoint rolled back to can be used
+	// again for another attempt
+	decoder.state = 50
+	if err = m.Rollback("b"); err != nil || decoder.state != 10 {
+		t.Errorf("second rollback: state %d, %v", decoder.state, err)
+	}
+}
+
+func TestRingDropsOldest(t *testing.T) {
+	decoder := &fakeDecoder{}
+	m, _ := NewManager(decoder, 2, nil, "")
+	for _, label := range []string{"a", "b", "c"} {
+		m.Save(label)
+	}
+	checkLabels(t, m, "b", "c")
+	if err := m.Rollback("a"); !errors.Is(err, ErrUnknownCheckpoint) {
+		t.Errorf("got %v want ErrUnknownCheckpoint", err)
+	}
+}
+
+func TestSaveReplacesLabel(t *testing.T) {
+	decoder := &fakeDecoder{}
+	m, _ := NewManager(decoder, 0, nil, "")
+	m.Save("a")
+	m.Save("b")
+	decoder.state = 7
+	m.Save("a")
+	checkLabels(t, m, "b", "a")
+	decoder.state = 0
+	m.Rollback("a")
+	if decoder.state != 7 {
+		t.Errorf("state %d want the newest snapshot 7", decoder.state)
+	}
+	m.Delete("b")
+	m.Delete("missing")
+	checkLabels(t, m, "a")
+}
+
+func TestBatch(t *testing.T) {
+	decoder := &fakeDecoder{state: 1}
+	m, _ := NewManager(decoder, 0, nil, "")
+	failure := errors.New("batch failed validation")
+	err := m.Batch("batch 1", func() error {
+		decoder.state = 5
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v want the batch error", err)
+	}
+	if decoder.state != 1 {
+		t.Errorf("state %d after a failed batch, want 1", decoder.state)
+	}
+	err = m.Batch("batch 2", func() error {
+		decoder.state = 6
+		return nil
+	})
+	if err != nil || decoder.state != 6 {
+		t.Errorf("successful batch: state %d, %v", decoder.state, err)
+	}
+}
+
+func TestCheckpointsArePersisted(t *testing.T) {
+	store := mteStore.NewMemoryStore()
+	decoder := &fakeDecoder{}
+	m, _ := NewManager(decoder, 0, store, "checkpoints")
+	for i, label := range []string{"a", "b", "c"} {
+		decoder.state = i
+		m.Save(label)
+	}
+	m.Rollback("b")
+
+	//----------------------------------------------
+	// A new Manager picks up where the last one was,
+	// a smaller ring keeps the newest checkpoints
+	other := &fakeDecoder{}
+	loaded, err := NewManager(other, 0, store, "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabels(t, loaded, "a", "b")
+	small, _ := NewManager(other, 1, store, "checkpoints")
+	checkLabels(t, small, "b")
+	if err = small.Rollback("b"); err != nil || other.state != 1 {
+		t.Errorf("rollback after reload: state %d, %v", other.state, err)
+	}
+
+	store.Set("broken", []byte("{"))
+	if _, err = NewManager(other, 0, store, "broken"); err == nil {
+		t.Error("malformed checkpoints accepted")
+	}
+	empty, err := NewManager(other, 0, store, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabels(t, empty)
+}
+
+func TestBatchWithCoderFakes(t *testing.T) {
+	encoder := mteCoder.NewFakeEncoder()
+	decoder := mteCoder.NewFakeDecoder(0)
+	for _, seeder := range []mteCoder.Seeder{encoder, decoder} {
+		seeder.SetEntropy(make([]byte, 32))
+		seeder.SetNonceInt(0)
+		if err := seeder.InstantiateStr("checkpoint test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var batch [][]byte
+	for _, message := range []string{"first", "second"} {
+		encoded, err := encoder.Encode([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, encoded)
+	}
+	m, _ := NewManager(decoder, 0, nil, "")
+
+	//-----------------------------------------------------
+	// The first attempt fails validation after decoding,
+	// the second one decodes the same batch again
+	failure := errors.New("batch failed validation")
+	for _, want := range []error{failure, nil} {
+		err := m.Batch("batch", func() error {
+			for _, encoded := range batch {
+				if _, err := decoder.Decode(encoded); err != nil {
+					return err
+				}
+			}
+			return want
+		})
+		if err != want {
+			t.Fatalf("batch: got %v want %v", err, want)
+		}
+	}
+	if _, err := decoder.Decode(batch[0]); err == nil {
+		t.Error("decoded a message of the batch after it was accepted")
+	}
+}
+
+func TestRollbackRestoreError(t *testing.T) {
+	store := mteStore.NewMemoryStore()
+	store.Set("checkpoints", []byte(`[{"Label":"a","State":"bm90IGEgc3RhdGU="}]`))
+	m, err := NewManager(mteCoder.NewFakeDecoder(0), 0, store, "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Rollback("a"); !errors.Is(err, mteCoder.ErrInvalidState) {
+		t.Errorf("got %v want %v", err, mteCoder.ErrInvalidState)
+	}
+	checkLabels(t, m, "a")
+}
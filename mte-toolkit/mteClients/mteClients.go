@@ -0,0 +1,175 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteClients
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//-------------------------------------
+	// Store key prefix of a client session
+	sessionPrefix = "session_"
+)
+
+//------------------
+// Error messages
+var ErrUnknownClient = errors.New("unknown client, perform the handshake first")
+
+//-----------------------------------------------------------------
+// Sessions of many clients in one Store, each under its client ID.
+// Use a mteStore.SealedStore, the sessions hold the Encoder and
+// Decoder states. Updates to one client are serialized, other
+// clients are not held up. A client lock only exists while it is
+// in use, so client IDs that never did a handshake leave nothing
+// behind.
+type Clients struct {
+	store  mteStore.Store
+	coders mteSession.Coders
+	lock   sync.Mutex
+	locks  map[string]*clientLock
+}
+
+//---------------------------------------------
+// Lock of one client and how many are waiting
+type clientLock struct {
+	sync.Mutex
+	users int
+}
+
+/**
+ * Creates the Clients
+ *
+ * store: where the sessions are kept
+ * coders: Encoders and Decoders of the sessions,
+ * usually mteAdapter.MteCoders
+ */
+func New(store mteStore.Store, coders mteSession.Coders) *Clients {
+	return &Clients{store: store, coders: coders, locks: make(map[string]*clientLock)}
+}
+
+/**
+ * Keeps a new session, replacing the one of an earlier handshake
+ */
+func (c *Clients) Set(session *mteSession.Session) error {
+	lock := c.acquire(session.ClientId)
+	defer c.release(session.ClientId, lock)
+	return c.save(session)
+}
+
+/**
+ * Loads the session of a client, hands it to update and saves
+ * it again when update succeeds, so a failed decode never moves
+ * the state forward
+ *
+ * Returns ErrUnknownClient when the client has no session
+ */
+func (c *Clients) Update(clientId string, update func(session *mteSession.Session) error) error {
+	lock := c.acquire(clientId)
+	defer c.release(clientId, lock)
+	sessionBytes, err := c.store.Get(sessionPrefix + clientId)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrUnknownClient, clientId)
+	}
+	if err != nil {
+		return err
+	}
+	var session mteSession.Session
+	err = json.Unmarshal(sessionBytes, &session)
+	if err != nil {
+		return err
+	}
+	session.UseCoders(c.coders)
+	err = update(&session)
+	if err != nil {
+		return err
+	}
+	return c.save(&session)
+}
+
+/**
+ * Reports if the client has a session
+ */
+func (c *Clients) Exists(clientId string) (out bool, err error) {
+	_, err = c.store.Get(sessionPrefix + clientId)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/**
+ * Drops the session of a client
+ */
+func (c *Clients) Delete(clientId string) error {
+	lock := c.acquire(clientId)
+	defer c.release(clientId, lock)
+	return c.store.Delete(sessionPrefix + clientId)
+}
+
+func (c *Clients) save(session *mteSession.Session) error {
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return c.store.Set(sessionPrefix+session.ClientId, sessionBytes)
+}
+
+/**
+ * Locks a client, creating its lock when nobody holds it
+ */
+func (c *Clients) acquire(clientId string) *clientLock {
+	c.lock.Lock()
+	lock, ok := c.locks[clientId]
+	if !ok {
+		lock = &clientLock{}
+		c.locks[clientId] = lock
+	}
+	lock.users++
+	c.lock.Unlock()
+	lock.Lock()
+	return lock
+}
+
+/**
+ * Unlocks a client and drops its lock once nobody waits on it
+ */
+func (c *Clients) release(clientId string, lock *clientLock) {
+	lock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(c.locks, clientId)
+	}
+}
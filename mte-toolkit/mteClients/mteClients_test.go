@@ -0,0 +1,190 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteClients
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+var legacyOptions = mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion}
+
+/**
+ * Creates the client side session of clientId and keeps the
+ * matching server side session in clients
+ */
+func newClient(t *testing.T, clients *Clients, clientId string) *mteSession.Session {
+	t.Helper()
+	a := mteEntropy.NewInsecureFixedTestProvider([]byte(clientId + " to server"))
+	b := mteEntropy.NewInsecureFixedTestProvider([]byte("server to " + clientId))
+	client, err := mteSession.New(mteSession.InsecureFakeCoders(0), "", clientId, 1, legacyOptions, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := mteSession.New(mteSession.InsecureFakeCoders(0), "", clientId, 1, legacyOptions, b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clients.Set(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func newClients(t *testing.T) *Clients {
+	t.Helper()
+	key, err := mteStore.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := mteStore.NewSealedStore(mteStore.NewMemoryStore(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(store, mteSession.InsecureFakeCoders(0))
+}
+
+func TestClientsAtTheSameTime(t *testing.T) {
+	clients := newClients(t)
+	const count = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for c := 0; c < 8; c++ {
+		client := newClient(t, clients, fmt.Sprintf("client-%d", c))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				message := fmt.Sprintf("%s %d", client.ClientId, i)
+				encoded, err := client.EncodeB64(message)
+				if err != nil {
+					errs <- err
+					return
+				}
+				//---------------------------------------
+				// The server answers through the store
+				var reply string
+				err = clients.Update(client.ClientId, func(session *mteSession.Session) error {
+					decoded, err := session.DecodeB64(encoded)
+					if err != nil {
+						return err
+					}
+					reply, err = session.EncodeB64("re: " + decoded)
+					return err
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				decoded, err := client.DecodeB64(reply)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if decoded != "re: "+message {
+					errs <- fmt.Errorf("got %q want %q", decoded, "re: "+message)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(clients.locks) != 0 {
+		t.Errorf("%d client locks left", len(clients.locks))
+	}
+}
+
+func TestFailedUpdateKeepsTheSession(t *testing.T) {
+	clients := newClients(t)
+	client := newClient(t, clients, "client")
+	encoded, err := client.Encode([]byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte(nil), encoded...)
+	corrupt[len(corrupt)-1]++
+	err = clients.Update(client.ClientId, func(session *mteSession.Session) error {
+		_, err := session.Decode(corrupt)
+		return err
+	})
+	if !errors.Is(err, mteCoder.ErrTokenDoesNotExist) {
+		t.Fatalf("corrupt message: got %v want %v", err, mteCoder.ErrTokenDoesNotExist)
+	}
+	err = clients.Update(client.ClientId, func(session *mteSession.Session) error {
+		_, err := session.Decode(encoded)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownClient(t *testing.T) {
+	clients := newClients(t)
+	called := false
+	err := clients.Update("nobody", func(session *mteSession.Session) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrUnknownClient) || called {
+		t.Errorf("got %v called %v, want %v", err, called, ErrUnknownClient)
+	}
+	if exists, err := clients.Exists("nobody"); exists || err != nil {
+		t.Errorf("exists %v %v", exists, err)
+	}
+
+	newClient(t, clients, "client")
+	if exists, err := clients.Exists("client"); !exists || err != nil {
+		t.Errorf("exists %v %v", exists, err)
+	}
+	err = clients.Delete("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clients.Update("client", func(session *mteSession.Session) error { return nil })
+	if !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("after delete: got %v want %v", err, ErrUnknownClient)
+	}
+	if len(clients.locks) != 0 {
+		t.Errorf("%d client locks left", len(clients.locks))
+	}
+}
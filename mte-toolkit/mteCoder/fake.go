@@ -0,0 +1,430 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCoder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+)
+
+const (
+	//---------------------------------------------
+	// Reseed interval of the fakes unless changed
+	// with SetReseedInterval, small enough to reach
+	DefaultFakeReseedInterval = 1 << 16
+
+	//-------------------------------------------
+	// Fake Core token: sequence number and check
+	// in front of the payload, and the fake MKE tag
+	fakeSeqSize   = 8
+	fakeCheckSize = 4
+	fakeTagSize   = 16
+)
+
+//------------------------------------------------------------
+// Deterministic DRBG stand-in shared by the fakes
+// The seed is a hash of the entropy, nonce and personalization,
+// the same inputs always give the same output. It only exists
+// so code can be tested without the MTE library, it is NOT
+// secure and must never protect real data.
+type fakeDrbg struct {
+	entropy []byte
+	nonce   uint64
+	state   fakeState
+}
+
+//---------------------------------------------
+// Everything SaveState keeps, as JSON
+type fakeState struct {
+	Seed           []byte
+	Next           uint64
+	Seen           []uint64 `json:",omitempty"`
+	ReseedCounter  uint64
+	ReseedInterval uint64
+}
+
+func (f *fakeDrbg) SetEntropy(entropy []byte) {
+	f.entropy = entropy
+}
+
+func (f *fakeDrbg) SetNonceInt(nonce uint64) {
+	f.nonce = nonce
+}
+
+/**
+ * Derives the seed and zeroes the entropy like the MTE does
+ */
+func (f *fakeDrbg) InstantiateStr(personal string) error {
+	if len(f.entropy) == 0 {
+		return fmt.Errorf("instantiate: %w: no entropy", ErrNotInstantiated)
+	}
+	seed := sha256.New()
+	seed.Write([]byte("mte fake drbg"))
+	seed.Write(f.entropy)
+	binary.Write(seed, binary.BigEndian, f.nonce)
+	seed.Write([]byte(personal))
+	for i := range f.entropy {
+		f.entropy[i] = 0
+	}
+	f.entropy = nil
+
+	interval := f.state.ReseedInterval
+	if interval == 0 {
+		interval = DefaultFakeReseedInterval
+	}
+	f.state = fakeState{Seed: seed.Sum(nil), ReseedInterval: interval}
+	return nil
+}
+
+func (f *fakeDrbg) GetReseedCounter() uint64 {
+	return f.state.ReseedCounter
+}
+
+func (f *fakeDrbg) GetReseedInterval() uint64 {
+	if f.state.ReseedInterval == 0 {
+		return DefaultFakeReseedInterval
+	}
+	return f.state.ReseedInterval
+}
+
+/**
+ * Changes the reseed interval, so tests can reach it
+ */
+func (f *fakeDrbg) SetReseedInterval(interval uint64) {
+	f.state.ReseedInterval = interval
+}
+
+func (f *fakeDrbg) Destroy() {
+	f.state = fakeState{}
+}
+
+func (f *fakeDrbg) SaveState() []byte {
+	stateBytes, _ := json.Marshal(f.state)
+	return stateBytes
+}
+
+func (f *fakeDrbg) RestoreState(state []byte) error {
+	var restored fakeState
+	err := json.Unmarshal(state, &restored)
+	if err != nil || len(restored.Seed) != sha256.Size {
+		return fmt.Errorf("restore state: %w", ErrInvalidState)
+	}
+	f.state = restored
+	return nil
+}
+
+/**
+ * Checks the DRBG can produce another message
+ */
+func (f *fakeDrbg) ready(action string) error {
+	if f.state.Seed == nil {
+		return fmt.Errorf("%s: %w", action, ErrNotInstantiated)
+	}
+	if f.state.ReseedCounter >= f.GetReseedInterval() {
+		return fmt.Errorf("%s: %w", action, ErrSeedLifeReached)
+	}
+	return nil
+}
+
+/**
+ * XORs data with the keystream of message seq starting at offset
+ */
+func (f *fakeDrbg) xor(seq uint64, offset int, data []byte) {
+	var block []byte
+	for i := range data {
+		position := offset + i
+		if block == nil || position%sha256.Size == 0 {
+			block = f.block(seq, uint64(position/sha256.Size))
+		}
+		data[i] ^= block[position%sha256.Size]
+	}
+}
+
+func (f *fakeDrbg) block(seq uint64, index uint64) []byte {
+	h := f.hash("block", seq)
+	binary.Write(h, binary.BigEndian, index)
+	return h.Sum(nil)
+}
+
+/**
+ * Starts a hash keyed with the seed for message seq
+ */
+func (f *fakeDrbg) hash(label string, seq uint64) hash.Hash {
+	h := sha256.New()
+	h.Write(f.state.Seed)
+	h.Write([]byte(label))
+	binary.Write(h, binary.BigEndian, seq)
+	return h
+}
+
+//-----------------------------------------------------------
+// Fake MTE Core Encoder
+// Puts the sequence number and a check in front of the
+// keystream encrypted message, a Decoder that was not
+// instantiated the same way reports ErrTokenDoesNotExist
+type FakeEncoder struct {
+	fakeDrbg
+}
+
+/**
+ * Creates a FakeEncoder, instantiate it before use
+ */
+func NewFakeEncoder() *FakeEncoder {
+	return &FakeEncoder{}
+}
+
+func (e *FakeEncoder) Encode(message []byte) (out []byte, err error) {
+	err = e.ready("encode")
+	if err != nil {
+		return nil, err
+	}
+	seq := e.state.Next
+	encoded := make([]byte, fakeSeqSize+fakeCheckSize+len(message))
+	binary.BigEndian.PutUint64(encoded, seq)
+	copy(encoded[fakeSeqSize:], e.check(seq, message))
+	payload := encoded[fakeSeqSize+fakeCheckSize:]
+	copy(payload, message)
+	e.xor(seq, 0, payload)
+
+	e.state.Next++
+	e.state.ReseedCounter++
+	return encoded, nil
+}
+
+//--------------------------------------------------------------
+// Fake MTE Core Decoder with the MTE sequence window behavior
+// 0 only accepts the next message, a positive window skips
+// ahead up to window messages, a negative window also accepts
+// late messages up to -window behind and reports replays
+type FakeDecoder struct {
+	fakeDrbg
+	window int
+}
+
+/**
+ * Creates a FakeDecoder with a sequence window,
+ * instantiate it before use
+ */
+func NewFakeDecoder(window int) *FakeDecoder {
+	return &FakeDecoder{window: window}
+}
+
+func (d *FakeDecoder) Decode(encoded []byte) (out []byte, err error) {
+	err = d.ready("decode")
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) < fakeSeqSize+fakeCheckSize {
+		return nil, fmt.Errorf("decode: %w", ErrTokenDoesNotExist)
+	}
+	seq := binary.BigEndian.Uint64(encoded)
+	message := append([]byte(nil), encoded[fakeSeqSize+fakeCheckSize:]...)
+	d.xor(seq, 0, message)
+	if !bytes.Equal(encoded[fakeSeqSize:fakeSeqSize+fakeCheckSize], d.check(seq, message)) {
+		return nil, fmt.Errorf("decode: %w", ErrTokenDoesNotExist)
+	}
+	err = d.accept(seq)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	d.state.ReseedCounter++
+	return message, nil
+}
+
+/**
+ * Applies the sequence window and moves the Decoder forward
+ */
+func (d *FakeDecoder) accept(seq uint64) error {
+	next := d.state.Next
+	switch {
+	case d.window == 0:
+		if seq != next {
+			return ErrSeqMismatch
+		}
+	case d.window > 0:
+		if seq < next || seq-next > uint64(d.window) {
+			return ErrSeqOutsideWindow
+		}
+	default:
+		window := uint64(-d.window)
+		if seq >= next && seq-next > window {
+			return ErrSeqOutsideWindow
+		}
+		if seq < next {
+			if next-seq > window {
+				return ErrSeqOutsideWindow
+			}
+			for _, seen := range d.state.Seen {
+				if seen == seq {
+					return ErrSeqAsyncReplay
+				}
+			}
+			d.state.Seen = append(d.state.Seen, seq)
+			return nil
+		}
+		//-------------------------------------------------
+		// Remember what was decoded within the window
+		d.state.Seen = append(d.state.Seen, seq)
+		kept := d.state.Seen[:0]
+		for _, seen := range d.state.Seen {
+			if seq+1-seen <= window {
+				kept = append(kept, seen)
+			}
+		}
+		d.state.Seen = kept
+	}
+	d.state.Next = seq + 1
+	return nil
+}
+
+/**
+ * Check value of a Core message
+ */
+func (f *fakeDrbg) check(seq uint64, message []byte) []byte {
+	h := f.hash("check", seq)
+	h.Write(message)
+	return h.Sum(nil)[:fakeCheckSize]
+}
+
+//------------------------------------------------------------
+// Fake MTE MKE Encoder in chunk mode
+// The chunks are encrypted in place with the keystream,
+// FinishEncrypt returns a tag over the whole message
+type FakeChunkEncoder struct {
+	fakeDrbg
+	started bool
+	offset  int
+	tag     hash.Hash
+}
+
+/**
+ * Creates a FakeChunkEncoder, instantiate it before use
+ */
+func NewFakeChunkEncoder() *FakeChunkEncoder {
+	return &FakeChunkEncoder{}
+}
+
+func (e *FakeChunkEncoder) StartEncrypt() error {
+	err := e.ready("start encrypt")
+	if err != nil {
+		return err
+	}
+	e.started = true
+	e.offset = 0
+	e.tag = e.hash("tag", e.state.Next)
+	return nil
+}
+
+func (e *FakeChunkEncoder) EncryptChunk(chunk []byte) error {
+	if !e.started {
+		return fmt.Errorf("encrypt chunk: %w", ErrNotInstantiated)
+	}
+	e.tag.Write(chunk)
+	e.xor(e.state.Next, e.offset, chunk)
+	e.offset += len(chunk)
+	return nil
+}
+
+func (e *FakeChunkEncoder) FinishEncrypt() (out []byte, err error) {
+	if !e.started {
+		return nil, fmt.Errorf("finish encrypt: %w", ErrNotInstantiated)
+	}
+	e.started = false
+	e.state.Next++
+	e.state.ReseedCounter++
+	return e.tag.Sum(nil)[:fakeTagSize], nil
+}
+
+func (e *FakeChunkEncoder) EncryptFinishBytes() int {
+	return fakeTagSize
+}
+
+//------------------------------------------------------------
+// Fake MTE MKE Decoder in chunk mode
+// DecryptChunk holds back the last bytes it was given until
+// FinishDecrypt, they may be the tag. A tag that does not
+// match reports ErrTokenDoesNotExist.
+type FakeChunkDecoder struct {
+	fakeDrbg
+	started bool
+	offset  int
+	pending []byte
+	tag     hash.Hash
+}
+
+/**
+ * Creates a FakeChunkDecoder, instantiate it before use
+ */
+func NewFakeChunkDecoder() *FakeChunkDecoder {
+	return &FakeChunkDecoder{}
+}
+
+func (d *FakeChunkDecoder) StartDecrypt() error {
+	err := d.ready("start decrypt")
+	if err != nil {
+		return err
+	}
+	d.started = true
+	d.offset = 0
+	d.pending = nil
+	d.tag = d.hash("tag", d.state.Next)
+	return nil
+}
+
+func (d *FakeChunkDecoder) DecryptChunk(chunk []byte) []byte {
+	if !d.started {
+		return nil
+	}
+	d.pending = append(d.pending, chunk...)
+	if len(d.pending) <= fakeTagSize {
+		return []byte{}
+	}
+	release := len(d.pending) - fakeTagSize
+	decrypted := append([]byte(nil), d.pending[:release]...)
+	d.pending = append([]byte(nil), d.pending[release:]...)
+	d.xor(d.state.Next, d.offset, decrypted)
+	d.offset += len(decrypted)
+	d.tag.Write(decrypted)
+	return decrypted
+}
+
+func (d *FakeChunkDecoder) FinishDecrypt() (out []byte, err error) {
+	if !d.started {
+		return nil, fmt.Errorf("finish decrypt: %w", ErrNotInstantiated)
+	}
+	d.started = false
+	if !bytes.Equal(d.pending, d.tag.Sum(nil)[:fakeTagSize]) {
+		return nil, fmt.Errorf("finish decrypt: %w", ErrTokenDoesNotExist)
+	}
+	d.state.Next++
+	d.state.ReseedCounter++
+	return []byte{}, nil
+}
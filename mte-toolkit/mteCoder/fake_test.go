@@ -0,0 +1,260 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCoder
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const (
+	testPersonal = "fake test"
+	testNonce    = uint64(1234)
+)
+
+//------------------------------------------------
+// The fakes must satisfy the interfaces
+var _ Encoder = (*FakeEncoder)(nil)
+var _ Decoder = (*FakeDecoder)(nil)
+var _ ChunkEncoder = (*FakeChunkEncoder)(nil)
+var _ ChunkDecoder = (*FakeChunkDecoder)(nil)
+
+//---------------------------------------------------
+// One delivery to the Decoder
+// msg is the index of the message in the encode order
+// corrupt flips the last byte of the encoded message
+type fakeStep struct {
+	msg     int
+	corrupt bool
+	err     error
+}
+
+var fakeCases = []struct {
+	name   string
+	window int
+	steps  []fakeStep
+}{
+	{
+		name:   "verification only",
+		window: 0,
+		steps: []fakeStep{
+			{msg: 0},
+			{msg: 0, err: ErrSeqMismatch},
+			{msg: 2, err: ErrSeqMismatch},
+			{msg: 1, corrupt: true, err: ErrTokenDoesNotExist},
+			{msg: 1},
+			{msg: 2},
+		},
+	},
+	{
+		name:   "forward only",
+		window: 2,
+		steps: []fakeStep{
+			{msg: 0},
+			{msg: 2},
+			{msg: 1, err: ErrSeqOutsideWindow},
+			{msg: 2, err: ErrSeqOutsideWindow},
+			{msg: 6, err: ErrSeqOutsideWindow},
+			{msg: 3},
+		},
+	},
+	{
+		name:   "async",
+		window: -2,
+		steps: []fakeStep{
+			{msg: 0},
+			{msg: 2},
+			{msg: 1},
+			{msg: 1, err: ErrSeqAsyncReplay},
+			{msg: 2, err: ErrSeqAsyncReplay},
+			{msg: 5},
+			{msg: 2, err: ErrSeqOutsideWindow},
+			{msg: 4},
+			{msg: 4, err: ErrSeqAsyncReplay},
+		},
+	},
+}
+
+func TestFakeSequencing(t *testing.T) {
+	for _, tc := range fakeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoder := NewFakeEncoder()
+			instantiate(t, encoder)
+			var encoded [][]byte
+			for i := 0; i < 8; i++ {
+				message, err := encoder.Encode([]byte{byte(i)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				encoded = append(encoded, message)
+			}
+			decoder := NewFakeDecoder(tc.window)
+			instantiate(t, decoder)
+			for i, step := range tc.steps {
+				message := append([]byte(nil), encoded[step.msg]...)
+				if step.corrupt {
+					message[len(message)-1]++
+				}
+				decoded, err := decoder.Decode(message)
+				if !errors.Is(err, step.err) || (step.err == nil && err != nil) {
+					t.Errorf("step %d: message %d: error %v, want %v", i, step.msg, err, step.err)
+					continue
+				}
+				if err == nil && !bytes.Equal(decoded, []byte{byte(step.msg)}) {
+					t.Errorf("step %d: decoded %v, want %v", i, decoded, step.msg)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeStateAndReseed(t *testing.T) {
+	encoder := NewFakeEncoder()
+	instantiate(t, encoder)
+	encoder.SetReseedInterval(3)
+	decoder := NewFakeDecoder(0)
+	instantiate(t, decoder)
+
+	first, err := EncodeStrB64(encoder, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := SaveStateB64(decoder)
+	for i := 0; i < 2; i++ {
+		decoded, err := DecodeStrB64(decoder, first)
+		if err != nil || decoded != "first" {
+			t.Fatalf("decode %d: %q %v", i, decoded, err)
+		}
+		err = RestoreStateB64(decoder, saved)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = decoder.RestoreState([]byte("not a state")); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("restore garbage: %v, want %v", err, ErrInvalidState)
+	}
+
+	for i := 1; i < 3; i++ {
+		if _, err = encoder.Encode([]byte("more")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !ReseedNeeded(encoder, .9) || encoder.GetReseedCounter() != 3 {
+		t.Errorf("reseed counter %d of %d", encoder.GetReseedCounter(), encoder.GetReseedInterval())
+	}
+	if _, err = encoder.Encode([]byte("too many")); !errors.Is(err, ErrSeedLifeReached) {
+		t.Errorf("encode past seed life: %v, want %v", err, ErrSeedLifeReached)
+	}
+}
+
+func TestFakeMismatchedInstantiate(t *testing.T) {
+	encoder := NewFakeEncoder()
+	instantiate(t, encoder)
+	decoder := NewFakeDecoder(0)
+	decoder.SetEntropy([]byte("other entropy"))
+	decoder.SetNonceInt(testNonce)
+	if err := decoder.InstantiateStr(testPersonal); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encoder.Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = decoder.Decode(encoded); !errors.Is(err, ErrTokenDoesNotExist) {
+		t.Errorf("decode: %v, want %v", err, ErrTokenDoesNotExist)
+	}
+}
+
+func TestFakeChunks(t *testing.T) {
+	encoder := NewFakeChunkEncoder()
+	instantiate(t, encoder)
+	decoder := NewFakeChunkDecoder()
+	instantiate(t, decoder)
+
+	for _, corrupt := range []bool{false, true} {
+		plain := bytes.Repeat([]byte("0123456789"), 10)
+		if err := encoder.StartEncrypt(); err != nil {
+			t.Fatal(err)
+		}
+		var stream []byte
+		for _, size := range []int{7, 33, 60} {
+			chunk := append([]byte(nil), plain[len(stream):len(stream)+size]...)
+			if err := encoder.EncryptChunk(chunk); err != nil {
+				t.Fatal(err)
+			}
+			stream = append(stream, chunk...)
+		}
+		finish, err := encoder.FinishEncrypt()
+		if err != nil || len(finish) != encoder.EncryptFinishBytes() {
+			t.Fatalf("finish encrypt: %d bytes, %v", len(finish), err)
+		}
+		stream = append(stream, finish...)
+		if corrupt {
+			stream[0]++
+		}
+
+		if err = decoder.StartDecrypt(); err != nil {
+			t.Fatal(err)
+		}
+		var decrypted []byte
+		for start := 0; start < len(stream); start += 25 {
+			end := start + 25
+			if end > len(stream) {
+				end = len(stream)
+			}
+			decrypted = append(decrypted, decoder.DecryptChunk(stream[start:end])...)
+		}
+		rest, err := decoder.FinishDecrypt()
+		if corrupt {
+			if !errors.Is(err, ErrTokenDoesNotExist) {
+				t.Errorf("corrupt finish decrypt: %v, want %v", err, ErrTokenDoesNotExist)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted = append(decrypted, rest...)
+		if !bytes.Equal(decrypted, plain) {
+			t.Errorf("decrypted %q, want %q", decrypted, plain)
+		}
+	}
+}
+
+/**
+ * Instantiates a fake with fixed test inputs
+ */
+func instantiate(t *testing.T, seeder Seeder) {
+	t.Helper()
+	seeder.SetEntropy([]byte("fake test entropy"))
+	seeder.SetNonceInt(testNonce)
+	err := seeder.InstantiateStr(testPersonal)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCoder
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+//------------------------------------------------------------
+// Errors every implementation wraps for the MTE statuses
+// callers act on, check them with errors.Is
+var ErrTokenDoesNotExist = errors.New("token does not exist")
+var ErrSeqMismatch = errors.New("sequence mismatch")
+var ErrSeqOutsideWindow = errors.New("sequence outside window")
+var ErrSeqAsyncReplay = errors.New("async replay")
+var ErrSeedLifeReached = errors.New("DRBG seed life reached")
+var ErrNotInstantiated = errors.New("not instantiated")
+var ErrInvalidState = errors.New("invalid state")
+
+//------------------------------------------------------
+// Instantiation and reseed counter of an Encoder or Decoder
+type Seeder interface {
+	SetEntropy(entropy []byte)
+	SetNonceInt(nonce uint64)
+	InstantiateStr(personal string) error
+	GetReseedCounter() uint64
+	GetReseedInterval() uint64
+	Destroy()
+}
+
+//-------------------------------------------------
+// Saves and restores an Encoder or Decoder state
+type State interface {
+	SaveState() []byte
+	RestoreState(state []byte) error
+}
+
+//--------------------
+// MTE Core Encoder
+type Encoder interface {
+	Seeder
+	State
+	Encode(message []byte) ([]byte, error)
+}
+
+//--------------------
+// MTE Core Decoder
+type Decoder interface {
+	Seeder
+	State
+	Decode(encoded []byte) ([]byte, error)
+}
+
+//------------------------------------------------------
+// MTE MKE Encoder used in chunk mode
+// EncryptChunk encrypts the chunk in place, FinishEncrypt
+// returns the EncryptFinishBytes that end the message
+type ChunkEncoder interface {
+	Seeder
+	State
+	StartEncrypt() error
+	EncryptChunk(chunk []byte) error
+	FinishEncrypt() ([]byte, error)
+	EncryptFinishBytes() int
+}
+
+//------------------------------------------------------
+// MTE MKE Decoder used in chunk mode
+// DecryptChunk may hold back data until FinishDecrypt
+type ChunkDecoder interface {
+	Seeder
+	State
+	StartDecrypt() error
+	DecryptChunk(chunk []byte) []byte
+	FinishDecrypt() ([]byte, error)
+}
+
+/**
+ * Encodes a string and base64 encodes the result,
+ * the same as EncodeStrB64 of the MTE
+ */
+func EncodeStrB64(encoder Encoder, message string) (out string, err error) {
+	encoded, err := encoder.Encode([]byte(message))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+/**
+ * Decodes a base64 encoded message to a string,
+ * the same as DecodeStrB64 of the MTE
+ */
+func DecodeStrB64(decoder Decoder, encoded string) (out string, err error) {
+	encodedBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := decoder.Decode(encodedBytes)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+/**
+ * Returns the state base64 encoded
+ */
+func SaveStateB64(state State) string {
+	return base64.StdEncoding.EncodeToString(state.SaveState())
+}
+
+/**
+ * Restores a state saved with SaveStateB64
+ */
+func RestoreStateB64(state State, saved string) error {
+	savedBytes, err := base64.StdEncoding.DecodeString(saved)
+	if err != nil {
+		return err
+	}
+	return state.RestoreState(savedBytes)
+}
+
+/**
+ * Returns true when the reseed counter passed the given
+ * share of the reseed interval and it is time to reseed
+ *
+ * percent: share of the reseed interval, for example .9
+ */
+func ReseedNeeded(seeder Seeder, percent float64) bool {
+	return float64(seeder.GetReseedCounter()) > float64(seeder.GetReseedInterval())*percent
+}
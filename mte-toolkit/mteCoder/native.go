@@ -0,0 +1,272 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteCoder
+
+//------------------------------------------------------------------
+// Status and DRBG functions of an MTE Go package. The toolkit and
+// every sample vendor their own copy of that package, a Library
+// lets all of them wrap it in the interfaces of this package the
+// same way without this package importing it.
+// S is the mte.Status type and D the mte.Drbgs type, Errors maps
+// the statuses callers act on to the errors of this package.
+type Library[S comparable, D any] struct {
+	Success           S
+	IsError           func(status S) bool
+	StatusName        func(status S) string
+	StatusDescription func(status S) string
+	ReseedInterval    func(drbg D) uint64
+	Errors            map[S]error
+}
+
+//--------------------------------------------------------------
+// MTE status as an error, errors.Is matches the error of this
+// package the Library maps the status to
+type StatusError[S comparable] struct {
+	Action string
+	Status S
+	text   string
+	err    error
+}
+
+func (e *StatusError[S]) Error() string {
+	return e.text
+}
+
+func (e *StatusError[S]) Unwrap() error {
+	return e.err
+}
+
+/**
+ * Creates the error of a status that is not success
+ */
+func (l *Library[S, D]) NewStatusError(action string, status S) *StatusError[S] {
+	return &StatusError[S]{
+		Action: action,
+		Status: status,
+		text:   action + " error (" + l.StatusName(status) + "): " + l.StatusDescription(status),
+		err:    l.Errors[status],
+	}
+}
+
+/**
+ * Returns nil for success, otherwise a StatusError
+ */
+func (l *Library[S, D]) Error(action string, status S) error {
+	if status == l.Success {
+		return nil
+	}
+	return l.NewStatusError(action, status)
+}
+
+/**
+ * Returns nil unless the decode status is an error
+ * Decoding can succeed with a warning status, for example
+ * when the Decoder skipped messages, the message is good
+ */
+func (l *Library[S, D]) DecodeError(action string, status S) error {
+	if !l.IsError(status) {
+		return nil
+	}
+	return l.NewStatusError(action, status)
+}
+
+//--------------------------------------------------
+// Methods every MTE Encoder and Decoder type has
+type NativeSeeder[S comparable, D any] interface {
+	SetEntropy(entropy []byte)
+	SetNonceInt(nonce uint64)
+	InstantiateStr(personal string) S
+	GetReseedCounter() uint64
+	GetDrbg() D
+	SaveState() []byte
+	RestoreState(state []byte) S
+	Destroy()
+}
+
+//----------------------------------
+// MTE Core and FLEN Encoder types
+type NativeEncoder[S comparable, D any] interface {
+	NativeSeeder[S, D]
+	Encode(message []byte) ([]byte, S)
+}
+
+//------------------------
+// MTE Core Decoder type
+type NativeDecoder[S comparable, D any] interface {
+	NativeSeeder[S, D]
+	Decode(encoded []byte) ([]byte, S)
+}
+
+//-----------------------
+// MTE MKE Encoder type
+type NativeChunkEncoder[S comparable, D any] interface {
+	NativeSeeder[S, D]
+	StartEncrypt() S
+	EncryptChunk(chunk []byte) S
+	FinishEncrypt() ([]byte, S)
+	EncryptFinishBytes() int
+}
+
+//-----------------------
+// MTE MKE Decoder type
+type NativeChunkDecoder[S comparable, D any] interface {
+	NativeSeeder[S, D]
+	StartDecrypt() S
+	DecryptChunk(chunk []byte) []byte
+	FinishDecrypt() ([]byte, S)
+}
+
+/**
+ * Wraps an instantiated or new MTE Core or FLEN Encoder
+ */
+func (l *Library[S, D]) Encoder(encoder NativeEncoder[S, D]) Encoder {
+	return &nativeEncoder[S, D]{nativeSeeder[S, D]{l, encoder}, encoder}
+}
+
+/**
+ * Wraps an instantiated or new MTE Core Decoder
+ */
+func (l *Library[S, D]) Decoder(decoder NativeDecoder[S, D]) Decoder {
+	return &nativeDecoder[S, D]{nativeSeeder[S, D]{l, decoder}, decoder}
+}
+
+/**
+ * Wraps an instantiated or new MTE MKE Encoder
+ */
+func (l *Library[S, D]) ChunkEncoder(encoder NativeChunkEncoder[S, D]) ChunkEncoder {
+	return &nativeChunkEncoder[S, D]{nativeSeeder[S, D]{l, encoder}, encoder}
+}
+
+/**
+ * Wraps an instantiated or new MTE MKE Decoder
+ */
+func (l *Library[S, D]) ChunkDecoder(decoder NativeChunkDecoder[S, D]) ChunkDecoder {
+	return &nativeChunkDecoder[S, D]{nativeSeeder[S, D]{l, decoder}, decoder}
+}
+
+//-----------------------------------------------
+// Seeder and State for any MTE Encoder or Decoder
+type nativeSeeder[S comparable, D any] struct {
+	library *Library[S, D]
+	base    NativeSeeder[S, D]
+}
+
+func (s nativeSeeder[S, D]) SetEntropy(entropy []byte) {
+	s.base.SetEntropy(entropy)
+}
+
+func (s nativeSeeder[S, D]) SetNonceInt(nonce uint64) {
+	s.base.SetNonceInt(nonce)
+}
+
+func (s nativeSeeder[S, D]) InstantiateStr(personal string) error {
+	return s.library.Error("Instantiate", s.base.InstantiateStr(personal))
+}
+
+func (s nativeSeeder[S, D]) GetReseedCounter() uint64 {
+	return s.base.GetReseedCounter()
+}
+
+func (s nativeSeeder[S, D]) GetReseedInterval() uint64 {
+	return s.library.ReseedInterval(s.base.GetDrbg())
+}
+
+func (s nativeSeeder[S, D]) Destroy() {
+	s.base.Destroy()
+}
+
+func (s nativeSeeder[S, D]) SaveState() []byte {
+	return s.base.SaveState()
+}
+
+func (s nativeSeeder[S, D]) RestoreState(state []byte) error {
+	return s.library.Error("Restore state", s.base.RestoreState(state))
+}
+
+type nativeEncoder[S comparable, D any] struct {
+	nativeSeeder[S, D]
+	encoder NativeEncoder[S, D]
+}
+
+func (e *nativeEncoder[S, D]) Encode(message []byte) (out []byte, err error) {
+	encoded, status := e.encoder.Encode(message)
+	return encoded, e.library.Error("Encode", status)
+}
+
+type nativeDecoder[S comparable, D any] struct {
+	nativeSeeder[S, D]
+	decoder NativeDecoder[S, D]
+}
+
+func (d *nativeDecoder[S, D]) Decode(encoded []byte) (out []byte, err error) {
+	decoded, status := d.decoder.Decode(encoded)
+	return decoded, d.library.DecodeError("Decode", status)
+}
+
+type nativeChunkEncoder[S comparable, D any] struct {
+	nativeSeeder[S, D]
+	encoder NativeChunkEncoder[S, D]
+}
+
+func (e *nativeChunkEncoder[S, D]) StartEncrypt() error {
+	return e.library.Error("Start encrypt", e.encoder.StartEncrypt())
+}
+
+func (e *nativeChunkEncoder[S, D]) EncryptChunk(chunk []byte) error {
+	return e.library.Error("Encrypt chunk", e.encoder.EncryptChunk(chunk))
+}
+
+func (e *nativeChunkEncoder[S, D]) FinishEncrypt() (out []byte, err error) {
+	finish, status := e.encoder.FinishEncrypt()
+	return finish, e.library.Error("Finish encrypt", status)
+}
+
+func (e *nativeChunkEncoder[S, D]) EncryptFinishBytes() int {
+	return e.encoder.EncryptFinishBytes()
+}
+
+type nativeChunkDecoder[S comparable, D any] struct {
+	nativeSeeder[S, D]
+	decoder NativeChunkDecoder[S, D]
+}
+
+func (d *nativeChunkDecoder[S, D]) StartDecrypt() error {
+	return d.library.Error("Start decrypt", d.decoder.StartDecrypt())
+}
+
+/**
+ * Returns nil when the chunk could not be decrypted, the
+ * MTE reports no status here, FinishDecrypt reports it
+ */
+func (d *nativeChunkDecoder[S, D]) DecryptChunk(chunk []byte) []byte {
+	return d.decoder.DecryptChunk(chunk)
+}
+
+func (d *nativeChunkDecoder[S, D]) FinishDecrypt() (out []byte, err error) {
+	finish, status := d.decoder.FinishDecrypt()
+	return finish, d.library.DecodeError("Finish decrypt", status)
+}
@@ -0,0 +1,135 @@
+/*
+****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*****************************************************************************
+*/
+package mteCoder
+
+import (
+	"errors"
+	"testing"
+)
+
+// -------------------------------------------------
+// Statuses and DRBG of a made up MTE Go package
+type testStatus int
+type testDrbg int
+
+const (
+	testSuccess testStatus = iota
+	testWarning
+	testTokenDoesNotExist
+	testOther
+)
+
+var testLibrary = &Library[testStatus, testDrbg]{
+	Success: testSuccess,
+	IsError: func(status testStatus) bool {
+		return status > testWarning
+	},
+	StatusName: func(status testStatus) string {
+		return [...]string{"success", "warning", "token", "other"}[status]
+	},
+	StatusDescription: func(status testStatus) string {
+		return "description"
+	},
+	ReseedInterval: func(drbg testDrbg) uint64 {
+		return uint64(drbg) * 10
+	},
+	Errors: map[testStatus]error{testTokenDoesNotExist: ErrTokenDoesNotExist},
+}
+
+// ---------------------------------------------------------
+// Native Encoder and Decoder returning the statuses set
+type testNative struct {
+	status   testStatus
+	state    []byte
+	personal string
+}
+
+func (n *testNative) SetEntropy(entropy []byte)                  {}
+func (n *testNative) SetNonceInt(nonce uint64)                   {}
+func (n *testNative) GetReseedCounter() uint64                   { return 3 }
+func (n *testNative) GetDrbg() testDrbg                          { return 7 }
+func (n *testNative) SaveState() []byte                          { return n.state }
+func (n *testNative) RestoreState(state []byte) testStatus       { n.state = state; return n.status }
+func (n *testNative) Destroy()                                   {}
+func (n *testNative) Encode(message []byte) ([]byte, testStatus) { return message, n.status }
+func (n *testNative) Decode(encoded []byte) ([]byte, testStatus) { return encoded, n.status }
+
+func (n *testNative) InstantiateStr(personal string) testStatus {
+	n.personal = personal
+	return n.status
+}
+
+func TestLibraryErrors(t *testing.T) {
+	if err := testLibrary.Error("Encode", testSuccess); err != nil {
+		t.Errorf("success: %v", err)
+	}
+	err := testLibrary.Error("Encode", testWarning)
+	var statusErr *StatusError[testStatus]
+	if !errors.As(err, &statusErr) || statusErr.Status != testWarning || statusErr.Action != "Encode" {
+		t.Fatalf("warning: got %#v", err)
+	}
+	if err.Error() != "Encode error (warning): description" {
+		t.Errorf("got %q", err.Error())
+	}
+	if err := testLibrary.DecodeError("Decode", testWarning); err != nil {
+		t.Errorf("decode warning: %v", err)
+	}
+	if err := testLibrary.DecodeError("Decode", testTokenDoesNotExist); !errors.Is(err, ErrTokenDoesNotExist) {
+		t.Errorf("got %v, want ErrTokenDoesNotExist", err)
+	}
+	if err := testLibrary.Error("Encode", testOther); err == nil || errors.Unwrap(err) != nil {
+		t.Errorf("unmapped status: got %v", err)
+	}
+}
+
+func TestLibraryWraps(t *testing.T) {
+	native := &testNative{}
+	encoder := testLibrary.Encoder(native)
+	if err := encoder.InstantiateStr(testPersonal); err != nil || native.personal != testPersonal {
+		t.Fatalf("instantiate: %v %q", err, native.personal)
+	}
+	if encoder.GetReseedInterval() != 70 || !ReseedNeeded(encoder, .01) {
+		t.Errorf("reseed interval %d", encoder.GetReseedInterval())
+	}
+
+	//---------------------------------------------
+	// Encoding stops at a warning, decoding does not
+	native.status = testWarning
+	if _, err := encoder.Encode([]byte("message")); err == nil {
+		t.Error("encode with a warning status succeeded")
+	}
+	decoded, err := testLibrary.Decoder(native).Decode([]byte("message"))
+	if err != nil || string(decoded) != "message" {
+		t.Errorf("decode with a warning status: %q %v", decoded, err)
+	}
+	native.status = testTokenDoesNotExist
+	if err := encoder.RestoreState([]byte("state")); !errors.Is(err, ErrTokenDoesNotExist) {
+		t.Errorf("restore: got %v", err)
+	}
+}
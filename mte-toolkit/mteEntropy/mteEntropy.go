@@ -0,0 +1,191 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteEntropy
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+)
+
+//------------------
+// Error messages
+var ErrShortEntropy = errors.New("not enough entropy")
+var ErrEntropyUsed = errors.New("entropy was already used")
+var ErrKeyFileMode = errors.New("key file can be read by other users")
+
+//----------------------------------------------------------
+// Source of the entropy an Encoder or Decoder is
+// instantiated with. Entropy returns at least size bytes,
+// use GetDrbgsEntropyMinBytes of the DRBG for the size.
+type Provider interface {
+	Entropy(size int) ([]byte, error)
+}
+
+//-------------------------------------------------
+// Anything that takes entropy, every MTE Encoder
+// and Decoder type has SetEntropy
+type Setter interface {
+	SetEntropy(entropy []byte)
+}
+
+/**
+ * Gets the entropy from the provider once and gives every
+ * Encoder or Decoder its own copy, the MTE zeroes it
+ *
+ * provider: source of the entropy
+ * size: minimum entropy size of the DRBG
+ * coders: Encoders and Decoders to set the entropy of
+ */
+func Set(provider Provider, size int, coders ...Setter) error {
+	entropy, err := provider.Entropy(size)
+	if err != nil {
+		return err
+	}
+	if len(entropy) < size {
+		return fmt.Errorf("%w: %d of %d bytes", ErrShortEntropy, len(entropy), size)
+	}
+	for _, coder := range coders {
+		coder.SetEntropy(append([]byte(nil), entropy...))
+	}
+	zero(entropy)
+	return nil
+}
+
+//---------------------------------------------------
+// Random entropy from crypto/rand, use it when both
+// sides of a demo or test run in the same process
+type RandomProvider struct{}
+
+func (RandomProvider) Entropy(size int) (out []byte, err error) {
+	entropy := make([]byte, size)
+	_, err = rand.Read(entropy)
+	if err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+//------------------------------------------------------
+// Shared secret of an ECDH handshake, it can only be
+// used once and is forgotten after Entropy is called
+type EcdhProvider struct {
+	secret []byte
+	lock   sync.Mutex
+}
+
+/**
+ * Creates the provider, it takes ownership of the secret
+ */
+func NewEcdhProvider(secret []byte) *EcdhProvider {
+	return &EcdhProvider{secret: secret}
+}
+
+func (p *EcdhProvider) Entropy(size int) (out []byte, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.secret == nil {
+		return nil, ErrEntropyUsed
+	}
+	secret := p.secret
+	p.secret = nil
+	if len(secret) < size {
+		zero(secret)
+		return nil, fmt.Errorf("%w: shared secret has %d of %d bytes", ErrShortEntropy, len(secret), size)
+	}
+	return secret, nil
+}
+
+//--------------------------------------------------------
+// Entropy read from a key file, only the first size bytes
+// are used. The file must not be readable by other users.
+type FileProvider struct {
+	Path string
+}
+
+func (p *FileProvider) Entropy(size int) (out []byte, err error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFileMode, p.Path)
+	}
+	key, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < size {
+		zero(key)
+		return nil, fmt.Errorf("%w: %s has %d of %d bytes", ErrShortEntropy, p.Path, len(key), size)
+	}
+	entropy := append([]byte(nil), key[:size]...)
+	zero(key)
+	return entropy, nil
+}
+
+//------------------------------------------------------------
+// Fixed entropy for tests and demos that need the same value
+// on every run. The value is repeated up to size. Anyone that
+// knows the value can decode every message, so every call
+// logs a warning. NEVER use it outside of tests and demos.
+type InsecureFixedTestProvider struct {
+	value []byte
+}
+
+/**
+ * Creates the provider, value must not be empty
+ */
+func NewInsecureFixedTestProvider(value []byte) *InsecureFixedTestProvider {
+	return &InsecureFixedTestProvider{value: append([]byte(nil), value...)}
+}
+
+func (p *InsecureFixedTestProvider) Entropy(size int) (out []byte, err error) {
+	log.Println("**************************************************************")
+	log.Println("* WARNING: INSECURE FIXED TEST ENTROPY IN USE                *")
+	log.Println("* Messages can be decoded by anyone. Never use in production *")
+	log.Println("**************************************************************")
+	if len(p.value) == 0 {
+		return nil, fmt.Errorf("%w: empty test entropy", ErrShortEntropy)
+	}
+	entropy := append([]byte(nil), p.value...)
+	for len(entropy) < size {
+		entropy = append(entropy, p.value[len(entropy)%len(p.value)])
+	}
+	return entropy, nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,155 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteEntropy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------
+// Setter that keeps the entropy it was given
+type recordSetter struct {
+	entropy []byte
+}
+
+func (s *recordSetter) SetEntropy(entropy []byte) {
+	s.entropy = entropy
+}
+
+//--------------------------------------------
+// Provider that returns a fixed value as is
+type shortProvider []byte
+
+func (p shortProvider) Entropy(size int) (out []byte, err error) {
+	return p, nil
+}
+
+func TestSetGivesEveryCoderACopy(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	want := append([]byte(nil), secret...)
+	a, b := &recordSetter{}, &recordSetter{}
+	err := Set(NewEcdhProvider(secret), len(secret), a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.entropy, want) || !bytes.Equal(b.entropy, want) {
+		t.Fatalf("entropy %q and %q, want %q", a.entropy, b.entropy, want)
+	}
+	a.entropy[0] = 'x'
+	if b.entropy[0] != want[0] {
+		t.Error("the coders share one entropy slice")
+	}
+}
+
+func TestSetEnforcesTheMinimumSize(t *testing.T) {
+	setter := &recordSetter{}
+	err := Set(shortProvider("too short"), 32, setter)
+	if !errors.Is(err, ErrShortEntropy) {
+		t.Errorf("got %v want %v", err, ErrShortEntropy)
+	}
+	if setter.entropy != nil {
+		t.Error("short entropy was set")
+	}
+}
+
+func TestEcdhProviderIsUsedOnce(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	provider := NewEcdhProvider(secret)
+	err := Set(provider, len(secret), &recordSetter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Errorf("secret %q was not zeroed", secret)
+	}
+	if _, err = provider.Entropy(len(secret)); !errors.Is(err, ErrEntropyUsed) {
+		t.Errorf("second use: got %v want %v", err, ErrEntropyUsed)
+	}
+
+	short := []byte("short")
+	_, err = NewEcdhProvider(short).Entropy(32)
+	if !errors.Is(err, ErrShortEntropy) {
+		t.Errorf("short secret: got %v want %v", err, ErrShortEntropy)
+	}
+	if !bytes.Equal(short, make([]byte, len(short))) {
+		t.Errorf("short secret %q was not zeroed", short)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entropy.key")
+	err := ioutil.WriteFile(path, []byte("0123456789abcdef"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &FileProvider{Path: path}
+	entropy, err := provider.Entropy(8)
+	if err != nil || string(entropy) != "01234567" {
+		t.Fatalf("got %q %v", entropy, err)
+	}
+	if _, err = provider.Entropy(32); !errors.Is(err, ErrShortEntropy) {
+		t.Errorf("short file: got %v want %v", err, ErrShortEntropy)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on windows")
+	}
+	for _, mode := range []os.FileMode{0640, 0604} {
+		err = os.Chmod(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = provider.Entropy(8); !errors.Is(err, ErrKeyFileMode) {
+			t.Errorf("mode %o: got %v want %v", mode, err, ErrKeyFileMode)
+		}
+	}
+}
+
+func TestInsecureFixedTestProvider(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	entropy, err := NewInsecureFixedTestProvider([]byte("abc")).Entropy(7)
+	if err != nil || string(entropy) != "abcabca" {
+		t.Fatalf("got %q %v", entropy, err)
+	}
+	if !strings.Contains(logged.String(), "INSECURE FIXED TEST ENTROPY") {
+		t.Errorf("no warning logged: %q", logged.String())
+	}
+	if _, err = NewInsecureFixedTestProvider(nil).Entropy(7); !errors.Is(err, ErrShortEntropy) {
+		t.Errorf("empty value: got %v want %v", err, ErrShortEntropy)
+	}
+}
@@ -0,0 +1,157 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteFrame
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"mteToolkit/mteCoder"
+)
+
+const (
+	//----------------------------------------------------
+	// Largest piece of plaintext encoded into one frame,
+	// longer writes are split into several frames
+	DefaultMessageSize = 16 * 1024
+)
+
+//--------------------------------------------------------------
+// net.Conn that encodes on Write and decodes on Read
+// Every Write is split into messages of at most MessageSize
+// bytes, each message is encoded and sent as a data frame.
+// Read decodes the next data frame and hands out its plaintext,
+// a close frame from the other side ends the stream with io.EOF.
+// Use mteAdapter to wrap the MTE Encoder and Decoder, they stay
+// live for the life of the connection.
+type Conn struct {
+	net.Conn
+	encoder     mteCoder.Encoder
+	decoder     mteCoder.Decoder
+	reader      *Reader
+	writer      *Writer
+	messageSize int
+	pending     []byte
+	readLock    sync.Mutex
+	writeLock   sync.Mutex
+}
+
+/**
+ * Wraps a connection
+ *
+ * conn: connection to the other side, usually TCP or a Unix socket
+ * encoder: Encoder paired with the Decoder of the other side
+ * decoder: Decoder paired with the Encoder of the other side
+ * maxSize: largest frame payload, DefaultMaxFrameSize when 0
+ *
+ * Returns the Conn, closing it closes conn
+ */
+func NewConn(conn net.Conn, encoder mteCoder.Encoder, decoder mteCoder.Decoder, maxSize int) *Conn {
+	return &Conn{
+		Conn:        conn,
+		encoder:     encoder,
+		decoder:     decoder,
+		reader:      NewReader(conn, maxSize),
+		writer:      NewWriter(conn, maxSize),
+		messageSize: DefaultMessageSize,
+	}
+}
+
+/**
+ * Sets the largest piece of plaintext encoded into one frame
+ * The encoded message must still fit the maximum frame size
+ */
+func (c *Conn) SetMessageSize(size int) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.messageSize = size
+}
+
+/**
+ * Encodes b and writes it as one or more data frames
+ */
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	for n < len(b) {
+		end := n + c.messageSize
+		if end > len(b) || c.messageSize <= 0 {
+			end = len(b)
+		}
+		encoded, err := c.encoder.Encode(b[n:end])
+		if err != nil {
+			return n, err
+		}
+		err = c.writer.WriteFrame(Frame{Type: TypeData, Payload: encoded})
+		if err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+/**
+ * Reads plaintext, decoding the next data frame when
+ * nothing is left of the previous one
+ */
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	for len(c.pending) == 0 {
+		frame, err := c.reader.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch frame.Type {
+		case TypeData:
+			c.pending, err = c.decoder.Decode(frame.Payload)
+			if err != nil {
+				return 0, err
+			}
+		case TypeClose:
+			return 0, io.EOF
+		default:
+			return 0, fmt.Errorf("%w: 0x%02x on a data connection", ErrUnknownType, frame.Type)
+		}
+	}
+	n = copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+/**
+ * Tells the other side we are done and closes the connection
+ */
+func (c *Conn) Close() error {
+	c.writeLock.Lock()
+	_ = c.writer.WriteFrame(Frame{Type: TypeClose})
+	c.writeLock.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,166 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteFrame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"mteToolkit/mteCoder"
+)
+
+/**
+ * Connects two Conns over an in-memory pipe with fake
+ * Encoders and Decoders, the Encoder of one side pairs
+ * with the Decoder of the other
+ */
+func connPair(t *testing.T) (client *Conn, server *Conn) {
+	clientEnd, serverEnd := net.Pipe()
+	clientEncoder := newFakeEncoder(t, "client to server")
+	serverDecoder := newFakeDecoder(t, "client to server")
+	serverEncoder := newFakeEncoder(t, "server to client")
+	clientDecoder := newFakeDecoder(t, "server to client")
+	client = NewConn(clientEnd, clientEncoder, clientDecoder, 0)
+	server = NewConn(serverEnd, serverEncoder, serverDecoder, 0)
+	t.Cleanup(func() {
+		clientEnd.Close()
+		serverEnd.Close()
+	})
+	return client, server
+}
+
+func newFakeEncoder(t *testing.T, entropy string) *mteCoder.FakeEncoder {
+	encoder := mteCoder.NewFakeEncoder()
+	seed(t, encoder, entropy)
+	return encoder
+}
+
+func newFakeDecoder(t *testing.T, entropy string) *mteCoder.FakeDecoder {
+	decoder := mteCoder.NewFakeDecoder(0)
+	seed(t, decoder, entropy)
+	return decoder
+}
+
+func seed(t *testing.T, seeder mteCoder.Seeder, entropy string) {
+	seeder.SetEntropy([]byte(entropy))
+	seeder.SetNonceInt(1)
+	if err := seeder.InstantiateStr("frame test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnWriteAndRead(t *testing.T) {
+	client, server := connPair(t)
+	message := bytes.Repeat([]byte("0123456789"), 5000)
+	client.SetMessageSize(4096)
+
+	//--------------------------------------------------
+	// The pipe blocks until the other side reads, so
+	// the client writes and closes in the background
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.Write(message)
+		if err == nil {
+			err = client.Close()
+		}
+		written <- err
+	}()
+	received, err := ioutil.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Errorf("got %d bytes want %d", len(received), len(message))
+	}
+}
+
+func TestConnBothDirections(t *testing.T) {
+	client, server := connPair(t)
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			n, err := server.Read(buffer)
+			if err != nil {
+				return
+			}
+			if _, err := server.Write(bytes.ToUpper(buffer[:n])); err != nil {
+				return
+			}
+		}
+	}()
+	for _, message := range []string{"hello", "mte", "frames"} {
+		if _, err := client.Write([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len(message))
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != string(bytes.ToUpper([]byte(message))) {
+			t.Errorf("got %q", reply)
+		}
+	}
+}
+
+func TestConnRejectsTamperedFrames(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+	defer serverEnd.Close()
+	server := NewConn(serverEnd, newFakeEncoder(t, "server to client"), newFakeDecoder(t, "client to server"), 0)
+
+	encoded, err := newFakeEncoder(t, "client to server").Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded[len(encoded)-1] ^= 0xff
+	go NewWriter(clientEnd, 0).WriteFrame(Frame{Type: TypeData, Payload: encoded})
+
+	_, err = server.Read(make([]byte, 16))
+	if !errors.Is(err, mteCoder.ErrTokenDoesNotExist) {
+		t.Errorf("got %v want ErrTokenDoesNotExist", err)
+	}
+}
+
+func TestConnRejectsOtherFrameTypes(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+	defer serverEnd.Close()
+	server := NewConn(serverEnd, newFakeEncoder(t, "server to client"), newFakeDecoder(t, "client to server"), 0)
+
+	go NewWriter(clientEnd, 0).WriteFrame(Frame{Type: TypeChunk, Payload: []byte("chunk")})
+	_, err := server.Read(make([]byte, 16))
+	if !errors.Is(err, ErrUnknownType) {
+		t.Errorf("got %v want ErrUnknownType", err)
+	}
+}
@@ -0,0 +1,217 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteFrame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	//-----------------------------------------------------
+	// Frame types, the byte after the length prefix
+	TypeData      = 0x01 // One encoded message
+	TypeChunk     = 0x02 // One MKE chunk of a streamed message
+	TypeFinish    = 0x03 // The FinishEncrypt bytes that end a streamed message
+	TypeHandshake = 0x04 // Handshake request or response
+	TypeClose     = 0x05 // The sender is done, no payload
+
+	//--------------------------------------------------
+	// Largest payload a frame may carry unless the
+	// Reader or Writer is given another limit
+	DefaultMaxFrameSize = 1 << 20
+)
+
+//------------------
+// Error messages
+var ErrFrameTooLarge = errors.New("frame is larger than the maximum frame size")
+var ErrUnknownType = errors.New("unknown frame type")
+var ErrMalformedFrame = errors.New("malformed frame")
+
+//------------------------------------------------------------
+// One frame on the wire: the payload length as an unsigned
+// varint, the type byte and the payload. The length does not
+// count the type byte.
+type Frame struct {
+	Type    byte
+	Payload []byte
+}
+
+/**
+ * Appends the frame to dst
+ *
+ * maxSize: largest payload allowed, DefaultMaxFrameSize when 0
+ *
+ * Returns dst with the frame appended
+ */
+func AppendFrame(dst []byte, frame Frame, maxSize int) (out []byte, err error) {
+	err = checkFrame(frame.Type, uint64(len(frame.Payload)), maxSize)
+	if err != nil {
+		return dst, err
+	}
+	var header [binary.MaxVarintLen64 + 1]byte
+	n := binary.PutUvarint(header[:], uint64(len(frame.Payload)))
+	header[n] = frame.Type
+	dst = append(dst, header[:n+1]...)
+	return append(dst, frame.Payload...), nil
+}
+
+/**
+ * Parses the first frame of a buffer
+ * The payload shares memory with the buffer
+ *
+ * maxSize: largest payload allowed, DefaultMaxFrameSize when 0
+ *
+ * Returns the frame and the number of bytes it used,
+ * io.ErrUnexpectedEOF when the buffer ends inside the frame
+ */
+func ParseFrame(buffer []byte, maxSize int) (frame Frame, n int, err error) {
+	size, n := binary.Uvarint(buffer)
+	if n == 0 {
+		return frame, 0, io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return frame, 0, fmt.Errorf("%w: length overflows", ErrMalformedFrame)
+	}
+	if len(buffer) == n {
+		return frame, 0, io.ErrUnexpectedEOF
+	}
+	frame.Type = buffer[n]
+	err = checkFrame(frame.Type, size, maxSize)
+	if err != nil {
+		return Frame{}, 0, err
+	}
+	n++
+	if uint64(len(buffer)-n) < size {
+		return Frame{}, 0, io.ErrUnexpectedEOF
+	}
+	frame.Payload = buffer[n : n+int(size)]
+	return frame, n + int(size), nil
+}
+
+//---------------------------------------------------
+// Reads frames from a stream, the length is checked
+// before the payload is read or allocated
+type Reader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+/**
+ * Creates a Reader
+ *
+ * maxSize: largest payload allowed, DefaultMaxFrameSize when 0
+ */
+func NewReader(r io.Reader, maxSize int) *Reader {
+	return &Reader{reader: bufio.NewReader(r), maxSize: maxSize}
+}
+
+/**
+ * Reads the next frame
+ * Returns io.EOF when the stream ends between frames and
+ * io.ErrUnexpectedEOF when it ends inside a frame
+ */
+func (r *Reader) ReadFrame() (out Frame, err error) {
+	size, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Frame{}, err
+		}
+		return Frame{}, fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+	}
+	frameType, err := r.reader.ReadByte()
+	if err != nil {
+		return Frame{}, unexpectedEOF(err)
+	}
+	err = checkFrame(frameType, size, r.maxSize)
+	if err != nil {
+		return Frame{}, err
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r.reader, payload)
+	if err != nil {
+		return Frame{}, unexpectedEOF(err)
+	}
+	return Frame{Type: frameType, Payload: payload}, nil
+}
+
+//---------------------------------------------------
+// Writes frames to a stream, one Write call per frame
+type Writer struct {
+	writer  io.Writer
+	maxSize int
+}
+
+/**
+ * Creates a Writer
+ *
+ * maxSize: largest payload allowed, DefaultMaxFrameSize when 0
+ */
+func NewWriter(w io.Writer, maxSize int) *Writer {
+	return &Writer{writer: w, maxSize: maxSize}
+}
+
+/**
+ * Writes one frame
+ */
+func (w *Writer) WriteFrame(frame Frame) error {
+	frameBytes, err := AppendFrame(nil, frame, w.maxSize)
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(frameBytes)
+	return err
+}
+
+/**
+ * Checks the type and the payload size of a frame
+ */
+func checkFrame(frameType byte, size uint64, maxSize int) error {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	if frameType < TypeData || frameType > TypeClose {
+		return fmt.Errorf("%w: 0x%02x", ErrUnknownType, frameType)
+	}
+	if size > uint64(maxSize) {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	if frameType == TypeClose && size != 0 {
+		return fmt.Errorf("%w: close frame has a payload", ErrMalformedFrame)
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
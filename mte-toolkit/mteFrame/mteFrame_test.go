@@ -0,0 +1,196 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteFrame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var frameCases = []Frame{
+	{Type: TypeData, Payload: []byte("encoded message")},
+	{Type: TypeChunk, Payload: bytes.Repeat([]byte{0xab}, 300)},
+	{Type: TypeFinish, Payload: []byte{}},
+	{Type: TypeHandshake, Payload: []byte(`{"Version":2}`)},
+	{Type: TypeClose, Payload: []byte{}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	var stream bytes.Buffer
+	writer := NewWriter(&stream, 0)
+	for _, frame := range frameCases {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//-----------------------------------------------
+	// The buffer parser and the stream Reader must
+	// agree on every frame
+	buffer := stream.Bytes()
+	reader := NewReader(bytes.NewReader(buffer), 0)
+	for i, want := range frameCases {
+		parsed, n, err := ParseFrame(buffer, 0)
+		if err != nil {
+			t.Fatalf("parse %d: %v", i, err)
+		}
+		buffer = buffer[n:]
+		read, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		for _, got := range []Frame{parsed, read} {
+			if got.Type != want.Type || !bytes.Equal(got.Payload, want.Payload) {
+				t.Errorf("frame %d: got %+v want %+v", i, got, want)
+			}
+		}
+	}
+	if len(buffer) != 0 {
+		t.Errorf("%d bytes left after the last frame", len(buffer))
+	}
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Errorf("after the last frame: got %v want io.EOF", err)
+	}
+}
+
+func TestLengthPrefixIsVarint(t *testing.T) {
+	frameBytes, err := AppendFrame(nil, Frame{Type: TypeData, Payload: make([]byte, 300)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//-------------------------------------------
+	// 300 is 0xac 0x02 as an unsigned varint
+	if !bytes.HasPrefix(frameBytes, []byte{0xac, 0x02, TypeData}) {
+		t.Errorf("header: got % x", frameBytes[:3])
+	}
+}
+
+func TestInvalidFrames(t *testing.T) {
+	cases := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"length only", []byte{0x03}, io.ErrUnexpectedEOF},
+		{"short payload", []byte{0x03, TypeData, 'a'}, io.ErrUnexpectedEOF},
+		{"unknown type", []byte{0x00, 0x7f}, ErrUnknownType},
+		{"type zero", []byte{0x00, 0x00}, ErrUnknownType},
+		{"too large", []byte{0x81, 0x80, 0x80, 0x01, TypeData}, ErrFrameTooLarge},
+		{"length overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, ErrMalformedFrame},
+		{"close with payload", []byte{0x01, TypeClose, 'a'}, ErrMalformedFrame},
+	}
+	for _, c := range cases {
+		_, _, err := ParseFrame(c.input, 0)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: ParseFrame got %v want %v", c.name, err, c.err)
+		}
+		_, err = NewReader(bytes.NewReader(c.input), 0).ReadFrame()
+		want := c.err
+		if c.name == "empty" {
+			want = io.EOF
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("%s: ReadFrame got %v want %v", c.name, err, want)
+		}
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	frame := Frame{Type: TypeData, Payload: make([]byte, 65)}
+	if _, err := AppendFrame(nil, frame, 64); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("AppendFrame: got %v want ErrFrameTooLarge", err)
+	}
+	frameBytes, err := AppendFrame(nil, frame, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseFrame(frameBytes, 64); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ParseFrame: got %v want ErrFrameTooLarge", err)
+	}
+	if _, err := NewReader(bytes.NewReader(frameBytes), 64).ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ReadFrame: got %v want ErrFrameTooLarge", err)
+	}
+}
+
+//----------------------------------------------------------
+// The parser must never panic, never read past the buffer
+// and every frame it accepts must encode to the same bytes
+func FuzzParseFrame(f *testing.F) {
+	for _, frame := range frameCases {
+		frameBytes, _ := AppendFrame(nil, frame, 0)
+		f.Add(frameBytes)
+	}
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, input []byte) {
+		frame, n, err := ParseFrame(input, 1024)
+		if err != nil {
+			if n != 0 {
+				t.Fatalf("error %v with %d bytes used", err, n)
+			}
+			return
+		}
+		if n > len(input) {
+			t.Fatalf("used %d of %d bytes", n, len(input))
+		}
+		if len(frame.Payload) > 1024 {
+			t.Fatalf("payload of %d bytes passed the limit", len(frame.Payload))
+		}
+		encoded, err := AppendFrame(nil, frame, 1024)
+		if err != nil {
+			t.Fatalf("accepted frame does not encode: %v", err)
+		}
+		//--------------------------------------------
+		// A varint may have redundant zero groups, so
+		// only the type and payload must match
+		again, _, err := ParseFrame(encoded, 1024)
+		if err != nil || again.Type != frame.Type || !bytes.Equal(again.Payload, frame.Payload) {
+			t.Fatalf("re-parse: got %+v, %v want %+v", again, err, frame)
+		}
+	})
+}
+
+//------------------------------------------------------
+// The stream Reader must agree with the buffer parser
+func FuzzReader(f *testing.F) {
+	for _, frame := range frameCases {
+		frameBytes, _ := AppendFrame(nil, frame, 0)
+		f.Add(frameBytes)
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		parsed, _, parseErr := ParseFrame(input, 1024)
+		read, readErr := NewReader(bytes.NewReader(input), 1024).ReadFrame()
+		if (parseErr == nil) != (readErr == nil) {
+			t.Fatalf("ParseFrame %v, ReadFrame %v", parseErr, readErr)
+		}
+		if parseErr == nil && (parsed.Type != read.Type || !bytes.Equal(parsed.Payload, read.Payload)) {
+			t.Fatalf("ParseFrame %+v, ReadFrame %+v", parsed, read)
+		}
+	})
+}
@@ -0,0 +1,367 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHandshake
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	//------------------------------------------------
+	// Handshake protocol versions
+	// Version 1 is the original four field model that
+	// carries no MTE options, Version 2 negotiates them
+	LegacyVersion   = 1
+	ProtocolVersion = 2
+
+	//------------------------------------------
+	// MTE "types" a session is allowed to use
+	ModeCore = "core"
+	ModeMke  = "mke"
+	ModeFlen = "flen"
+
+	//-------------------------------
+	// Device platforms
+	PlatformIos     = "ios"
+	PlatformAndroid = "android"
+)
+
+//-----------------------------------------------------
+// Returned when the two sides have no option in common
+var ErrNoCommonOption = errors.New("no common MTE option")
+
+//-------------------------------------------------------
+// Returned when the server picks something we never offered
+var ErrUnexpectedOption = errors.New("server selected an option that was not offered")
+
+//---------------------------------------------------------
+// Returned when a message does not fit the FLEN fixed length
+var ErrMessageTooLong = errors.New("message is longer than the fixed length")
+
+//----------------------------------------------------------
+// Capabilities one side advertises, in order of preference
+// Drbgs and Verifiers hold mte.Drbgs and mte.Verifiers values
+// TimeWindow is the largest time window this side accepts
+// SequenceWindow is the requested sequence window, the sign
+// selects forward-only (positive) or async (negative) mode
+// FixedLength is the largest FLEN fixed length this side
+// accepts, FLEN is only agreed on when both sides set it
+type Capabilities struct {
+	Drbgs          []int
+	TokBytes       []int
+	Verifiers      []int
+	TimeWindow     uint64
+	SequenceWindow int
+	Modes          []string
+	FixedLength    int
+}
+
+//-------------------------------------------------------
+// Options both sides agreed on, used to create matching
+// Encoders (NewEncOpt) and Decoders (NewDecOpt)
+// FixedLength is the FLEN fixed length, zero without FLEN
+type MteOptions struct {
+	Version        int
+	Drbg           int
+	TokBytes       int
+	Verifiers      int
+	TimeWindow     uint64
+	SequenceWindow int
+	Modes          []string
+	FixedLength    int
+}
+
+//-----------------------------------------------------------
+// Device the client runs on, the server needs the jailbreak
+// algorithm (mte.JailAlgo value) to build a matching Decoder
+// The platform, app version and the optional attestation blob
+// from the platform attestation service are recorded with the
+// client so a mismatch can be traced back to the device
+type Device struct {
+	Platform    string `json:",omitempty"`
+	AppVersion  string `json:",omitempty"`
+	JailAlgo    int
+	Attestation []byte `json:",omitempty"`
+}
+
+//-----------------------------------------------------
+// Versioned handshake request sent by the client
+// The first four fields are the original HandshakeModel
+// so a legacy server still understands the request
+type HandshakeRequest struct {
+	TimeStamp              string
+	ConversationIdentifier string
+	ClientEncoderPublicKey string
+	ClientDecoderPublicKey string
+	Version                int
+	Capabilities           *Capabilities `json:",omitempty"`
+	Device                 *Device       `json:",omitempty"`
+}
+
+//-------------------------------------------------------
+// Versioned handshake response sent back by the server
+// A legacy server leaves Version and Options empty
+type HandshakeResponse struct {
+	TimeStamp              string
+	ConversationIdentifier string
+	ClientEncoderPublicKey string
+	ClientDecoderPublicKey string
+	Version                int
+	Options                *MteOptions `json:",omitempty"`
+}
+
+/**
+ * Reports if these options came from a legacy handshake
+ * In that case both sides use the MTE defaults (NewEncDef, NewDecDef)
+ */
+func (o MteOptions) IsDefault() bool {
+	return o.Version < ProtocolVersion
+}
+
+/**
+ * Reports if the agreed options allow the given MTE mode
+ * Legacy handshakes only know about the default Core and MKE
+ */
+func (o MteOptions) HasMode(mode string) bool {
+	if o.IsDefault() {
+		return mode == ModeCore || mode == ModeMke
+	}
+	return contains(o.Modes, mode)
+}
+
+/**
+ * Checks a message fits the FLEN fixed length
+ * The FLEN Encoder cuts longer messages off, so they
+ * must be rejected before they are encoded
+ *
+ * size: message size in bytes
+ */
+func (o MteOptions) CheckFixedLength(size int) error {
+	if size > o.FixedLength {
+		return fmt.Errorf("%w: %d bytes, fixed length is %d", ErrMessageTooLong, size, o.FixedLength)
+	}
+	return nil
+}
+
+/**
+ * Capabilities offering only the MTE defaults
+ * The defaults come first so legacy and new servers end up
+ * with the same Encoder and Decoder. This package does not use
+ * the MTE, so the caller passes in its defaults
+ *
+ * drbg: mte.GetDefaultDrbg()
+ * tokBytes: mte.GetDefaultTokBytes()
+ * verifiers: mte.GetDefaultVerifiers()
+ * modes: MTE modes to offer
+ *
+ * Returns the Capabilities
+ */
+func DefaultCapabilities(drbg int, tokBytes int, verifiers int, modes ...string) Capabilities {
+	return Capabilities{
+		Drbgs:     []int{drbg},
+		TokBytes:  []int{tokBytes},
+		Verifiers: []int{verifiers},
+		Modes:     modes,
+	}
+}
+
+/**
+ * Creates a versioned handshake request for the client
+ *
+ * clientId: conversation identifier
+ * offer: client capabilities in order of preference
+ *
+ * Returns HandshakeRequest without the public keys set
+ */
+func NewRequest(clientId string, offer Capabilities) HandshakeRequest {
+	return HandshakeRequest{
+		ConversationIdentifier: clientId,
+		Version:                ProtocolVersion,
+		Capabilities:           &offer,
+	}
+}
+
+/**
+ * Server side negotiation
+ * Picks the first client preference the server also supports
+ * for each option, the smaller time window and the sequence
+ * window both sides can honor
+ *
+ * client: capabilities from the HandshakeRequest
+ * server: capabilities of this server
+ *
+ * Returns the agreed MteOptions
+ */
+func Negotiate(client Capabilities, server Capabilities) (out MteOptions, err error) {
+	var options MteOptions
+	options.Version = ProtocolVersion
+
+	//--------------------------
+	// Pick the DRBG to use
+	options.Drbg, err = firstCommon(client.Drbgs, server.Drbgs)
+	if err != nil {
+		return options, fmt.Errorf("%w: drbg", err)
+	}
+	//--------------------------
+	// Pick the token size
+	options.TokBytes, err = firstCommon(client.TokBytes, server.TokBytes)
+	if err != nil {
+		return options, fmt.Errorf("%w: token bytes", err)
+	}
+	//--------------------------
+	// Pick the verifiers
+	options.Verifiers, err = firstCommon(client.Verifiers, server.Verifiers)
+	if err != nil {
+		return options, fmt.Errorf("%w: verifiers", err)
+	}
+	//------------------------------------------
+	// Use the smaller of the two fixed lengths
+	options.FixedLength = client.FixedLength
+	if server.FixedLength < options.FixedLength {
+		options.FixedLength = server.FixedLength
+	}
+	//-------------------------------------
+	// Keep every mode both sides support,
+	// FLEN needs a fixed length as well
+	for _, mode := range client.Modes {
+		if mode == ModeFlen && options.FixedLength <= 0 {
+			continue
+		}
+		if contains(server.Modes, mode) {
+			options.Modes = append(options.Modes, mode)
+		}
+	}
+	if !contains(options.Modes, ModeFlen) {
+		options.FixedLength = 0
+	}
+	if len(options.Modes) == 0 {
+		return options, fmt.Errorf("%w: mode", ErrNoCommonOption)
+	}
+	//----------------------------------------
+	// Use the smaller of the two time windows
+	options.TimeWindow = client.TimeWindow
+	if server.TimeWindow < options.TimeWindow {
+		options.TimeWindow = server.TimeWindow
+	}
+	options.SequenceWindow = commonSequenceWindow(client.SequenceWindow, server.SequenceWindow)
+
+	return options, nil
+}
+
+/**
+ * Client side check of the server response
+ * Makes sure the server only picked options the client offered
+ *
+ * offer: capabilities the client sent in the request
+ * response: handshake response from the server
+ *
+ * Returns the agreed MteOptions, legacy servers get the defaults
+ */
+func Accept(offer Capabilities, response HandshakeResponse) (out MteOptions, err error) {
+	//-------------------------------------------
+	// Legacy server, both sides use MTE defaults
+	if response.Version < ProtocolVersion || response.Options == nil {
+		return MteOptions{Version: LegacyVersion}, nil
+	}
+	options := *response.Options
+	if !containsInt(offer.Drbgs, options.Drbg) {
+		return options, fmt.Errorf("%w: drbg %d", ErrUnexpectedOption, options.Drbg)
+	}
+	if !containsInt(offer.TokBytes, options.TokBytes) {
+		return options, fmt.Errorf("%w: token bytes %d", ErrUnexpectedOption, options.TokBytes)
+	}
+	if !containsInt(offer.Verifiers, options.Verifiers) {
+		return options, fmt.Errorf("%w: verifiers %d", ErrUnexpectedOption, options.Verifiers)
+	}
+	if options.TimeWindow > offer.TimeWindow {
+		return options, fmt.Errorf("%w: time window %d", ErrUnexpectedOption, options.TimeWindow)
+	}
+	if commonSequenceWindow(offer.SequenceWindow, options.SequenceWindow) != options.SequenceWindow {
+		return options, fmt.Errorf("%w: sequence window %d", ErrUnexpectedOption, options.SequenceWindow)
+	}
+	if len(options.Modes) == 0 {
+		return options, fmt.Errorf("%w: mode", ErrNoCommonOption)
+	}
+	for _, mode := range options.Modes {
+		if !contains(offer.Modes, mode) {
+			return options, fmt.Errorf("%w: mode %s", ErrUnexpectedOption, mode)
+		}
+	}
+	if contains(options.Modes, ModeFlen) && (options.FixedLength <= 0 || options.FixedLength > offer.FixedLength) {
+		return options, fmt.Errorf("%w: fixed length %d", ErrUnexpectedOption, options.FixedLength)
+	}
+	return options, nil
+}
+
+/**
+ * Sequence window both sides can honor
+ * Zero (verification only) wins, mixed forward-only and async
+ * fall back to verification only, otherwise the smaller window
+ */
+func commonSequenceWindow(a int, b int) int {
+	if a == 0 || b == 0 || (a < 0) != (b < 0) {
+		return 0
+	}
+	if a < 0 {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func firstCommon(preferred []int, supported []int) (out int, err error) {
+	for _, value := range preferred {
+		if containsInt(supported, value) {
+			return value, nil
+		}
+	}
+	return 0, ErrNoCommonOption
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHandshake
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testCapabilities() Capabilities {
+	return Capabilities{
+		Drbgs:          []int{3, 1},
+		TokBytes:       []int{16, 8},
+		Verifiers:      []int{0},
+		TimeWindow:     5000,
+		SequenceWindow: 4,
+		Modes:          []string{ModeCore, ModeMke},
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name   string
+		client func(c *Capabilities)
+		server func(c *Capabilities)
+		want   MteOptions
+		err    error
+	}{
+		{
+			name: "same capabilities",
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 5000,
+				SequenceWindow: 4, Modes: []string{ModeCore, ModeMke}},
+		},
+		{
+			name:   "client preference wins",
+			server: func(c *Capabilities) { c.Drbgs = []int{1, 3}; c.TokBytes = []int{8, 16} },
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 5000,
+				SequenceWindow: 4, Modes: []string{ModeCore, ModeMke}},
+		},
+		{
+			name:   "no common drbg",
+			server: func(c *Capabilities) { c.Drbgs = []int{7} },
+			err:    ErrNoCommonOption,
+		},
+		{
+			name:   "no common token size",
+			server: func(c *Capabilities) { c.TokBytes = []int{32} },
+			err:    ErrNoCommonOption,
+		},
+		{
+			name:   "no common verifiers",
+			server: func(c *Capabilities) { c.Verifiers = []int{1} },
+			err:    ErrNoCommonOption,
+		},
+		{
+			name:   "no common mode",
+			client: func(c *Capabilities) { c.Modes = []string{ModeMke} },
+			server: func(c *Capabilities) { c.Modes = []string{ModeCore} },
+			err:    ErrNoCommonOption,
+		},
+		{
+			name:   "smaller time window",
+			server: func(c *Capabilities) { c.TimeWindow = 1000 },
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 1000,
+				SequenceWindow: 4, Modes: []string{ModeCore, ModeMke}},
+		},
+		{
+			name:   "smaller fixed length wins",
+			client: func(c *Capabilities) { c.Modes = []string{ModeFlen}; c.FixedLength = 256 },
+			server: func(c *Capabilities) { c.Modes = []string{ModeCore, ModeFlen}; c.FixedLength = 128 },
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 5000,
+				SequenceWindow: 4, Modes: []string{ModeFlen}, FixedLength: 128},
+		},
+		{
+			name:   "flen needs a fixed length on both sides",
+			client: func(c *Capabilities) { c.Modes = []string{ModeFlen, ModeCore}; c.FixedLength = 256 },
+			server: func(c *Capabilities) { c.Modes = []string{ModeCore, ModeFlen} },
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 5000,
+				SequenceWindow: 4, Modes: []string{ModeCore}},
+		},
+		{
+			name:   "only flen without a fixed length",
+			client: func(c *Capabilities) { c.Modes = []string{ModeFlen} },
+			server: func(c *Capabilities) { c.Modes = []string{ModeFlen}; c.FixedLength = 64 },
+			err:    ErrNoCommonOption,
+		},
+		{
+			name:   "fixed length dropped without flen",
+			client: func(c *Capabilities) { c.FixedLength = 256 },
+			server: func(c *Capabilities) { c.FixedLength = 128 },
+			want: MteOptions{Version: ProtocolVersion, Drbg: 3, TokBytes: 16, TimeWindow: 5000,
+				SequenceWindow: 4, Modes: []string{ModeCore, ModeMke}},
+		},
+	}
+	for _, c := range cases {
+		client, server := testCapabilities(), testCapabilities()
+		if c.client != nil {
+			c.client(&client)
+		}
+		if c.server != nil {
+			c.server(&server)
+		}
+		got, err := Negotiate(client, server)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("%s: got %v want %v", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCommonSequenceWindow(t *testing.T) {
+	cases := []struct {
+		client, server, want int
+	}{
+		{0, 0, 0},
+		{0, 8, 0},
+		{-8, 0, 0},
+		{4, 8, 4},
+		{8, 4, 4},
+		{-4, -8, -4},
+		{-8, -4, -4},
+		{4, -4, 0},
+		{-4, 4, 0},
+	}
+	for _, c := range cases {
+		client, server := testCapabilities(), testCapabilities()
+		client.SequenceWindow, server.SequenceWindow = c.client, c.server
+		options, err := Negotiate(client, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options.SequenceWindow != c.want {
+			t.Errorf("%d and %d: got %d want %d", c.client, c.server, options.SequenceWindow, c.want)
+		}
+		if _, err = Accept(client, HandshakeResponse{Version: ProtocolVersion, Options: &options}); err != nil {
+			t.Errorf("%d and %d: accept: %v", c.client, c.server, err)
+		}
+	}
+}
+
+func TestAccept(t *testing.T) {
+	offer := testCapabilities()
+	offer.Modes = append(offer.Modes, ModeFlen)
+	offer.FixedLength = 128
+	agreed := func(change func(o *MteOptions)) *MteOptions {
+		options := MteOptions{Version: ProtocolVersion, Drbg: 1, TokBytes: 8, TimeWindow: 1000,
+			SequenceWindow: 2, Modes: []string{ModeCore}}
+		if change != nil {
+			change(&options)
+		}
+		return &options
+	}
+	cases := []struct {
+		name    string
+		options *MteOptions
+		err     error
+	}{
+		{"offered options", agreed(nil), nil},
+		{"flen within the fixed length", agreed(func(o *MteOptions) { o.Modes = []string{ModeFlen}; o.FixedLength = 64 }), nil},
+		{"drbg not offered", agreed(func(o *MteOptions) { o.Drbg = 7 }), ErrUnexpectedOption},
+		{"token size not offered", agreed(func(o *MteOptions) { o.TokBytes = 32 }), ErrUnexpectedOption},
+		{"verifiers not offered", agreed(func(o *MteOptions) { o.Verifiers = 1 }), ErrUnexpectedOption},
+		{"larger time window", agreed(func(o *MteOptions) { o.TimeWindow = 6000 }), ErrUnexpectedOption},
+		{"larger sequence window", agreed(func(o *MteOptions) { o.SequenceWindow = 8 }), ErrUnexpectedOption},
+		{"async instead of forward only", agreed(func(o *MteOptions) { o.SequenceWindow = -2 }), ErrUnexpectedOption},
+		{"mode not offered", agreed(func(o *MteOptions) { o.Modes = []string{"other"} }), ErrUnexpectedOption},
+		{"no mode", agreed(func(o *MteOptions) { o.Modes = nil }), ErrNoCommonOption},
+		{"flen without a fixed length", agreed(func(o *MteOptions) { o.Modes = []string{ModeFlen} }), ErrUnexpectedOption},
+		{"flen longer than offered", agreed(func(o *MteOptions) { o.Modes = []string{ModeFlen}; o.FixedLength = 256 }), ErrUnexpectedOption},
+	}
+	for _, c := range cases {
+		got, err := Accept(offer, HandshakeResponse{Version: ProtocolVersion, Options: c.options})
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v want %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, *c.options) {
+			t.Errorf("%s: got %+v want %+v", c.name, got, *c.options)
+		}
+	}
+}
+
+func TestAcceptLegacyServer(t *testing.T) {
+	for _, response := range []HandshakeResponse{
+		{},
+		{Version: LegacyVersion},
+		{Version: ProtocolVersion},
+	} {
+		got, err := Accept(testCapabilities(), response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.IsDefault() || !got.HasMode(ModeCore) || !got.HasMode(ModeMke) || got.HasMode(ModeFlen) {
+			t.Errorf("version %d: got %+v want the MTE defaults", response.Version, got)
+		}
+	}
+}
+
+func TestCheckFixedLength(t *testing.T) {
+	options := MteOptions{Version: ProtocolVersion, Modes: []string{ModeFlen}, FixedLength: 16}
+	if err := options.CheckFixedLength(16); err != nil {
+		t.Errorf("16 bytes: %v", err)
+	}
+	if err := options.CheckFixedLength(17); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("17 bytes: got %v want ErrMessageTooLong", err)
+	}
+}
@@ -0,0 +1,294 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//-------------------
+	// Default settings
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultDialTimeout    = 10 * time.Second
+	DefaultRetries        = 3
+	DefaultMinBackoff     = 250 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+
+	//---------------
+	// Header names
+	ClientIdHeader = "x-client-id"
+)
+
+//--------------------------------------------------
+// Returned when the server answers with a non-2xx
+// status, Body holds whatever the server sent back
+// The server fields are set when Body is a ResponseModel
+type StatusError struct {
+	StatusCode   int
+	Status       string
+	Body         []byte
+	ResultCode   string
+	Message      string
+	ExceptionUid string
+}
+
+func (e *StatusError) Error() string {
+	message := "server returned " + e.Status
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.ResultCode != "" {
+		message += " (result code " + e.ResultCode + ")"
+	}
+	return message
+}
+
+//------------------
+// Error messages
+var errEmptyBody = errors.New("empty body")
+
+//------------------------------------------------------
+// Request to send, the Body is kept so the request can
+// be sent again when it is safe to retry
+type Request struct {
+	Method      string
+	Url         string
+	ClientId    string
+	ContentType string
+	Body        []byte
+	Header      http.Header
+}
+
+//------------------------------------------------------
+// Http client shared by the samples
+// RequestTimeout limits each attempt made by Do
+// Retries is the number of extra attempts Do may make
+type Client struct {
+	HttpClient     *http.Client
+	RequestTimeout time.Duration
+	Retries        int
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+}
+
+//-------------------------------------------
+// Random source for the backoff jitter
+var jitterLock sync.Mutex
+var jitter = mrand.New(mrand.NewSource(time.Now().UnixNano()))
+
+/**
+ * Creates a Client with the default timeouts and retries
+ * The dial and response header timeouts are set on the transport,
+ * there is no overall timeout so large uploads can still stream
+ */
+func NewClient() *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: DefaultDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = DefaultRequestTimeout
+	return &Client{
+		HttpClient:     &http.Client{Transport: transport},
+		RequestTimeout: DefaultRequestTimeout,
+		Retries:        DefaultRetries,
+		MinBackoff:     DefaultMinBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+	}
+}
+
+/**
+ * Sends the request, retrying failures with jittered backoff
+ *
+ * Idempotent requests (GET, HEAD, OPTIONS) are retried on any
+ * transport error and on 429, 502, 503 and 504 responses.
+ * Every other request, like a POST holding an MTE encoded body,
+ * is only retried when the connection could not be made at all.
+ * Once the body may have reached the server it is never sent again,
+ * the server Decoder would reject the replayed message or fall out
+ * of sync with the client Encoder.
+ *
+ * ctx: cancels the request and any backoff wait
+ * r: request to send
+ *
+ * Returns the response body of a 2xx response
+ */
+func (c *Client) Do(ctx context.Context, r Request) (out []byte, err error) {
+	method := strings.ToUpper(r.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	for attempt := 0; ; attempt++ {
+		body, statusCode, err := c.attempt(ctx, method, r)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= c.Retries || !canRetry(method, statusCode, err) {
+			return nil, err
+		}
+		//---------------------------------------
+		// Wait before trying again, unless the
+		// caller gives up first
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+/**
+ * Sends a request once and returns the body of a 2xx response
+ * Use this for requests with a streaming body that can not be
+ * sent again, like an MKE encoded file upload
+ */
+func (c *Client) Send(ctx context.Context, req *http.Request) (out []byte, err error) {
+	body, _, err := c.send(req.WithContext(ctx))
+	return body, err
+}
+
+/**
+ * Makes one attempt of the request
+ */
+func (c *Client) attempt(ctx context.Context, method string, r Request) (out []byte, statusCode int, err error) {
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+	var bodyReader io.Reader
+	if r.Body != nil {
+		bodyReader = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.Url, bodyReader)
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if r.ClientId != "" {
+		req.Header.Set(ClientIdHeader, r.ClientId)
+	}
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+	return c.send(req)
+}
+
+/**
+ * Sends the request, always reads and closes the response body
+ * and checks the status code before anybody decodes the body
+ */
+func (c *Client) send(req *http.Request) (out []byte, statusCode int, err error) {
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+		statusErr.readServerMessage()
+		return nil, resp.StatusCode, statusErr
+	}
+	return body, resp.StatusCode, nil
+}
+
+/**
+ * Full jitter backoff, a random wait between zero and
+ * MinBackoff doubled for each attempt, capped at MaxBackoff
+ */
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.MinBackoff
+	for i := 0; i < attempt && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+	return time.Duration(jitter.Int63n(int64(wait) + 1))
+}
+
+/**
+ * Decides if a failed attempt may be sent again
+ */
+func canRetry(method string, statusCode int, err error) bool {
+	//----------------------------------------------
+	// Nothing was sent when the connection failed
+	if isDialError(err) {
+		return true
+	}
+	if !isIdempotent(method) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	//----------------------------------------------
+	// The caller canceled, do not try again
+	return !errors.Is(err, context.Canceled)
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
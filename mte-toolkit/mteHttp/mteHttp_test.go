@@ -0,0 +1,276 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//-------------------------------------------------
+// Transport that records every response body so
+// the tests can check they were all closed
+type bodyTracker struct {
+	transport http.RoundTripper
+	lock      sync.Mutex
+	bodies    []*trackedBody
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	closed int32
+}
+
+func (b *trackedBody) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return b.ReadCloser.Close()
+}
+
+func (t *bodyTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body := &trackedBody{ReadCloser: resp.Body}
+	resp.Body = body
+	t.lock.Lock()
+	t.bodies = append(t.bodies, body)
+	t.lock.Unlock()
+	return resp, nil
+}
+
+func (t *bodyTracker) checkClosed(test *testing.T) {
+	test.Helper()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i, body := range t.bodies {
+		if atomic.LoadInt32(&body.closed) == 0 {
+			test.Errorf("response body %d was not closed", i)
+		}
+	}
+}
+
+/**
+ * Creates a Client with short backoffs and a transport
+ * recording the response bodies
+ */
+func newTestClient() (*Client, *bodyTracker) {
+	client := NewClient()
+	client.MinBackoff = time.Millisecond
+	client.MaxBackoff = 4 * time.Millisecond
+	tracker := &bodyTracker{transport: client.HttpClient.Transport}
+	client.HttpClient.Transport = tracker
+	return client, tracker
+}
+
+/**
+ * Starts a server answering with the status codes in order,
+ * the last one is repeated. Returns the server and its
+ * request counter
+ */
+func statusServer(t *testing.T, statusCodes ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1)) - 1
+		if n >= len(statusCodes) {
+			n = len(statusCodes) - 1
+		}
+		w.WriteHeader(statusCodes[n])
+		io.WriteString(w, `{"success":false,"message":"try again","resultCode":"RC1"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestPostIsNotRetriedOnErrorStatus(t *testing.T) {
+	for _, statusCode := range []int{
+		http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+	} {
+		server, requests := statusServer(t, statusCode)
+		client, tracker := newTestClient()
+		_, err := client.Do(context.Background(), Request{Method: http.MethodPost, Url: server.URL, Body: []byte("encoded")})
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != statusCode {
+			t.Fatalf("status %d: got %v", statusCode, err)
+		}
+		if statusErr.Message != "try again" || statusErr.ResultCode != "RC1" {
+			t.Errorf("status %d: server message not read: %+v", statusCode, statusErr)
+		}
+		if n := atomic.LoadInt32(requests); n != 1 {
+			t.Errorf("status %d: POST sent %d times", statusCode, n)
+		}
+		tracker.checkClosed(t)
+	}
+}
+
+func TestPostIsNotRetriedOnDroppedConnection(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		io.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+	client, _ := newTestClient()
+	_, err := client.Do(context.Background(), Request{Method: http.MethodPost, Url: server.URL, Body: []byte("encoded")})
+	if err == nil {
+		t.Fatal("dropped connection reported no error")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("POST sent %d times", n)
+	}
+}
+
+func TestPostIsRetriedWhenDialFails(t *testing.T) {
+	server, requests := statusServer(t, http.StatusOK)
+	client, tracker := newTestClient()
+	transport := tracker.transport.(*http.Transport)
+	dial := transport.DialContext
+	var dials int32
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+		}
+		return dial(ctx, network, address)
+	}
+	_, err := client.Do(context.Background(), Request{Method: http.MethodPost, Url: server.URL, Body: []byte("encoded")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("POST reached the server %d times", n)
+	}
+	tracker.checkClosed(t)
+}
+
+func TestGetIsRetried(t *testing.T) {
+	server, requests := statusServer(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	client, tracker := newTestClient()
+	_, err := client.Do(context.Background(), Request{Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("GET sent %d times, want 3", n)
+	}
+	tracker.checkClosed(t)
+}
+
+func TestGetGivesUpAfterRetries(t *testing.T) {
+	server, requests := statusServer(t, http.StatusServiceUnavailable)
+	client, tracker := newTestClient()
+	client.Retries = 2
+	_, err := client.Do(context.Background(), Request{Url: server.URL})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("got %v want a StatusError", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("GET sent %d times, want 3", n)
+	}
+	tracker.checkClosed(t)
+}
+
+func TestGetIsNotRetriedOnClientError(t *testing.T) {
+	server, requests := statusServer(t, http.StatusNotFound)
+	client, tracker := newTestClient()
+	_, err := client.Do(context.Background(), Request{Url: server.URL})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v want a 404 StatusError", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("GET sent %d times, want 1", n)
+	}
+	tracker.checkClosed(t)
+}
+
+func TestContextCancelsBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client, tracker := newTestClient()
+	client.MinBackoff = time.Hour
+	client.MaxBackoff = time.Hour
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do(ctx, Request{Url: server.URL})
+		done <- err
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do kept waiting after the context was canceled")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("GET sent %d times, want 1", n)
+	}
+	tracker.checkClosed(t)
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	limits := []time.Duration{10, 20, 40, 50, 50, 50}
+	for attempt, limit := range limits {
+		limit *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if wait := client.backoff(attempt); wait < 0 || wait > limit {
+				t.Fatalf("attempt %d: waited %v, limit %v", attempt, wait, limit)
+			}
+		}
+	}
+	if wait := (&Client{}).backoff(3); wait != 0 {
+		t.Errorf("no backoff configured: waited %v", wait)
+	}
+}
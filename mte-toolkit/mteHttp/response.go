@@ -0,0 +1,122 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHttp
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+//----------------------------------------------------------
+// Response envelope the API server wraps every answer in
+// AccessToken is only filled in by the login route, it is
+// the only field the server does not name like the struct
+type ResponseModel[T any] struct {
+	Message      string
+	Success      bool
+	ResultCode   string
+	AccessToken  string `json:"access_token,omitempty"`
+	ExceptionUid string
+	Data         T
+}
+
+//-------------------------------------------------------
+// Returned when a response body is not a ResponseModel
+type DecodeError struct {
+	Body []byte
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return "malformed server response: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+//------------------------------------------------------
+// Returned when the server answers with Success false
+type ServerError struct {
+	ResultCode   string
+	Message      string
+	ExceptionUid string
+}
+
+func (e *ServerError) Error() string {
+	message := "server error"
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.ResultCode != "" {
+		message += " (result code " + e.ResultCode + ")"
+	}
+	if e.ExceptionUid != "" {
+		message += " exception " + e.ExceptionUid
+	}
+	return message
+}
+
+/**
+ * Decodes a response body into a ResponseModel
+ *
+ * body: body of a 2xx response
+ *
+ * Returns the ResponseModel, a *DecodeError when the body is not
+ * valid JSON or a *ServerError when the server reports a failure
+ */
+func DecodeResponse[T any](body []byte) (out ResponseModel[T], err error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return out, &DecodeError{Body: body, Err: errEmptyBody}
+	}
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		return out, &DecodeError{Body: body, Err: err}
+	}
+	if !out.Success {
+		return out, &ServerError{
+			ResultCode:   out.ResultCode,
+			Message:      out.Message,
+			ExceptionUid: out.ExceptionUid,
+		}
+	}
+	return out, nil
+}
+
+/**
+ * Fills in the server message of a non-2xx response
+ * when its body holds a ResponseModel, otherwise leaves it alone
+ */
+func (e *StatusError) readServerMessage() {
+	var model ResponseModel[json.RawMessage]
+	if json.Unmarshal(e.Body, &model) != nil {
+		return
+	}
+	e.ResultCode = model.ResultCode
+	e.Message = model.Message
+	e.ExceptionUid = model.ExceptionUid
+}
@@ -0,0 +1,137 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		want  ResponseModel[string]
+		error interface{}
+	}{
+		{
+			name: "success",
+			body: `{"message":"ok","success":true,"resultCode":"0","data":"encoded"}`,
+			want: ResponseModel[string]{Message: "ok", Success: true, ResultCode: "0", Data: "encoded"},
+		},
+		{
+			name: "field names of the server",
+			body: `{"Message":"ok","Success":true,"ResultCode":"0","ExceptionUid":"","Data":"encoded"}`,
+			want: ResponseModel[string]{Message: "ok", Success: true, ResultCode: "0", Data: "encoded"},
+		},
+		{
+			name: "access token",
+			body: `{"success":true,"access_token":"token","data":"encoded"}`,
+			want: ResponseModel[string]{Success: true, AccessToken: "token", Data: "encoded"},
+		},
+		{
+			name: "camel case token is not the access token",
+			body: `{"success":true,"accessToken":"token"}`,
+			want: ResponseModel[string]{Success: true},
+		},
+		{
+			name:  "server failure",
+			body:  `{"message":"bad login","success":false,"resultCode":"RC7","exceptionUid":"abc"}`,
+			error: &ServerError{ResultCode: "RC7", Message: "bad login", ExceptionUid: "abc"},
+		},
+		{
+			name:  "success missing",
+			body:  `{"data":"encoded"}`,
+			error: &ServerError{},
+		},
+		{name: "empty", body: "", error: &DecodeError{}},
+		{name: "white space", body: " \r\n", error: &DecodeError{}},
+		{name: "not json", body: "<html>Bad Gateway</html>", error: &DecodeError{}},
+		{name: "truncated", body: `{"success":true,"data":"enc`, error: &DecodeError{}},
+		{name: "wrong data type", body: `{"success":true,"data":42}`, error: &DecodeError{}},
+	}
+	for _, c := range cases {
+		got, err := DecodeResponse[string]([]byte(c.body))
+		switch want := c.error.(type) {
+		case nil:
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			} else if got != c.want {
+				t.Errorf("%s: got %+v want %+v", c.name, got, c.want)
+			}
+		case *ServerError:
+			var serverErr *ServerError
+			if !errors.As(err, &serverErr) {
+				t.Errorf("%s: got %v want a ServerError", c.name, err)
+			} else if *serverErr != *want {
+				t.Errorf("%s: got %+v want %+v", c.name, *serverErr, *want)
+			}
+		case *DecodeError:
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Errorf("%s: got %v want a DecodeError", c.name, err)
+			} else if string(decodeErr.Body) != c.body || decodeErr.Err == nil {
+				t.Errorf("%s: DecodeError does not keep the body and cause: %+v", c.name, decodeErr)
+			}
+		}
+	}
+}
+
+func TestDecodeResponseKeepsJsonErrors(t *testing.T) {
+	_, err := DecodeResponse[string]([]byte("{"))
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("got %v want the json.SyntaxError", err)
+	}
+}
+
+func TestResponseModelFieldNames(t *testing.T) {
+	modelBytes, err := json.Marshal(ResponseModel[string]{Success: true, AccessToken: "token", Data: "encoded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Message":"","Success":true,"ResultCode":"","access_token":"token","ExceptionUid":"","Data":"encoded"}`
+	if string(modelBytes) != want {
+		t.Errorf("got %s want %s", modelBytes, want)
+	}
+}
+
+func TestStatusErrorReadsServerMessage(t *testing.T) {
+	statusErr := &StatusError{Status: "400 Bad Request",
+		Body: []byte(`{"message":"invalid","success":false,"resultCode":"RC2","exceptionUid":"e1"}`)}
+	statusErr.readServerMessage()
+	if statusErr.Message != "invalid" || statusErr.ResultCode != "RC2" || statusErr.ExceptionUid != "e1" {
+		t.Errorf("got %+v", statusErr)
+	}
+
+	plain := &StatusError{Status: "502 Bad Gateway", Body: []byte("<html></html>")}
+	plain.readServerMessage()
+	if plain.Message != "" || plain.Error() != "server returned 502 Bad Gateway" {
+		t.Errorf("got %q", plain.Error())
+	}
+}
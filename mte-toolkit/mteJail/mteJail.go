@@ -0,0 +1,343 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteJail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//--------------------------------------------
+	// Store key prefix of the device registrations
+	devicePrefix = "jail_"
+
+	//---------------------------------
+	// Event name sent to the webhook
+	EventSuspectedCompromise = "suspected_compromise"
+
+	//--------------------
+	// Content type const
+	jsonContent = "application/json"
+)
+
+//------------------
+// Error messages
+var ErrNoDevice = errors.New("handshake request has no device")
+var ErrUnknownDevice = errors.New("no device registered for client")
+var ErrJailAlgo = errors.New("invalid jailbreak algorithm")
+var ErrSuspectedCompromise = errors.New("suspected compromised device")
+
+//--------------------------------------------------------
+// Jail nonce callback that keeps a copy of the mutated
+// nonce so it can be displayed or compared
+type Retain struct {
+	*mte.Jail
+	mutated []byte
+}
+
+/**
+ * Creates the jail nonce callback for a device type
+ *
+ * algo: jailbreak algorithm of the device
+ * nonce: nonce agreed on during handshake
+ */
+func NewRetain(algo mte.JailAlgo, nonce uint64) *Retain {
+	retain := &Retain{Jail: mte.NewJail()}
+	retain.SetAlgo(algo)
+	retain.SetNonceSeed(nonce)
+	return retain
+}
+
+/**
+ * Returns the mutated nonce of the last instantiate
+ */
+func (r *Retain) Mutated() []byte {
+	return r.mutated
+}
+
+func (r *Retain) NonceCallback(minLength int, maxLength int, nonce []byte, nBytes *int) {
+	r.Jail.NonceCallback(minLength, maxLength, nonce, nBytes)
+
+	//--------------------------------------
+	// Retain a copy of the mutated nonce
+	r.mutated = make([]byte, *nBytes)
+	copy(r.mutated, nonce)
+}
+
+//------------------------------------------------
+// The part of an Encoder or Decoder needed to
+// instantiate it with the jail nonce callback
+type instantiator interface {
+	SetNonceCallback(cb mte.NonceCallback)
+	SetEntropy(entropy []byte)
+	GetDrbg() mte.Drbgs
+	InstantiateStr(personal string) mte.Status
+}
+
+/**
+ * Instantiates an Encoder or Decoder with the jail nonce
+ * callback of a device type instead of the plain nonce
+ *
+ * coder: Encoder or Decoder to instantiate
+ * algo: jailbreak algorithm of the device
+ * nonce: nonce seed
+ * entropy: source of the entropy
+ * personal: personalization string
+ *
+ * Returns the callback holding the mutated nonce
+ */
+func Instantiate(coder instantiator,
+	algo mte.JailAlgo,
+	nonce uint64,
+	entropy mteEntropy.Provider,
+	personal string) (out *Retain, err error) {
+	retain := NewRetain(algo, nonce)
+	coder.SetNonceCallback(retain)
+	err = mteEntropy.Set(entropy, mte.GetDrbgsEntropyMinBytes(coder.GetDrbg()), coder)
+	if err != nil {
+		return nil, fmt.Errorf("entropy: %w", err)
+	}
+	status := coder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		return nil, mteAdapter.NewStatusError("instantiate", status)
+	}
+	return retain, nil
+}
+
+//----------------------------------------------------------
+// Reported when a message from a client can not be decoded
+// because its nonce was mutated differently than expected
+// for the registered device type
+// The device fields are the ones registered at handshake,
+// Attestation is the SHA-256 of the attestation blob
+type Event struct {
+	Event       string    `json:"event"`
+	ClientId    string    `json:"clientId"`
+	Platform    string    `json:"platform,omitempty"`
+	AppVersion  string    `json:"appVersion,omitempty"`
+	JailAlgo    int       `json:"jailAlgo"`
+	Device      string    `json:"device"`
+	Attestation string    `json:"attestation,omitempty"`
+	Status      string    `json:"status"`
+	Time        time.Time `json:"time"`
+}
+
+//-----------------------------------------------
+// Receives the suspected-compromise events
+type Reporter interface {
+	Report(ctx context.Context, event Event) error
+}
+
+//-----------------------------------------------
+// Reports the events to a function
+type ReporterFunc func(ctx context.Context, event Event) error
+
+func (f ReporterFunc) Report(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+//-----------------------------------------------------
+// Reports the events by posting them as JSON to a url
+type WebhookReporter struct {
+	Url    string
+	Client *mteHttp.Client
+}
+
+func (w *WebhookReporter) Report(ctx context.Context, event Event) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = w.Client.Do(ctx, mteHttp.Request{
+		Method:      "POST",
+		Url:         w.Url,
+		ClientId:    event.ClientId,
+		ContentType: jsonContent,
+		Body:        eventBytes,
+	})
+	return err
+}
+
+//------------------------------------------------------------
+// Server side jailbreak verification
+// The device type of every client is registered at handshake
+// and kept in the store, every Decoder for that client is
+// instantiated with the jail nonce callback of its device type.
+// A device that mutates the nonce differently, because it is
+// jailbroken or rooted, produces messages that fail to decode
+// with "token does not exist", those are reported as events.
+type Service struct {
+	store    mteStore.Store
+	reporter Reporter
+}
+
+/**
+ * Creates the Service
+ *
+ * store: where the device registrations are kept
+ * reporter: receives the suspected-compromise events, may be nil
+ */
+func NewService(store mteStore.Store, reporter Reporter) *Service {
+	return &Service{store: store, reporter: reporter}
+}
+
+/**
+ * Registers the device of a client
+ * The whole Device is kept so the platform, app version and
+ * attestation can be traced when its messages fail to decode
+ */
+func (s *Service) Register(clientId string, device mteHandshake.Device) error {
+	if device.JailAlgo <= mte.JailAlgoNone || device.JailAlgo >= mte.NumJailAlgo {
+		return fmt.Errorf("%w: %d", ErrJailAlgo, device.JailAlgo)
+	}
+	deviceBytes, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(devicePrefix+clientId, deviceBytes)
+}
+
+/**
+ * Returns the device registered for a client
+ */
+func (s *Service) Device(clientId string) (out mteHandshake.Device, err error) {
+	var device mteHandshake.Device
+	deviceBytes, err := s.store.Get(devicePrefix + clientId)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		return device, fmt.Errorf("%w: %s", ErrUnknownDevice, clientId)
+	}
+	if err != nil {
+		return device, err
+	}
+	err = json.Unmarshal(deviceBytes, &device)
+	return device, err
+}
+
+/**
+ * Answers a client handshake and registers the device it sent
+ * Requests without a device are refused, the Decoder could
+ * not verify the device
+ *
+ * request: handshake request sent by the client
+ * server: MTE options this server supports
+ *
+ * Returns the response to send back and the server secrets
+ */
+func (s *Service) Handshake(request mteHandshake.HandshakeRequest,
+	server mteHandshake.Capabilities) (out mteHandshake.HandshakeResponse, secrets *mteSession.Secrets, err error) {
+	if request.Device == nil {
+		return out, nil, ErrNoDevice
+	}
+	response, secrets, err := mteAdapter.Respond(request, server)
+	if err != nil {
+		return response, nil, err
+	}
+	err = s.Register(secrets.ClientId, *secrets.Device)
+	if err != nil {
+		return response, nil, err
+	}
+	return response, secrets, nil
+}
+
+/**
+ * Creates the Decoder for a client from the handshake secrets
+ * The jail nonce callback of the registered device type
+ * mutates the nonce before the Decoder is instantiated
+ */
+func (s *Service) NewDecoder(secrets *mteSession.Secrets) (out *mte.MteDec, err error) {
+	device, err := s.Device(secrets.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	decoder := mteAdapter.NewCoreDecoder(secrets.Options)
+	_, err = Instantiate(decoder, mte.JailAlgo(device.JailAlgo), secrets.Nonce,
+		mteEntropy.NewEcdhProvider(secrets.DecoderEntropy), secrets.ClientId)
+	if err != nil {
+		decoder.Destroy()
+		return nil, fmt.Errorf("Decoder %w", err)
+	}
+	return decoder, nil
+}
+
+/**
+ * Decodes a message from a client
+ * A warning status, for example skipped messages, still
+ * decoded the message. A "token does not exist" status means
+ * the client nonce was mutated differently, it is reported
+ * and ErrSuspectedCompromise is returned
+ */
+func (s *Service) Decode(ctx context.Context,
+	clientId string,
+	decoder *mte.MteDec,
+	encoded []byte) (out []byte, err error) {
+	decoded, status := decoder.Decode(encoded)
+	if !mte.StatusIsError(status) {
+		return decoded, nil
+	}
+	if status != mte.Status_mte_status_token_does_not_exist {
+		return nil, mteAdapter.NewStatusError("Decode", status)
+	}
+	device, err := s.Device(clientId)
+	if err != nil {
+		return nil, err
+	}
+	if s.reporter != nil {
+		event := Event{
+			Event:      EventSuspectedCompromise,
+			ClientId:   clientId,
+			Platform:   device.Platform,
+			AppVersion: device.AppVersion,
+			JailAlgo:   device.JailAlgo,
+			Device:     mte.JailAlgos[device.JailAlgo],
+			Status:     mte.GetStatusName(status),
+			Time:       time.Now().UTC(),
+		}
+		if len(device.Attestation) > 0 {
+			digest := sha256.Sum256(device.Attestation)
+			event.Attestation = hex.EncodeToString(digest[:])
+		}
+		err = s.reporter.Report(ctx, event)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s (report failed: %v)", ErrSuspectedCompromise, clientId, err)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSuspectedCompromise, clientId)
+}
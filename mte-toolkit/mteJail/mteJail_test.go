@@ -0,0 +1,278 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteJail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+//---------------------------------------------------------------
+// The golden vectors are recorded with a licensed MTE library:
+//   go test ./mteJail -run TestGoldenVectors -update
+// A failure afterwards means a device type now mutates the nonce
+// differently and devices in the field would be flagged
+//---------------------------------------------------------------
+
+var update = flag.Bool("update", false, "record the golden vectors again")
+
+const (
+	goldenPath   = "testdata/golden.json"
+	testPersonal = "user1"
+	testEntropy  = "0123456789abcdef"
+	testNonce    = uint64(1234)
+	testMessage  = "jailbreak test message"
+
+	//------------------------------------------
+	// Same Encoder and Decoder options as the
+	// mte-jailbreak sample and jailcheck command
+	testDrbg      = mte.Drbgs_mte_drbgs_hash_sha1
+	testTokBytes  = 2
+	testVerifiers = mte.Verifiers_mte_verifiers_none
+)
+
+//----------------------------------------------
+// Mutated nonce and encoded sample of one
+// device type, both base64 encoded
+type goldenVector struct {
+	JailAlgo     int    `json:"jailAlgo"`
+	Device       string `json:"device"`
+	MutatedNonce string `json:"mutatedNonce"`
+	Encoded      string `json:"encoded"`
+}
+
+type goldenFile struct {
+	MteVersion string         `json:"mteVersion"`
+	Vectors    []goldenVector `json:"vectors"`
+}
+
+func TestMutationIsStable(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	for algo := mte.JailAlgoNone; algo < mte.NumJailAlgo; algo++ {
+		first := newGoldenVector(t, mte.JailAlgo(algo))
+		second := newGoldenVector(t, mte.JailAlgo(algo))
+		if first != second {
+			t.Errorf("%v: got %+v then %+v", mte.JailAlgos[algo], first, second)
+		}
+	}
+}
+
+func TestMutationIsDistinct(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	seen := make(map[string]string)
+	for algo := mte.JailAlgoNone; algo < mte.NumJailAlgo; algo++ {
+		vector := newGoldenVector(t, mte.JailAlgo(algo))
+		if other, ok := seen[vector.MutatedNonce]; ok {
+			t.Errorf("%v mutates the nonce the same way as %v", vector.Device, other)
+		}
+		seen[vector.MutatedNonce] = vector.Device
+	}
+}
+
+func TestGoldenVectors(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	golden := goldenFile{MteVersion: mte.GetVersion()}
+	for algo := mte.JailAlgoNone; algo < mte.NumJailAlgo; algo++ {
+		golden.Vectors = append(golden.Vectors, newGoldenVector(t, mte.JailAlgo(algo)))
+	}
+	if *update {
+		goldenBytes, err := json.MarshalIndent(golden, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.MkdirAll(filepath.Dir(goldenPath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(goldenPath, append(goldenBytes, '\n'), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	goldenBytes, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want goldenFile
+	err = json.Unmarshal(goldenBytes, &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.Vectors) == 0 {
+		t.Fatalf("no golden vectors in %s, record them with -update and commit the file", goldenPath)
+	}
+	if len(want.Vectors) != len(golden.Vectors) {
+		t.Fatalf("%d golden vectors, want %d", len(want.Vectors), len(golden.Vectors))
+	}
+	for i, vector := range golden.Vectors {
+		if vector != want.Vectors[i] {
+			t.Errorf("%v: got %+v, recorded with MTE %v %+v", vector.Device, vector, want.MteVersion, want.Vectors[i])
+		}
+	}
+}
+
+func TestCrossAlgorithmDecode(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	for encAlgo := mte.JailAlgoNone; encAlgo < mte.NumJailAlgo; encAlgo++ {
+		encoded := newGoldenVector(t, mte.JailAlgo(encAlgo)).Encoded
+		for decAlgo := mte.JailAlgoNone; decAlgo < mte.NumJailAlgo; decAlgo++ {
+			decoder := mte.NewDecOpt(testDrbg, testTokBytes, testVerifiers, 0, 0)
+			_, err := Instantiate(decoder, mte.JailAlgo(decAlgo), testNonce, testProvider(), testPersonal)
+			if err != nil {
+				decoder.Destroy()
+				t.Fatal(err)
+			}
+			decoded, status := decoder.DecodeStrB64(encoded)
+			decoder.Destroy()
+
+			name := mte.JailAlgos[encAlgo] + " decoded as " + mte.JailAlgos[decAlgo]
+			if encAlgo == decAlgo {
+				if status != mte.Status_mte_status_success || decoded != testMessage {
+					t.Errorf("%v: %v %q, want success %q", name, mte.GetStatusName(status), decoded, testMessage)
+				}
+				continue
+			}
+			if status != mte.Status_mte_status_token_does_not_exist {
+				t.Errorf("%v: %v, want %v", name, mte.GetStatusName(status),
+					mte.GetStatusName(mte.Status_mte_status_token_does_not_exist))
+			}
+		}
+	}
+}
+
+func TestServiceReportsCompromise(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	var events []Event
+	service := NewService(mteStore.NewMemoryStore(), ReporterFunc(func(ctx context.Context, event Event) error {
+		events = append(events, event)
+		return nil
+	}))
+	err := service.Register("client", mteHandshake.Device{JailAlgo: mte.NumJailAlgo})
+	if !errors.Is(err, ErrJailAlgo) {
+		t.Fatalf("register invalid device: %v, want %v", err, ErrJailAlgo)
+	}
+	err = service.Register("client", mteHandshake.Device{JailAlgo: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := &mteSession.Secrets{
+		ClientId:       "client",
+		Nonce:          testNonce,
+		Options:        mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion},
+		DecoderEntropy: make([]byte, mte.GetDrbgsEntropyMinBytes(mte.GetDefaultDrbg())),
+	}
+
+	//---------------------------------------------
+	// A device that mutates the nonce like device
+	// type 1 decodes, any other device type does not
+	for algo := 1; algo < mte.NumJailAlgo; algo++ {
+		encoder := mteAdapter.NewCoreEncoder(secrets.Options)
+		_, err = Instantiate(encoder, mte.JailAlgo(algo), secrets.Nonce,
+			mteEntropy.NewInsecureFixedTestProvider([]byte{0}), secrets.ClientId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, status := encoder.Encode([]byte(testMessage))
+		encoder.Destroy()
+		if status != mte.Status_mte_status_success {
+			t.Fatalf("encode: %v", mte.GetStatusName(status))
+		}
+
+		secrets.DecoderEntropy = make([]byte, len(secrets.DecoderEntropy))
+		decoder, err := service.NewDecoder(secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := service.Decode(context.Background(), secrets.ClientId, decoder, encoded)
+		decoder.Destroy()
+		if algo == 1 {
+			if err != nil || !bytes.Equal(decoded, []byte(testMessage)) {
+				t.Errorf("%v: %q %v, want %q", mte.JailAlgos[algo], decoded, err, testMessage)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrSuspectedCompromise) {
+			t.Errorf("%v: %v, want %v", mte.JailAlgos[algo], err, ErrSuspectedCompromise)
+		}
+	}
+	if len(events) != mte.NumJailAlgo-2 {
+		t.Fatalf("%d events, want %d", len(events), mte.NumJailAlgo-2)
+	}
+	for _, event := range events {
+		if event.Event != EventSuspectedCompromise || event.ClientId != "client" || event.JailAlgo != 1 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+}
+
+/**
+ * Instantiates an Encoder with the jail nonce callback of
+ * algo and encodes the test message with it
+ */
+func newGoldenVector(t *testing.T, algo mte.JailAlgo) goldenVector {
+	t.Helper()
+	encoder := mte.NewEncOpt(testDrbg, testTokBytes, testVerifiers)
+	defer encoder.Destroy()
+	retain, err := Instantiate(encoder, algo, testNonce, testProvider(), testPersonal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, status := encoder.EncodeStrB64(testMessage)
+	if status != mte.Status_mte_status_success {
+		t.Fatalf("%v: encode: %v", mte.JailAlgos[algo], mte.GetStatusName(status))
+	}
+	return goldenVector{
+		JailAlgo:     int(algo),
+		Device:       mte.JailAlgos[algo],
+		MutatedNonce: base64.StdEncoding.EncodeToString(retain.Mutated()),
+		Encoded:      encoded,
+	}
+}
+
+/**
+ * The fixed test entropy of the mte-jailbreak sample
+ */
+func testProvider() mteEntropy.Provider {
+	return mteEntropy.NewInsecureFixedTestProvider([]byte(testEntropy))
+}
@@ -0,0 +1,184 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteMode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mteToolkit/mteHandshake"
+)
+
+const (
+	//----------------------------------------------------
+	// Mode markers, the first byte of every envelope tells
+	// the receiving side which Decoder to use
+	// FLEN messages are decoded with the Core Decoder
+	MarkerCore = 0x01
+	MarkerMke  = 0x02
+	MarkerFlen = 0x03
+
+	//----------------------------------------------------
+	// Messages this size or larger use MKE by default
+	DefaultThreshold = 1024
+)
+
+//------------------
+// Error messages
+var ErrMalformedEnvelope = errors.New("malformed envelope")
+var ErrUnknownMarker = errors.New("unknown mode marker")
+var ErrModeNotAgreed = errors.New("mode was not agreed on during handshake")
+
+//---------------------------------------------------------
+// Decides which MTE mode a message is sent with
+// Messages of Threshold bytes or more, and messages with
+// one of the MkeContentTypes, use MKE, the rest use Core
+type Policy struct {
+	Threshold       int
+	MkeContentTypes []string
+}
+
+//------------------------------------------------------
+// Sends files and binary uploads with MKE, short text
+// and JSON messages such as a login with Core
+var DefaultPolicy = Policy{
+	Threshold:       DefaultThreshold,
+	MkeContentTypes: []string{"application/octet-stream", "multipart/form-data"},
+}
+
+/**
+ * Picks the mode for a message
+ * Falls back to the other mode when the handshake did not
+ * agree on the one the policy prefers, FLEN is never picked,
+ * use it on purpose for messages whose length must not show
+ *
+ * size: message size in bytes, -1 when not known yet
+ * contentType: content type of the message, may be empty
+ * options: MTE options agreed on during handshake
+ *
+ * Returns mteHandshake.ModeCore or mteHandshake.ModeMke
+ */
+func (p Policy) Pick(size int64, contentType string, options mteHandshake.MteOptions) (out string, err error) {
+	mode := mteHandshake.ModeCore
+	if (p.Threshold > 0 && size >= int64(p.Threshold)) || p.isMkeContent(contentType) {
+		mode = mteHandshake.ModeMke
+	}
+	if options.HasMode(mode) {
+		return mode, nil
+	}
+	if mode == mteHandshake.ModeMke {
+		mode = mteHandshake.ModeCore
+	} else {
+		mode = mteHandshake.ModeMke
+	}
+	if options.HasMode(mode) {
+		return mode, nil
+	}
+	return "", ErrModeNotAgreed
+}
+
+func (p Policy) isMkeContent(contentType string) bool {
+	//------------------------------------------
+	// Ignore parameters such as "; charset=utf-8"
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	for _, mkeType := range p.MkeContentTypes {
+		if strings.EqualFold(mediaType, mkeType) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns the marker byte of a mode
+ */
+func Marker(mode string) (out byte, err error) {
+	switch mode {
+	case mteHandshake.ModeCore:
+		return MarkerCore, nil
+	case mteHandshake.ModeMke:
+		return MarkerMke, nil
+	case mteHandshake.ModeFlen:
+		return MarkerFlen, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownMarker, mode)
+}
+
+/**
+ * Returns the mode of a marker byte
+ */
+func Mode(marker byte) (out string, err error) {
+	switch marker {
+	case MarkerCore:
+		return mteHandshake.ModeCore, nil
+	case MarkerMke:
+		return mteHandshake.ModeMke, nil
+	case MarkerFlen:
+		return mteHandshake.ModeFlen, nil
+	}
+	return "", fmt.Errorf("%w: 0x%02x", ErrUnknownMarker, marker)
+}
+
+/**
+ * Puts the mode marker in front of an encoded message
+ *
+ * Returns the envelope
+ */
+func Wrap(mode string, encoded []byte) (out []byte, err error) {
+	marker, err := Marker(mode)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 0, 1+len(encoded))
+	envelope = append(envelope, marker)
+	return append(envelope, encoded...), nil
+}
+
+/**
+ * Splits an envelope into the mode and the encoded message
+ */
+func Unwrap(envelope []byte) (mode string, encoded []byte, err error) {
+	if len(envelope) < 2 {
+		return "", nil, ErrMalformedEnvelope
+	}
+	mode, err = Mode(envelope[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return mode, envelope[1:], nil
+}
+
+/**
+ * Personalization string of the Encoder and Decoder of a mode
+ * Core and MKE are instantiated from the same handshake secrets,
+ * a different personalization string per mode keeps their
+ * random streams apart
+ */
+func Personalization(clientId string, mode string) string {
+	return clientId + "/" + mode
+}
@@ -0,0 +1,135 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteMode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"mteToolkit/mteHandshake"
+)
+
+var (
+	legacyOptions = mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion}
+	coreOnly      = mteHandshake.MteOptions{Version: mteHandshake.ProtocolVersion, Modes: []string{mteHandshake.ModeCore}}
+	mkeOnly       = mteHandshake.MteOptions{Version: mteHandshake.ProtocolVersion, Modes: []string{mteHandshake.ModeMke}}
+	bothModes     = mteHandshake.MteOptions{Version: mteHandshake.ProtocolVersion,
+		Modes: []string{mteHandshake.ModeCore, mteHandshake.ModeMke, mteHandshake.ModeFlen}}
+	noModes = mteHandshake.MteOptions{Version: mteHandshake.ProtocolVersion, Modes: []string{mteHandshake.ModeFlen}}
+)
+
+func TestPick(t *testing.T) {
+	cases := []struct {
+		name        string
+		size        int64
+		contentType string
+		options     mteHandshake.MteOptions
+		want        string
+		err         error
+	}{
+		{name: "small message", size: DefaultThreshold - 1, options: bothModes, want: mteHandshake.ModeCore},
+		{name: "at the threshold", size: DefaultThreshold, options: bothModes, want: mteHandshake.ModeMke},
+		{name: "above the threshold", size: 1 << 20, options: bothModes, want: mteHandshake.ModeMke},
+		{name: "size not known yet", size: -1, options: bothModes, want: mteHandshake.ModeCore},
+		{name: "json", size: 10, contentType: "application/json", options: bothModes, want: mteHandshake.ModeCore},
+		{name: "binary content type", size: 10, contentType: "application/octet-stream",
+			options: bothModes, want: mteHandshake.ModeMke},
+		{name: "content type with parameters", size: 10, contentType: "multipart/form-data; boundary=x",
+			options: bothModes, want: mteHandshake.ModeMke},
+		{name: "content type case and spaces", size: 10, contentType: " Application/Octet-Stream ;q=1",
+			options: bothModes, want: mteHandshake.ModeMke},
+		{name: "legacy handshake", size: 1 << 20, options: legacyOptions, want: mteHandshake.ModeMke},
+		{name: "MKE not agreed falls back to Core", size: 1 << 20, options: coreOnly, want: mteHandshake.ModeCore},
+		{name: "Core not agreed falls back to MKE", size: 10, options: mkeOnly, want: mteHandshake.ModeMke},
+		{name: "neither agreed", size: 10, options: noModes, err: ErrModeNotAgreed},
+	}
+	for _, c := range cases {
+		got, err := DefaultPolicy.Pick(c.size, c.contentType, c.options)
+		if !errors.Is(err, c.err) || got != c.want {
+			t.Errorf("%s: got %q %v want %q %v", c.name, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestPickWithoutThreshold(t *testing.T) {
+	policy := Policy{MkeContentTypes: []string{"image/png"}}
+	if got, _ := policy.Pick(1<<30, "", bothModes); got != mteHandshake.ModeCore {
+		t.Errorf("large message without threshold: got %q", got)
+	}
+	if got, _ := policy.Pick(1, "image/png", bothModes); got != mteHandshake.ModeMke {
+		t.Errorf("MKE content type: got %q", got)
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	for _, mode := range []string{mteHandshake.ModeCore, mteHandshake.ModeMke, mteHandshake.ModeFlen} {
+		encoded := []byte("encoded " + mode)
+		envelope, err := Wrap(mode, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		marker, _ := Marker(mode)
+		if envelope[0] != marker {
+			t.Errorf("%s: marker 0x%02x want 0x%02x", mode, envelope[0], marker)
+		}
+		got, payload, err := Unwrap(envelope)
+		if err != nil || got != mode || !bytes.Equal(payload, encoded) {
+			t.Errorf("%s: got %q %q %v", mode, got, payload, err)
+		}
+	}
+	if _, err := Wrap("aes", []byte("x")); !errors.Is(err, ErrUnknownMarker) {
+		t.Errorf("unknown mode: got %v want %v", err, ErrUnknownMarker)
+	}
+}
+
+func TestUnwrapRejects(t *testing.T) {
+	cases := []struct {
+		name     string
+		envelope []byte
+		err      error
+	}{
+		{name: "empty", envelope: nil, err: ErrMalformedEnvelope},
+		{name: "marker only", envelope: []byte{MarkerCore}, err: ErrMalformedEnvelope},
+		{name: "no marker", envelope: []byte{0x00, 1, 2}, err: ErrUnknownMarker},
+		{name: "unknown marker", envelope: []byte{0x7f, 1, 2}, err: ErrUnknownMarker},
+	}
+	for _, c := range cases {
+		mode, encoded, err := Unwrap(c.envelope)
+		if !errors.Is(err, c.err) || mode != "" || encoded != nil {
+			t.Errorf("%s: got %q %q %v want %v", c.name, mode, encoded, err, c.err)
+		}
+	}
+}
+
+func TestPersonalizationPerMode(t *testing.T) {
+	core := Personalization("client", mteHandshake.ModeCore)
+	mke := Personalization("client", mteHandshake.ModeMke)
+	if core == mke || core == "client" {
+		t.Errorf("personalization strings %q and %q", core, mke)
+	}
+}
@@ -0,0 +1,362 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteMux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//-----------------------------------------
+	// Store key prefixes, followed by the
+	// client ID, a slash and the channel name.
+	// Channel names have no slash, so the key
+	// ends the client ID at the last slash
+	encPrefix = "enc_"
+	decPrefix = "dec_"
+	optPrefix = "opt_"
+
+	//---------------------------------------
+	// Longest channel name a frame can carry
+	MaxChannelName = 255
+)
+
+//------------------
+// Error messages
+var ErrUnknownChannel = errors.New("unknown channel")
+var ErrChannelName = errors.New("invalid channel name")
+var ErrMalformedFrame = errors.New("malformed frame")
+
+//----------------------------------------------------------
+// Returned when a frame could not be decoded
+// The channel state is left as it was, so the failed
+// frame never affects the channel or any other channel
+type DecodeError struct {
+	Channel string
+	Status  mte.Status
+}
+
+func (e *DecodeError) Error() string {
+	return "channel " + e.Channel + ": " + mteAdapter.NewStatusError("Decode", e.Status).Error()
+}
+
+//-----------------------------------------------------------------
+// Several named channels between the same client and server over
+// one handshake. Every channel has its own Encoder and Decoder,
+// instantiated from the handshake secrets with the personalization
+// string clientId/channel, so the server derives the same pairs.
+// Channel names can not hold a slash, otherwise client a/b with
+// channel c and client a with channel b/c would share the
+// personalization string and the store keys.
+// Each state is kept in the store under its own key, a lost frame
+// or a reseed on one channel never touches the others.
+type Mux struct {
+	clientId string
+	options  mteHandshake.MteOptions
+	store    mteStore.Store
+	channels map[string]*sync.Mutex
+}
+
+/**
+ * Creates the channels from the handshake secrets
+ * Every channel is instantiated right away, the secrets are
+ * not kept so channels can not be added later
+ *
+ * secrets: result of mteAdapter.Exchange
+ * channels: names of the channels, for example control and data
+ * store: where the channel states are kept, use a SealedStore
+ *
+ * Returns the Mux
+ */
+func New(secrets *mteSession.Secrets, channels []string, store mteStore.Store) (out *Mux, err error) {
+	err = checkChannels(channels)
+	if err != nil {
+		return nil, err
+	}
+	m := newMux(secrets.ClientId, secrets.Options, channels, store)
+	for _, channel := range channels {
+		err = m.instantiate(channel, secrets.Nonce, secrets.EncoderEntropy, secrets.DecoderEntropy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	optionBytes, err := json.Marshal(secrets.Options)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Set(optPrefix+secrets.ClientId, optionBytes)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+/**
+ * Opens channels created earlier by New, possibly in
+ * another process sharing the same store
+ */
+func Open(clientId string, channels []string, store mteStore.Store) (out *Mux, err error) {
+	err = checkChannels(channels)
+	if err != nil {
+		return nil, err
+	}
+	optionBytes, err := store.Get(optPrefix + clientId)
+	if err != nil {
+		return nil, fmt.Errorf("loading options: %w", err)
+	}
+	var options mteHandshake.MteOptions
+	err = json.Unmarshal(optionBytes, &options)
+	if err != nil {
+		return nil, fmt.Errorf("parsing options: %w", err)
+	}
+	return newMux(clientId, options, channels, store), nil
+}
+
+/**
+ * Checks the channel names fit a frame and hold no slash
+ */
+func checkChannels(channels []string) error {
+	for _, channel := range channels {
+		if len(channel) == 0 || len(channel) > MaxChannelName || strings.Contains(channel, "/") {
+			return fmt.Errorf("%w: %q", ErrChannelName, channel)
+		}
+	}
+	return nil
+}
+
+func newMux(clientId string, options mteHandshake.MteOptions, channels []string, store mteStore.Store) *Mux {
+	m := &Mux{clientId: clientId, options: options, store: store, channels: make(map[string]*sync.Mutex)}
+	for _, channel := range channels {
+		m.channels[channel] = &sync.Mutex{}
+	}
+	return m
+}
+
+/**
+ * Instantiates one channel again from the secrets of a new
+ * handshake, for example when it reached its reseed interval
+ * The other channels keep their state
+ */
+func (m *Mux) Reseed(channel string, secrets *mteSession.Secrets) error {
+	lock, err := m.lock(channel)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return m.instantiate(channel, secrets.Nonce, secrets.EncoderEntropy, secrets.DecoderEntropy)
+}
+
+/**
+ * Returns true when the channel Encoder or Decoder is
+ * close to its reseed interval and should be reseeded
+ *
+ * percent: share of the reseed interval, for example .9
+ */
+func (m *Mux) ReseedNeeded(channel string, percent float64) (out bool, err error) {
+	lock, err := m.lock(channel)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+	encoder, err := m.restoreEncoder(channel)
+	if err != nil {
+		return false, err
+	}
+	defer encoder.Destroy()
+	decoder, err := m.restoreDecoder(channel)
+	if err != nil {
+		return false, err
+	}
+	defer decoder.Destroy()
+	maxSeed := float64(mte.GetDrbgsReseedInterval(encoder.GetDrbg())) * percent
+	return float64(encoder.GetReseedCounter()) > maxSeed || float64(decoder.GetReseedCounter()) > maxSeed, nil
+}
+
+/**
+ * Encodes a message on a channel
+ *
+ * Returns the frame, tagged with the channel name
+ */
+func (m *Mux) Encode(channel string, message []byte) (out []byte, err error) {
+	lock, err := m.lock(channel)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	encoder, err := m.restoreEncoder(channel)
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Destroy()
+	encoded, status := encoder.Encode(message)
+	if status != mte.Status_mte_status_success {
+		return nil, mteAdapter.NewStatusError("Encode", status)
+	}
+	err = m.store.Set(m.key(encPrefix, channel), encoder.SaveState())
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 0, 1+len(channel)+len(encoded))
+	frame = append(frame, byte(len(channel)))
+	frame = append(frame, channel...)
+	return append(frame, encoded...), nil
+}
+
+/**
+ * Decodes a frame on the channel it is tagged with
+ *
+ * Returns the channel name and the message
+ */
+func (m *Mux) Decode(frame []byte) (channel string, message []byte, err error) {
+	channel, encoded, err := ParseFrame(frame)
+	if err != nil {
+		return "", nil, err
+	}
+	lock, err := m.lock(channel)
+	if err != nil {
+		return channel, nil, err
+	}
+	defer lock.Unlock()
+	decoder, err := m.restoreDecoder(channel)
+	if err != nil {
+		return channel, nil, err
+	}
+	defer decoder.Destroy()
+	decoded, status := decoder.Decode(encoded)
+	if mte.StatusIsError(status) {
+		return channel, nil, &DecodeError{Channel: channel, Status: status}
+	}
+	err = m.store.Set(m.key(decPrefix, channel), decoder.SaveState())
+	if err != nil {
+		return channel, nil, err
+	}
+	return channel, decoded, nil
+}
+
+/**
+ * Splits a frame into the channel name and the encoded message
+ */
+func ParseFrame(frame []byte) (channel string, encoded []byte, err error) {
+	if len(frame) < 1 || len(frame) < 1+int(frame[0]) {
+		return "", nil, ErrMalformedFrame
+	}
+	nameLength := int(frame[0])
+	return string(frame[1 : 1+nameLength]), frame[1+nameLength:], nil
+}
+
+/**
+ * Instantiates the Encoder and Decoder of a channel
+ * and saves their states, the secrets are copied as
+ * every channel is instantiated from the same ones
+ */
+func (m *Mux) instantiate(channel string, nonce uint64, encoderEntropy []byte, decoderEntropy []byte) error {
+	personal := m.clientId + "/" + channel
+
+	encoder := mteAdapter.NewCoreEncoder(m.options)
+	defer encoder.Destroy()
+	err := mteEntropy.Set(mteEntropy.NewEcdhProvider(append([]byte(nil), encoderEntropy...)),
+		mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder)
+	if err != nil {
+		return fmt.Errorf("Encoder entropy: %w", err)
+	}
+	encoder.SetNonceInt(nonce)
+	status := encoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		return mteAdapter.NewStatusError("Encoder instantiate", status)
+	}
+
+	decoder := mteAdapter.NewCoreDecoder(m.options)
+	defer decoder.Destroy()
+	err = mteEntropy.Set(mteEntropy.NewEcdhProvider(append([]byte(nil), decoderEntropy...)),
+		mte.GetDrbgsEntropyMinBytes(decoder.GetDrbg()), decoder)
+	if err != nil {
+		return fmt.Errorf("Decoder entropy: %w", err)
+	}
+	decoder.SetNonceInt(nonce)
+	status = decoder.InstantiateStr(personal)
+	if status != mte.Status_mte_status_success {
+		return mteAdapter.NewStatusError("Decoder instantiate", status)
+	}
+
+	err = m.store.Set(m.key(encPrefix, channel), encoder.SaveState())
+	if err != nil {
+		return err
+	}
+	return m.store.Set(m.key(decPrefix, channel), decoder.SaveState())
+}
+
+func (m *Mux) restoreEncoder(channel string) (out *mte.MteEnc, err error) {
+	state, err := m.store.Get(m.key(encPrefix, channel))
+	if err != nil {
+		return nil, fmt.Errorf("loading %s Encoder: %w", channel, err)
+	}
+	encoder := mteAdapter.NewCoreEncoder(m.options)
+	status := encoder.RestoreState(state)
+	if status != mte.Status_mte_status_success {
+		encoder.Destroy()
+		return nil, mteAdapter.NewStatusError("Encoder restore", status)
+	}
+	return encoder, nil
+}
+
+func (m *Mux) restoreDecoder(channel string) (out *mte.MteDec, err error) {
+	state, err := m.store.Get(m.key(decPrefix, channel))
+	if err != nil {
+		return nil, fmt.Errorf("loading %s Decoder: %w", channel, err)
+	}
+	decoder := mteAdapter.NewCoreDecoder(m.options)
+	status := decoder.RestoreState(state)
+	if status != mte.Status_mte_status_success {
+		decoder.Destroy()
+		return nil, mteAdapter.NewStatusError("Decoder restore", status)
+	}
+	return decoder, nil
+}
+
+func (m *Mux) lock(channel string) (out *sync.Mutex, err error) {
+	lock, ok := m.channels[channel]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, channel)
+	}
+	lock.Lock()
+	return lock, nil
+}
+
+func (m *Mux) key(prefix string, channel string) string {
+	return prefix + m.clientId + "/" + channel
+}
@@ -0,0 +1,223 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteMux
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mte"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+const testClientId = "mux-test-client"
+
+var testChannels = []string{"control", "data"}
+
+func TestChannelNames(t *testing.T) {
+	for _, channel := range []string{"", "b/c", "/", strings.Repeat("x", MaxChannelName+1)} {
+		_, err := New(&mteSession.Secrets{ClientId: "a"}, []string{"control", channel}, mteStore.NewMemoryStore())
+		if !errors.Is(err, ErrChannelName) {
+			t.Errorf("New %q: got %v want %v", channel, err, ErrChannelName)
+		}
+		_, err = Open("a", []string{channel}, mteStore.NewMemoryStore())
+		if !errors.Is(err, ErrChannelName) {
+			t.Errorf("Open %q: got %v want %v", channel, err, ErrChannelName)
+		}
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	for _, tc := range []struct {
+		frame   []byte
+		channel string
+		encoded []byte
+		err     error
+	}{
+		{frame: []byte("\x04datatoken"), channel: "data", encoded: []byte("token")},
+		{frame: []byte("\x04data"), channel: "data", encoded: []byte{}},
+		{frame: []byte("\x05data"), err: ErrMalformedFrame},
+		{frame: []byte{}, err: ErrMalformedFrame},
+	} {
+		channel, encoded, err := ParseFrame(tc.frame)
+		if err != tc.err || channel != tc.channel || !bytes.Equal(encoded, tc.encoded) {
+			t.Errorf("%q: got %q %q %v want %q %q %v", tc.frame, channel, encoded, err, tc.channel, tc.encoded, tc.err)
+		}
+	}
+}
+
+func TestChannelsAreIndependent(t *testing.T) {
+	client, server := newTestPair(t)
+
+	//-----------------------------------------------
+	// Interleave the channels, each keeps its order
+	var frames [][]byte
+	for _, channel := range []string{"control", "data", "data", "control"} {
+		frame, err := client.Encode(channel, []byte(channel+" message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	if bytes.Contains(frames[0], []byte("control message")) {
+		t.Fatal("frame holds the plaintext")
+	}
+
+	//------------------------------------------------
+	// A damaged data frame leaves both channels alone
+	damaged := append([]byte(nil), frames[1]...)
+	damaged[len(damaged)-1]++
+	_, _, err := server.Decode(damaged)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Channel != "data" {
+		t.Fatalf("damaged frame: got %v want a data DecodeError", err)
+	}
+	for i, frame := range frames {
+		channel, message, err := server.Decode(frame)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(message) != channel+" message" {
+			t.Errorf("frame %d: got %q on %s", i, message, channel)
+		}
+	}
+
+	_, err = client.Encode("heartbeat", []byte("ping"))
+	if !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("unknown channel: got %v want %v", err, ErrUnknownChannel)
+	}
+}
+
+func TestOpenSharesTheStore(t *testing.T) {
+	client, _ := newTestPair(t)
+	serverStore := mteStore.NewMemoryStore()
+	_, err := New(serverSecrets(), testChannels, serverStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		frame, err := client.Encode("data", []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//----------------------------------------------
+		// Every frame is decoded by a freshly opened Mux
+		server, err := Open(testClientId, testChannels, serverStore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, message, err := server.Decode(frame)
+		if err != nil || !bytes.Equal(message, []byte{byte(i)}) {
+			t.Fatalf("frame %d: got %v %v", i, message, err)
+		}
+	}
+	_, err = Open("other-client", testChannels, serverStore)
+	if !errors.Is(err, mteStore.ErrNotFound) {
+		t.Errorf("unknown client: got %v want %v", err, mteStore.ErrNotFound)
+	}
+}
+
+func TestReseedOneChannel(t *testing.T) {
+	client, server := newTestPair(t)
+	for _, channel := range testChannels {
+		frame, err := client.Encode(channel, []byte("before"))
+		if err == nil {
+			_, _, err = server.Decode(frame)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fresh := clientSecrets()
+	fresh.Nonce++
+	err := client.Reseed("data", fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := client.Encode("control", []byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := server.Decode(frame)
+	if err != nil || string(message) != "after" {
+		t.Errorf("control after reseeding data: got %q %v", message, err)
+	}
+	frame, err = client.Encode("data", []byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = server.Decode(frame)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Errorf("data reseeded on one side only: got %v want a DecodeError", err)
+	}
+}
+
+/**
+ * Creates a client and a server Mux from matching secrets
+ */
+func newTestPair(t *testing.T) (client *Mux, server *Mux) {
+	t.Helper()
+	mteTesting.RequireLicense(t)
+	client, err := New(clientSecrets(), testChannels, mteStore.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = New(serverSecrets(), testChannels, mteStore.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+//------------------------------------------------------
+// Fixed secrets, the server Decoder gets the entropy of
+// the client Encoder and the other way around
+func clientSecrets() *mteSession.Secrets {
+	return &mteSession.Secrets{
+		ClientId:       testClientId,
+		Nonce:          1234,
+		Options:        mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion},
+		EncoderEntropy: testEntropy(1),
+		DecoderEntropy: testEntropy(2),
+	}
+}
+
+func serverSecrets() *mteSession.Secrets {
+	secrets := clientSecrets()
+	secrets.EncoderEntropy, secrets.DecoderEntropy = secrets.DecoderEntropy, secrets.EncoderEntropy
+	return secrets
+}
+
+func testEntropy(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, mte.GetDrbgsEntropyMinBytes(mte.GetDefaultDrbg()))
+}
@@ -0,0 +1,217 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteProxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteSession"
+)
+
+const (
+	//----------------------------------------------
+	// Share of the reseed interval after which the
+	// forward proxy does a new handshake
+	DefaultReseedPercent = .9
+)
+
+//------------------------------------------------------------------
+// Forward proxy that lets applications without MTE talk to an MTE
+// server. It does the handshake with the server when it is created,
+// encodes the body of every local request with MTE Core, sends it
+// to the server with the x-client-id header and decodes the
+// ResponseModel the server answers with, so the application gets
+// the plaintext response back.
+// The server decodes the requests of a client in the order they
+// were encoded, so requests are sent one at a time.
+type ForwardProxy struct {
+	MaxBodySize   int64
+	ReseedPercent float64
+	serverUrl     *url.URL
+	clientId      string
+	capabilities  mteHandshake.Capabilities
+	client        *mteHttp.Client
+	lock          sync.Mutex
+	session       *mteSession.Session
+	outOfSync     bool
+	proxy         *httputil.ReverseProxy
+}
+
+/**
+ * Creates the forward proxy and performs the handshake
+ *
+ * ctx: cancels the handshake
+ * serverUrl: url of the MTE server, requests to the proxy
+ * are sent to the same path on this server
+ * clientId: client ID sent in the x-client-id header
+ * offer: MTE options this client supports
+ *
+ * Returns the ForwardProxy
+ */
+func NewForwardProxy(ctx context.Context,
+	serverUrl *url.URL,
+	clientId string,
+	offer mteHandshake.Capabilities) (out *ForwardProxy, err error) {
+	p := &ForwardProxy{
+		MaxBodySize:   DefaultMaxBodySize,
+		ReseedPercent: DefaultReseedPercent,
+		serverUrl:     serverUrl,
+		clientId:      clientId,
+		capabilities:  offer,
+		client:        mteHttp.NewClient(),
+	}
+	err = p.Handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.proxy = httputil.NewSingleHostReverseProxy(serverUrl)
+	director := p.proxy.Director
+	p.proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = serverUrl.Host
+		r.Header.Del("Accept-Encoding")
+		r.Header.Set(mteHttp.ClientIdHeader, clientId)
+	}
+	p.proxy.ModifyResponse = p.decodeResponse
+	p.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Proxy error for %s: %v", r.URL.Path, err)
+		p.outOfSync = true
+		http.Error(w, "MTE server request failed", http.StatusBadGateway)
+	}
+	return p, nil
+}
+
+/**
+ * Performs a new handshake with the server
+ * Replaces the Encoder and Decoder states
+ */
+func (p *ForwardProxy) Handshake(ctx context.Context) error {
+	session, err := mteAdapter.PerformHandshake(ctx, p.client,
+		p.serverUrl.String(), p.clientId, p.capabilities)
+	if err != nil {
+		return err
+	}
+	p.session = session
+	p.outOfSync = false
+	return nil
+}
+
+func (p *ForwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	body, err := readBody(r.Body, p.MaxBodySize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(body) > 0 {
+		body, err = p.encode(body)
+		if err != nil {
+			log.Printf("Encode error: %v", err)
+			http.Error(w, "request could not be encoded", http.StatusInternalServerError)
+			return
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	p.proxy.ServeHTTP(w, r)
+
+	//------------------------------------------------
+	// Check if we have reached reseed max or the
+	// server may not hold the same states anymore,
+	// the next request uses a new handshake
+	if p.outOfSync || p.reseedNeeded() {
+		err = p.Handshake(r.Context())
+		if err != nil {
+			log.Printf("Handshake error: %v", err)
+		}
+	}
+}
+
+/**
+ * Encodes a request body with the Encoder of the session
+ */
+func (p *ForwardProxy) encode(body []byte) (out []byte, err error) {
+	encoded, err := p.session.EncodeB64(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encoded), nil
+}
+
+/**
+ * Decodes the ResponseModel the server answered with
+ * Responses without encoded data, such as the errors the
+ * server sends before decoding, are passed on as they are
+ * and an error status marks the states out of sync
+ */
+func (p *ForwardProxy) decodeResponse(resp *http.Response) error {
+	body, err := readBody(resp.Body, p.MaxBodySize)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var model mteHttp.ResponseModel[string]
+	if json.Unmarshal(body, &model) == nil && model.Data != "" {
+		decoded, err := p.session.DecodeB64(model.Data)
+		if err != nil {
+			return err
+		}
+		body = []byte(decoded)
+		resp.Header.Set("Content-Type", http.DetectContentType(body))
+	} else if resp.StatusCode >= http.StatusBadRequest {
+		p.outOfSync = true
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del("Content-Encoding")
+	return nil
+}
+
+/**
+ * Reports whether the Encoder or Decoder passed
+ * ReseedPercent of the reseed interval
+ */
+func (p *ForwardProxy) reseedNeeded() bool {
+	needed, err := p.session.ReseedNeeded(p.ReseedPercent)
+	return err == nil && needed
+}
@@ -0,0 +1,176 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteProxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteStore"
+)
+
+const testClientId = "proxy-test-client"
+
+//-------------------------------------------------------------
+// Forward proxy, reverse proxy and an upstream echo service
+// chained the way they are deployed. Only the connections
+// between the two proxies carry MTE, they are recorded.
+type testChain struct {
+	forward   *ForwardProxy
+	reverse   *ReverseProxy
+	local     *httptest.Server
+	server    *httptest.Server
+	upstreamN int32
+	wire      mteTesting.Wire
+}
+
+func newTestChain(t *testing.T) *testChain {
+	mteTesting.RequireLicense(t)
+	c := &testChain{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&c.upstreamN, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte("echo: "), body...))
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamUrl, _ := url.Parse(upstream.URL)
+
+	c.reverse = NewReverseProxy(upstreamUrl, mteStore.NewMemoryStore(), mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+	c.server = httptest.NewUnstartedServer(c.reverse)
+	c.server.Listener = c.wire.Listener(c.server.Listener)
+	c.server.Start()
+	t.Cleanup(c.server.Close)
+	serverUrl, _ := url.Parse(c.server.URL)
+
+	var err error
+	c.forward, err = NewForwardProxy(context.Background(), serverUrl, testClientId,
+		mteAdapter.DefaultCapabilities(mteHandshake.ModeCore))
+	if err != nil {
+		t.Fatalf("forward proxy: %v", err)
+	}
+	c.local = httptest.NewServer(c.forward)
+	t.Cleanup(c.local.Close)
+	return c
+}
+
+/**
+ * Sends a request to target and returns the status and body
+ */
+func send(t *testing.T, method string, target string, clientId string, body string) (status int, out string) {
+	t.Helper()
+	request, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientId != "" {
+		request.Header.Set(mteHttp.ClientIdHeader, clientId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(responseBytes)
+}
+
+func TestPlaintextNeverOnTheWire(t *testing.T) {
+	c := newTestChain(t)
+	secrets := []string{"first secret request", "second secret request"}
+	for _, secret := range secrets {
+		status, reply := send(t, http.MethodPost, c.local.URL+"/api/echo", "", secret)
+		if status != http.StatusOK || reply != "echo: "+secret {
+			t.Fatalf("got %d %q, want %d %q", status, reply, http.StatusOK, "echo: "+secret)
+		}
+	}
+	status, reply := send(t, http.MethodGet, c.local.URL+"/api/echo", "", "")
+	if status != http.StatusOK || reply != "echo: " {
+		t.Fatalf("empty body: got %d %q", status, reply)
+	}
+	c.wire.CheckEncoded(t, []byte(secrets[0]), []byte(secrets[1]), []byte("echo: "+secrets[0]), []byte("echo: "+secrets[1]))
+}
+
+func TestUnknownClient(t *testing.T) {
+	c := newTestChain(t)
+	for _, body := range []string{"", "not encoded"} {
+		status, _ := send(t, http.MethodPost, c.server.URL+"/api/echo", "never-did-a-handshake", body)
+		if status != http.StatusUnauthorized {
+			t.Errorf("body %q: got %d, want %d", body, status, http.StatusUnauthorized)
+		}
+	}
+	status, _ := send(t, http.MethodGet, c.server.URL+"/api/echo", "", "")
+	if status != http.StatusBadRequest {
+		t.Errorf("no client ID: got %d, want %d", status, http.StatusBadRequest)
+	}
+	if n := atomic.LoadInt32(&c.upstreamN); n != 0 {
+		t.Errorf("upstream got %d requests, want none", n)
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	c := newTestChain(t)
+	c.reverse.MaxBodySize = 256
+	large := string(bytes.Repeat([]byte("x"), 512))
+
+	//------------------------------------------------
+	// Too large for the forward proxy, never encoded
+	c.forward.MaxBodySize = 256
+	status, _ := send(t, http.MethodPost, c.local.URL+"/api/echo", "", large)
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("forward proxy: got %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+
+	//--------------------------------------------------
+	// Too large for the reverse proxy once encoded, the
+	// forward proxy does a new handshake afterwards
+	c.forward.MaxBodySize = DefaultMaxBodySize
+	status, _ = send(t, http.MethodPost, c.local.URL+"/api/echo", "", large)
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("reverse proxy: got %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+	if n := atomic.LoadInt32(&c.upstreamN); n != 0 {
+		t.Errorf("upstream got %d requests, want none", n)
+	}
+	status, reply := send(t, http.MethodPost, c.local.URL+"/api/echo", "", "small")
+	if status != http.StatusOK || reply != "echo: small" {
+		t.Errorf("after oversized body: got %d %q", status, reply)
+	}
+}
@@ -0,0 +1,304 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteProxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteClients"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteHttp"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+)
+
+const (
+	//----------------------------------------------
+	// Largest request or response body the proxy
+	// reads, unless MaxBodySize is set
+	DefaultMaxBodySize = 16 << 20
+)
+
+//------------------
+// Error messages
+var ErrBodyTooLarge = errors.New("body is larger than the maximum body size")
+var ErrUnknownClient = mteClients.ErrUnknownClient
+
+//-------------------------------------------------
+// Context key of the client ID of a proxied request
+type clientIdKey struct{}
+
+//----------------------------------------------------------------
+// Reverse proxy that terminates MTE in front of a service that
+// does not know about it. It answers the handshake route like the
+// Echo API, decodes the base64 MTE Core body of every request with
+// the Decoder of the x-client-id client, forwards the plaintext to
+// the upstream service and encodes the upstream response back into
+// a ResponseModel with the Encoder of the same client.
+// Requests of one client must arrive in the order they were encoded.
+type ReverseProxy struct {
+	HandshakeRoute string
+	MaxBodySize    int64
+	capabilities   mteHandshake.Capabilities
+	clients        *mteClients.Clients
+	proxy          *httputil.ReverseProxy
+}
+
+/**
+ * Creates the reverse proxy
+ *
+ * upstream: url of the service the plaintext requests go to
+ * store: where the client sessions are kept, use a SealedStore
+ * server: MTE options the proxy supports
+ *
+ * Returns the ReverseProxy
+ */
+func NewReverseProxy(upstream *url.URL, store mteStore.Store, server mteHandshake.Capabilities) *ReverseProxy {
+	p := &ReverseProxy{
+		HandshakeRoute: mteSession.HandshakeRoute,
+		MaxBodySize:    DefaultMaxBodySize,
+		capabilities:   server,
+		clients:        mteClients.New(store, mteAdapter.MteCoders),
+	}
+	p.proxy = httputil.NewSingleHostReverseProxy(upstream)
+	director := p.proxy.Director
+	p.proxy.Director = func(r *http.Request) {
+		director(r)
+		//-----------------------------------------------
+		// The response body is encoded as is, so do not
+		// let the upstream compress it
+		r.Header.Del("Accept-Encoding")
+	}
+	p.proxy.ModifyResponse = p.encodeResponse
+	p.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Proxy error for %s: %v", r.URL.Path, err)
+		writeResponse(w, http.StatusBadGateway, mteHttp.ResponseModel[string]{
+			Message:    "upstream request failed",
+			ResultCode: strconv.Itoa(http.StatusBadGateway),
+		})
+	}
+	return p
+}
+
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == p.HandshakeRoute && r.Method == http.MethodPost {
+		p.handshake(w, r)
+		return
+	}
+	clientId := r.Header.Get(mteHttp.ClientIdHeader)
+	if clientId == "" {
+		writeError(w, http.StatusBadRequest, "missing "+mteHttp.ClientIdHeader+" header")
+		return
+	}
+	//------------------------------------------------
+	// Only clients that did the handshake get through,
+	// also when there is no body to decode
+	exists, err := p.clients.Exists(clientId)
+	if err != nil {
+		log.Printf("Session error for client %s: %v", clientId, err)
+		writeError(w, http.StatusInternalServerError, "session could not be loaded")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("%v: %s", ErrUnknownClient, clientId))
+		return
+	}
+
+	//-----------------------------------------
+	// Decode the request body, an empty body
+	// such as a GET is forwarded as it is
+	body, err := readBody(r.Body, p.MaxBodySize)
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		body, err = p.decode(clientId, body)
+		if errors.Is(err, ErrUnknownClient) {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("Decode error for client %s: %v", clientId, err)
+			writeError(w, http.StatusBadRequest, "request could not be decoded")
+			return
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	r = r.WithContext(context.WithValue(r.Context(), clientIdKey{}, clientId))
+	p.proxy.ServeHTTP(w, r)
+}
+
+/**
+ * Answers the handshake and keeps the new client session
+ */
+func (p *ReverseProxy) handshake(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r.Body, p.MaxBodySize)
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	var request mteHandshake.HandshakeRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil || request.ConversationIdentifier == "" {
+		writeError(w, http.StatusBadRequest, "invalid handshake request")
+		return
+	}
+	clientId := r.Header.Get(mteHttp.ClientIdHeader)
+	if clientId != "" && clientId != request.ConversationIdentifier {
+		writeError(w, http.StatusBadRequest, mteHttp.ClientIdHeader+" does not match the conversation identifier")
+		return
+	}
+	response, secrets, err := mteAdapter.Respond(request, p.capabilities)
+	if err != nil {
+		log.Printf("Handshake error for client %s: %v", request.ConversationIdentifier, err)
+		writeError(w, http.StatusBadRequest, "handshake failed")
+		return
+	}
+	session, err := mteAdapter.NewSession("", secrets.ClientId, secrets.Nonce, secrets.Options,
+		mteEntropy.NewEcdhProvider(secrets.EncoderEntropy), mteEntropy.NewEcdhProvider(secrets.DecoderEntropy))
+	if err == nil {
+		err = p.clients.Set(session)
+	}
+	if err != nil {
+		log.Printf("Handshake error for client %s: %v", secrets.ClientId, err)
+		writeError(w, http.StatusInternalServerError, "handshake failed")
+		return
+	}
+	writeResponse(w, http.StatusOK, mteHttp.ResponseModel[mteHandshake.HandshakeResponse]{
+		Message:    "Success",
+		Success:    true,
+		ResultCode: strconv.Itoa(http.StatusOK),
+		Data:       response,
+	})
+}
+
+/**
+ * Decodes a base64 MTE Core request body
+ */
+func (p *ReverseProxy) decode(clientId string, body []byte) (out []byte, err error) {
+	err = p.clients.Update(clientId, func(session *mteSession.Session) error {
+		decoded, err := session.DecodeB64(string(bytes.TrimSpace(body)))
+		if err != nil {
+			return err
+		}
+		out = []byte(decoded)
+		return nil
+	})
+	return out, err
+}
+
+/**
+ * Encodes the upstream response into a ResponseModel
+ * The status code of the upstream is kept, Success is
+ * false for 4xx and 5xx answers
+ */
+func (p *ReverseProxy) encodeResponse(resp *http.Response) error {
+	clientId, _ := resp.Request.Context().Value(clientIdKey{}).(string)
+	body, err := readBody(resp.Body, p.MaxBodySize)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var encoded string
+	err = p.clients.Update(clientId, func(session *mteSession.Session) error {
+		var err error
+		encoded, err = session.EncodeB64(string(body))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	modelBytes, err := json.Marshal(mteHttp.ResponseModel[string]{
+		Message:    http.StatusText(resp.StatusCode),
+		Success:    resp.StatusCode < http.StatusBadRequest,
+		ResultCode: strconv.Itoa(resp.StatusCode),
+		Data:       encoded,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(modelBytes))
+	resp.ContentLength = int64(len(modelBytes))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(modelBytes)))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Encoding")
+	return nil
+}
+
+/**
+ * Reads a body up to maxSize, DefaultMaxBodySize when not set
+ */
+func readBody(body io.Reader, maxSize int64) (out []byte, err error) {
+	if body == nil {
+		return nil, nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	out, err = ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	return out, nil
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeResponse(w, statusCode, mteHttp.ResponseModel[string]{
+		Message:    message,
+		ResultCode: strconv.Itoa(statusCode),
+	})
+}
+
+func writeResponse[T any](w http.ResponseWriter, statusCode int, model mteHttp.ResponseModel[T]) {
+	modelBytes, err := json.Marshal(model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(modelBytes)
+}
@@ -0,0 +1,245 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSequencing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	//-------------------------------------------------
+	// Largest packet a UDP link reads, and how often a
+	// blocked read wakes up to check for cancellation
+	MaxPacketSize = 65507
+	pollInterval  = 250 * time.Millisecond
+)
+
+//------------------
+// Error messages
+var ErrLinkClosed = errors.New("link closed")
+
+//--------------------------------------------------------
+// Carries packets between the Sender and the Receiver
+// Packets may be lost, duplicated or delivered out of
+// order, the Receiver reports what the Decoder made of it
+type Link interface {
+	Send(ctx context.Context, packet []byte) error
+	Receive(ctx context.Context) ([]byte, error)
+	Close() error
+}
+
+//-------------------------------------------------
+// Link over UDP, the listening side answers to
+// whoever sent it the last packet. Send and Receive
+// can be called from different goroutines
+type UDPLink struct {
+	conn       *net.UDPConn
+	remoteLock sync.Mutex
+	remote     *net.UDPAddr
+}
+
+/**
+ * Listens for packets on address, for example ":5000"
+ */
+func ListenUDP(address string) (out *UDPLink, err error) {
+	localAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPLink{conn: conn}, nil
+}
+
+/**
+ * Sends packets to address, for example "localhost:5000"
+ */
+func DialUDP(address string) (out *UDPLink, err error) {
+	remoteAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPLink{conn: conn}, nil
+}
+
+/**
+ * Returns the local address of the link
+ */
+func (l *UDPLink) LocalAddr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+/**
+ * Sends one packet
+ */
+func (l *UDPLink) Send(ctx context.Context, packet []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.remoteLock.Lock()
+	remote := l.remote
+	l.remoteLock.Unlock()
+	if remote != nil {
+		_, err := l.conn.WriteToUDP(packet, remote)
+		return err
+	}
+	_, err := l.conn.Write(packet)
+	return err
+}
+
+/**
+ * Waits for the next packet or until ctx is done
+ */
+func (l *UDPLink) Receive(ctx context.Context) (out []byte, err error) {
+	buf := make([]byte, MaxPacketSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err = l.conn.SetReadDeadline(time.Now().Add(pollInterval))
+		if err != nil {
+			return nil, err
+		}
+		n, remote, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil, ErrLinkClosed
+			}
+			return nil, err
+		}
+		if l.conn.RemoteAddr() == nil {
+			l.remoteLock.Lock()
+			l.remote = remote
+			l.remoteLock.Unlock()
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+/**
+ * Closes the socket
+ */
+func (l *UDPLink) Close() error {
+	return l.conn.Close()
+}
+
+//------------------------------------------------
+// The part of a message queue client we need,
+// wrap the client of your broker to implement it
+type Queue interface {
+	Publish(ctx context.Context, message []byte) error
+	Consume(ctx context.Context) ([]byte, error)
+}
+
+//-----------------------------------
+// Link over a message queue adapter
+type QueueLink struct {
+	Queue Queue
+}
+
+/**
+ * Publishes one packet
+ */
+func (l *QueueLink) Send(ctx context.Context, packet []byte) error {
+	return l.Queue.Publish(ctx, packet)
+}
+
+/**
+ * Consumes the next packet
+ */
+func (l *QueueLink) Receive(ctx context.Context) (out []byte, err error) {
+	return l.Queue.Consume(ctx)
+}
+
+/**
+ * Closes the queue when it can be closed
+ */
+func (l *QueueLink) Close() error {
+	if closer, ok := l.Queue.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//------------------------------------------------
+// Queue held in memory, for demos and tests
+type MemoryQueue struct {
+	messages chan []byte
+	done     chan struct{}
+}
+
+/**
+ * Creates a MemoryQueue holding up to size messages
+ */
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{messages: make(chan []byte, size), done: make(chan struct{})}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, message []byte) error {
+	select {
+	case q.messages <- append([]byte(nil), message...):
+		return nil
+	case <-q.done:
+		return ErrLinkClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *MemoryQueue) Consume(ctx context.Context) (out []byte, err error) {
+	select {
+	case message := <-q.messages:
+		return message, nil
+	case <-q.done:
+		return nil, ErrLinkClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *MemoryQueue) Close() error {
+	select {
+	case <-q.done:
+	default:
+		close(q.done)
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSequencing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"mteToolkit/mte"
+)
+
+const (
+	//-------------------------------------------
+	// Default reorder policy
+	DefaultReorderTimeout = 500 * time.Millisecond
+	DefaultMaxPending     = 64
+
+	//---------------------------------------------
+	// Run checks the timeout at least this rarely,
+	// however short the timeout is
+	minFlushInterval = time.Millisecond
+)
+
+//------------------
+// Error messages
+var ErrDuplicatePacket = errors.New("duplicate packet")
+var ErrPacketMissing = errors.New("packet never arrived")
+var ErrSequenceExhausted = errors.New("sequence number exhausted")
+
+//-----------------------------------------------------------------
+// When the ReorderBuffer gives up waiting for a missing packet
+// Timeout is how long it waits once a later packet has arrived
+// MaxPending is how many later packets it holds before it stops
+// waiting, whichever comes first
+// DropLate drops packets that arrive after they were given up on,
+// otherwise they are decoded and released out of order with Late set
+type ReorderPolicy struct {
+	Timeout    time.Duration
+	MaxPending int
+	DropLate   bool
+}
+
+/**
+ * Returns the default reorder policy
+ */
+func DefaultReorderPolicy() ReorderPolicy {
+	return ReorderPolicy{Timeout: DefaultReorderTimeout, MaxPending: DefaultMaxPending}
+}
+
+//-------------------------------------------------------
+// Decodes one packet, Receiver and CoderReceiver do this
+type PacketDecoder interface {
+	Decode(packet []byte) Event
+}
+
+//---------------------------------------------------------------
+// Holds packets that arrive out of order and decodes them in
+// sequence order, so OnEvent sees the messages in the order they
+// were sent. Because the Decoder sees the packets in order, its
+// window only has to cover the packets that are skipped, not
+// every reordering on the link.
+// The sequence number in front of the packet is not protected,
+// so the buffer only moves past a sequence number once a packet
+// for it decoded. A packet with a forged sequence number fails
+// to decode and is dropped without moving the buffer, the real
+// packet for that position is still accepted.
+// Add and Flush are not safe for concurrent use, Run calls
+// both from one goroutine.
+type ReorderBuffer struct {
+	receiver PacketDecoder
+	policy   ReorderPolicy
+	next     uint64
+	pending  map[uint64][][]byte
+	count    int
+	gapSince time.Time
+	OnEvent  func(Event)
+	Now      func() time.Time
+}
+
+/**
+ * Creates a ReorderBuffer in front of decoder
+ * Use an async Decoder created with NewDecoder, its sequence
+ * window must be at least the number of packets that may be
+ * skipped in a row.
+ *
+ * decoder: instantiated Decoder
+ * policy: when to stop waiting for a missing packet
+ * onEvent: called for every message, in sequence order
+ */
+func NewReorderBuffer(decoder *mte.MteDec, policy ReorderPolicy, onEvent func(Event)) *ReorderBuffer {
+	return NewReorderBufferWith(NewReceiver(decoder, nil, nil), policy, onEvent)
+}
+
+/**
+ * Creates a ReorderBuffer in front of any PacketDecoder,
+ * for example a CoderReceiver
+ */
+func NewReorderBufferWith(receiver PacketDecoder, policy ReorderPolicy, onEvent func(Event)) *ReorderBuffer {
+	if policy.Timeout <= 0 {
+		policy.Timeout = DefaultReorderTimeout
+	}
+	if policy.MaxPending <= 0 {
+		policy.MaxPending = DefaultMaxPending
+	}
+	return &ReorderBuffer{
+		receiver: receiver,
+		policy:   policy,
+		pending:  make(map[uint64][][]byte),
+		OnEvent:  onEvent,
+		Now:      time.Now,
+	}
+}
+
+/**
+ * Sets the sequence number of the next packet to release
+ * The Sender starts at 0, use this when joining a link later
+ */
+func (b *ReorderBuffer) SetNext(sequence uint64) {
+	b.next = sequence
+	for pendingSequence, packets := range b.pending {
+		if pendingSequence < sequence {
+			b.count -= len(packets)
+			delete(b.pending, pendingSequence)
+		}
+	}
+	b.release()
+}
+
+/**
+ * Adds an arrived packet and releases every message that
+ * is now in order
+ */
+func (b *ReorderBuffer) Add(packet []byte) {
+	if len(packet) < headerSize {
+		b.emit(Event{Kind: EventMalformed, Err: ErrPacketTooShort})
+		return
+	}
+	sequence := binary.BigEndian.Uint64(packet)
+	if sequence == math.MaxUint64 {
+		//-----------------------------------------------
+		// The Sender never gets here, the Decoder needs
+		// a reseed long before, and next would wrap
+		b.emit(Event{Kind: EventMalformed, Sequence: sequence, Err: ErrSequenceExhausted})
+		return
+	}
+	if sequence < b.next {
+		//------------------------------------------
+		// Already released or given up on, a late
+		// packet or a replay the Decoder will spot
+		if b.policy.DropLate {
+			return
+		}
+		event := b.receiver.Decode(packet)
+		event.Late = true
+		b.emit(event)
+		return
+	}
+	//----------------------------------------------
+	// Keep every packet that claims this position,
+	// one of them may carry a forged sequence number
+	b.pending[sequence] = append(b.pending[sequence], append([]byte(nil), packet...))
+	b.count++
+	b.release()
+	if b.count > b.policy.MaxPending {
+		b.skipGap()
+	}
+}
+
+/**
+ * Stops waiting for a missing packet once the timeout passed
+ * Call it regularly, Run does this for you
+ */
+func (b *ReorderBuffer) Flush() {
+	if b.count > 0 && b.Now().Sub(b.gapSince) >= b.policy.Timeout {
+		b.skipGap()
+	}
+}
+
+/**
+ * Receives packets from link and releases them in order
+ * until ctx is done or the link fails
+ */
+func (b *ReorderBuffer) Run(ctx context.Context, link Link) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	packets := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			packet, err := link.Receive(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case packets <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	interval := b.policy.Timeout / 2
+	if interval < minFlushInterval {
+		interval = minFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case packet := <-packets:
+			b.Add(packet)
+		case <-ticker.C:
+			b.Flush()
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+/**
+ * Decodes and releases the pending packets that are in order
+ */
+func (b *ReorderBuffer) release() {
+	for b.count > 0 {
+		event, duplicates, ok := b.decodePending(b.next)
+		if !ok {
+			break
+		}
+		b.next++
+		b.emitDecoded(event, duplicates)
+	}
+	//-------------------------------------------
+	// Start the timeout when a gap shows up
+	if b.count == 0 {
+		b.gapSince = time.Time{}
+	} else if b.gapSince.IsZero() {
+		b.gapSince = b.Now()
+	}
+}
+
+/**
+ * Gives up on the missing packets before the oldest pending
+ * packet that decodes and releases from there. Pending packets
+ * that do not decode are dropped on the way, they may carry a
+ * forged sequence number and must not move the buffer forward.
+ */
+func (b *ReorderBuffer) skipGap() {
+	sequences := make([]uint64, 0, len(b.pending))
+	for sequence := range b.pending {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	for _, sequence := range sequences {
+		event, duplicates, ok := b.decodePending(sequence)
+		if !ok {
+			continue
+		}
+		//----------------------------------------------
+		// Report a large gap once, the sequence number
+		// may be forged to make us loop for a long time
+		if sequence-b.next > uint64(b.policy.MaxPending) {
+			b.emit(Event{Kind: EventSkipped, Sequence: b.next,
+				Err: fmt.Errorf("%w: %d packets", ErrPacketMissing, sequence-b.next)})
+			b.next = sequence
+		}
+		for ; b.next < sequence; b.next++ {
+			b.emit(Event{Kind: EventSkipped, Sequence: b.next, Err: ErrPacketMissing})
+		}
+		b.next++
+		b.emitDecoded(event, duplicates)
+		break
+	}
+	b.gapSince = time.Time{}
+	b.release()
+}
+
+/**
+ * Decodes the packets held for sequence until one decodes
+ * Every packet that does not decode is reported and dropped,
+ * the ones left after a packet decoded are duplicates
+ *
+ * Returns the event of the packet that decoded, the number
+ * of duplicates and true
+ */
+func (b *ReorderBuffer) decodePending(sequence uint64) (out Event, duplicates int, ok bool) {
+	packets := b.pending[sequence]
+	if len(packets) == 0 {
+		return out, 0, false
+	}
+	delete(b.pending, sequence)
+	b.count -= len(packets)
+	for i, packet := range packets {
+		event := b.receiver.Decode(packet)
+		if event.Kind == EventDecoded {
+			return event, len(packets) - i - 1, true
+		}
+		b.emit(event)
+	}
+	return out, 0, false
+}
+
+/**
+ * Releases a decoded message followed by its duplicates
+ */
+func (b *ReorderBuffer) emitDecoded(event Event, duplicates int) {
+	b.emit(event)
+	for i := 0; i < duplicates; i++ {
+		b.emit(Event{Kind: EventReplay, Sequence: event.Sequence, Err: ErrDuplicatePacket})
+	}
+}
+
+func (b *ReorderBuffer) emit(event Event) {
+	if b.OnEvent != nil {
+		b.OnEvent(event)
+	}
+}
@@ -0,0 +1,268 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSequencing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"mteToolkit/mteCoder"
+)
+
+//----------------------------------------------
+// Both receivers can sit behind a ReorderBuffer
+var _ PacketDecoder = (*Receiver)(nil)
+var _ PacketDecoder = (*CoderReceiver)(nil)
+
+const testTimeout = time.Second
+
+//----------------------------------------------------
+// One step of a reorder test
+// wait moves the clock forward and flushes the buffer,
+// otherwise message msg is added, or a packet that is
+// not an encoded message when forged is set
+type reorderStep struct {
+	msg      int
+	forged   bool
+	sequence uint64
+	wait     time.Duration
+}
+
+func add(msg int) reorderStep {
+	return reorderStep{msg: msg}
+}
+
+func forge(sequence uint64) reorderStep {
+	return reorderStep{forged: true, sequence: sequence}
+}
+
+func wait(d time.Duration) reorderStep {
+	return reorderStep{wait: d}
+}
+
+var reorderCases = []struct {
+	name   string
+	policy ReorderPolicy
+	steps  []reorderStep
+	events []string
+}{
+	{
+		name:   "gap filled in time",
+		steps:  []reorderStep{add(0), add(2), add(1), add(3)},
+		events: []string{"0 decoded message 0", "1 decoded message 1", "2 decoded message 2", "3 decoded message 3"},
+	},
+	{
+		name:  "timeout skips the gap",
+		steps: []reorderStep{add(0), add(2), wait(testTimeout / 2), wait(testTimeout / 2), add(1), add(3)},
+		events: []string{"0 decoded message 0", "1 skipped", "2 decoded message 2",
+			"1 decoded late message 1", "3 decoded message 3"},
+	},
+	{
+		name:   "max pending skips the gap",
+		policy: ReorderPolicy{MaxPending: 2},
+		steps:  []reorderStep{add(0), add(2), add(3), add(4)},
+		events: []string{"0 decoded message 0", "1 skipped", "2 decoded message 2", "3 decoded message 3", "4 decoded message 4"},
+	},
+	{
+		name:   "forged high sequence does not move the buffer",
+		steps:  []reorderStep{add(0), forge(1000), wait(testTimeout), add(1), add(2)},
+		events: []string{"0 decoded message 0", "1000 error", "1 decoded message 1", "2 decoded message 2"},
+	},
+	{
+		name:   "forged packets filling the buffer are dropped",
+		policy: ReorderPolicy{MaxPending: 2},
+		steps:  []reorderStep{add(0), forge(5), forge(6), forge(7), add(1)},
+		events: []string{"0 decoded message 0", "5 error", "6 error", "7 error", "1 decoded message 1"},
+	},
+	{
+		name:   "forged packet ahead of the real one",
+		steps:  []reorderStep{add(0), add(2), forge(1), add(1)},
+		events: []string{"0 decoded message 0", "1 error", "1 decoded message 1", "2 decoded message 2"},
+	},
+	{
+		name:   "last sequence number is rejected",
+		steps:  []reorderStep{add(0), forge(math.MaxUint64), add(1)},
+		events: []string{"0 decoded message 0", "18446744073709551615 malformed", "1 decoded message 1"},
+	},
+	{
+		name:   "late duplicate",
+		steps:  []reorderStep{add(0), add(1), add(1), add(0)},
+		events: []string{"0 decoded message 0", "1 decoded message 1", "1 replay late", "0 replay late"},
+	},
+	{
+		name:   "late duplicate dropped",
+		policy: ReorderPolicy{DropLate: true},
+		steps:  []reorderStep{add(0), add(1), add(1)},
+		events: []string{"0 decoded message 0", "1 decoded message 1"},
+	},
+	{
+		name:   "duplicate while pending",
+		steps:  []reorderStep{add(0), add(2), add(2), add(1)},
+		events: []string{"0 decoded message 0", "1 decoded message 1", "2 decoded message 2", "2 replay"},
+	},
+}
+
+func TestReorderBuffer(t *testing.T) {
+	for _, tc := range reorderCases {
+		t.Run(tc.name, func(t *testing.T) {
+			packets := encodeFakePackets(t, 8)
+			decoder := mteCoder.NewFakeDecoder(-4)
+			instantiateFake(t, decoder)
+
+			var events []string
+			policy := tc.policy
+			policy.Timeout = testTimeout
+			buffer := NewReorderBufferWith(NewCoderReceiver(decoder), policy, func(event Event) {
+				events = append(events, describeEvent(event))
+			})
+			now := time.Unix(0, 0)
+			buffer.Now = func() time.Time { return now }
+
+			for _, step := range tc.steps {
+				switch {
+				case step.wait > 0:
+					now = now.Add(step.wait)
+					buffer.Flush()
+				case step.forged:
+					packet := make([]byte, headerSize, headerSize+6)
+					binary.BigEndian.PutUint64(packet, step.sequence)
+					buffer.Add(append(packet, "forged"...))
+				default:
+					buffer.Add(packets[step.msg])
+				}
+			}
+			if !reflect.DeepEqual(events, tc.events) {
+				t.Errorf("events:\n got %q\nwant %q", events, tc.events)
+			}
+		})
+	}
+}
+
+func TestRunWithTinyTimeout(t *testing.T) {
+	packets := encodeFakePackets(t, 3)
+	decoder := mteCoder.NewFakeDecoder(-4)
+	instantiateFake(t, decoder)
+	events := make(chan string, 4)
+	buffer := NewReorderBufferWith(NewCoderReceiver(decoder), ReorderPolicy{Timeout: time.Nanosecond}, func(event Event) {
+		events <- describeEvent(event)
+	})
+
+	queue := NewMemoryQueue(len(packets))
+	for _, i := range []int{0, 2} {
+		if err := queue.Publish(context.Background(), packets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- buffer.Run(ctx, &QueueLink{Queue: queue}) }()
+
+	//------------------------------------------------
+	// The gap times out right away, the ticker flushes
+	// it without message 1 ever arriving
+	var got []string
+	for len(got) < 3 {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-time.After(testTimeout):
+			t.Fatalf("events so far %q", got)
+		}
+	}
+	want := []string{"0 decoded message 0", "1 skipped", "2 decoded message 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events:\n got %q\nwant %q", got, want)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: got %v want %v", err, context.Canceled)
+	}
+}
+
+func TestCoderReceiverErrors(t *testing.T) {
+	packets := encodeFakePackets(t, 2)
+	decoder := mteCoder.NewFakeDecoder(0)
+	instantiateFake(t, decoder)
+	receiver := NewCoderReceiver(decoder)
+
+	for i, want := range []EventKind{EventMismatch, EventDecoded, EventDecoded, EventMismatch} {
+		packet := packets[[]int{1, 0, 1, 1}[i]]
+		if event := receiver.Decode(packet); event.Kind != want {
+			t.Errorf("packet %d: got %v %v, want %v", i, event.Kind, event.Err, want)
+		}
+	}
+	if event := receiver.Decode([]byte{1}); event.Kind != EventMalformed || event.Err != ErrPacketTooShort {
+		t.Errorf("short packet: got %v %v", event.Kind, event.Err)
+	}
+}
+
+/**
+ * Encodes count messages with a fake Encoder and returns the
+ * packets, each with its sequence number in front
+ */
+func encodeFakePackets(t *testing.T, count int) [][]byte {
+	t.Helper()
+	encoder := mteCoder.NewFakeEncoder()
+	instantiateFake(t, encoder)
+	var packets [][]byte
+	for i := 0; i < count; i++ {
+		encoded, err := encoder.Encode([]byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := make([]byte, headerSize, headerSize+len(encoded))
+		binary.BigEndian.PutUint64(packet, uint64(i))
+		packets = append(packets, append(packet, encoded...))
+	}
+	return packets
+}
+
+func instantiateFake(t *testing.T, seeder mteCoder.Seeder) {
+	t.Helper()
+	seeder.SetEntropy(make([]byte, 32))
+	seeder.SetNonceInt(0)
+	if err := seeder.InstantiateStr(testPersonal); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func describeEvent(event Event) string {
+	out := fmt.Sprintf("%d %v", event.Sequence, event.Kind)
+	if event.Late {
+		out += " late"
+	}
+	if event.Message != nil {
+		out += " " + string(event.Message)
+	}
+	return out
+}
@@ -0,0 +1,318 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSequencing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mte"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+)
+
+//-----------------------------------------------------------------
+// The expected statuses are what MTE 3.0.x reports, a failure after
+// a library upgrade means the sequencing behavior has changed
+//-----------------------------------------------------------------
+
+const testPersonal = "sequencing test"
+
+//---------------------------------------------------
+// One delivery to the Decoder
+// msg is the index of the message in the encode order
+// corrupt flips the first byte of the MTE token
+// restore rolls the Decoder back to the state saved
+// right after it was instantiated instead of delivering
+type seqStep struct {
+	msg     int
+	corrupt bool
+	restore bool
+	status  mte.Status
+	plain   string
+}
+
+type seqCase struct {
+	name   string
+	window int
+	encode []string
+	steps  []seqStep
+}
+
+var fourMessages = []string{"message 0", "message 1", "message 2", "message 3"}
+
+var seqCases = []seqCase{
+	{
+		name:   "verification only in order",
+		window: 0,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+		},
+	},
+	{
+		name:   "verification only rejects replay, gap and corruption",
+		window: 0,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 0, status: mte.Status_mte_status_seq_mismatch},
+			{msg: 2, status: mte.Status_mte_status_seq_mismatch},
+			{msg: 1, corrupt: true, status: mte.Status_mte_status_seq_mismatch},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+		},
+	},
+	{
+		name:   "forward only skips ahead within the window",
+		window: 2,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 0, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 2, corrupt: true, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 1, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 2, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+		},
+	},
+	{
+		name:   "forward only rejects a gap beyond the window",
+		window: 2,
+		encode: []string{"message 0", "message 1", "message 2", "message 3", "message 4"},
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 4, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+			{msg: 4, status: mte.Status_mte_status_success, plain: "message 4"},
+		},
+	},
+	{
+		name:   "async accepts reordering and reports replays",
+		window: -2,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 0, status: mte.Status_mte_status_seq_async_replay},
+			{msg: 2, corrupt: true, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 2, status: mte.Status_mte_status_seq_async_replay},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{msg: 2, status: mte.Status_mte_status_seq_async_replay},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+		},
+	},
+	{
+		name:   "async rejects a gap beyond the window",
+		window: -2,
+		encode: []string{"message 0", "message 1", "message 2", "message 3", "message 4"},
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 4, status: mte.Status_mte_status_seq_outside_window},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 4, status: mte.Status_mte_status_success, plain: "message 4"},
+			{msg: 1, status: mte.Status_mte_status_seq_outside_window},
+		},
+	},
+	{
+		name:   "async restore state rolls back and replays are accepted again",
+		window: -2,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{msg: 1, status: mte.Status_mte_status_seq_async_replay},
+			{restore: true},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 2, status: mte.Status_mte_status_success, plain: "message 2"},
+			{msg: 3, status: mte.Status_mte_status_success, plain: "message 3"},
+		},
+	},
+	{
+		name:   "verification only restore state",
+		window: 0,
+		encode: fourMessages,
+		steps: []seqStep{
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+			{restore: true},
+			{msg: 1, status: mte.Status_mte_status_seq_mismatch},
+			{msg: 0, status: mte.Status_mte_status_success, plain: "message 0"},
+			{msg: 1, status: mte.Status_mte_status_success, plain: "message 1"},
+		},
+	},
+}
+
+func TestSequencing(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	for _, tc := range seqCases {
+		t.Run(tc.name, func(t *testing.T) {
+			packets := encodePackets(t, tc.encode)
+			decoder := newTestDecoder(t, Config{SequenceWindow: tc.window})
+			defer decoder.Destroy()
+			saved := decoder.SaveState()
+			receiver := NewReceiver(decoder, nil, nil)
+
+			for i, step := range tc.steps {
+				if step.restore {
+					status := decoder.RestoreState(saved)
+					if status != mte.Status_mte_status_success {
+						t.Fatalf("step %d: restore state: %v", i, mte.GetStatusName(status))
+					}
+					continue
+				}
+				packet := append([]byte(nil), packets[step.msg]...)
+				if step.corrupt {
+					packet[headerSize]++
+				}
+				event := receiver.Decode(packet)
+				if event.Status != step.status {
+					t.Errorf("step %d: message %d: status %v, want %v", i, step.msg,
+						mte.GetStatusName(event.Status), mte.GetStatusName(step.status))
+				}
+				if event.Kind != KindOf(step.status) {
+					t.Errorf("step %d: message %d: kind %v, want %v", i, step.msg, event.Kind, KindOf(step.status))
+				}
+				if string(event.Message) != step.plain {
+					t.Errorf("step %d: message %d: plaintext %q, want %q", i, step.msg, event.Message, step.plain)
+				}
+				if event.Sequence != uint64(step.msg) {
+					t.Errorf("step %d: sequence %d, want %d", i, event.Sequence, step.msg)
+				}
+			}
+		})
+	}
+}
+
+func TestMalformedPacket(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	decoder := newTestDecoder(t, Config{})
+	defer decoder.Destroy()
+	event := NewReceiver(decoder, nil, nil).Decode([]byte{1, 2, 3})
+	if event.Kind != EventMalformed || event.Err != ErrPacketTooShort {
+		t.Errorf("got %v %v, want %v %v", event.Kind, event.Err, EventMalformed, ErrPacketTooShort)
+	}
+}
+
+func TestCoderReceiverUnknownError(t *testing.T) {
+	broken := errors.New("broken Decoder")
+	receiver := NewCoderReceiver(failingDecoder{err: broken})
+	event := receiver.Decode(encodeFakePackets(t, 1)[0])
+	if event.Kind != EventError || event.Err != broken {
+		t.Errorf("got %v %v, want %v %v", event.Kind, event.Err, EventError, broken)
+	}
+	if event.Status == mte.Status_mte_status_success {
+		t.Errorf("failed decode reported status %v", mte.GetStatusName(event.Status))
+	}
+}
+
+//-----------------------------------------------
+// Decoder that fails every message with err
+type failingDecoder struct {
+	mteCoder.Decoder
+	err error
+}
+
+func (d failingDecoder) Decode(encoded []byte) (out []byte, err error) {
+	return nil, d.err
+}
+
+//---------------------------------------------
+// Link that keeps every packet sent over it
+type recordLink struct {
+	packets [][]byte
+}
+
+func (l *recordLink) Send(ctx context.Context, packet []byte) error {
+	l.packets = append(l.packets, packet)
+	return nil
+}
+
+func (l *recordLink) Receive(ctx context.Context) ([]byte, error) {
+	return nil, ErrLinkClosed
+}
+
+func (l *recordLink) Close() error {
+	return nil
+}
+
+/**
+ * Encodes the messages in order and returns the packets
+ */
+func encodePackets(t *testing.T, messages []string) [][]byte {
+	t.Helper()
+	encoder := mte.NewEncDef()
+	defer encoder.Destroy()
+	err := mteEntropy.Set(testEntropy, mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder.SetNonceInt(0)
+	status := encoder.InstantiateStr(testPersonal)
+	if status != mte.Status_mte_status_success {
+		t.Fatalf("encoder instantiate: %v", mte.GetStatusName(status))
+	}
+	link := &recordLink{}
+	sender := NewSender(encoder, link)
+	for _, message := range messages {
+		_, err := sender.Send(context.Background(), []byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return link.packets
+}
+
+/**
+ * Creates an instantiated Decoder for config
+ */
+func newTestDecoder(t *testing.T, config Config) *mte.MteDec {
+	t.Helper()
+	decoder := NewDecoder(config)
+	err := mteEntropy.Set(testEntropy, mte.GetDrbgsEntropyMinBytes(decoder.GetDrbg()), decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder.SetNonceInt(0)
+	status := decoder.InstantiateStr(testPersonal)
+	if status != mte.Status_mte_status_success {
+		t.Fatalf("decoder instantiate: %v", mte.GetStatusName(status))
+	}
+	return decoder
+}
+
+//----------------------------------------------------
+// Fixed all-zero entropy, insecure and only for tests
+var testEntropy = mteEntropy.NewInsecureFixedTestProvider([]byte{0})
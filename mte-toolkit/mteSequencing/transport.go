@@ -0,0 +1,316 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSequencing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"mteToolkit/mte"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteCoder"
+)
+
+const (
+	//-----------------------------------------------
+	// Every packet starts with the sender sequence
+	// number followed by the MTE encoded message
+	headerSize = 8
+)
+
+//------------------
+// Error messages
+var ErrPacketTooShort = errors.New("packet too short")
+
+//-------------------------------------------------------------
+// Receiver settings
+// SequenceWindow 0 is verification only, a positive window
+// is forward only and a negative window is async mode
+// TimeWindow is only used with the timestamp verifiers
+type Config struct {
+	SequenceWindow int    `json:"sequenceWindow"`
+	TimeWindow     uint64 `json:"timeWindow"`
+}
+
+/**
+ * Reads the receiver settings from a json file
+ */
+func LoadConfig(path string) (out Config, err error) {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return out, fmt.Errorf("reading config: %w", err)
+	}
+	err = json.Unmarshal(configBytes, &out)
+	if err != nil {
+		return out, fmt.Errorf("parsing config: %w", err)
+	}
+	return out, nil
+}
+
+/**
+ * Creates a Decoder using the windows from config
+ * The caller sets the entropy and nonce and instantiates it
+ */
+func NewDecoder(config Config) *mte.MteDec {
+	return mte.NewDecWin(config.TimeWindow, config.SequenceWindow)
+}
+
+//-------------------------------------------------
+// What the Decoder made of a received packet
+type EventKind int
+
+const (
+	EventDecoded EventKind = iota
+	EventReplay
+	EventOutsideWindow
+	EventMismatch
+	EventTimeOutsideWindow
+	EventMalformed
+	EventError
+	EventSkipped
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventDecoded:
+		return "decoded"
+	case EventReplay:
+		return "replay"
+	case EventOutsideWindow:
+		return "outside window"
+	case EventMismatch:
+		return "mismatch"
+	case EventTimeOutsideWindow:
+		return "time outside window"
+	case EventMalformed:
+		return "malformed"
+	case EventSkipped:
+		return "skipped"
+	}
+	return "error"
+}
+
+/**
+ * Returns the event kind for a decode status
+ */
+func KindOf(status mte.Status) EventKind {
+	switch status {
+	case mte.Status_mte_status_seq_async_replay:
+		return EventReplay
+	case mte.Status_mte_status_seq_outside_window:
+		return EventOutsideWindow
+	case mte.Status_mte_status_seq_mismatch:
+		return EventMismatch
+	case mte.Status_mte_status_time_outside_window:
+		return EventTimeOutsideWindow
+	}
+	if mte.StatusIsError(status) {
+		return EventError
+	}
+	return EventDecoded
+}
+
+//------------------------------------------------------------
+// One decoded packet
+// Sequence is the number the sender put in front of the packet,
+// it is not protected, the Decoder does the real sequence check
+// Status is the decode status, for example seq_async_replay
+// Message is only set when Kind is EventDecoded
+// Skipped is the number of messages the Decoder skipped over
+// Late is set by the ReorderBuffer for a packet that arrived
+// after the buffer had already moved past its sequence number
+type Event struct {
+	Kind     EventKind
+	Sequence uint64
+	Status   mte.Status
+	Message  []byte
+	Skipped  uint32
+	Late     bool
+	Err      error
+}
+
+//------------------------------------------------
+// Encodes messages and sends them over a Link
+type Sender struct {
+	encoder  *mte.MteEnc
+	link     Link
+	sequence uint64
+	lock     sync.Mutex
+}
+
+/**
+ * Creates a Sender using an instantiated Encoder
+ */
+func NewSender(encoder *mte.MteEnc, link Link) *Sender {
+	return &Sender{encoder: encoder, link: link}
+}
+
+/**
+ * Encodes and sends one message
+ *
+ * Returns the sequence number of the packet
+ */
+func (s *Sender) Send(ctx context.Context, message []byte) (out uint64, err error) {
+	s.lock.Lock()
+	encoded, status := s.encoder.Encode(message)
+	if status != mte.Status_mte_status_success {
+		s.lock.Unlock()
+		return 0, mteAdapter.NewStatusError("Encode", status)
+	}
+	sequence := s.sequence
+	s.sequence++
+	s.lock.Unlock()
+
+	packet := make([]byte, headerSize+len(encoded))
+	binary.BigEndian.PutUint64(packet, sequence)
+	copy(packet[headerSize:], encoded)
+	return sequence, s.link.Send(ctx, packet)
+}
+
+//----------------------------------------------------------
+// Receives packets from a Link and decodes them
+// OnEvent is called for every packet, in arrival order
+type Receiver struct {
+	decoder *mte.MteDec
+	link    Link
+	OnEvent func(Event)
+}
+
+/**
+ * Creates a Receiver using an instantiated Decoder
+ * created with NewDecoder
+ */
+func NewReceiver(decoder *mte.MteDec, link Link, onEvent func(Event)) *Receiver {
+	return &Receiver{decoder: decoder, link: link, OnEvent: onEvent}
+}
+
+/**
+ * Decodes one packet and reports what happened
+ * The Decoder is not safe for concurrent use, call
+ * Decode from one goroutine only
+ */
+func (r *Receiver) Decode(packet []byte) Event {
+	if len(packet) < headerSize {
+		return Event{Kind: EventMalformed, Err: ErrPacketTooShort}
+	}
+	event := Event{Sequence: binary.BigEndian.Uint64(packet)}
+	decoded, status := r.decoder.Decode(packet[headerSize:])
+	event.Status = status
+	event.Kind = KindOf(status)
+	event.Skipped = r.decoder.GetMsgSkipped()
+	if event.Kind == EventDecoded {
+		event.Message = decoded
+	} else {
+		event.Err = mteAdapter.NewStatusError("Decode", status)
+	}
+	return event
+}
+
+/**
+ * Receives and decodes packets until ctx is done or the
+ * link fails, every packet is reported to OnEvent
+ */
+func (r *Receiver) Run(ctx context.Context) error {
+	for {
+		packet, err := r.link.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		event := r.Decode(packet)
+		if r.OnEvent != nil {
+			r.OnEvent(event)
+		}
+	}
+}
+
+//-----------------------------------------------------------
+// Decodes packets with an mteCoder.Decoder, for example the
+// mteCoder fakes in tests. The Decoder has no skipped count,
+// Status comes from an mteAdapter StatusError or an mteCoder
+// error, any other error is reported as invalid_input,
+// Err always holds the error the Decoder returned
+type CoderReceiver struct {
+	decoder mteCoder.Decoder
+}
+
+/**
+ * Creates a CoderReceiver using an instantiated Decoder
+ */
+func NewCoderReceiver(decoder mteCoder.Decoder) *CoderReceiver {
+	return &CoderReceiver{decoder: decoder}
+}
+
+/**
+ * Decodes one packet and reports what happened
+ * Call Decode from one goroutine only
+ */
+func (r *CoderReceiver) Decode(packet []byte) Event {
+	if len(packet) < headerSize {
+		return Event{Kind: EventMalformed, Err: ErrPacketTooShort}
+	}
+	event := Event{Sequence: binary.BigEndian.Uint64(packet)}
+	decoded, err := r.decoder.Decode(packet[headerSize:])
+	if err == nil {
+		event.Kind = EventDecoded
+		event.Status = mte.Status_mte_status_success
+		event.Message = decoded
+		return event
+	}
+	event.Err = err
+	var statusErr *mteAdapter.StatusError
+	if errors.As(err, &statusErr) {
+		event.Status = statusErr.Status
+		event.Kind = KindOf(statusErr.Status)
+		return event
+	}
+	//------------------------------------------------
+	// Never report success for a packet that failed
+	event.Status = mte.Status_mte_status_invalid_input
+	event.Kind = EventError
+	for _, known := range []struct {
+		err    error
+		status mte.Status
+		kind   EventKind
+	}{
+		{mteCoder.ErrSeqAsyncReplay, mte.Status_mte_status_seq_async_replay, EventReplay},
+		{mteCoder.ErrSeqOutsideWindow, mte.Status_mte_status_seq_outside_window, EventOutsideWindow},
+		{mteCoder.ErrSeqMismatch, mte.Status_mte_status_seq_mismatch, EventMismatch},
+		{mteCoder.ErrTokenDoesNotExist, mte.Status_mte_status_token_does_not_exist, EventError},
+	} {
+		if errors.Is(err, known.err) {
+			event.Status = known.status
+			event.Kind = known.kind
+			break
+		}
+	}
+	return event
+}
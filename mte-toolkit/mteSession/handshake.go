@@ -0,0 +1,51 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSession
+
+import (
+	"mteToolkit/mteHandshake"
+)
+
+const (
+	//------------------------------------
+	// Default handshake route of the API
+	HandshakeRoute = "/api/handshake"
+)
+
+//----------------------------------------------------------
+// Result of the key exchange, everything needed to
+// instantiate Encoders and Decoders matching the server
+// The entropy is secret, clear it once it has been used
+type Secrets struct {
+	ServerUrl      string
+	ClientId       string
+	Nonce          uint64
+	Options        mteHandshake.MteOptions
+	EncoderEntropy []byte
+	DecoderEntropy []byte
+	Device         *mteHandshake.Device
+}
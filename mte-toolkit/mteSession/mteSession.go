@@ -0,0 +1,373 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSession
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+)
+
+const (
+	//------------------------------
+	// Session file format version
+	sessionVersion  = 1
+	sessionFileMode = 0600
+
+	//----------------------------------------
+	// Entropy size of the mteCoder fakes
+	fakeEntropyBytes = 32
+)
+
+//-------------------------------------------------------------
+// Creates the Core Encoders and Decoders a Session works with
+// EntropyBytes is the entropy size they need for the options
+// mteAdapter.MteCoders creates the ones of the MTE library
+type Coders struct {
+	NewEncoder   func(options mteHandshake.MteOptions) mteCoder.Encoder
+	NewDecoder   func(options mteHandshake.MteOptions) mteCoder.Decoder
+	EntropyBytes func(options mteHandshake.MteOptions) int
+}
+
+//--------------------------------------------------------
+// A session loaded from a file has no Coders until
+// UseCoders picks them
+var ErrNoCoders = errors.New("session has no Coders")
+
+/**
+ * The mteCoder fakes, so code using a Session can be tested
+ * without the MTE library. They are NOT secure and must never
+ * protect real data.
+ *
+ * window: sequence window of the fake Decoders
+ */
+func InsecureFakeCoders(window int) Coders {
+	return Coders{
+		NewEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+			return mteCoder.NewFakeEncoder()
+		},
+		NewDecoder: func(options mteHandshake.MteOptions) mteCoder.Decoder {
+			return mteCoder.NewFakeDecoder(window)
+		},
+		EntropyBytes: func(options mteHandshake.MteOptions) int {
+			return fakeEntropyBytes
+		},
+	}
+}
+
+//------------------------------------------------------
+// Session material shared by every command and process
+// that talks to the same server as the same client
+// The Encoder and Decoder states are secret, protect the
+// session file the same way as the shared secrets
+type Session struct {
+	Version      int
+	ServerUrl    string
+	ClientId     string
+	Nonce        uint64
+	Drbg         int
+	Options      mteHandshake.MteOptions
+	EncoderState string
+	DecoderState string
+	coders       *Coders
+}
+
+/**
+ * Creates a new session
+ * Instantiates the Encoder and Decoder and keeps their states
+ * The session keeps using coders until it is saved,
+ * call UseCoders again after Load
+ *
+ * coders: Coders of the Encoder and Decoder, usually
+ * mteAdapter.MteCoders
+ * serverUrl: server the handshake was done with
+ * clientId: client ID, also used as personalization string
+ * nonce: nonce agreed on during handshake
+ * options: MTE options agreed on during handshake
+ * encoderEntropy: Encoder entropy, usually mteEntropy.NewEcdhProvider
+ * with the Encoder shared secret
+ * decoderEntropy: Decoder entropy
+ *
+ * Returns the Session
+ */
+func New(coders Coders,
+	serverUrl string,
+	clientId string,
+	nonce uint64,
+	options mteHandshake.MteOptions,
+	encoderEntropy mteEntropy.Provider,
+	decoderEntropy mteEntropy.Provider) (out *Session, err error) {
+
+	session := &Session{
+		Version:   sessionVersion,
+		ServerUrl: serverUrl,
+		ClientId:  clientId,
+		Nonce:     nonce,
+		Options:   options,
+		coders:    &coders,
+	}
+	//--------------------
+	// Initialize Encoder
+	encoder := coders.NewEncoder(options)
+	defer encoder.Destroy()
+	err = mteEntropy.Set(encoderEntropy, coders.EntropyBytes(options), encoder)
+	if err != nil {
+		return nil, fmt.Errorf("Encoder entropy: %w", err)
+	}
+	encoder.SetNonceInt(nonce)
+	err = encoder.InstantiateStr(clientId)
+	if err != nil {
+		return nil, fmt.Errorf("Encoder instantiate: %w", err)
+	}
+	session.EncoderState = mteCoder.SaveStateB64(encoder)
+
+	//--------------------
+	// Initialize Decoder
+	decoder := coders.NewDecoder(options)
+	defer decoder.Destroy()
+	err = mteEntropy.Set(decoderEntropy, coders.EntropyBytes(options), decoder)
+	if err != nil {
+		return nil, fmt.Errorf("Decoder entropy: %w", err)
+	}
+	decoder.SetNonceInt(nonce)
+	err = decoder.InstantiateStr(clientId)
+	if err != nil {
+		return nil, fmt.Errorf("Decoder instantiate: %w", err)
+	}
+	session.DecoderState = mteCoder.SaveStateB64(decoder)
+
+	return session, nil
+}
+
+/**
+ * Makes the session restore its Encoder and Decoder
+ * with coders, a loaded session needs this before use
+ */
+func (s *Session) UseCoders(coders Coders) {
+	s.coders = &coders
+}
+
+/**
+ * Loads a session file written by Save
+ */
+func Load(path string) (out *Session, err error) {
+	sessionBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	err = json.Unmarshal(sessionBytes, &session)
+	if err != nil {
+		return nil, err
+	}
+	if session.Version != sessionVersion {
+		return nil, errors.New("unsupported session file version")
+	}
+	if session.EncoderState == "" || session.DecoderState == "" {
+		return nil, errors.New("session file has no Encoder or Decoder state")
+	}
+	return &session, nil
+}
+
+/**
+ * Saves the session to a file only the current user can read
+ * Writes to a temporary file first so a crash never leaves
+ * a half written session behind
+ */
+func (s *Session) Save(path string) error {
+	sessionBytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(sessionFileMode); err == nil {
+		_, err = tmp.Write(sessionBytes)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+/**
+ * Restores the Core Encoder from the session with its Coders
+ * Call SaveEncoderState after using it so the state moves forward
+ */
+func (s *Session) CoreEncoder() (out mteCoder.Encoder, err error) {
+	coders, err := s.getCoders()
+	if err != nil {
+		return nil, err
+	}
+	encoder := coders.NewEncoder(s.Options)
+	err = mteCoder.RestoreStateB64(encoder, s.EncoderState)
+	if err != nil {
+		encoder.Destroy()
+		return nil, fmt.Errorf("Encoder restore: %w", err)
+	}
+	return encoder, nil
+}
+
+/**
+ * Restores the Core Decoder from the session with its Coders
+ * Call SaveDecoderState after using it so the state moves forward
+ */
+func (s *Session) CoreDecoder() (out mteCoder.Decoder, err error) {
+	coders, err := s.getCoders()
+	if err != nil {
+		return nil, err
+	}
+	decoder := coders.NewDecoder(s.Options)
+	err = mteCoder.RestoreStateB64(decoder, s.DecoderState)
+	if err != nil {
+		decoder.Destroy()
+		return nil, fmt.Errorf("Decoder restore: %w", err)
+	}
+	return decoder, nil
+}
+
+/**
+ * Keeps the current state of an Encoder from CoreEncoder
+ */
+func (s *Session) SaveEncoderState(encoder mteCoder.State) {
+	s.EncoderState = mteCoder.SaveStateB64(encoder)
+}
+
+/**
+ * Keeps the current state of a Decoder from CoreDecoder
+ */
+func (s *Session) SaveDecoderState(decoder mteCoder.State) {
+	s.DecoderState = mteCoder.SaveStateB64(decoder)
+}
+
+/**
+ * Encodes a message with the Core Encoder and moves the
+ * Encoder state forward
+ *
+ * Returns the encoded message
+ */
+func (s *Session) Encode(message []byte) (out []byte, err error) {
+	encoder, err := s.CoreEncoder()
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Destroy()
+	encoded, err := encoder.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	s.SaveEncoderState(encoder)
+	return encoded, nil
+}
+
+/**
+ * Decodes a message with the Core Decoder
+ * The Decoder state only moves forward when it decoded, so a
+ * message that fails does not affect the ones after it
+ *
+ * Returns the decoded message
+ */
+func (s *Session) Decode(encoded []byte) (out []byte, err error) {
+	decoder, err := s.CoreDecoder()
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Destroy()
+	decoded, err := decoder.Decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	s.SaveDecoderState(decoder)
+	return decoded, nil
+}
+
+/**
+ * Encodes a string like Encode, the result is base64 encoded
+ */
+func (s *Session) EncodeB64(message string) (out string, err error) {
+	encoded, err := s.Encode([]byte(message))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+/**
+ * Decodes a base64 encoded message like Decode
+ */
+func (s *Session) DecodeB64(encoded string) (out string, err error) {
+	encodedBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := s.Decode(encodedBytes)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+/**
+ * Returns true when the Encoder or Decoder reseed counter
+ * passed the given share of its reseed interval
+ *
+ * percent: share of the reseed interval, for example .9
+ */
+func (s *Session) ReseedNeeded(percent float64) (out bool, err error) {
+	encoder, err := s.CoreEncoder()
+	if err != nil {
+		return false, err
+	}
+	defer encoder.Destroy()
+	decoder, err := s.CoreDecoder()
+	if err != nil {
+		return false, err
+	}
+	defer decoder.Destroy()
+	return mteCoder.ReseedNeeded(encoder, percent) || mteCoder.ReseedNeeded(decoder, percent), nil
+}
+
+func (s *Session) getCoders() (out Coders, err error) {
+	if s.coders == nil {
+		return Coders{}, ErrNoCoders
+	}
+	return *s.coders, nil
+}
@@ -0,0 +1,177 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSession
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+)
+
+const (
+	testClientId = "session-test-client"
+	testNonce    = uint64(1234)
+)
+
+var legacyOptions = mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion}
+
+/**
+ * Creates a client and a server session on the fakes, the
+ * server Decoder gets the entropy of the client Encoder
+ */
+func newFakePair(t *testing.T) (client *Session, server *Session) {
+	t.Helper()
+	a := mteEntropy.NewInsecureFixedTestProvider([]byte("client to server"))
+	b := mteEntropy.NewInsecureFixedTestProvider([]byte("server to client"))
+	client, err := New(InsecureFakeCoders(0), "", testClientId, testNonce, legacyOptions, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = New(InsecureFakeCoders(0), "", testClientId, testNonce, legacyOptions, b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestEncodeDecodeBothDirections(t *testing.T) {
+	client, server := newFakePair(t)
+	for i, pair := range []struct{ from, to *Session }{{client, server}, {server, client}, {client, server}} {
+		encoded, err := pair.from.EncodeB64("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := pair.to.DecodeB64(encoded)
+		if err != nil || decoded != "hello" {
+			t.Fatalf("message %d: got %q %v", i, decoded, err)
+		}
+	}
+}
+
+func TestFailedDecodeKeepsTheState(t *testing.T) {
+	client, server := newFakePair(t)
+	first, err := client.Encode([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.Encode([]byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte(nil), first...)
+	corrupt[len(corrupt)-1]++
+	state := server.DecoderState
+	if _, err = server.Decode(corrupt); !errors.Is(err, mteCoder.ErrTokenDoesNotExist) {
+		t.Errorf("corrupt message: got %v want %v", err, mteCoder.ErrTokenDoesNotExist)
+	}
+	if _, err = server.Decode(second); !errors.Is(err, mteCoder.ErrSeqMismatch) {
+		t.Errorf("out of order: got %v want %v", err, mteCoder.ErrSeqMismatch)
+	}
+	if server.DecoderState != state {
+		t.Fatal("a failed decode changed the Decoder state")
+	}
+	for _, message := range [][]byte{first, second} {
+		if _, err = server.Decode(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = server.Decode(second); !errors.Is(err, mteCoder.ErrSeqMismatch) {
+		t.Errorf("replay: got %v want %v", err, mteCoder.ErrSeqMismatch)
+	}
+}
+
+func TestSaveAndLoadCarryOn(t *testing.T) {
+	client, server := newFakePair(t)
+	path := filepath.Join(t.TempDir(), "session.json")
+	for i := 0; i < 3; i++ {
+		err := client.Save(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//-------------------------------------------
+		// Every message is encoded by a new process
+		client, err = Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.EncodeB64("no coders"); !errors.Is(err, ErrNoCoders) {
+			t.Fatalf("loaded session without coders: got %v want %v", err, ErrNoCoders)
+		}
+		client.UseCoders(InsecureFakeCoders(0))
+		encoded, err := client.EncodeB64("message")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.DecodeB64(encoded); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+}
+
+func TestCoreEncoderRejectsBadState(t *testing.T) {
+	client, _ := newFakePair(t)
+	client.EncoderState = "bm90IGEgc3RhdGU="
+	if _, err := client.EncodeB64("hello"); !errors.Is(err, mteCoder.ErrInvalidState) {
+		t.Errorf("got %v want %v", err, mteCoder.ErrInvalidState)
+	}
+	client.DecoderState = "not base64"
+	if _, err := client.CoreDecoder(); err == nil {
+		t.Error("restored a Decoder from a broken state")
+	}
+}
+
+func TestReseedNeeded(t *testing.T) {
+	client, server := newFakePair(t)
+	percent := 2.0 / mteCoder.DefaultFakeReseedInterval
+	for i := 0; i < 3; i++ {
+		needed, err := client.ReseedNeeded(percent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if needed != (i > 2) {
+			t.Fatalf("after %d messages: reseed needed %v", i, needed)
+		}
+		encoded, err := client.Encode([]byte("message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.Decode(encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, session := range []*Session{client, server} {
+		needed, err := session.ReseedNeeded(percent)
+		if err != nil || !needed {
+			t.Errorf("after 3 messages: reseed needed %v %v", needed, err)
+		}
+	}
+}
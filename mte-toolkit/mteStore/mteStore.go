@@ -0,0 +1,228 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteStore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	//---------------------------------------
+	// Size of the key used by a SealedStore
+	KeySize       = 32
+	stateFileMode = 0600
+	stateDirMode  = 0700
+)
+
+//------------------
+// Error messages
+var ErrNotFound = errors.New("state not found")
+var ErrSealedState = errors.New("sealed state could not be opened")
+
+//-------------------------------------------------------
+// Keeps Encoder and Decoder states and other values
+// between calls, processes or restarts
+// Get returns ErrNotFound when there is no value for key
+type Store interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+//---------------------------------
+// Store held in memory
+type MemoryStore struct {
+	values map[string][]byte
+	lock   sync.RWMutex
+}
+
+/**
+ * Creates an empty MemoryStore
+ */
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key string) (out []byte, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *MemoryStore) Set(key string, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+//------------------------------------------------------
+// Store that keeps one file per key in a directory
+// The files can only be read by the current user
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) Get(key string) (out []byte, err error) {
+	value, err := ioutil.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+/**
+ * Writes the value to a temporary file first so a
+ * crash never leaves a half written state behind
+ */
+func (s *FileStore) Set(key string, value []byte) error {
+	err := os.MkdirAll(s.Dir, stateDirMode)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(s.Dir, ".state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(value)
+	if err == nil {
+		err = file.Chmod(stateFileMode)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path(key))
+}
+
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+/**
+ * Hex encodes the key so any key is a safe file name
+ */
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.Dir, hex.EncodeToString([]byte(key)))
+}
+
+//----------------------------------------------------------
+// Store that seals every value with AES-GCM before handing
+// it to the wrapped Store, the same way the multiple
+// clients sample protects the states it keeps in its cache
+// The key is used as additional data so a sealed value can
+// not be moved to another key
+type SealedStore struct {
+	store Store
+	gcm   cipher.AEAD
+}
+
+/**
+ * Creates a new random key for a SealedStore
+ */
+func NewKey() (out []byte, err error) {
+	key := make([]byte, KeySize)
+	_, err = io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+/**
+ * Wraps store so every value is sealed with key
+ *
+ * store: Store holding the sealed values
+ * key: AES key of KeySize bytes
+ */
+func NewSealedStore(store Store, key []byte) (out *SealedStore, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SealedStore{store: store, gcm: gcm}, nil
+}
+
+func (s *SealedStore) Get(key string) (out []byte, err error) {
+	sealed, err := s.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := s.gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrSealedState
+	}
+	value, err := s.gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(key))
+	if err != nil {
+		return nil, ErrSealedState
+	}
+	return value, nil
+}
+
+func (s *SealedStore) Set(key string, value []byte) error {
+	nonce := make([]byte, s.gcm.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(key, s.gcm.Seal(nonce, nonce, value, []byte(key)))
+}
+
+func (s *SealedStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
@@ -0,0 +1,235 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteStore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+/**
+ * Checks the basic Store contract on any Store
+ */
+func checkStore(t *testing.T, store Store) {
+	t.Helper()
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key: got %v want ErrNotFound", err)
+	}
+	value := []byte("encoder state")
+	if err := store.Set("client/1", value); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'X'
+	got, err := store.Get("client/1")
+	if err != nil || string(got) != "encoder state" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if err = store.Set("client/1", []byte("next state")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.Get("client/1"); string(got) != "next state" {
+		t.Errorf("after overwrite got %q", got)
+	}
+	if err = store.Delete("client/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get("client/1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("after delete: got %v want ErrNotFound", err)
+	}
+	if err = store.Delete("client/1"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	checkStore(t, store)
+
+	//------------------------------------------
+	// Changing a value read does not change it
+	store.Set("key", []byte("value"))
+	got, _ := store.Get("key")
+	got[0] = 'X'
+	if got, _ = store.Get("key"); string(got) != "value" {
+		t.Errorf("stored value changed to %q", got)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "states")
+	checkStore(t, &FileStore{Dir: dir})
+
+	store := &FileStore{Dir: dir}
+	if err := store.Set("../escape", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d files in the store, want 1", len(entries))
+	}
+	if mode := entries[0].Mode().Perm(); mode != stateFileMode {
+		t.Errorf("file mode %o want %o", mode, stateFileMode)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != stateDirMode {
+		t.Errorf("directory mode %o want %o", mode, stateDirMode)
+	}
+}
+
+func TestFileStoreWritesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	store := &FileStore{Dir: dir}
+	values := [][]byte{bytes.Repeat([]byte{'a'}, 1<<20), bytes.Repeat([]byte{'b'}, 1<<19)}
+	if err := store.Set("state", values[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	//-----------------------------------------------
+	// Readers never see a half written value while
+	// the value is replaced over and over
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := store.Set("state", values[i%2]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			entries, _ := ioutil.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("%d files left in the store, want 1", len(entries))
+			}
+			return
+		default:
+		}
+		got, err := store.Get("state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, values[0]) && !bytes.Equal(got, values[1]) {
+			t.Fatalf("read a partial value of %d bytes", len(got))
+		}
+	}
+}
+
+func newTestSealedStore(t *testing.T) (*SealedStore, *MemoryStore) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := NewMemoryStore()
+	sealed, err := NewSealedStore(inner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed, inner
+}
+
+func TestSealedStore(t *testing.T) {
+	sealed, inner := newTestSealedStore(t)
+	checkStore(t, sealed)
+
+	secret := []byte("decoder state")
+	sealed.Set("client", secret)
+	raw, err := inner.Get("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, secret) {
+		t.Error("the wrapped store holds the plaintext")
+	}
+	sealed.Set("client", secret)
+	again, _ := inner.Get("client")
+	if bytes.Equal(raw, again) {
+		t.Error("sealing the same value twice gave the same bytes")
+	}
+}
+
+func TestSealedStoreRejectsTampering(t *testing.T) {
+	sealed, inner := newTestSealedStore(t)
+	sealed.Set("alice", []byte("alice state"))
+	raw, _ := inner.Get("alice")
+
+	//------------------------------------------------
+	// A value copied to another key fails the AAD
+	inner.Set("bob", raw)
+	if _, err := sealed.Get("bob"); !errors.Is(err, ErrSealedState) {
+		t.Errorf("moved value: got %v want ErrSealedState", err)
+	}
+
+	for i := range raw {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 0x01
+		inner.Set("alice", tampered)
+		if _, err := sealed.Get("alice"); !errors.Is(err, ErrSealedState) {
+			t.Fatalf("byte %d flipped: got %v want ErrSealedState", i, err)
+		}
+	}
+	inner.Set("alice", raw[:len(raw)-1])
+	if _, err := sealed.Get("alice"); !errors.Is(err, ErrSealedState) {
+		t.Errorf("truncated: got %v want ErrSealedState", err)
+	}
+	inner.Set("alice", raw[:4])
+	if _, err := sealed.Get("alice"); !errors.Is(err, ErrSealedState) {
+		t.Errorf("shorter than the nonce: got %v want ErrSealedState", err)
+	}
+
+	//------------------------------------------
+	// Another key can not open the sealed value
+	other, _ := newTestSealedStore(t)
+	otherStore := &SealedStore{store: inner, gcm: other.gcm}
+	inner.Set("alice", raw)
+	if _, err := otherStore.Get("alice"); !errors.Is(err, ErrSealedState) {
+		t.Errorf("wrong key: got %v want ErrSealedState", err)
+	}
+}
+
+func TestNewSealedStoreKeySize(t *testing.T) {
+	if _, err := NewSealedStore(NewMemoryStore(), make([]byte, 7)); err == nil {
+		t.Error("7 byte key accepted")
+	}
+}
@@ -0,0 +1,481 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSwitch
+
+import (
+	"fmt"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteMode"
+	"mteToolkit/mteSession"
+)
+
+const (
+	//----------------------------------------
+	// Entropy size of the mteCoder fakes
+	fakeEntropyBytes = 32
+)
+
+//-------------------------------------------------------------
+// Creates the Encoders and Decoders of every mode
+// MKE messages are encrypted in one chunk, the same way the
+// MKE Add-on streams files. FLEN is decoded with the Core
+// Decoder. EntropyBytes is the entropy size they need
+// mteAdapter.SwitchCoders creates the ones of the MTE library
+type Coders struct {
+	NewCoreEncoder func(options mteHandshake.MteOptions) mteCoder.Encoder
+	NewCoreDecoder func(options mteHandshake.MteOptions) mteCoder.Decoder
+	NewFlenEncoder func(options mteHandshake.MteOptions) mteCoder.Encoder
+	NewMkeEncoder  func(options mteHandshake.MteOptions) mteCoder.ChunkEncoder
+	NewMkeDecoder  func(options mteHandshake.MteOptions) mteCoder.ChunkDecoder
+	EntropyBytes   func(options mteHandshake.MteOptions) int
+}
+
+/**
+ * The mteCoder fakes, so code using a Session can be tested
+ * without the MTE library. They are NOT secure and must never
+ * protect real data. The fake FLEN Encoder does not pad.
+ */
+func InsecureFakeCoders() Coders {
+	return Coders{
+		NewCoreEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+			return mteCoder.NewFakeEncoder()
+		},
+		NewCoreDecoder: func(options mteHandshake.MteOptions) mteCoder.Decoder {
+			return mteCoder.NewFakeDecoder(0)
+		},
+		NewFlenEncoder: func(options mteHandshake.MteOptions) mteCoder.Encoder {
+			return mteCoder.NewFakeEncoder()
+		},
+		NewMkeEncoder: func(options mteHandshake.MteOptions) mteCoder.ChunkEncoder {
+			return mteCoder.NewFakeChunkEncoder()
+		},
+		NewMkeDecoder: func(options mteHandshake.MteOptions) mteCoder.ChunkDecoder {
+			return mteCoder.NewFakeChunkDecoder()
+		},
+		EntropyBytes: func(options mteHandshake.MteOptions) int {
+			return fakeEntropyBytes
+		},
+	}
+}
+
+//-------------------------------------------------------------
+// Core and MKE Encoder and Decoder pairs over one handshake
+// FLEN uses the Core pair, EncodeMode with mteHandshake.ModeFlen
+// pads the message to the agreed fixed length
+// Each message is sent with the mode the policy picks and the
+// envelope carries a mode marker, so the receiving side knows
+// which Decoder to use. Both pairs are instantiated from the
+// handshake secrets with the personalization string
+// clientId/mode, so the server derives the same four states.
+// A legacy server knows neither markers nor modes, then only
+// the MKE pair is instantiated with the client ID, Core shares
+// its state and messages are sent without a marker.
+// The session is not safe for concurrent use.
+type Session struct {
+	ClientId         string
+	Options          mteHandshake.MteOptions
+	Policy           mteMode.Policy
+	CoreEncoderState string `json:",omitempty"`
+	CoreDecoderState string `json:",omitempty"`
+	MkeEncoderState  string `json:",omitempty"`
+	MkeDecoderState  string `json:",omitempty"`
+	coders           *Coders
+}
+
+//---------------------------------------------------
+// An Encoder or Decoder of a mode being instantiated
+type instance struct {
+	mode  string
+	coder interface {
+		mteCoder.Seeder
+		mteCoder.State
+	}
+}
+
+/**
+ * Creates the session from the handshake secrets
+ * Every mode the handshake agreed on is instantiated, the
+ * entropy of the secrets is cleared once it has been used
+ * The session keeps using coders until it is written out,
+ * call UseCoders again after reading it back
+ *
+ * coders: Coders of every mode, usually mteAdapter.SwitchCoders
+ * secrets: result of the handshake key exchange
+ * policy: decides the mode of each message, usually mteMode.DefaultPolicy
+ *
+ * Returns the Session
+ */
+func New(coders Coders, secrets *mteSession.Secrets, policy mteMode.Policy) (out *Session, err error) {
+	s := &Session{ClientId: secrets.ClientId, Options: secrets.Options, Policy: policy, coders: &coders}
+	var encoders, decoders []instance
+	if s.ModeSwitching() && (s.Options.HasMode(mteHandshake.ModeCore) || s.Options.HasMode(mteHandshake.ModeFlen)) {
+		encoders = append(encoders, instance{mteHandshake.ModeCore, coders.NewCoreEncoder(s.Options)})
+		decoders = append(decoders, instance{mteHandshake.ModeCore, coders.NewCoreDecoder(s.Options)})
+	}
+	if s.Options.HasMode(mteHandshake.ModeMke) {
+		encoders = append(encoders, instance{mteHandshake.ModeMke, coders.NewMkeEncoder(s.Options)})
+		decoders = append(decoders, instance{mteHandshake.ModeMke, coders.NewMkeDecoder(s.Options)})
+	}
+	err = s.instantiate("Encoder", secrets.EncoderEntropy, secrets.Nonce, encoders, s.encoderState)
+	if err != nil {
+		return nil, err
+	}
+	err = s.instantiate("Decoder", secrets.DecoderEntropy, secrets.Nonce, decoders, s.decoderState)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/**
+ * Makes the session restore its Encoders and Decoders
+ * with coders, a session read back needs this before use
+ */
+func (s *Session) UseCoders(coders Coders) {
+	s.coders = &coders
+}
+
+/**
+ * Reports if the handshake agreed on mode switching
+ * Only a server that speaks handshake version 2 knows the
+ * mode markers and the personalization string per mode
+ */
+func (s *Session) ModeSwitching() bool {
+	return !s.Options.IsDefault()
+}
+
+/**
+ * Encodes a message with the mode the policy picks
+ *
+ * message: message to encode
+ * contentType: content type of the message, may be empty
+ *
+ * Returns the envelope, the mode marker followed by the
+ * encoded message, and the mode
+ */
+func (s *Session) Encode(message []byte, contentType string) (out []byte, mode string, err error) {
+	mode, err = s.Policy.Pick(int64(len(message)), contentType, s.Options)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err = s.EncodeMode(mode, message)
+	return out, mode, err
+}
+
+/**
+ * Encodes a message with the given mode
+ * A legacy session returns the encoded message without marker
+ *
+ * Returns the envelope
+ */
+func (s *Session) EncodeMode(mode string, message []byte) (out []byte, err error) {
+	if !s.Options.HasMode(mode) {
+		return nil, fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mode)
+	}
+	coders, err := s.getCoders()
+	if err != nil {
+		return nil, err
+	}
+	var encoded []byte
+	switch mode {
+	case mteHandshake.ModeCore, mteHandshake.ModeFlen:
+		//----------------------------------------------
+		// FLEN shares the Core Encoder state, messages
+		// longer than the fixed length are rejected
+		newEncoder := coders.NewCoreEncoder
+		if mode == mteHandshake.ModeFlen {
+			err = s.Options.CheckFixedLength(len(message))
+			if err != nil {
+				return nil, err
+			}
+			newEncoder = coders.NewFlenEncoder
+		}
+		encoder := newEncoder(s.Options)
+		defer encoder.Destroy()
+		err = mteCoder.RestoreStateB64(encoder, *s.encoderState(mode))
+		if err != nil {
+			return nil, fmt.Errorf("%s Encoder restore: %w", mode, err)
+		}
+		encoded, err = encoder.Encode(message)
+		if err != nil {
+			return nil, err
+		}
+		*s.encoderState(mode) = mteCoder.SaveStateB64(encoder)
+	case mteHandshake.ModeMke:
+		encoder, err := s.MkeEncoder()
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Destroy()
+		encoded, err = encrypt(encoder, message)
+		if err != nil {
+			return nil, err
+		}
+		s.SaveMkeEncoderState(encoder)
+	default:
+		return nil, fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mode)
+	}
+	if !s.ModeSwitching() {
+		return encoded, nil
+	}
+	return mteMode.Wrap(mode, encoded)
+}
+
+/**
+ * Decodes an envelope with the Decoder its marker names
+ * A legacy session has no markers, use DecodeMode
+ *
+ * Returns the message and the mode it was sent with
+ */
+func (s *Session) Decode(envelope []byte) (message []byte, mode string, err error) {
+	mode, encoded, err := mteMode.Unwrap(envelope)
+	if err != nil {
+		return nil, "", err
+	}
+	message, err = s.DecodeMode(mode, encoded)
+	return message, mode, err
+}
+
+/**
+ * Decodes a message without marker with the Decoder of a mode
+ * The Decoder state is only kept when the message decoded
+ *
+ * Returns the message
+ */
+func (s *Session) DecodeMode(mode string, encoded []byte) (message []byte, err error) {
+	if !s.Options.HasMode(mode) {
+		return nil, fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mode)
+	}
+	coders, err := s.getCoders()
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case mteHandshake.ModeCore, mteHandshake.ModeFlen:
+		decoder := coders.NewCoreDecoder(s.Options)
+		defer decoder.Destroy()
+		err = mteCoder.RestoreStateB64(decoder, *s.decoderState(mode))
+		if err != nil {
+			return nil, fmt.Errorf("%s Decoder restore: %w", mode, err)
+		}
+		message, err = decoder.Decode(encoded)
+		if err != nil {
+			return nil, err
+		}
+		*s.decoderState(mode) = mteCoder.SaveStateB64(decoder)
+	case mteHandshake.ModeMke:
+		decoder := coders.NewMkeDecoder(s.Options)
+		defer decoder.Destroy()
+		err = mteCoder.RestoreStateB64(decoder, *s.decoderState(mode))
+		if err != nil {
+			return nil, fmt.Errorf("%s Decoder restore: %w", mode, err)
+		}
+		message, err = decrypt(decoder, encoded)
+		if err != nil {
+			return nil, err
+		}
+		*s.decoderState(mode) = mteCoder.SaveStateB64(decoder)
+	default:
+		return nil, fmt.Errorf("%w: %s", mteMode.ErrModeNotAgreed, mode)
+	}
+	return message, nil
+}
+
+/**
+ * Restores the MKE Encoder to stream a message in chunks
+ * Call SaveMkeEncoderState once the other side decoded it,
+ * a message that was not decoded can be sent again from
+ * the same state
+ */
+func (s *Session) MkeEncoder() (out mteCoder.ChunkEncoder, err error) {
+	coders, err := s.getCoders()
+	if err != nil {
+		return nil, err
+	}
+	encoder := coders.NewMkeEncoder(s.Options)
+	err = mteCoder.RestoreStateB64(encoder, *s.encoderState(mteHandshake.ModeMke))
+	if err != nil {
+		encoder.Destroy()
+		return nil, fmt.Errorf("%s Encoder restore: %w", mteHandshake.ModeMke, err)
+	}
+	return encoder, nil
+}
+
+/**
+ * Keeps the current state of an Encoder from MkeEncoder
+ */
+func (s *Session) SaveMkeEncoderState(encoder mteCoder.State) {
+	*s.encoderState(mteHandshake.ModeMke) = mteCoder.SaveStateB64(encoder)
+}
+
+/**
+ * Returns true when any Encoder or Decoder is close to its
+ * reseed interval and a new handshake should be done
+ *
+ * percent: share of the reseed interval, for example .9
+ */
+func (s *Session) ReseedNeeded(percent float64) (out bool, err error) {
+	coders, err := s.getCoders()
+	if err != nil {
+		return false, err
+	}
+	states := []struct {
+		instance
+		state string
+	}{
+		{instance{mteHandshake.ModeCore, coders.NewCoreEncoder(s.Options)}, s.CoreEncoderState},
+		{instance{mteHandshake.ModeCore, coders.NewCoreDecoder(s.Options)}, s.CoreDecoderState},
+		{instance{mteHandshake.ModeMke, coders.NewMkeEncoder(s.Options)}, s.MkeEncoderState},
+		{instance{mteHandshake.ModeMke, coders.NewMkeDecoder(s.Options)}, s.MkeDecoderState},
+	}
+	for _, state := range states {
+		defer state.coder.Destroy()
+	}
+	for _, state := range states {
+		if state.state == "" {
+			continue
+		}
+		err = mteCoder.RestoreStateB64(state.coder, state.state)
+		if err != nil {
+			return false, fmt.Errorf("%s restore: %w", state.mode, err)
+		}
+		if mteCoder.ReseedNeeded(state.coder, percent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/**
+ * Instantiates the Encoders or Decoders of every mode
+ * They all get the same entropy and their own
+ * personalization string
+ */
+func (s *Session) instantiate(name string,
+	entropy []byte,
+	nonce uint64,
+	instances []instance,
+	state func(mode string) *string) error {
+	setters := make([]mteEntropy.Setter, len(instances))
+	for i, instance := range instances {
+		defer instance.coder.Destroy()
+		setters[i] = instance.coder
+	}
+	err := mteEntropy.Set(mteEntropy.NewEcdhProvider(entropy), s.coders.EntropyBytes(s.Options), setters...)
+	if err != nil {
+		return fmt.Errorf("%s entropy: %w", name, err)
+	}
+	for _, instance := range instances {
+		instance.coder.SetNonceInt(nonce)
+		err = instance.coder.InstantiateStr(s.personalization(instance.mode))
+		if err != nil {
+			return fmt.Errorf("%s %s instantiate: %w", instance.mode, name, err)
+		}
+		*state(instance.mode) = mteCoder.SaveStateB64(instance.coder)
+	}
+	return nil
+}
+
+/**
+ * Personalization string of the Encoder and Decoder of a mode
+ * A legacy server instantiates with the client ID
+ */
+func (s *Session) personalization(mode string) string {
+	if !s.ModeSwitching() {
+		return s.ClientId
+	}
+	return mteMode.Personalization(s.ClientId, mode)
+}
+
+/**
+ * Returns the Encoder state of a mode
+ * FLEN shares the Core state, a legacy session keeps
+ * one state for both modes in the MKE state
+ */
+func (s *Session) encoderState(mode string) *string {
+	if mode != mteHandshake.ModeMke && s.ModeSwitching() {
+		return &s.CoreEncoderState
+	}
+	return &s.MkeEncoderState
+}
+
+/**
+ * Returns the Decoder state of a mode, shared the
+ * same way as the Encoder state
+ */
+func (s *Session) decoderState(mode string) *string {
+	if mode != mteHandshake.ModeMke && s.ModeSwitching() {
+		return &s.CoreDecoderState
+	}
+	return &s.MkeDecoderState
+}
+
+func (s *Session) getCoders() (out Coders, err error) {
+	if s.coders == nil {
+		return Coders{}, mteSession.ErrNoCoders
+	}
+	return *s.coders, nil
+}
+
+/**
+ * Encrypts a message in one chunk
+ */
+func encrypt(encoder mteCoder.ChunkEncoder, message []byte) (out []byte, err error) {
+	err = encoder.StartEncrypt()
+	if err != nil {
+		return nil, err
+	}
+	encrypted := append([]byte(nil), message...)
+	err = encoder.EncryptChunk(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	finish, err := encoder.FinishEncrypt()
+	if err != nil {
+		return nil, err
+	}
+	return append(encrypted, finish...), nil
+}
+
+/**
+ * Decrypts a message encrypted in one chunk
+ */
+func decrypt(decoder mteCoder.ChunkDecoder, encrypted []byte) (out []byte, err error) {
+	err = decoder.StartDecrypt()
+	if err != nil {
+		return nil, err
+	}
+	decrypted := append([]byte(nil), decoder.DecryptChunk(encrypted)...)
+	finish, err := decoder.FinishDecrypt()
+	if err != nil {
+		return nil, err
+	}
+	return append(decrypted, finish...), nil
+}
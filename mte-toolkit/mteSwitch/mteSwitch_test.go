@@ -0,0 +1,214 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteSwitch
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteMode"
+	"mteToolkit/mteSession"
+)
+
+const testClientId = "switch-test-client"
+
+var switchOptions = mteHandshake.MteOptions{
+	Version:     mteHandshake.ProtocolVersion,
+	Modes:       []string{mteHandshake.ModeCore, mteHandshake.ModeMke, mteHandshake.ModeFlen},
+	FixedLength: 16,
+}
+
+/**
+ * Creates a client and a server session on the fakes, the
+ * server Decoders get the entropy of the client Encoders
+ */
+func newFakePair(t *testing.T, options mteHandshake.MteOptions) (client *Session, server *Session) {
+	t.Helper()
+	secrets := func(encoder string, decoder string) *mteSession.Secrets {
+		return &mteSession.Secrets{
+			ClientId:       testClientId,
+			Nonce:          1234,
+			Options:        options,
+			EncoderEntropy: bytes.Repeat([]byte(encoder), 2),
+			DecoderEntropy: bytes.Repeat([]byte(decoder), 2),
+		}
+	}
+	client, err := New(InsecureFakeCoders(), secrets("client to server", "server to client"), mteMode.DefaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = New(InsecureFakeCoders(), secrets("server to client", "client to server"), mteMode.DefaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestMarkerRouting(t *testing.T) {
+	client, server := newFakePair(t, switchOptions)
+	messages := []struct {
+		message     []byte
+		contentType string
+		mode        string
+		marker      byte
+	}{
+		{[]byte("login"), "application/json", mteHandshake.ModeCore, mteMode.MarkerCore},
+		{[]byte("file"), "application/octet-stream", mteHandshake.ModeMke, mteMode.MarkerMke},
+		{bytes.Repeat([]byte("x"), mteMode.DefaultThreshold), "text/plain", mteHandshake.ModeMke, mteMode.MarkerMke},
+		{[]byte("after"), "text/plain", mteHandshake.ModeCore, mteMode.MarkerCore},
+	}
+	for i, m := range messages {
+		coreState, mkeState := client.CoreEncoderState, client.MkeEncoderState
+		envelope, mode, err := client.Encode(m.message, m.contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != m.mode || envelope[0] != m.marker {
+			t.Fatalf("message %d: mode %s marker %d, want %s %d", i, mode, envelope[0], m.mode, m.marker)
+		}
+		if (mode == mteHandshake.ModeCore) != (client.CoreEncoderState != coreState) ||
+			(mode == mteHandshake.ModeMke) != (client.MkeEncoderState != mkeState) {
+			t.Errorf("message %d: %s moved the state of the other mode", i, mode)
+		}
+		decoded, mode, err := server.Decode(envelope)
+		if err != nil || mode != m.mode || !bytes.Equal(decoded, m.message) {
+			t.Fatalf("message %d: decoded %d bytes with %s, %v", i, len(decoded), mode, err)
+		}
+	}
+}
+
+func TestFlenSharesTheCoreState(t *testing.T) {
+	client, server := newFakePair(t, switchOptions)
+	var envelopes [][]byte
+	for _, mode := range []string{mteHandshake.ModeCore, mteHandshake.ModeFlen, mteHandshake.ModeCore} {
+		envelope, err := client.EncodeMode(mode, []byte(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	if envelopes[1][0] != mteMode.MarkerFlen {
+		t.Fatalf("FLEN marker %d, want %d", envelopes[1][0], mteMode.MarkerFlen)
+	}
+
+	//--------------------------------------------------
+	// The Core Decoder must see the FLEN message in
+	// between, otherwise the last one is out of sequence
+	_, err := server.DecodeMode(mteHandshake.ModeCore, envelopes[2][1:])
+	if !errors.Is(err, mteCoder.ErrSeqMismatch) {
+		t.Errorf("skipped FLEN message: got %v want %v", err, mteCoder.ErrSeqMismatch)
+	}
+	for i, envelope := range envelopes {
+		_, mode, err := server.Decode(envelope)
+		if err != nil {
+			t.Fatalf("message %d (%s): %v", i, mode, err)
+		}
+	}
+	if server.MkeDecoderState == "" || client.MkeEncoderState == "" {
+		t.Fatal("MKE was not instantiated")
+	}
+
+	state := client.CoreEncoderState
+	_, err = client.EncodeMode(mteHandshake.ModeFlen, make([]byte, switchOptions.FixedLength+1))
+	if !errors.Is(err, mteHandshake.ErrMessageTooLong) {
+		t.Errorf("long FLEN message: got %v want %v", err, mteHandshake.ErrMessageTooLong)
+	}
+	if client.CoreEncoderState != state {
+		t.Error("a rejected FLEN message changed the Core Encoder state")
+	}
+}
+
+func TestFailedDecodeKeepsTheState(t *testing.T) {
+	client, server := newFakePair(t, switchOptions)
+	for _, mode := range []string{mteHandshake.ModeCore, mteHandshake.ModeMke} {
+		envelope, err := client.EncodeMode(mode, []byte("message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt := append([]byte(nil), envelope...)
+		corrupt[len(corrupt)-1]++
+		core, mke := server.CoreDecoderState, server.MkeDecoderState
+		if _, _, err = server.Decode(corrupt); !errors.Is(err, mteCoder.ErrTokenDoesNotExist) {
+			t.Errorf("%s corrupt message: got %v want %v", mode, err, mteCoder.ErrTokenDoesNotExist)
+		}
+		if server.CoreDecoderState != core || server.MkeDecoderState != mke {
+			t.Fatalf("%s: a failed decode changed the Decoder state", mode)
+		}
+		if _, _, err = server.Decode(envelope); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+	}
+	if _, _, err := server.Decode([]byte{0x7f, 1, 2}); !errors.Is(err, mteMode.ErrUnknownMarker) {
+		t.Errorf("unknown marker: got %v want %v", err, mteMode.ErrUnknownMarker)
+	}
+}
+
+func TestLegacySharesOneState(t *testing.T) {
+	client, server := newFakePair(t, mteHandshake.MteOptions{Version: mteHandshake.LegacyVersion})
+	if client.CoreEncoderState != "" || client.MkeEncoderState == "" {
+		t.Fatal("a legacy session must only instantiate MKE")
+	}
+	for _, mode := range []string{mteHandshake.ModeCore, mteHandshake.ModeMke} {
+		encoded, err := client.EncodeMode(mode, []byte(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := server.DecodeMode(mode, encoded)
+		if err != nil || string(decoded) != mode {
+			t.Fatalf("%s: decoded %q, %v", mode, decoded, err)
+		}
+	}
+	if _, err := client.EncodeMode(mteHandshake.ModeFlen, []byte("flen")); !errors.Is(err, mteMode.ErrModeNotAgreed) {
+		t.Errorf("legacy FLEN: got %v want %v", err, mteMode.ErrModeNotAgreed)
+	}
+}
+
+func TestReseedNeededAndNoCoders(t *testing.T) {
+	client, _ := newFakePair(t, switchOptions)
+	percent := 1.0 / mteCoder.DefaultFakeReseedInterval
+	needed, err := client.ReseedNeeded(percent)
+	if err != nil || needed {
+		t.Fatalf("new session: reseed needed %v %v", needed, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = client.EncodeMode(mteHandshake.ModeMke, []byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	needed, err = client.ReseedNeeded(percent)
+	if err != nil || !needed {
+		t.Errorf("after 2 MKE messages: reseed needed %v %v", needed, err)
+	}
+
+	client.coders = nil
+	if _, err = client.EncodeMode(mteHandshake.ModeCore, []byte("message")); !errors.Is(err, mteSession.ErrNoCoders) {
+		t.Errorf("no coders: got %v want %v", err, mteSession.ErrNoCoders)
+	}
+}
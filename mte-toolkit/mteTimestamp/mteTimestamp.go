@@ -0,0 +1,165 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteTimestamp
+
+import (
+	"sync"
+	"time"
+
+	"mteToolkit/mte"
+)
+
+const (
+	//---------------------------------------------
+	// Unit of the timestamps handed to the MTE and
+	// of the time window the Decoder enforces
+	TimestampUnit = time.Millisecond
+
+	//---------------------------------------
+	// Verifier used when none is configured
+	DefaultVerifiers = mte.Verifiers_mte_verifiers_t64
+)
+
+//---------------------------------------------------
+// Where the Encoder and Decoder get the time from
+type Clock interface {
+	Now() time.Time
+}
+
+//-----------------------
+// The real system time
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+//------------------------------------------------
+// Clock that only moves when told to, for tests
+type FakeClock struct {
+	now  time.Time
+	lock sync.Mutex
+}
+
+/**
+ * Creates a FakeClock set to now
+ */
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+/**
+ * Sets the time of the clock
+ */
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
+
+/**
+ * Moves the clock forward by d
+ */
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+//-----------------------------------------------------
+// Timestamp callback of the MTE reading from a Clock
+type Callback struct {
+	Clock Clock
+}
+
+/**
+ * Returns the current time in TimestampUnit since the epoch
+ */
+func (c *Callback) TimestampCallback() uint64 {
+	return uint64(c.Clock.Now().UnixNano() / int64(TimestampUnit))
+}
+
+/**
+ * Converts a timestamp reported by the Decoder back to a time
+ */
+func ToTime(timestamp uint64) time.Time {
+	return time.Unix(0, int64(timestamp)*int64(TimestampUnit))
+}
+
+//------------------------------------------------------------
+// Time window settings
+// Window is how old a message may be when it is decoded
+// Verifiers must be one of the timestamp verifiers, t64
+// when not set. SequenceWindow is used as with NewDecWin.
+type Config struct {
+	Window         time.Duration
+	Verifiers      mte.Verifiers
+	SequenceWindow int
+	Clock          Clock
+}
+
+func (c Config) verifiers() mte.Verifiers {
+	if c.Verifiers == mte.Verifiers_mte_verifiers_none {
+		return DefaultVerifiers
+	}
+	return c.Verifiers
+}
+
+func (c Config) callback() *Callback {
+	if c.Clock == nil {
+		return &Callback{Clock: SystemClock{}}
+	}
+	return &Callback{Clock: c.Clock}
+}
+
+/**
+ * Creates an Encoder that puts a timestamp in every message
+ * The caller sets the entropy and nonce and instantiates it
+ */
+func NewEncoder(config Config) *mte.MteEnc {
+	encoder := mte.NewEncOpt(mte.GetDefaultDrbg(), mte.GetDefaultTokBytes(), config.verifiers())
+	encoder.SetTimestampCallback(config.callback())
+	return encoder
+}
+
+/**
+ * Creates a Decoder that rejects messages older than the
+ * window with the time_outside_window status
+ * The caller sets the entropy and nonce and instantiates it
+ */
+func NewDecoder(config Config) *mte.MteDec {
+	window := uint64(config.Window / TimestampUnit)
+	decoder := mte.NewDecOpt(mte.GetDefaultDrbg(), mte.GetDefaultTokBytes(), config.verifiers(), window, config.SequenceWindow)
+	decoder.SetTimestampCallback(config.callback())
+	return decoder
+}
@@ -0,0 +1,139 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteTimestamp
+
+import (
+	"testing"
+	"time"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mte"
+	"mteToolkit/mteEntropy"
+)
+
+const testPersonal = "time window test"
+
+var testStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTimeWindow(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	const window = 2 * time.Second
+	cases := []struct {
+		name   string
+		delay  time.Duration
+		status mte.Status
+	}{
+		{name: "decoded right away", delay: 0, status: mte.Status_mte_status_success},
+		{name: "decoded within the window", delay: window / 2, status: mte.Status_mte_status_success},
+		{name: "older than the window", delay: window + time.Second, status: mte.Status_mte_status_time_outside_window},
+		{name: "much older than the window", delay: time.Hour, status: mte.Status_mte_status_time_outside_window},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := NewFakeClock(testStart)
+			config := Config{Window: window, SequenceWindow: -2, Clock: clock}
+			encoder, decoder := newTestPair(t, config)
+			defer encoder.Destroy()
+			defer decoder.Destroy()
+
+			encoded, status := encoder.EncodeStr("hello")
+			if status != mte.Status_mte_status_success {
+				t.Fatalf("encode: %v", mte.GetStatusName(status))
+			}
+			clock.Advance(tc.delay)
+			decoded, status := decoder.DecodeStr(encoded)
+			if status != tc.status {
+				t.Fatalf("decode status %v, want %v", mte.GetStatusName(status), mte.GetStatusName(tc.status))
+			}
+			if tc.status != mte.Status_mte_status_success {
+				return
+			}
+			if decoded != "hello" {
+				t.Errorf("decoded %q, want %q", decoded, "hello")
+			}
+			if got := ToTime(decoder.GetEncTs()); !got.Equal(testStart) {
+				t.Errorf("encode timestamp %v, want %v", got, testStart)
+			}
+			if got := ToTime(decoder.GetDecTs()); !got.Equal(testStart.Add(tc.delay)) {
+				t.Errorf("decode timestamp %v, want %v", got, testStart.Add(tc.delay))
+			}
+		})
+	}
+}
+
+func TestRejectedMessageDoesNotBlockNewer(t *testing.T) {
+	mteTesting.RequireLicense(t)
+	const window = time.Second
+	clock := NewFakeClock(testStart)
+	encoder, decoder := newTestPair(t, Config{Window: window, SequenceWindow: -2, Clock: clock})
+	defer encoder.Destroy()
+	defer decoder.Destroy()
+
+	stale, status := encoder.EncodeStr("stale")
+	if status != mte.Status_mte_status_success {
+		t.Fatalf("encode: %v", mte.GetStatusName(status))
+	}
+	clock.Advance(2 * window)
+	fresh, status := encoder.EncodeStr("fresh")
+	if status != mte.Status_mte_status_success {
+		t.Fatalf("encode: %v", mte.GetStatusName(status))
+	}
+
+	_, status = decoder.DecodeStr(stale)
+	if status != mte.Status_mte_status_time_outside_window {
+		t.Errorf("stale message status %v, want %v", mte.GetStatusName(status),
+			mte.GetStatusName(mte.Status_mte_status_time_outside_window))
+	}
+	decoded, status := decoder.DecodeStr(fresh)
+	if status != mte.Status_mte_status_success || decoded != "fresh" {
+		t.Errorf("fresh message %v %q, want success %q", mte.GetStatusName(status), decoded, "fresh")
+	}
+}
+
+/**
+ * Creates an instantiated Encoder and Decoder sharing config
+ * Uses fixed all-zero entropy, insecure and only for tests
+ */
+func newTestPair(t *testing.T, config Config) (*mte.MteEnc, *mte.MteDec) {
+	t.Helper()
+	encoder := NewEncoder(config)
+	decoder := NewDecoder(config)
+	err := mteEntropy.Set(mteEntropy.NewInsecureFixedTestProvider([]byte{0}),
+		mte.GetDrbgsEntropyMinBytes(encoder.GetDrbg()), encoder, decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder.SetNonceInt(0)
+	if status := encoder.InstantiateStr(testPersonal); status != mte.Status_mte_status_success {
+		t.Fatalf("encoder instantiate: %v", mte.GetStatusName(status))
+	}
+	decoder.SetNonceInt(0)
+	if status := decoder.InstantiateStr(testPersonal); status != mte.Status_mte_status_success {
+		t.Fatalf("decoder instantiate: %v", mte.GetStatusName(status))
+	}
+	return encoder, decoder
+}
@@ -0,0 +1,189 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteUpload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHttp"
+)
+
+const (
+	//---------------------------------------
+	// Chunk size used when none is given
+	DefaultChunkSize = 1024
+)
+
+//------------------
+// Error messages
+var ErrDecrypt = errors.New("chunk could not be decrypted")
+
+/**
+ * Size of the encrypted stream for a plaintext of size
+ * bytes, use it as the Content-Length of the upload
+ */
+func EncryptedSize(encoder mteCoder.ChunkEncoder, size int64) int64 {
+	return size + int64(encoder.EncryptFinishBytes())
+}
+
+/**
+ * Reports whether the server rejected an upload before it
+ * decoded any of it. The server checks the client and the
+ * access token before it decodes the body and answers 401
+ * when they are rejected, only then can the upload be sent
+ * again from the same Encoder state. After any other outcome,
+ * an error included, the server may have decoded the body
+ * and moved its Decoder forward, so keep the Encoder state.
+ *
+ * err: error of sending the upload, nil when it was accepted
+ */
+func NotDecoded(err error) bool {
+	var statusErr *mteHttp.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized
+}
+
+/**
+ * Encrypts everything read from r with the MKE Encoder in
+ * chunk mode and writes it to w, followed by the finish bytes
+ * Save the Encoder state afterwards unless NotDecoded says the
+ * server never decoded the upload, also after an error the
+ * Encoder may have moved forward
+ *
+ * encoder: restored or instantiated MKE Encoder
+ * w: where the encrypted stream goes, usually a pipe to the request
+ * r: plaintext, for example the file to upload
+ * chunkSize: bytes encrypted at a time, DefaultChunkSize when 0
+ *
+ * Returns the number of bytes written to w
+ */
+func Encrypt(encoder mteCoder.ChunkEncoder, w io.Writer, r io.Reader, chunkSize int) (written int64, err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	err = encoder.StartEncrypt()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			err = encoder.EncryptChunk(chunk)
+			if err != nil {
+				return written, err
+			}
+			n, err = w.Write(chunk)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+	finish, err := encoder.FinishEncrypt()
+	if err != nil {
+		return written, err
+	}
+	n, err := w.Write(finish)
+	return written + int64(n), err
+}
+
+/**
+ * Decrypts a stream written by Encrypt
+ * Nothing read from r can be trusted until this returns nil,
+ * the MKE only checks the message as a whole at the end
+ *
+ * Returns the number of bytes written to w
+ */
+func DecryptStream(decoder mteCoder.ChunkDecoder, w io.Writer, r io.Reader, chunkSize int) (written int64, err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	err = decoder.StartDecrypt()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			decrypted := decoder.DecryptChunk(buf[:n])
+			if decrypted == nil {
+				return written, fmt.Errorf("%w at byte %d", ErrDecrypt, written)
+			}
+			n, err = w.Write(decrypted)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+	finish, err := decoder.FinishDecrypt()
+	if err != nil {
+		return written, err
+	}
+	n, err := w.Write(finish)
+	return written + int64(n), err
+}
+
+/**
+ * Decrypts a short message encrypted in chunk mode,
+ * for example the reply of the upload server
+ *
+ * Returns the plaintext
+ */
+func Decrypt(decoder mteCoder.ChunkDecoder, encoded []byte) (out []byte, err error) {
+	err = decoder.StartDecrypt()
+	if err != nil {
+		return nil, err
+	}
+	decrypted := decoder.DecryptChunk(append([]byte(nil), encoded...))
+	if decrypted == nil {
+		return nil, ErrDecrypt
+	}
+	finish, err := decoder.FinishDecrypt()
+	if err != nil {
+		return nil, err
+	}
+	return append(decrypted, finish...), nil
+}
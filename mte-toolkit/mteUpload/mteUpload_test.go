@@ -0,0 +1,141 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteUpload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"testing/iotest"
+
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHttp"
+)
+
+func TestEncryptAndDecryptStream(t *testing.T) {
+	for _, size := range []int{0, 1, 100, DefaultChunkSize, 3*DefaultChunkSize + 17} {
+		encoder, decoder := newFakePair(t)
+		plain := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+
+		var encrypted bytes.Buffer
+		written, err := Encrypt(encoder, &encrypted, iotest.HalfReader(bytes.NewReader(plain)), 0)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if written != int64(encrypted.Len()) || written != EncryptedSize(encoder, int64(size)) {
+			t.Errorf("%d bytes: wrote %d, buffer %d, EncryptedSize %d",
+				size, written, encrypted.Len(), EncryptedSize(encoder, int64(size)))
+		}
+		if size > 0 && bytes.Contains(encrypted.Bytes(), plain) {
+			t.Errorf("%d bytes: the plaintext is in the upload", size)
+		}
+
+		var decrypted bytes.Buffer
+		_, err = DecryptStream(decoder, &decrypted, iotest.OneByteReader(&encrypted), 7)
+		if err != nil {
+			t.Fatalf("%d bytes: decrypt: %v", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plain) {
+			t.Errorf("%d bytes: decrypted message differs", size)
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	encoder, decoder := newFakePair(t)
+	var encrypted bytes.Buffer
+	_, err := Encrypt(encoder, &encrypted, bytes.NewReader([]byte("reply from the server")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), encrypted.Bytes()...)
+	tampered[0]++
+	state := decoder.SaveState()
+	if _, err = Decrypt(decoder, tampered); !errors.Is(err, mteCoder.ErrTokenDoesNotExist) {
+		t.Errorf("tampered: got %v want %v", err, mteCoder.ErrTokenDoesNotExist)
+	}
+	if err = decoder.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := Decrypt(decoder, encrypted.Bytes())
+	if err != nil || string(decrypted) != "reply from the server" {
+		t.Errorf("got %q %v", decrypted, err)
+	}
+}
+
+func TestEncryptReportsReadErrors(t *testing.T) {
+	encoder, _ := newFakePair(t)
+	failure := errors.New("disk on fire")
+	_, err := Encrypt(encoder, &bytes.Buffer{}, iotest.ErrReader(failure), 0)
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v want %v", err, failure)
+	}
+}
+
+func TestNotDecoded(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&mteHttp.StatusError{StatusCode: http.StatusUnauthorized}, true},
+		{fmt.Errorf("upload: %w", &mteHttp.StatusError{StatusCode: http.StatusUnauthorized}), true},
+		{&mteHttp.StatusError{StatusCode: http.StatusInternalServerError}, false},
+		{&mteHttp.StatusError{StatusCode: http.StatusBadRequest}, false},
+		{errors.New("connection reset by peer"), false},
+	} {
+		if got := NotDecoded(c.err); got != c.want {
+			t.Errorf("%v: got %v want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestDecryptNeedsStart(t *testing.T) {
+	decoder := mteCoder.NewFakeChunkDecoder()
+	if _, err := Decrypt(decoder, []byte("anything")); !errors.Is(err, mteCoder.ErrNotInstantiated) {
+		t.Errorf("got %v want %v", err, mteCoder.ErrNotInstantiated)
+	}
+}
+
+/**
+ * Creates a fake MKE Encoder and the Decoder that matches it
+ */
+func newFakePair(t *testing.T) (encoder *mteCoder.FakeChunkEncoder, decoder *mteCoder.FakeChunkDecoder) {
+	t.Helper()
+	encoder = mteCoder.NewFakeChunkEncoder()
+	decoder = mteCoder.NewFakeChunkDecoder()
+	for _, seeder := range []mteCoder.Seeder{encoder, decoder} {
+		seeder.SetEntropy([]byte("upload test entropy"))
+		seeder.SetNonceInt(1234)
+		if err := seeder.InstantiateStr("upload test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return encoder, decoder
+}
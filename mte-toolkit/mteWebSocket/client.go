@@ -0,0 +1,151 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteWebSocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	//-----------------------------------------------
+	// How long each side waits for the other during
+	// the first exchange on the socket
+	HandshakeTimeout = 10 * time.Second
+)
+
+/**
+ * Connects to an MTE WebSocket server
+ * Resumes the session saved in the store when the server
+ * still has it, otherwise performs a new handshake
+ *
+ * ctx: cancels the dial
+ * url: ws or wss url of the server
+ * header: extra headers for the upgrade request, can be nil
+ * clientId: client ID, also used as personalization string
+ * offer: MTE options this client supports
+ * store: where the session is saved when the connection closes
+ *
+ * Returns the Conn
+ */
+func Dial(ctx context.Context,
+	url string,
+	header http.Header,
+	clientId string,
+	offer mteHandshake.Capabilities,
+	store mteStore.Store) (out *Conn, err error) {
+	saved, err := takeSession(store, clientId, nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading session: %w", err)
+	}
+	exchange, err := mteAdapter.NewClientExchange(mteHandshake.NewRequest(clientId, offer))
+	if err != nil {
+		return nil, err
+	}
+	defer exchange.Close()
+
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	if err != nil {
+		//-------------------------------------------
+		// The server never saw this connection, so
+		// the saved session is still good
+		if saved != nil {
+			_ = saveSession(store, saved)
+		}
+		return nil, err
+	}
+	out, err = clientHandshake(ws, url, store, exchange, saved)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+/**
+ * Sends the hello message and creates the connection
+ * from the answer of the server
+ */
+func clientHandshake(ws *websocket.Conn,
+	url string,
+	store mteStore.Store,
+	exchange *mteAdapter.ClientExchange,
+	saved *mteSession.Session) (out *Conn, err error) {
+	request := hello{Resume: saved != nil, Handshake: exchange.Request()}
+	if saved != nil {
+		request.Proof, err = resumeProof(saved, request.Handshake)
+		if err != nil {
+			return nil, fmt.Errorf("creating resume proof: %w", err)
+		}
+	}
+	ws.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	ws.SetWriteDeadline(time.Now().Add(HandshakeTimeout))
+	err = ws.WriteJSON(request)
+	if err != nil {
+		return nil, fmt.Errorf("sending handshake: %w", err)
+	}
+	var reply helloReply
+	err = ws.ReadJSON(&reply)
+	if err != nil {
+		return nil, fmt.Errorf("reading handshake: %w", err)
+	}
+	ws.SetReadDeadline(time.Time{})
+	ws.SetWriteDeadline(time.Time{})
+
+	if reply.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrHandshake, reply.Error)
+	}
+	if reply.Resumed {
+		if saved == nil {
+			return nil, fmt.Errorf("%w: server resumed a session the client does not have", ErrHandshake)
+		}
+		return newConn(ws, store, saved, true)
+	}
+	if reply.Handshake == nil {
+		return nil, fmt.Errorf("%w: no handshake response", ErrHandshake)
+	}
+	secrets, err := exchange.Finish(*reply.Handshake)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mteAdapter.NewSession(url, secrets.ClientId, secrets.Nonce, secrets.Options,
+		mteEntropy.NewEcdhProvider(secrets.EncoderEntropy), mteEntropy.NewEcdhProvider(secrets.DecoderEntropy))
+	if err != nil {
+		return nil, err
+	}
+	return newConn(ws, store, session, false)
+}
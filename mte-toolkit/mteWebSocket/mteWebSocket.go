@@ -0,0 +1,333 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteWebSocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteCoder"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	//-----------------------------------------
+	// Message types, the same as websocket's
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+
+	//-------------------------------------
+	// Store key prefix of a saved session
+	sessionPrefix = "ws_"
+)
+
+//------------------
+// Error messages
+var (
+	ErrMessageType = errors.New("only text and binary messages can be sent")
+	ErrHandshake   = errors.New("websocket handshake failed")
+)
+
+//-------------------------------------------------------------
+// First message of a connection, sent by the client
+// Resume asks the server to continue the session it saved
+// for the client when the connection closed. Proof is the
+// Encoder public key of Handshake encoded with the saved
+// Encoder, only the client that owns the session can make
+// it and the server Decoder takes it only once. Handshake
+// is used when the server has no saved session or the
+// proof does not decode.
+type hello struct {
+	Resume    bool                          `json:"resume,omitempty"`
+	Proof     string                        `json:"proof,omitempty"`
+	Handshake mteHandshake.HandshakeRequest `json:"handshake"`
+}
+
+//-------------------------------------------------
+// Answer of the server to the hello message
+type helloReply struct {
+	Resumed   bool                            `json:"resumed,omitempty"`
+	Handshake *mteHandshake.HandshakeResponse `json:"handshake,omitempty"`
+	Error     string                          `json:"error,omitempty"`
+}
+
+//------------------------------------------------------------------
+// Saved sessions are taken out of the store, so two connections
+// can never continue the same session and a process that stops
+// without closing the connection leaves nothing stale behind
+var storeLock sync.Mutex
+
+//-------------------------------------------------------------------
+// WebSocket connection with MTE
+// The handshake runs as the first exchange on the socket, then
+// every text and binary message is encoded with an Encoder and
+// Decoder that live as long as the connection and are never
+// written out in between. Binary messages carry the MTE packet,
+// text messages carry it as base64 so they stay valid UTF-8.
+// When the connection closes the states are saved to the store,
+// the next connection of the same client continues from them
+// instead of doing a new handshake. WriteMessage and ReadMessage
+// can be called from different goroutines.
+type Conn struct {
+	ws        *websocket.Conn
+	store     mteStore.Store
+	session   *mteSession.Session
+	resumed   bool
+	encoder   mteCoder.Encoder
+	decoder   mteCoder.Decoder
+	writeLock sync.Mutex
+	readLock  sync.Mutex
+	closeOnce sync.Once
+	closeErr  error
+}
+
+/**
+ * Returns the client ID of the connection
+ */
+func (c *Conn) ClientId() string {
+	return c.session.ClientId
+}
+
+/**
+ * Reports whether the connection continued a saved session
+ * instead of doing a new handshake
+ */
+func (c *Conn) Resumed() bool {
+	return c.resumed
+}
+
+/**
+ * Encodes a message and sends it
+ *
+ * messageType: TextMessage or BinaryMessage
+ * message: plaintext message
+ */
+func (c *Conn) WriteMessage(messageType int, message []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("%w: type %d", ErrMessageType, messageType)
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.encoder == nil {
+		return net.ErrClosed
+	}
+	encoded, err := c.encoder.Encode(message)
+	if err != nil {
+		return err
+	}
+	if messageType == TextMessage {
+		encoded = []byte(base64.StdEncoding.EncodeToString(encoded))
+	}
+	return c.ws.WriteMessage(messageType, encoded)
+}
+
+/**
+ * Waits for the next message and decodes it
+ * Returns the type of the message and the plaintext, or a
+ * *websocket.CloseError once the other side closed
+ */
+func (c *Conn) ReadMessage() (messageType int, out []byte, err error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if c.decoder == nil {
+		return 0, nil, net.ErrClosed
+	}
+	messageType, encoded, err := c.ws.ReadMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	switch messageType {
+	case TextMessage:
+		encoded, err = base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			return 0, nil, err
+		}
+	case BinaryMessage:
+	default:
+		return 0, nil, fmt.Errorf("%w: type %d", ErrMessageType, messageType)
+	}
+	decoded, err := c.decoder.Decode(encoded)
+	if err != nil {
+		return 0, nil, err
+	}
+	return messageType, decoded, nil
+}
+
+/**
+ * Closes the connection and saves the Encoder and Decoder
+ * states, so the next connection of this client can resume
+ * Closing the socket ends a ReadMessage that is waiting
+ */
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writeLock.Lock()
+		_ = c.ws.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		c.writeLock.Unlock()
+		err := c.ws.Close()
+
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		c.readLock.Lock()
+		defer c.readLock.Unlock()
+		c.session.SaveEncoderState(c.encoder)
+		c.session.SaveDecoderState(c.decoder)
+		c.encoder.Destroy()
+		c.decoder.Destroy()
+		c.encoder = nil
+		c.decoder = nil
+		saveErr := saveSession(c.store, c.session)
+		if saveErr != nil {
+			err = fmt.Errorf("saving session: %w", saveErr)
+		}
+		c.closeErr = err
+	})
+	return c.closeErr
+}
+
+/**
+ * Creates the connection from a session
+ * Restores the live Encoder and Decoder
+ */
+func newConn(ws *websocket.Conn, store mteStore.Store, session *mteSession.Session, resumed bool) (out *Conn, err error) {
+	encoder, err := session.CoreEncoder()
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := session.CoreDecoder()
+	if err != nil {
+		encoder.Destroy()
+		return nil, err
+	}
+	return &Conn{
+		ws:      ws,
+		store:   store,
+		session: session,
+		resumed: resumed,
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+/**
+ * Encodes the resume proof for a hello with the saved Encoder
+ * The saved session keeps the Encoder state after the proof
+ */
+func resumeProof(saved *mteSession.Session, request mteHandshake.HandshakeRequest) (out string, err error) {
+	encoder, err := saved.CoreEncoder()
+	if err != nil {
+		return "", err
+	}
+	defer encoder.Destroy()
+	out, err = mteCoder.EncodeStrB64(encoder, request.ClientEncoderPublicKey)
+	if err != nil {
+		return "", err
+	}
+	saved.SaveEncoderState(encoder)
+	return out, nil
+}
+
+/**
+ * Checks the resume proof of a hello with the saved Decoder
+ * The saved session keeps the Decoder state after the proof
+ * when it is good
+ */
+func checkResumeProof(saved *mteSession.Session, request hello) bool {
+	if request.Proof == "" || request.Handshake.ClientEncoderPublicKey == "" {
+		return false
+	}
+	decoder, err := saved.CoreDecoder()
+	if err != nil {
+		return false
+	}
+	defer decoder.Destroy()
+	decoded, err := mteCoder.DecodeStrB64(decoder, request.Proof)
+	if err != nil || decoded != request.Handshake.ClientEncoderPublicKey {
+		return false
+	}
+	saved.SaveDecoderState(decoder)
+	return true
+}
+
+/**
+ * Takes the saved session of a client out of the store
+ * The session is only removed when claim accepts it, a
+ * rejected one stays stored. claim runs under the store
+ * lock so two connections can never take the same session,
+ * a nil claim takes any saved session
+ * Returns nil when there is none or claim rejected it
+ */
+func takeSession(store mteStore.Store,
+	clientId string,
+	claim func(saved *mteSession.Session) bool) (out *mteSession.Session, err error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	sessionBytes, err := store.Get(sessionPrefix + clientId)
+	if errors.Is(err, mteStore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session mteSession.Session
+	err = json.Unmarshal(sessionBytes, &session)
+	if err != nil {
+		return nil, err
+	}
+	session.UseCoders(mteAdapter.MteCoders)
+	if claim != nil && !claim(&session) {
+		return nil, nil
+	}
+	err = store.Delete(sessionPrefix + clientId)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+/**
+ * Puts a session back in the store
+ */
+func saveSession(store mteStore.Store, session *mteSession.Session) error {
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	return store.Set(sessionPrefix+session.ClientId, sessionBytes)
+}
+
@@ -0,0 +1,283 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteWebSocket
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mteToolkit/internal/mteTesting"
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteStore"
+
+	"github.com/gorilla/websocket"
+)
+
+const testClientId = "websocket-test-client"
+
+//------------------------------------------------
+// Test server handing every upgraded Conn to the
+// test through a channel
+type testServer struct {
+	*httptest.Server
+	conns chan *Conn
+	wire  mteTesting.Wire
+}
+
+func newTestServer(t *testing.T, store mteStore.Store) *testServer {
+	mteTesting.RequireLicense(t)
+	s := &testServer{conns: make(chan *Conn, 1)}
+	upgrader := &Upgrader{Capabilities: mteAdapter.DefaultCapabilities(mteHandshake.ModeCore), Store: store}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			close(s.conns)
+			return
+		}
+		s.conns <- conn
+	}))
+	s.Listener = s.wire.Listener(s.Listener)
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+/**
+ * Connects a client and returns both ends
+ */
+func (s *testServer) connect(t *testing.T, store mteStore.Store) (client *Conn, server *Conn) {
+	client, err := Dial(context.Background(), s.url(), nil, testClientId, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore), store)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server, ok := <-s.conns
+	if !ok {
+		client.Close()
+		t.FailNow()
+	}
+	return client, server
+}
+
+/**
+ * Closes the client and waits for the server to see it,
+ * then closes the server so both sides saved their state
+ */
+func disconnect(t *testing.T, client *Conn, server *Conn) {
+	err := client.Close()
+	if err != nil {
+		t.Fatalf("client close: %v", err)
+	}
+	_, _, err = server.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("server read after close: %v", err)
+	}
+	err = server.Close()
+	if err != nil {
+		t.Fatalf("server close: %v", err)
+	}
+}
+
+func exchange(t *testing.T, from *Conn, to *Conn, messageType int, message string) {
+	t.Helper()
+	err := from.WriteMessage(messageType, []byte(message))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	gotType, got, err := to.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if gotType != messageType || string(got) != message {
+		t.Fatalf("got %d %q, want %d %q", gotType, got, messageType, message)
+	}
+}
+
+func TestTextAndBinaryBothDirections(t *testing.T) {
+	server := newTestServer(t, mteStore.NewMemoryStore())
+	client, serverConn := server.connect(t, mteStore.NewMemoryStore())
+	defer disconnect(t, client, serverConn)
+	if client.Resumed() || serverConn.Resumed() {
+		t.Fatal("first connection resumed")
+	}
+	if serverConn.ClientId() != testClientId {
+		t.Fatalf("server client ID %q", serverConn.ClientId())
+	}
+	for i := 0; i < 3; i++ {
+		exchange(t, client, serverConn, TextMessage, "hello server")
+		exchange(t, serverConn, client, TextMessage, "hello client")
+		exchange(t, client, serverConn, BinaryMessage, "\x00\x01\x02 binary")
+		exchange(t, serverConn, client, BinaryMessage, "\xff\xfe binary")
+	}
+}
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	serverStore := mteStore.NewMemoryStore()
+	clientStore := mteStore.NewMemoryStore()
+	server := newTestServer(t, serverStore)
+
+	client, serverConn := server.connect(t, clientStore)
+	exchange(t, client, serverConn, TextMessage, "before disconnect")
+	exchange(t, serverConn, client, BinaryMessage, "answer before disconnect")
+	disconnect(t, client, serverConn)
+
+	client, serverConn = server.connect(t, clientStore)
+	defer disconnect(t, client, serverConn)
+	if !client.Resumed() || !serverConn.Resumed() {
+		t.Fatalf("resumed client %v server %v", client.Resumed(), serverConn.Resumed())
+	}
+	exchange(t, client, serverConn, TextMessage, "after disconnect")
+	exchange(t, serverConn, client, BinaryMessage, "answer after disconnect")
+
+	//--------------------------------------------
+	// The saved sessions are taken while in use
+	_, err := serverStore.Get(sessionPrefix + testClientId)
+	if !errors.Is(err, mteStore.ErrNotFound) {
+		t.Fatalf("server session still stored: %v", err)
+	}
+}
+
+func TestServerWithoutSessionDoesHandshake(t *testing.T) {
+	clientStore := mteStore.NewMemoryStore()
+	first := newTestServer(t, mteStore.NewMemoryStore())
+	client, serverConn := first.connect(t, clientStore)
+	disconnect(t, client, serverConn)
+
+	//--------------------------------------------
+	// A restarted server lost the session, the
+	// client falls back to a new handshake
+	second := newTestServer(t, mteStore.NewMemoryStore())
+	client, serverConn = second.connect(t, clientStore)
+	defer disconnect(t, client, serverConn)
+	if client.Resumed() || serverConn.Resumed() {
+		t.Fatal("resumed without a server session")
+	}
+	exchange(t, client, serverConn, TextMessage, "new session")
+}
+
+func TestResumeNeedsProof(t *testing.T) {
+	serverStore := mteStore.NewMemoryStore()
+	clientStore := mteStore.NewMemoryStore()
+	server := newTestServer(t, serverStore)
+	client, serverConn := server.connect(t, clientStore)
+	disconnect(t, client, serverConn)
+	saved, err := serverStore.Get(sessionPrefix + testClientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//---------------------------------------------------
+	// A second client with the same ID and no state asks
+	// to resume, without a proof or with a made up one
+	for _, proof := range []string{"", base64.StdEncoding.EncodeToString([]byte("made up proof"))} {
+		reply, otherConn := server.resumeWithoutState(t, proof)
+		if reply.Resumed || reply.Handshake == nil || otherConn.Resumed() {
+			t.Fatalf("proof %q: resumed %v, handshake %v, server resumed %v",
+				proof, reply.Resumed, reply.Handshake != nil, otherConn.Resumed())
+		}
+		defer otherConn.Close()
+		stored, err := serverStore.Get(sessionPrefix + testClientId)
+		if err != nil || string(stored) != string(saved) {
+			t.Fatalf("proof %q: saved session changed, %v", proof, err)
+		}
+	}
+
+	//-----------------------------------------------
+	// The session the first client saved was kept,
+	// it still resumes
+	client, serverConn = server.connect(t, clientStore)
+	defer disconnect(t, client, serverConn)
+	if !client.Resumed() || !serverConn.Resumed() {
+		t.Fatalf("resumed client %v server %v", client.Resumed(), serverConn.Resumed())
+	}
+	exchange(t, client, serverConn, TextMessage, "after the other client")
+}
+
+/**
+ * Sends a hello asking to resume without having a saved
+ * session and returns the reply and the server side
+ */
+func (s *testServer) resumeWithoutState(t *testing.T, proof string) (reply helloReply, server *Conn) {
+	clientExchange, err := mteAdapter.NewClientExchange(
+		mteHandshake.NewRequest(testClientId, mteAdapter.DefaultCapabilities(mteHandshake.ModeCore)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientExchange.Close()
+	ws, _, err := websocket.DefaultDialer.Dial(s.url(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	err = ws.WriteJSON(hello{Resume: true, Proof: proof, Handshake: clientExchange.Request()})
+	if err != nil {
+		t.Fatalf("sending hello: %v", err)
+	}
+	err = ws.ReadJSON(&reply)
+	if err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	server, ok := <-s.conns
+	if !ok {
+		t.FailNow()
+	}
+	return reply, server
+}
+
+func TestWireIsEncoded(t *testing.T) {
+	server := newTestServer(t, mteStore.NewMemoryStore())
+	client, serverConn := server.connect(t, mteStore.NewMemoryStore())
+	defer disconnect(t, client, serverConn)
+	text := "this text must not show on the wire"
+	binary := "these bytes must not show on the wire"
+	//-------------------------------------------------
+	// Client frames are masked, so only the frames the
+	// server sends show whether the payload is encoded
+	exchange(t, serverConn, client, TextMessage, text)
+	exchange(t, serverConn, client, BinaryMessage, binary)
+	server.wire.CheckEncoded(t, []byte(text), []byte(binary))
+}
+
+func TestRejectsOtherMessageTypes(t *testing.T) {
+	server := newTestServer(t, mteStore.NewMemoryStore())
+	client, serverConn := server.connect(t, mteStore.NewMemoryStore())
+	defer disconnect(t, client, serverConn)
+	err := client.WriteMessage(websocket.PingMessage, nil)
+	if !errors.Is(err, ErrMessageType) {
+		t.Fatalf("got %v, want ErrMessageType", err)
+	}
+}
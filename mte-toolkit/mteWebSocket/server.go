@@ -0,0 +1,127 @@
+/*****************************************************************************
+THIS SOFTWARE MAY NOT BE USED FOR PRODUCTION. Otherwise,
+The MIT License (MIT)
+
+Copyright (c) Eclypses, Inc.
+
+All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+******************************************************************************/
+package mteWebSocket
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"mteToolkit/mteAdapter"
+	"mteToolkit/mteEntropy"
+	"mteToolkit/mteHandshake"
+	"mteToolkit/mteSession"
+	"mteToolkit/mteStore"
+
+	"github.com/gorilla/websocket"
+)
+
+//-------------------------------------------------------------
+// Server side of the MTE WebSocket transport
+// Upgrade turns an HTTP request into a Conn, the sessions of
+// closed connections are kept in Store until the client comes
+// back. Use a SealedStore, the sessions hold the MTE states.
+type Upgrader struct {
+	Upgrader     websocket.Upgrader
+	Capabilities mteHandshake.Capabilities
+	Store        mteStore.Store
+}
+
+/**
+ * Upgrades the request to a WebSocket and runs the first
+ * exchange, resuming the saved session of the client when
+ * both sides have it and the client proved it, or answering
+ * its handshake
+ *
+ * Returns the Conn, the response has been written either way
+ */
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (out *Conn, err error) {
+	ws, err := u.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	out, err = u.serverHandshake(ws)
+	if err != nil {
+		_ = ws.WriteJSON(helloReply{Error: "handshake failed"})
+		ws.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+/**
+ * Reads the hello message and answers it
+ */
+func (u *Upgrader) serverHandshake(ws *websocket.Conn) (out *Conn, err error) {
+	ws.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	ws.SetWriteDeadline(time.Now().Add(HandshakeTimeout))
+	defer ws.SetWriteDeadline(time.Time{})
+	var request hello
+	err = ws.ReadJSON(&request)
+	if err != nil {
+		return nil, fmt.Errorf("reading handshake: %w", err)
+	}
+	ws.SetReadDeadline(time.Time{})
+	clientId := request.Handshake.ConversationIdentifier
+	if clientId == "" {
+		return nil, fmt.Errorf("%w: no client ID", ErrHandshake)
+	}
+
+	//------------------------------------------------------
+	// A saved session is only taken when the client proves
+	// it still has its side, otherwise it stays stored for
+	// the client that has it and this one gets a handshake
+	saved, err := takeSession(u.Store, clientId, func(saved *mteSession.Session) bool {
+		return request.Resume && checkResumeProof(saved, request)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading session: %w", err)
+	}
+	if saved != nil {
+		err = ws.WriteJSON(helloReply{Resumed: true})
+		if err != nil {
+			_ = saveSession(u.Store, saved)
+			return nil, fmt.Errorf("sending handshake: %w", err)
+		}
+		return newConn(ws, u.Store, saved, true)
+	}
+
+	response, secrets, err := mteAdapter.Respond(request.Handshake, u.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mteAdapter.NewSession("", secrets.ClientId, secrets.Nonce, secrets.Options,
+		mteEntropy.NewEcdhProvider(secrets.EncoderEntropy), mteEntropy.NewEcdhProvider(secrets.DecoderEntropy))
+	if err != nil {
+		return nil, err
+	}
+	err = ws.WriteJSON(helloReply{Handshake: &response})
+	if err != nil {
+		return nil, fmt.Errorf("sending handshake: %w", err)
+	}
+	return newConn(ws, u.Store, session, false)
+}